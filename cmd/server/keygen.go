@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"chat-backend/config"
+	"chat-backend/utils"
+)
+
+const keygenUsage = "usage: server keygen [ed25519 | rsa]"
+
+// runKeygen implements the `keygen` subcommand, which adds a new signing key
+// to JWT_KEYS_DIR. Running servers start signing with it within a minute.
+func runKeygen(cfg config.Config, args []string) error {
+	if cfg.JWTKeysDir == "" {
+		return errors.New("JWT_KEYS_DIR is not set")
+	}
+	kind := "ed25519"
+	if len(args) > 0 {
+		kind = args[0]
+	}
+	if len(args) > 1 || (kind != "ed25519" && kind != "rsa") {
+		return errors.New(keygenUsage)
+	}
+
+	path, err := utils.GenerateKeyFile(cfg.JWTKeysDir, kind)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s key %s\n", kind, path)
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -11,8 +12,10 @@ import (
 
 	"chat-backend/config"
 	"chat-backend/handlers"
+	"chat-backend/models"
 	"chat-backend/repository"
 	"chat-backend/services"
+	"chat-backend/utils"
 	"chat-backend/ws"
 )
 
@@ -49,39 +52,243 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
+type repositories struct {
+	users        repository.UserRepository
+	chats        repository.ChatRepository
+	messages     repository.MessageRepository
+	memberships  repository.MembershipRepository
+	reactions    repository.ReactionRepository
+	restrictions repository.RestrictionRepository
+	invites      repository.InviteRepository
+	readMarkers  repository.ReadMarkerRepository
+	mentions     repository.MentionRepository
+	search       repository.SearchIndex
+	attachments  repository.AttachmentRepository
+	sessions     repository.SessionRepository
+	resets       repository.PasswordResetRepository
+	twoFactor    repository.TwoFactorRepository
+	identities   repository.IdentityRepository
+	closeFn      func() error
+}
+
+func (r repositories) Close() error {
+	if r.closeFn == nil {
+		return nil
+	}
+	return r.closeFn()
+}
+
+// openRepositories builds the repository set selected by cfg.Storage.
+func openRepositories(cfg config.Config) (repositories, error) {
+	switch cfg.Storage {
+	case "", "memory":
+		return repositories{
+			users:        repository.NewInMemoryUserRepo(),
+			chats:        repository.NewInMemoryChatRepo(),
+			messages:     repository.NewInMemoryMessageRepo(),
+			memberships:  repository.NewInMemoryMembershipRepo(),
+			reactions:    repository.NewInMemoryReactionRepo(),
+			restrictions: repository.NewInMemoryRestrictionRepo(),
+			invites:      repository.NewInMemoryInviteRepo(),
+			readMarkers:  repository.NewInMemoryReadMarkerRepo(),
+			mentions:     repository.NewInMemoryMentionRepo(),
+			search:       repository.NewInMemorySearchIndex(),
+			attachments:  repository.NewInMemoryAttachmentRepo(),
+			sessions:     repository.NewInMemorySessionRepo(),
+			resets:       repository.NewInMemoryPasswordResetRepo(),
+			twoFactor:    repository.NewInMemoryTwoFactorRepo(),
+			identities:   repository.NewInMemoryIdentityRepo(),
+		}, nil
+	case "sqlite":
+		db, err := repository.OpenSQLite(cfg.DBPath)
+		if err != nil {
+			return repositories{}, err
+		}
+		log.Printf("Using SQLite storage at %s", cfg.DBPath)
+		repos := sqlRepositories(db)
+		repos.search = repository.NewSQLiteSearchIndex(db)
+		return repos, nil
+	case "postgres":
+		db, err := repository.OpenPostgres(cfg.DatabaseURL)
+		if err != nil {
+			return repositories{}, err
+		}
+		log.Printf("Using PostgreSQL storage")
+		repos := sqlRepositories(db)
+		// Search runs on an in-memory index, rebuilt from the database
+		index := repository.NewInMemorySearchIndex()
+		if err := index.Load(db); err != nil {
+			db.Close()
+			return repositories{}, fmt.Errorf("build search index: %w", err)
+		}
+		repos.search = index
+		return repos, nil
+	default:
+		return repositories{}, fmt.Errorf("unknown storage %q (expected memory, sqlite or postgres)", cfg.Storage)
+	}
+}
+
+func sqlRepositories(db *repository.DB) repositories {
+	return repositories{
+		users:        repository.NewSQLUserRepo(db),
+		chats:        repository.NewSQLChatRepo(db),
+		messages:     repository.NewSQLMessageRepo(db),
+		memberships:  repository.NewSQLMembershipRepo(db),
+		reactions:    repository.NewSQLReactionRepo(db),
+		restrictions: repository.NewSQLRestrictionRepo(db),
+		invites:      repository.NewSQLInviteRepo(db),
+		readMarkers:  repository.NewSQLReadMarkerRepo(db),
+		mentions:     repository.NewSQLMentionRepo(db),
+		attachments:  repository.NewSQLAttachmentRepo(db),
+		sessions:     repository.NewSQLSessionRepo(db),
+		resets:       repository.NewSQLPasswordResetRepo(db),
+		twoFactor:    repository.NewSQLTwoFactorRepo(db),
+		identities:   repository.NewSQLIdentityRepo(db),
+		closeFn:      db.Close,
+	}
+}
+
+// openBlobStore builds the blob store selected by cfg.BlobStore.
+func openBlobStore(cfg config.Config) (repository.BlobStore, error) {
+	switch cfg.BlobStore {
+	case "", "local":
+		log.Printf("Storing uploads in %s", cfg.BlobDir)
+		return repository.NewLocalBlobStore(cfg.BlobDir)
+	case "s3":
+		log.Printf("Storing uploads in S3 bucket %s at %s", cfg.S3Bucket, cfg.S3Endpoint)
+		return repository.NewS3BlobStore(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q (expected local or s3)", cfg.BlobStore)
+	}
+}
+
+// openKeyRing loads the token signing keys from cfg.JWTKeysDir, or falls
+// back to the shared cfg.JWTSecret when no directory is set.
+func openKeyRing(cfg config.Config) (*utils.KeyRing, error) {
+	if cfg.JWTKeysDir == "" {
+		log.Printf("Signing access tokens with JWT_SECRET (HS256); set JWT_KEYS_DIR to publish verification keys")
+		return utils.NewSecretKeyRing(cfg.JWTSecret), nil
+	}
+	grace := time.Duration(cfg.JWTKeyGrace) * time.Minute
+	return utils.LoadKeyRing(cfg.JWTKeysDir, grace)
+}
+
+// openNotifier builds the notifier selected by cfg.Notifier.
+func openNotifier(cfg config.Config) (services.Notifier, error) {
+	switch cfg.Notifier {
+	case "", "log":
+		log.Printf("Account messages are written to the log, not sent")
+		return services.LogNotifier{}, nil
+	case "smtp":
+		log.Printf("Sending account messages through SMTP server %s", cfg.SMTPAddr)
+		return services.NewSMTPNotifier(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+	default:
+		return nil, fmt.Errorf("unknown notifier %q (expected log or smtp)", cfg.Notifier)
+	}
+}
+
+// ensureDefaultRoom creates the "General" room, or finds it when a
+// persistent store already has it from a previous run.
+func ensureDefaultRoom(chatRepo repository.ChatRepository) (*models.ChatRoom, error) {
+	room, err := chatRepo.Create("General", true, 0) // System created room
+	if err == nil {
+		return room, nil
+	}
+
+	rooms, listErr := chatRepo.List()
+	if listErr != nil {
+		return nil, listErr
+	}
+	for i := range rooms {
+		if rooms[i].Name == "General" {
+			return &rooms[i], nil
+		}
+	}
+	return nil, err
+}
+
 func main() {
 	// --- config/env ---
 	cfg := config.Load()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := runKeygen(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("keygen: %v", err)
+		}
+		return
+	}
+
 	log.Printf("Starting chat server on port %s", cfg.Port)
 
-	// --- repos (in-memory for now) ---
-	userRepo := repository.NewInMemoryUserRepo()
-	messageRepo := repository.NewInMemoryMessageRepo()
-	chatRepo := repository.NewInMemoryChatRepo()
-	membershipRepo := repository.NewInMemoryMembershipRepo()
+	// --- repos ---
+	repos, err := openRepositories(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", cfg.Storage, err)
+	}
+	defer repos.Close()
+	userRepo := repos.users
+	messageRepo := repos.messages
+	chatRepo := repos.chats
+	membershipRepo := repos.memberships
+	reactionRepo := repos.reactions
+	restrictionRepo := repos.restrictions
+	inviteRepo := repos.invites
+	readMarkerRepo := repos.readMarkers
+	mentionRepo := repos.mentions
+	searchIndex := repos.search
+	attachmentRepo := repos.attachments
+	sessionRepo := repos.sessions
+	resetRepo := repos.resets
+	twoFactorRepo := repos.twoFactor
+	identityRepo := repos.identities
+
+	blobStore, err := openBlobStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s blob store: %v", cfg.BlobStore, err)
+	}
+	notifier, err := openNotifier(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up %s notifier: %v", cfg.Notifier, err)
+	}
+	keys, err := openKeyRing(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	keys.StartReloading(time.Minute)
 
 	// --- create default room ---
-	defaultRoom, err := chatRepo.Create("General", true, 0) // System created room
+	defaultRoom, err := ensureDefaultRoom(chatRepo)
 	if err != nil {
-		log.Printf("Warning: could not create default room: %v", err)
-	} else {
-		log.Printf("Created default room: %s (ID: %d)", defaultRoom.Name, defaultRoom.ID)
+		log.Fatalf("Could not create default room: %v", err)
 	}
+	log.Printf("Using default room: %s (ID: %d)", defaultRoom.Name, defaultRoom.ID)
 
 	// --- websocket hub ---
 	hub := ws.NewHub()
-	go hub.Run()
 
 	// --- services ---
-	authSvc := services.NewAuthService(userRepo, &cfg)
-	msgSvc := services.NewMessageService(messageRepo, chatRepo, userRepo, hub, &cfg)
-	chatSvc := services.NewChatService(chatRepo, userRepo, messageRepo, membershipRepo)
+	authSvc := services.NewAuthService(userRepo, sessionRepo, resetRepo, twoFactorRepo, notifier, keys, hub, &cfg)
+	oidcSvc, err := services.NewOIDCService(authSvc, userRepo, identityRepo, &http.Client{Timeout: 10 * time.Second}, &cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up identity providers: %v", err)
+	}
+	msgSvc := services.NewMessageService(messageRepo, reactionRepo, readMarkerRepo, mentionRepo, searchIndex, attachmentRepo, blobStore, chatRepo, membershipRepo, restrictionRepo, userRepo, hub, hub, &cfg)
+	chatSvc := services.NewChatService(chatRepo, userRepo, messageRepo, reactionRepo, readMarkerRepo, mentionRepo, membershipRepo, restrictionRepo, inviteRepo, searchIndex, attachmentRepo, blobStore, hub)
+	hub.SetRoomLookup(chatSvc.UserRoomIDs)
+	msgSvc.StartImageWorkers(cfg.ImageWorkers)
+	go hub.Run()
 
 	// --- handlers ---
-	authH := handlers.NewAuthHandler(authSvc)
-	msgH := handlers.NewMessageHandler(msgSvc, authSvc)
+	authH := handlers.NewAuthHandler(authSvc, oidcSvc)
+	msgH := handlers.NewMessageHandler(msgSvc)
 	chatH := handlers.NewChatHandler(hub, chatSvc, authSvc, msgSvc)
+	withAuth := handlers.WithAuth(authSvc)
 
 	// --- mux and routes ---
 	mux := http.NewServeMux()
@@ -97,17 +304,73 @@ func main() {
 	mux.HandleFunc("/api/register/", authH.Register)
 	mux.HandleFunc("/api/login", authH.Login)
 	mux.HandleFunc("/api/login/", authH.Login)
-	mux.HandleFunc("/api/rooms", chatH.WithAuth(chatH.Rooms))               // GET list rooms
-	mux.HandleFunc("/api/rooms/", chatH.WithAuth(chatH.Rooms))              // GET list rooms
-	mux.HandleFunc("/api/rooms/create", chatH.WithAuth(chatH.Create))       // POST create room
-	mux.HandleFunc("/api/rooms/create/", chatH.WithAuth(chatH.Create))      // POST create room
-	mux.HandleFunc("/api/rooms/delete", chatH.WithAuth(chatH.Delete))       // DELETE delete room
-	mux.HandleFunc("/api/rooms/delete/", chatH.WithAuth(chatH.Delete))      // DELETE delete room
-	mux.HandleFunc("/api/rooms/join", chatH.WithAuth(chatH.JoinViaInvite))  // POST join room via invite
-	mux.HandleFunc("/api/rooms/join/", chatH.WithAuth(chatH.JoinViaInvite)) // POST join room via invite
-	mux.HandleFunc("/api/messages", msgH.WithAuth(msgH.ListMessages))       // GET ?roomId=1
-	mux.HandleFunc("/api/messages/", msgH.WithAuth(msgH.ListMessages))      // GET ?roomId=1
-	mux.HandleFunc("/ws", chatH.WS)                                         // WS ?roomId=1&token=<token>
+	mux.HandleFunc("/api/login/2fa", authH.LoginTwoFactor)                    // POST finish a login with a 2FA code
+	mux.HandleFunc("/api/oidc/providers", authH.OIDCProviders)                // GET identity providers to log in with
+	mux.HandleFunc("/api/oidc/login", authH.OIDCLogin)                        // POST start a login at a provider
+	mux.HandleFunc("/api/oidc/callback", authH.OIDCCallback)                  // POST finish it with the state and code
+	mux.HandleFunc("/api/oidc/identities", withAuth(authH.Identities))        // GET provider accounts linked to mine
+	mux.HandleFunc("/api/oidc/link", withAuth(authH.LinkIdentity))            // POST start linking a provider account
+	mux.HandleFunc("/api/oidc/link/callback", withAuth(authH.LinkCallback))   // POST finish linking it
+	mux.HandleFunc("/.well-known/jwks.json", authH.JWKS)                      // GET public keys for verifying tokens
+	mux.HandleFunc("/api/refresh", authH.Refresh)                             // POST trade a refresh token for new tokens
+	mux.HandleFunc("/api/logout", withAuth(authH.Logout))                     // POST end this session
+	mux.HandleFunc("/api/sessions", withAuth(authH.Sessions))                 // GET my active sessions
+	mux.HandleFunc("/api/sessions/revoke", withAuth(authH.RevokeSession))     // POST end one of my sessions
+	mux.HandleFunc("/api/logout/others", withAuth(authH.LogoutOthers))        // POST end all my sessions but this one
+	mux.HandleFunc("/api/password/change", withAuth(authH.ChangePassword))    // POST change my password
+	mux.HandleFunc("/api/password/forgot", authH.ForgotPassword)              // POST send me a reset token
+	mux.HandleFunc("/api/password/reset", authH.ResetPassword)                // POST new password with a reset token
+	mux.HandleFunc("/api/account/email", withAuth(authH.SetEmail))            // POST set my email for resets
+	mux.HandleFunc("/api/admin/reset", withAuth(authH.AdminResetPassword))    // POST reset a user's password (admins)
+	mux.HandleFunc("/api/2fa", withAuth(authH.TwoFactorStatus))               // GET is 2FA on, recovery codes left
+	mux.HandleFunc("/api/2fa/enroll", withAuth(authH.EnrollTwoFactor))        // POST new TOTP secret and otpauth URI
+	mux.HandleFunc("/api/2fa/confirm", withAuth(authH.ConfirmTwoFactor))      // POST first code turns 2FA on
+	mux.HandleFunc("/api/2fa/recovery", withAuth(authH.RecoveryCodes))        // POST replace my recovery codes
+	mux.HandleFunc("/api/2fa/disable", withAuth(authH.DisableTwoFactor))      // POST turn 2FA off
+	mux.HandleFunc("/api/rooms", withAuth(chatH.Rooms))                       // GET list rooms
+	mux.HandleFunc("/api/rooms/", withAuth(chatH.Rooms))                      // GET list rooms
+	mux.HandleFunc("/api/rooms/create", withAuth(chatH.Create))               // POST create room
+	mux.HandleFunc("/api/rooms/create/", withAuth(chatH.Create))              // POST create room
+	mux.HandleFunc("/api/rooms/delete", withAuth(chatH.Delete))               // DELETE delete room
+	mux.HandleFunc("/api/rooms/delete/", withAuth(chatH.Delete))              // DELETE delete room
+	mux.HandleFunc("/api/rooms/join", withAuth(chatH.JoinViaInvite))          // POST join room via invite
+	mux.HandleFunc("/api/rooms/join/", withAuth(chatH.JoinViaInvite))         // POST join room via invite
+	mux.HandleFunc("/api/rooms/invites", withAuth(chatH.Invites))             // GET ?roomId=2 active invites
+	mux.HandleFunc("/api/rooms/invites/create", withAuth(chatH.CreateInvite)) // POST new invite with optional expiry and max uses
+	mux.HandleFunc("/api/rooms/invites/revoke", withAuth(chatH.RevokeInvite)) // POST revoke an invite
+	mux.HandleFunc("/api/rooms/invites/rotate", withAuth(chatH.RotateInvite)) // POST replace an invite with a new code
+	mux.HandleFunc("/api/rooms/members", withAuth(chatH.Members))             // GET ?roomId=2 members, roles and presence
+	mux.HandleFunc("/api/rooms/members/role", withAuth(chatH.SetRole))        // PUT promote or demote a member
+	mux.HandleFunc("/api/rooms/transfer", withAuth(chatH.TransferOwnership))  // POST hand the room to another member
+	mux.HandleFunc("/api/rooms/kick", withAuth(chatH.Kick))                   // POST remove a member and disconnect them
+	mux.HandleFunc("/api/rooms/ban", withAuth(chatH.Ban))                     // POST ban a member, optionally for a while
+	mux.HandleFunc("/api/rooms/unban", withAuth(chatH.Unban))                 // POST lift a ban
+	mux.HandleFunc("/api/rooms/mute", withAuth(chatH.Mute))                   // POST stop a member from posting
+	mux.HandleFunc("/api/rooms/unmute", withAuth(chatH.Unmute))               // POST lift a mute
+	mux.HandleFunc("/api/rooms/restrictions", withAuth(chatH.Restrictions))   // GET ?roomId=2 active bans and mutes
+	mux.HandleFunc("/api/messages", withAuth(msgH.ListMessages))              // GET ?roomId=1[&before=|after=|around=<msgId>]
+	mux.HandleFunc("/api/messages/", withAuth(msgH.ListMessages))             // GET ?roomId=1[&before=|after=|around=<msgId>]
+	mux.HandleFunc("/api/messages/send", withAuth(msgH.SendMessage))          // POST room message or thread reply
+	mux.HandleFunc("/api/messages/upload", withAuth(msgH.Upload))             // POST multipart message with files
+	mux.HandleFunc("/api/attachments", withAuth(msgH.DownloadAttachment))     // GET ?id=7 file contents
+	mux.HandleFunc("/api/attachments/thumbnail", withAuth(msgH.Thumbnail))    // GET ?id=7 image thumbnail
+	mux.HandleFunc("/api/messages/thread", withAuth(msgH.ListThread))         // GET ?id=42 thread replies
+	mux.HandleFunc("/api/messages/edit", withAuth(msgH.EditMessage))          // PUT edit own message
+	mux.HandleFunc("/api/messages/delete", withAuth(msgH.DeleteMessage))      // DELETE ?id=42
+	mux.HandleFunc("/api/messages/edits", withAuth(msgH.MessageEdits))        // GET ?id=42 edit history
+	mux.HandleFunc("/api/messages/search", withAuth(msgH.Search))             // GET ?q=deploy&roomId=&senderId=&from=&to=&limit=&offset=
+	mux.HandleFunc("/api/messages/read", withAuth(msgH.MarkRead))             // POST move my read marker in a room
+	mux.HandleFunc("/api/messages/reads", withAuth(msgH.ReadMarkers))         // GET ?roomId=2 read markers for receipts
+	mux.HandleFunc("/api/reactions/add", withAuth(msgH.AddReaction))          // POST react to a message
+	mux.HandleFunc("/api/reactions/remove", withAuth(msgH.RemoveReaction))    // DELETE ?message_id=42&emoji=<emoji>
+	mux.HandleFunc("/api/mentions", withAuth(msgH.ListMentions))              // GET ?unread=true&limit=50 my recent mentions
+	mux.HandleFunc("/api/mentions/read", withAuth(msgH.MarkMentionsRead))     // POST mark mentions read
+	mux.HandleFunc("/api/dms", withAuth(msgH.ListDirectThreads))              // GET list my conversations
+	mux.HandleFunc("/api/dms/", withAuth(msgH.ListDirectThreads))             // GET list my conversations
+	mux.HandleFunc("/api/dms/open", withAuth(msgH.OpenDirect))                // POST open conversation with a user
+	mux.HandleFunc("/api/dms/messages", withAuth(msgH.ListDirectMessages))    // GET ?userId=2
+	mux.HandleFunc("/api/dms/send", withAuth(msgH.SendDirect))                // POST send a direct message
+	mux.HandleFunc("/ws", chatH.WS)                                           // WS ?roomId=1&token=<token> (roomId optional for DMs only)
 
 	// Apply middleware
 	handler := withCORS(loggingMiddleware(mux))
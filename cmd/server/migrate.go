@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"chat-backend/config"
+	"chat-backend/repository"
+)
+
+const migrateUsage = "usage: server migrate [up | down [N] | status]"
+
+// runMigrate implements the `migrate` subcommand against the database
+// selected by STORAGE.
+func runMigrate(cfg config.Config, args []string) error {
+	var db *repository.DB
+	var err error
+	switch cfg.Storage {
+	case "sqlite":
+		db, err = repository.ConnectSQLite(cfg.DBPath)
+	case "postgres":
+		db, err = repository.ConnectPostgres(cfg.DatabaseURL)
+	default:
+		return fmt.Errorf("STORAGE=%q has no schema to migrate (expected sqlite or postgres)", cfg.Storage)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q; %s", args[1], migrateUsage)
+			}
+		}
+		rolledBack, err := db.MigrateDown(steps)
+		for _, m := range rolledBack {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown command %q; %s", cmd, migrateUsage)
+	}
+	return nil
+}
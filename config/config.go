@@ -3,30 +3,176 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Port         string
-	JWTSecret    string
-	JWTExpiry    int // in hours
-	LogLevel     string
+	Port             string
+	JWTSecret        string   // HS256 signing secret, used without JWTKeysDir
+	JWTKeysDir       string   // directory of RS256/EdDSA private keys; the newest signs
+	JWTKeyGrace      int      // minutes a rotated-out key still verifies tokens
+	JWTIssuer        string   // iss claim
+	JWTAudience      string   // aud claim
+	AccessTTL        int      // access token lifetime in minutes
+	SessionTTL       int      // days a session lasts without being refreshed
+	LoginBackoff     int      // failed logins for a username before each retry is delayed
+	LoginLockout     int      // failed logins that lock a username out
+	IPLoginBackoff   int      // the same for one IP address, across usernames
+	IPLoginLockout   int      // failed logins that lock an IP address out
+	LockoutTTL       int      // minutes a lockout lasts and failures are remembered
+	ResetTokenTTL    int      // password reset token lifetime in minutes
+	ChallengeTTL     int      // minutes to enter a two-factor code after the password
+	TOTPIssuer       string   // name authenticator apps show for accounts
+	ResetURL         string   // page that takes a reset token; the token is added as ?token=
+	AdminUsers       []string // usernames allowed to reset other users' passwords
+	Notifier         string   // how account messages are delivered: "log" or "smtp"
+	SMTPAddr         string   // host:port
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         string
+	OIDCProviders    []OIDCProvider
+	OIDCRedirectURL  string // page providers send users back to; it posts the code to /api/oidc/callback
+	OIDCLoginTTL     int    // minutes to log in at a provider and come back
+	LogLevel         string
 	MaxMessageLength int
+	MaxReactions     int    // distinct emojis allowed on one message
+	Storage          string // "memory", "sqlite" or "postgres"
+	DBPath           string // SQLite database file
+	DatabaseURL      string // PostgreSQL connection string
+	BlobStore        string // where uploads are kept: "local" or "s3"
+	BlobDir          string // directory for the local blob store
+	S3Endpoint       string // e.g. https://s3.amazonaws.com or http://localhost:9000 for MinIO
+	S3Bucket         string
+	S3Region         string
+	S3AccessKey      string
+	S3SecretKey      string
+	MaxUploadSize    int      // bytes per uploaded file
+	UploadTypes      []string // allowed MIME types; "image/*" allows a whole family
+	ImageWorkers     int      // goroutines making thumbnails
+	ImageQueueSize   int      // images waiting for a worker before new ones are skipped
+	ThumbnailSize    int      // longest side of a thumbnail in pixels
+}
+
+// OIDCProvider is an OpenID Connect identity provider users can log in with.
+type OIDCProvider struct {
+	ID           string // short name used in the API and in variable names
+	Name         string // shown to users
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client
+	Scopes       []string
 }
 
 func Load() Config {
 	port := getEnv("PORT", "8081")
 	secret := getEnv("JWT_SECRET", "dev-super-secret-change-me")
-	jwtExpiry := getEnvAsInt("JWT_EXPIRY", 24)
+	jwtKeysDir := getEnv("JWT_KEYS_DIR", "")
+	jwtKeyGrace := getEnvAsInt("JWT_KEY_GRACE", 60)
+	jwtIssuer := getEnv("JWT_ISSUER", "chat-backend")
+	jwtAudience := getEnv("JWT_AUDIENCE", "chat-backend")
+	accessTTL := getEnvAsInt("ACCESS_TOKEN_TTL", 15)
+	sessionTTL := getEnvAsInt("SESSION_TTL", 30)
+	loginBackoff := getEnvAsInt("LOGIN_BACKOFF_AFTER", 3)
+	loginLockout := getEnvAsInt("LOGIN_LOCKOUT_AFTER", 10)
+	ipLoginBackoff := getEnvAsInt("LOGIN_IP_BACKOFF_AFTER", 20)
+	ipLoginLockout := getEnvAsInt("LOGIN_IP_LOCKOUT_AFTER", 100)
+	lockoutTTL := getEnvAsInt("LOGIN_LOCKOUT_TTL", 15)
+	resetTokenTTL := getEnvAsInt("RESET_TOKEN_TTL", 30)
+	resetURL := getEnv("RESET_URL", "")
+	challengeTTL := getEnvAsInt("LOGIN_CHALLENGE_TTL", 5)
+	totpIssuer := getEnv("TOTP_ISSUER", "Chat")
+	adminUsers := getEnvAsList("ADMIN_USERS", "")
+	notifier := getEnv("NOTIFIER", "log")
+	smtpAddr := getEnv("SMTP_ADDR", "localhost:25")
+	smtpUsername := getEnv("SMTP_USERNAME", "")
+	smtpPassword := getEnv("SMTP_PASSWORD", "")
+	smtpFrom := getEnv("SMTP_FROM", "Chat <no-reply@localhost>")
+	oidcProviders := loadOIDCProviders()
+	oidcRedirectURL := getEnv("OIDC_REDIRECT_URL", "")
+	oidcLoginTTL := getEnvAsInt("OIDC_LOGIN_TTL", 10)
 	logLevel := getEnv("LOG_LEVEL", "info")
 	maxMsgLen := getEnvAsInt("MAX_MESSAGE_LENGTH", 1000)
-	
+	maxReactions := getEnvAsInt("MAX_REACTIONS", 20)
+	storage := getEnv("STORAGE", "memory")
+	dbPath := getEnv("DB_PATH", "chat.db")
+	databaseURL := getEnv("DATABASE_URL", "")
+	blobStore := getEnv("BLOB_STORE", "local")
+	blobDir := getEnv("BLOB_DIR", "uploads")
+	s3Endpoint := getEnv("S3_ENDPOINT", "")
+	s3Bucket := getEnv("S3_BUCKET", "")
+	s3Region := getEnv("S3_REGION", "us-east-1")
+	s3AccessKey := getEnv("S3_ACCESS_KEY", "")
+	s3SecretKey := getEnv("S3_SECRET_KEY", "")
+	maxUploadSize := getEnvAsInt("MAX_UPLOAD_SIZE", 10<<20)
+	uploadTypes := getEnvAsList("UPLOAD_TYPES", "image/*,application/pdf,text/plain")
+	imageWorkers := getEnvAsInt("IMAGE_WORKERS", 2)
+	imageQueueSize := getEnvAsInt("IMAGE_QUEUE_SIZE", 100)
+	thumbnailSize := getEnvAsInt("THUMBNAIL_SIZE", 320)
+
 	return Config{
-		Port:         port,
-		JWTSecret:    secret,
-		JWTExpiry:    jwtExpiry,
-		LogLevel:     logLevel,
+		Port:             port,
+		JWTSecret:        secret,
+		JWTKeysDir:       jwtKeysDir,
+		JWTKeyGrace:      jwtKeyGrace,
+		JWTIssuer:        jwtIssuer,
+		JWTAudience:      jwtAudience,
+		AccessTTL:        accessTTL,
+		SessionTTL:       sessionTTL,
+		LoginBackoff:     loginBackoff,
+		LoginLockout:     loginLockout,
+		IPLoginBackoff:   ipLoginBackoff,
+		IPLoginLockout:   ipLoginLockout,
+		LockoutTTL:       lockoutTTL,
+		ResetTokenTTL:    resetTokenTTL,
+		ResetURL:         resetURL,
+		ChallengeTTL:     challengeTTL,
+		TOTPIssuer:       totpIssuer,
+		AdminUsers:       adminUsers,
+		Notifier:         notifier,
+		SMTPAddr:         smtpAddr,
+		SMTPUsername:     smtpUsername,
+		SMTPPassword:     smtpPassword,
+		SMTPFrom:         smtpFrom,
+		OIDCProviders:    oidcProviders,
+		OIDCRedirectURL:  oidcRedirectURL,
+		OIDCLoginTTL:     oidcLoginTTL,
+		LogLevel:         logLevel,
 		MaxMessageLength: maxMsgLen,
+		MaxReactions:     maxReactions,
+		Storage:          storage,
+		DBPath:           dbPath,
+		DatabaseURL:      databaseURL,
+		BlobStore:        blobStore,
+		BlobDir:          blobDir,
+		S3Endpoint:       s3Endpoint,
+		S3Bucket:         s3Bucket,
+		S3Region:         s3Region,
+		S3AccessKey:      s3AccessKey,
+		S3SecretKey:      s3SecretKey,
+		MaxUploadSize:    maxUploadSize,
+		UploadTypes:      uploadTypes,
+		ImageWorkers:     imageWorkers,
+		ImageQueueSize:   imageQueueSize,
+		ThumbnailSize:    thumbnailSize,
+	}
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS. Each is set
+// up with variables named after it, e.g. OIDC_CORP_ISSUER for "corp".
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, id := range getEnvAsList("OIDC_PROVIDERS", "") {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		providers = append(providers, OIDCProvider{
+			ID:           id,
+			Name:         getEnv(prefix+"NAME", id),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       getEnvAsList(prefix+"SCOPES", "openid,email,profile"),
+		})
 	}
+	return providers
 }
 
 func getEnv(key, defaultValue string) string {
@@ -44,3 +190,14 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsList splits a comma-separated variable, dropping empty entries.
+func getEnvAsList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
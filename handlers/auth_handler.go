@@ -3,16 +3,22 @@ package handlers
 import (
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 
+	"chat-backend/models"
 	"chat-backend/services"
 )
 
 type AuthHandler struct {
-	svc *services.AuthService
+	svc  *services.AuthService
+	oidc *services.OIDCService
 }
 
-func NewAuthHandler(s *services.AuthService) *AuthHandler { return &AuthHandler{svc: s} }
+func NewAuthHandler(s *services.AuthService, oidc *services.OIDCService) *AuthHandler {
+	return &AuthHandler{svc: s, oidc: oidc}
+}
 
 type ErrorResponse struct {
 	Error   string `json:"error"`
@@ -37,6 +43,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Email    string `json:"email"` // optional, for password resets
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -49,24 +56,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.svc.Register(req.Username, req.Password)
+	user, err := h.svc.Register(req.Username, req.Password, req.Email)
 	if err != nil {
 		respondWithError(w, "Registration failed", err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	token, err := h.svc.CreateToken(user.ID, user.Username)
+	tokens, err := h.svc.StartSession(user, clientInfo(r))
 	if err != nil {
 		respondWithError(w, "Token creation failed", "Could not create authentication token", http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]interface{}{
-		"token": token,
-		"user":  user,
-	}
-
-	respondWithSuccess(w, response)
+	respondWithSuccess(w, tokenResponse(tokens, user))
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -94,18 +96,650 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, user, err := h.svc.Login(req.Username, req.Password)
+	result, err := h.svc.Login(req.Username, req.Password, clientInfo(r))
+	if seconds, ok := services.RetryAfter(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		respondWithError(w, "Too many attempts", err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		respondWithError(w, "Authentication failed", err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	respondWithLogin(w, result)
+}
+
+// Finish a login that needs a second factor: POST {"challenge": "...",
+// "code": "123456"}. The code can also be a recovery code.
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	tokens, user, err := h.svc.CompleteLogin(req.Challenge, req.Code, clientInfo(r))
+	if err != nil {
+		respondWithError(w, "Authentication failed", err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	respondWithSuccess(w, tokenResponse(tokens, user))
+}
+
+// The identity providers users can log in with: GET
+func (h *AuthHandler) OIDCProviders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondWithSuccess(w, h.oidc.Providers())
+}
+
+// Start logging in at an identity provider: POST {"provider": "corp"}.
+// Returns the "authorization_url" to send the user to, and the "state" they
+// will come back with.
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := h.oidc.StartLogin(req.Provider)
+	if err != nil {
+		respondWithServiceError(w, "Login failed", err)
+		return
+	}
+
+	respondWithSuccess(w, redirect)
+}
+
+// Finish a login at an identity provider with what it sent the user back
+// with: POST {"state": "...", "code": "..."}. Replies like a password login.
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.oidc.FinishLogin(req.State, req.Code, clientInfo(r))
 	if err != nil {
 		respondWithError(w, "Authentication failed", err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	response := map[string]interface{}{
-		"token": token,
-		"user":  user,
+	respondWithLogin(w, result)
+}
+
+// Trade a refresh token for a new access and refresh token: POST
+// {"refresh_token": "..."}. Each refresh token works once.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.svc.Refresh(req.RefreshToken)
+	if err != nil {
+		respondWithError(w, "Refresh failed", err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	respondWithSuccess(w, tokens)
+}
+
+// End the caller's session: POST. Its tokens stop working and its WebSocket
+// connections are closed.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RevokeSession(userID, r.Header.Get("X-Session-ID")); err != nil {
+		respondWithServiceError(w, "Logout failed", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Logged out"})
+}
+
+// List my active sessions: GET. The one making the request has "current": true.
+func (h *AuthHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := h.svc.ListSessions(userID, r.Header.Get("X-Session-ID"))
+	if err != nil {
+		respondWithError(w, "Internal error", "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithSuccess(w, sessions)
+}
+
+// Revoke one of my sessions: POST {"session_id": "..."}
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" {
+		respondWithError(w, "Invalid JSON", "session_id is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RevokeSession(userID, req.SessionID); err != nil {
+		respondWithServiceError(w, "Failed to revoke session", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Session revoked"})
+}
+
+// Revoke all my sessions except the one making the request: POST
+func (h *AuthHandler) LogoutOthers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := h.svc.RevokeOtherSessions(userID, r.Header.Get("X-Session-ID"))
+	if err != nil {
+		respondWithServiceError(w, "Failed to revoke sessions", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]int{"revoked": revoked})
+}
+
+// Change my password: POST {"current_password": "...", "new_password": "..."}.
+// My other sessions are ended.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.ChangePassword(userID, r.Header.Get("X-Session-ID"), req.CurrentPassword, req.NewPassword); err != nil {
+		respondWithServiceError(w, "Failed to change password", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Password changed"})
+}
+
+// Set the address my password resets go to: POST {"email": "...",
+// "password": "..."}. An empty email removes it.
+func (h *AuthHandler) SetEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.SetEmail(userID, req.Password, req.Email); err != nil {
+		respondWithServiceError(w, "Failed to set email", err)
+		return
 	}
 
-	respondWithSuccess(w, response)
+	respondWithSuccess(w, map[string]string{"message": "Email updated"})
+}
+
+// Ask for a password reset token: POST {"username": "..."}. The reply is the
+// same whether or not the account exists.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RequestPasswordReset(req.Username); err != nil {
+		respondWithError(w, "Reset failed", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "If the account has an email address, a reset token has been sent to it"})
+}
+
+// Choose a new password with a reset token: POST {"token": "...",
+// "new_password": "..."}. All sessions of the account are ended.
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.ResetPassword(req.Token, req.NewPassword); err != nil {
+		respondWithError(w, "Reset failed", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Password reset, please log in"})
+}
+
+// Admins only: reset another user's password: POST {"username": "..."}. The
+// user is logged out and sent a reset token; if it could not be sent, it is
+// in the reply as "reset_token" for the admin to pass on.
+func (h *AuthHandler) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		respondWithError(w, "Invalid JSON", "username is required", http.StatusBadRequest)
+		return
+	}
+
+	adminID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.svc.AdminResetPassword(adminID, req.Username)
+	if err != nil {
+		respondWithServiceError(w, "Reset failed", err)
+		return
+	}
+
+	resp := map[string]interface{}{"sent": token == ""}
+	if token != "" {
+		resp["reset_token"] = token
+	}
+	respondWithSuccess(w, resp)
+}
+
+// Whether I have two-factor authentication on: GET
+func (h *AuthHandler) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.svc.TwoFactorStatus(userID)
+	if err != nil {
+		respondWithError(w, "Internal error", "Failed to load two-factor status", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithSuccess(w, status)
+}
+
+// Start turning on two-factor authentication: POST {"password": "..."}.
+// Returns the TOTP "secret" and an otpauth:// "uri" for authenticator apps.
+func (h *AuthHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.svc.EnrollTOTP(userID, req.Password)
+	if err != nil {
+		respondWithServiceError(w, "Failed to set up two-factor authentication", err)
+		return
+	}
+
+	respondWithSuccess(w, enrollment)
+}
+
+// Turn two-factor authentication on with the first code from the app: POST
+// {"code": "123456"}. Returns the recovery codes, which are not shown again.
+func (h *AuthHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.svc.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		respondWithServiceError(w, "Failed to confirm two-factor authentication", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string][]string{"recovery_codes": codes})
+}
+
+// Replace my recovery codes: POST {"code": "123456"}
+func (h *AuthHandler) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.svc.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		respondWithServiceError(w, "Failed to replace recovery codes", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string][]string{"recovery_codes": codes})
+}
+
+// Turn two-factor authentication off: POST {"password": "...", "code": "..."}
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.DisableTwoFactor(userID, req.Password, req.Code); err != nil {
+		respondWithServiceError(w, "Failed to turn off two-factor authentication", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Two-factor authentication turned off"})
+}
+
+// The identity provider accounts linked to mine: GET
+func (h *AuthHandler) Identities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := h.oidc.Identities(userID)
+	if err != nil {
+		respondWithError(w, "Internal error", "Failed to list identities", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithSuccess(w, identities)
+}
+
+// Start linking my account at an identity provider, to log in through it:
+// POST {"provider": "corp"}. Replies like /api/oidc/login.
+func (h *AuthHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := h.oidc.StartLink(userID, req.Provider)
+	if err != nil {
+		respondWithServiceError(w, "Failed to link identity", err)
+		return
+	}
+
+	respondWithSuccess(w, redirect)
+}
+
+// Finish linking my account at an identity provider: POST {"state": "...",
+// "code": "..."}. Returns the linked identity.
+func (h *AuthHandler) LinkCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.oidc.FinishLink(userID, req.State, req.Code)
+	if err != nil {
+		respondWithServiceError(w, "Failed to link identity", err)
+		return
+	}
+
+	respondWithSuccess(w, identity)
+}
+
+// The public keys access tokens are signed with, as a JSON Web Key Set: GET.
+// Served as is rather than in the usual envelope, for JWT libraries to read.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.svc.JWKS())
+}
+
+// clientInfo describes the client making r. The IP is the address of the
+// connection itself, as there is no trusted proxy to take a forwarded one
+// from.
+func clientInfo(r *http.Request) services.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return services.ClientInfo{UserAgent: r.UserAgent(), IP: ip}
+}
+
+// tokenResponse is the reply to a login or registration.
+func tokenResponse(tokens *services.TokenPair, user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user":          user,
+	}
+}
+
+// respondWithLogin replies to a successful first login step with tokens, or
+// with the challenge to answer when a two-factor code is needed.
+func respondWithLogin(w http.ResponseWriter, result *services.LoginResult) {
+	if result.Challenge != nil {
+		respondWithSuccess(w, map[string]interface{}{
+			"two_factor_required": true,
+			"challenge":           result.Challenge.Token,
+			"expires_in":          result.Challenge.ExpiresIn,
+		})
+		return
+	}
+	respondWithSuccess(w, tokenResponse(result.Tokens, result.User))
 }
 
 func respondWithError(w http.ResponseWriter, error, message string, statusCode int) {
@@ -117,6 +751,16 @@ func respondWithError(w http.ResponseWriter, error, message string, statusCode i
 	})
 }
 
+// respondWithServiceError reports a service error as 403 when the caller is
+// not allowed to do something, and as a 400 otherwise.
+func respondWithServiceError(w http.ResponseWriter, error string, err error) {
+	if services.IsForbidden(err) {
+		respondWithError(w, "Forbidden", err.Error(), http.StatusForbidden)
+		return
+	}
+	respondWithError(w, error, err.Error(), http.StatusBadRequest)
+}
+
 func respondWithSuccess(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"chat-backend/config"
+	"chat-backend/repository"
+	"chat-backend/services"
+	"chat-backend/utils"
+)
+
+func TestLoginTooManyAttempts(t *testing.T) {
+	cfg := config.Config{JWTIssuer: "chat-test", JWTAudience: "chat-test", AccessTTL: 15, SessionTTL: 30,
+		LoginBackoff: 1, LoginLockout: 2, IPLoginBackoff: 20, IPLoginLockout: 100, LockoutTTL: 15}
+	auth := services.NewAuthService(repository.NewInMemoryUserRepo(), repository.NewInMemorySessionRepo(),
+		repository.NewInMemoryPasswordResetRepo(), repository.NewInMemoryTwoFactorRepo(), services.LogNotifier{},
+		utils.NewSecretKeyRing("test-secret"), noConns{}, &cfg)
+	if _, err := auth.Register("alice", "correct horse", ""); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	h := NewAuthHandler(auth, nil)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"username": "alice", "password": "` + password + `"}`
+		rec := httptest.NewRecorder()
+		h.Login(rec, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body)))
+		return rec
+	}
+
+	// The second failure locks the username out for LockoutTTL minutes
+	for i := 0; i < 2; i++ {
+		if rec := login("wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d: status %d", i, rec.Code)
+		}
+	}
+	for _, password := range []string{"wrong", "correct horse"} {
+		rec := login(password)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%q while locked out: status %d, want 429", password, rec.Code)
+		}
+		if seconds, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || seconds <= 0 || seconds > 15*60 {
+			t.Errorf("Retry-After = %q, want up to 900 seconds", rec.Header().Get("Retry-After"))
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Error != "Too many attempts" {
+			t.Errorf("body = %+v, %v", resp, err)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"chat-backend/models"
 	"chat-backend/services"
 	"chat-backend/ws"
 )
@@ -21,33 +23,7 @@ func NewChatHandler(h *ws.Hub, c *services.ChatService, a *services.AuthService,
 	return &ChatHandler{hub: h, chatSvc: c, authSvc: a, msgSvc: m}
 }
 
-// Middleware for authentication
-func (h *ChatHandler) WithAuth(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			// Fallback for WebSocket clients
-			proto := r.Header.Get("Sec-WebSocket-Protocol")
-			if proto != "" {
-				token = proto
-			}
-		}
-		if token == "" {
-			respondWithError(w, "Unauthorized", "Missing Authorization header (token only)", http.StatusUnauthorized)
-			return
-		}
-		uid, uname, err := h.authSvc.ParseToken(token)
-		if err != nil {
-			respondWithError(w, "Unauthorized", "Invalid token", http.StatusUnauthorized)
-			return
-		}
-		r.Header.Set("X-User-ID", strconv.Itoa(uid))
-		r.Header.Set("X-Username", uname)
-		next(w, r)
-	}
-}
-
-// List chat rooms
+// List chat rooms with the caller's unread and mention counts
 func (h *ChatHandler) Rooms(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
@@ -69,7 +45,13 @@ func (h *ChatHandler) Rooms(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithSuccess(w, rooms)
+	summaries, err := h.msgSvc.SummarizeRooms(userID, rooms)
+	if err != nil {
+		respondWithError(w, "Internal error", "Failed to count unread messages", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithSuccess(w, summaries)
 }
 
 // Create a chat room
@@ -139,7 +121,7 @@ func (h *ChatHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.chatSvc.DeleteRoom(roomID, userID); err != nil {
-		respondWithError(w, "Room deletion failed", err.Error(), http.StatusBadRequest)
+		respondWithServiceError(w, "Room deletion failed", err)
 		return
 	}
 
@@ -180,25 +162,404 @@ func (h *ChatHandler) JoinViaInvite(w http.ResponseWriter, r *http.Request) {
 
 	room, err := h.chatSvc.JoinRoomByInvite(req.InviteCode, userID)
 	if err != nil {
-		respondWithError(w, "Join failed", err.Error(), http.StatusBadRequest)
+		respondWithServiceError(w, "Join failed", err)
 		return
 	}
 
 	respondWithSuccess(w, room)
 }
 
+// List room members with their roles and presence: GET ?roomId=2
+func (h *ChatHandler) Members(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	members, err := h.chatSvc.ListMembers(roomID, userID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to list members", err)
+		return
+	}
+
+	respondWithSuccess(w, members)
+}
+
+// Promote or demote a member: PUT {"room_id": 2, "user_id": 5, "role": "moderator"}
+func (h *ChatHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		respondWithError(w, "Method not allowed", "Use PUT method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RoomID int         `json:"room_id"`
+		UserID int         `json:"user_id"`
+		Role   models.Role `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	membership, err := h.chatSvc.SetMemberRole(req.RoomID, userID, req.UserID, req.Role)
+	if err != nil {
+		respondWithServiceError(w, "Failed to change role", err)
+		return
+	}
+
+	respondWithSuccess(w, membership)
+}
+
+// Hand the room to another member: POST {"room_id": 2, "user_id": 5}
+func (h *ChatHandler) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RoomID int `json:"room_id"`
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.chatSvc.TransferOwnership(req.RoomID, userID, req.UserID); err != nil {
+		respondWithServiceError(w, "Failed to transfer ownership", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Ownership transferred"})
+}
+
+// Create an invite: POST {"room_id": 2, "expires_in_minutes": 1440, "max_uses": 10}
+func (h *ChatHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RoomID           int `json:"room_id"`
+		ExpiresInMinutes int `json:"expires_in_minutes"` // 0 never expires
+		MaxUses          int `json:"max_uses"`           // 0 unlimited
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.chatSvc.CreateInvite(req.RoomID, userID, time.Duration(req.ExpiresInMinutes)*time.Minute, req.MaxUses)
+	if err != nil {
+		respondWithServiceError(w, "Failed to create invite", err)
+		return
+	}
+
+	respondWithSuccess(w, invite)
+}
+
+// List a room's active invites with usage: GET ?roomId=2
+func (h *ChatHandler) Invites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	invites, err := h.chatSvc.ListInvites(roomID, userID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to list invites", err)
+		return
+	}
+
+	respondWithSuccess(w, invites)
+}
+
+// Revoke an invite: POST {"invite_id": 7}
+func (h *ChatHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	inviteID, userID, ok := decodeInviteAction(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.chatSvc.RevokeInvite(inviteID, userID); err != nil {
+		respondWithServiceError(w, "Failed to revoke invite", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Invite revoked"})
+}
+
+// Replace an invite with a new code: POST {"invite_id": 7}
+func (h *ChatHandler) RotateInvite(w http.ResponseWriter, r *http.Request) {
+	inviteID, userID, ok := decodeInviteAction(w, r)
+	if !ok {
+		return
+	}
+
+	invite, err := h.chatSvc.RotateInvite(inviteID, userID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to rotate invite", err)
+		return
+	}
+
+	respondWithSuccess(w, invite)
+}
+
+// decodeInviteAction parses {"invite_id": 7} and the acting user's ID,
+// writing the error response itself when either is invalid.
+func decodeInviteAction(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return 0, 0, false
+	}
+
+	var req struct {
+		InviteID int `json:"invite_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return req.InviteID, userID, true
+}
+
+// moderationRequest is the body shared by the kick, ban and mute endpoints.
+type moderationRequest struct {
+	RoomID          int    `json:"room_id"`
+	UserID          int    `json:"user_id"`
+	Reason          string `json:"reason"`
+	DurationMinutes int    `json:"duration_minutes"` // bans and mutes only; 0 means until lifted
+}
+
+// decodeModeration parses a moderation request and the acting user's ID,
+// writing the error response itself when either is invalid.
+func decodeModeration(w http.ResponseWriter, r *http.Request) (moderationRequest, int, bool) {
+	var req moderationRequest
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return req, 0, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return req, 0, false
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return req, 0, false
+	}
+	return req, userID, true
+}
+
+// Kick a member out of a room: POST {"room_id": 2, "user_id": 5, "reason": "spam"}
+func (h *ChatHandler) Kick(w http.ResponseWriter, r *http.Request) {
+	req, userID, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.chatSvc.Kick(req.RoomID, userID, req.UserID); err != nil {
+		respondWithServiceError(w, "Failed to kick member", err)
+		return
+	}
+
+	h.removeFromRoom(req.RoomID, req.UserID, "member_kicked", "kicked", userID, req.Reason, nil)
+	respondWithSuccess(w, map[string]string{"message": "Member kicked"})
+}
+
+// Ban a member from a room: POST {"room_id": 2, "user_id": 5, "reason": "spam", "duration_minutes": 60}
+func (h *ChatHandler) Ban(w http.ResponseWriter, r *http.Request) {
+	req, userID, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+
+	ban, err := h.chatSvc.Ban(req.RoomID, userID, req.UserID, req.Reason, time.Duration(req.DurationMinutes)*time.Minute)
+	if err != nil {
+		respondWithServiceError(w, "Failed to ban member", err)
+		return
+	}
+
+	h.removeFromRoom(req.RoomID, req.UserID, "member_banned", "banned", userID, req.Reason, ban.ExpiresAt)
+	respondWithSuccess(w, ban)
+}
+
+// Lift a ban: POST {"room_id": 2, "user_id": 5}
+func (h *ChatHandler) Unban(w http.ResponseWriter, r *http.Request) {
+	req, userID, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.chatSvc.Unban(req.RoomID, userID, req.UserID); err != nil {
+		respondWithServiceError(w, "Failed to unban member", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Member unbanned"})
+}
+
+// Stop a member from posting: POST {"room_id": 2, "user_id": 5, "reason": "cool off", "duration_minutes": 10}
+func (h *ChatHandler) Mute(w http.ResponseWriter, r *http.Request) {
+	req, userID, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+
+	mute, err := h.chatSvc.Mute(req.RoomID, userID, req.UserID, req.Reason, time.Duration(req.DurationMinutes)*time.Minute)
+	if err != nil {
+		respondWithServiceError(w, "Failed to mute member", err)
+		return
+	}
+
+	payload := map[string]any{
+		"room_id": req.RoomID,
+		"user_id": req.UserID,
+		"by":      userID,
+		"reason":  req.Reason,
+	}
+	if mute.ExpiresAt != nil {
+		payload["expires_at"] = mute.ExpiresAt.UnixMilli()
+	}
+	h.hub.BroadcastEvent(req.RoomID, "member_muted", payload)
+
+	respondWithSuccess(w, mute)
+}
+
+// Lift a mute: POST {"room_id": 2, "user_id": 5}
+func (h *ChatHandler) Unmute(w http.ResponseWriter, r *http.Request) {
+	req, userID, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.chatSvc.Unmute(req.RoomID, userID, req.UserID); err != nil {
+		respondWithServiceError(w, "Failed to unmute member", err)
+		return
+	}
+
+	h.hub.BroadcastEvent(req.RoomID, "member_unmuted", map[string]any{
+		"room_id": req.RoomID,
+		"user_id": req.UserID,
+		"by":      userID,
+	})
+	respondWithSuccess(w, map[string]string{"message": "Member unmuted"})
+}
+
+// Active bans and mutes in a room: GET ?roomId=2
+func (h *ChatHandler) Restrictions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	restrictions, err := h.chatSvc.ListRestrictions(roomID, userID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to list restrictions", err)
+		return
+	}
+
+	respondWithSuccess(w, restrictions)
+}
+
+// removeFromRoom closes a kicked or banned user's connections to the room and
+// tells the remaining members.
+func (h *ChatHandler) removeFromRoom(roomID, targetID int, event, action string, actorID int, reason string, expiresAt *time.Time) {
+	closeReason := action
+	if reason != "" {
+		closeReason += ": " + reason
+	}
+	h.hub.DisconnectUser(roomID, targetID, closeReason)
+
+	payload := map[string]any{
+		"room_id": roomID,
+		"user_id": targetID,
+		"by":      actorID,
+		"reason":  reason,
+	}
+	if expiresAt != nil {
+		payload["expires_at"] = expiresAt.UnixMilli()
+	}
+	h.hub.BroadcastEvent(roomID, event, payload)
+}
+
 // WebSocket handler
 func (h *ChatHandler) WS(w http.ResponseWriter, r *http.Request) {
 	log.Printf("WebSocket connection attempt from %s", r.RemoteAddr)
 	log.Printf("Request headers: %+v", r.Header)
 	log.Printf("Request URL: %s", r.URL.String())
 
+	// roomId is optional: without it the connection only receives direct messages
 	roomIDStr := r.URL.Query().Get("roomId")
-	if roomIDStr == "" {
-		log.Printf("WebSocket connection rejected: missing roomId parameter")
-		respondWithError(w, "Missing parameter", "roomId query parameter is required", http.StatusBadRequest)
-		return
-	}
 
 	// Extract token from URL query parameters for WebSocket connections
 	token := r.URL.Query().Get("token")
@@ -208,35 +569,39 @@ func (h *ChatHandler) WS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate the token
-	uid, uname, err := h.authSvc.ParseToken(token)
+	// Validate the token and that its session has not been revoked
+	id, err := h.authSvc.ParseToken(token)
 	if err != nil {
 		log.Printf("WebSocket connection rejected: invalid token - %v", err)
 		respondWithError(w, "Unauthorized", "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	uid, uname := id.UserID, id.Username
+
+	roomID := 0
+	if roomIDStr != "" {
+		roomID, err = strconv.Atoi(roomIDStr)
+		if err != nil || roomID <= 0 {
+			log.Printf("WebSocket connection rejected: invalid roomId '%s'", roomIDStr)
+			respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
+			return
+		}
 
-	roomID, err := strconv.Atoi(roomIDStr)
-	if err != nil {
-		log.Printf("WebSocket connection rejected: invalid roomId '%s' - %v", roomIDStr, err)
-		respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
-		return
-	}
-
-	// Check if user can access this room
-	canAccess, err := h.chatSvc.CanUserAccessRoom(roomID, uid)
-	if err != nil {
-		log.Printf("WebSocket connection rejected: error checking room access - %v", err)
-		respondWithError(w, "Access error", "Failed to verify room access", http.StatusInternalServerError)
-		return
-	}
+		// Check if user can access this room
+		canAccess, err := h.chatSvc.CanUserAccessRoom(roomID, uid)
+		if err != nil {
+			log.Printf("WebSocket connection rejected: error checking room access - %v", err)
+			respondWithError(w, "Access error", "Failed to verify room access", http.StatusInternalServerError)
+			return
+		}
 
-	if !canAccess {
-		log.Printf("WebSocket connection rejected: user %s (ID: %d) cannot access room %d", uname, uid, roomID)
-		respondWithError(w, "Access denied", "You don't have access to this room", http.StatusForbidden)
-		return
+		if !canAccess {
+			log.Printf("WebSocket connection rejected: user %s (ID: %d) cannot access room %d", uname, uid, roomID)
+			respondWithError(w, "Access denied", "You don't have access to this room", http.StatusForbidden)
+			return
+		}
 	}
 
 	log.Printf("WebSocket connection validated for user %s (ID: %d) in room %d", uname, uid, roomID)
-	h.hub.ServeWS(w, r, roomID, uid, uname, h.msgSvc)
+	h.hub.ServeWS(w, r, roomID, uid, uname, id.SessionID, h.msgSvc)
 }
@@ -1,37 +1,26 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"chat-backend/models"
 	"chat-backend/services"
 )
 
 type MessageHandler struct {
-	svc     *services.MessageService
-	authSvc *services.AuthService
+	svc *services.MessageService
 }
 
-func NewMessageHandler(s *services.MessageService, a *services.AuthService) *MessageHandler {
-	return &MessageHandler{svc: s, authSvc: a}
-}
-
-func (h *MessageHandler) WithAuth(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
-			respondWithError(w, "Unauthorized", "Missing Authorization header (token only)", http.StatusUnauthorized)
-			return
-		}
-		uid, _, err := h.authSvc.ParseToken(token)
-		if err != nil {
-			respondWithError(w, "Unauthorized", "Invalid token", http.StatusUnauthorized)
-			return
-		}
-		// stash user id in context if you want; for now pass along header
-		r.Header.Set("X-User-ID", strconv.Itoa(uid))
-		next(w, r)
-	}
+func NewMessageHandler(s *services.MessageService) *MessageHandler {
+	return &MessageHandler{svc: s}
 }
 
 func (h *MessageHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
@@ -39,19 +28,19 @@ func (h *MessageHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
 		return
 	}
-	
+
 	roomIDStr := r.URL.Query().Get("roomId")
 	if roomIDStr == "" {
 		respondWithError(w, "Missing parameter", "roomId query parameter is required", http.StatusBadRequest)
 		return
 	}
-	
+
 	roomID, err := strconv.Atoi(roomIDStr)
 	if err != nil {
 		respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
 		return
 	}
-	
+
 	// Get limit from query params, default to 50
 	limitStr := r.URL.Query().Get("limit")
 	limit := 50
@@ -60,12 +49,737 @@ func (h *MessageHandler) ListMessages(w http.ResponseWriter, r *http.Request) {
 			limit = parsedLimit
 		}
 	}
-	
-	msgs, err := h.svc.List(roomID, limit)
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var cursor services.MessageCursor
+	for name, dst := range map[string]*int{"before": &cursor.Before, "after": &cursor.After, "around": &cursor.Around} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			respondWithError(w, "Invalid parameter", name+" must be a valid message id", http.StatusBadRequest)
+			return
+		}
+		*dst = id
+	}
+
+	page, err := h.svc.List(userID, roomID, cursor, limit)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch messages", err)
+		return
+	}
+
+	respondWithSuccess(w, page)
+}
+
+// Open a direct conversation with another user, returning them and the recent history
+func (h *MessageHandler) OpenDirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.Username == "" {
+		respondWithError(w, "Missing username", "Username is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	other, msgs, err := h.svc.OpenDirect(userID, req.Username, 50)
+	if err != nil {
+		respondWithError(w, "Failed to open conversation", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondWithSuccess(w, map[string]interface{}{
+		"user":     other,
+		"messages": msgs,
+	})
+}
+
+// List the caller's direct conversations with their latest message
+func (h *MessageHandler) ListDirectThreads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	threads, err := h.svc.ListDirectThreads(userID)
+	if err != nil {
+		respondWithError(w, "Internal error", "Failed to list conversations", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithSuccess(w, threads)
+}
+
+// Direct message history with one user: GET ?userId=2&limit=50
+func (h *MessageHandler) ListDirectMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	otherID, err := strconv.Atoi(r.URL.Query().Get("userId"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "userId must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	msgs, err := h.svc.ListDirect(userID, otherID, limit)
 	if err != nil {
 		respondWithError(w, "Failed to fetch messages", err.Error(), http.StatusBadRequest)
 		return
 	}
-	
+
 	respondWithSuccess(w, msgs)
 }
+
+// Send a direct message: POST {"receiver_id": 2, "content": "hi"}
+func (h *MessageHandler) SendDirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ReceiverID int    `json:"receiver_id"`
+		Content    string `json:"content"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.svc.SendDirect(userID, req.ReceiverID, req.Content)
+	if err != nil {
+		respondWithError(w, "Failed to send message", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondWithSuccess(w, msg)
+}
+
+// Edit one of your own messages: PUT {"id": 42, "content": "fixed typo"}
+func (h *MessageHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		respondWithError(w, "Method not allowed", "Use PUT method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.svc.Edit(userID, req.ID, req.Content)
+	if err != nil {
+		respondWithServiceError(w, "Failed to edit message", err)
+		return
+	}
+
+	respondWithSuccess(w, msg)
+}
+
+// Delete a message: DELETE ?id=42
+func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", "Use DELETE method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "Message id must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.Delete(userID, messageID); err != nil {
+		respondWithServiceError(w, "Failed to delete message", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Message deleted successfully"})
+}
+
+// Edit history of a message: GET ?id=42
+func (h *MessageHandler) MessageEdits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "Message id must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	edits, err := h.svc.ListEdits(userID, messageID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch edit history", err)
+		return
+	}
+
+	respondWithSuccess(w, edits)
+}
+
+// Send a room message or thread reply: POST {"room_id": 1, "content": "hi"}
+// or {"thread_id": 42, "content": "reply"}
+func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RoomID   int    `json:"room_id"`
+		ThreadID int    `json:"thread_id"`
+		Content  string `json:"content"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.RoomID == 0 && req.ThreadID == 0 {
+		respondWithError(w, "Missing room", "room_id or thread_id is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var msg *models.Message
+	if req.ThreadID != 0 {
+		msg, err = h.svc.Reply(userID, req.ThreadID, req.Content)
+	} else {
+		msg, err = h.svc.Send(req.RoomID, userID, req.Content)
+	}
+	if err != nil {
+		respondWithServiceError(w, "Failed to send message", err)
+		return
+	}
+
+	respondWithSuccess(w, msg)
+}
+
+// Send a message with files: POST multipart/form-data with room_id,
+// thread_id or receiver_id, an optional content field and one or more "file"
+// parts
+func (h *MessageHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.svc.MaxUploadRequestSize())
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, "Upload too large", "The request is larger than the upload limits allow", http.StatusRequestEntityTooLarge)
+			return
+		}
+		respondWithError(w, "Invalid form", "Expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var roomID, threadID, receiverID int
+	for name, dst := range map[string]*int{"room_id": &roomID, "thread_id": &threadID, "receiver_id": &receiverID} {
+		value := r.FormValue(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			respondWithError(w, "Invalid parameter", name+" must be a valid id", http.StatusBadRequest)
+			return
+		}
+		*dst = id
+	}
+	if roomID == 0 && threadID == 0 && receiverID == 0 {
+		respondWithError(w, "Missing destination", "room_id, thread_id or receiver_id is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var files []services.FileUpload
+	for _, header := range r.MultipartForm.File["file"] {
+		f, err := header.Open()
+		if err != nil {
+			respondWithError(w, "Invalid form", "Could not read "+header.Filename, http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		files = append(files, services.FileUpload{Name: header.Filename, Size: header.Size, Body: f})
+	}
+
+	content := r.FormValue("content")
+	var msg *models.Message
+	switch {
+	case receiverID != 0:
+		msg, err = h.svc.SendDirect(userID, receiverID, content, files...)
+	case threadID != 0:
+		msg, err = h.svc.Reply(userID, threadID, content, files...)
+	default:
+		msg, err = h.svc.Send(roomID, userID, content, files...)
+	}
+	if err != nil {
+		respondWithServiceError(w, "Failed to send message", err)
+		return
+	}
+
+	respondWithSuccess(w, msg)
+}
+
+// Download an attachment: GET ?id=7. Images are shown inline, anything else
+// is downloaded.
+func (h *MessageHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "Attachment id must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	att, body, err := h.svc.OpenAttachment(userID, attachmentID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch attachment", err)
+		return
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(att.ContentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", att.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.FileName}))
+	sendFile(w, att.ID, body)
+}
+
+// Thumbnail of an image attachment: GET ?id=7. Attachments list a
+// thumbnail_type once their thumbnail is ready; until then this fails.
+func (h *MessageHandler) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "Attachment id must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	att, body, err := h.svc.OpenThumbnail(userID, attachmentID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch thumbnail", err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", att.ThumbnailType)
+	w.Header().Set("Content-Disposition", "inline")
+	sendFile(w, att.ID, body)
+}
+
+// sendFile streams an attachment's contents with headers that stop browsers
+// from sniffing or running them.
+func sendFile(w http.ResponseWriter, attachmentID int, body io.Reader) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private")
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Failed to send attachment %d: %v", attachmentID, err)
+	}
+}
+
+// Replies in a thread: GET ?id=42[&after=<msgId>&limit=50]
+func (h *MessageHandler) ListThread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rootID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "Thread id must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	afterID := 0
+	if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+		afterID, err = strconv.Atoi(afterStr)
+		if err != nil || afterID <= 0 {
+			respondWithError(w, "Invalid parameter", "after must be a valid message id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.svc.ListThread(userID, rootID, afterID, limit)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch thread", err)
+		return
+	}
+
+	respondWithSuccess(w, page)
+}
+
+// React to a message: POST {"message_id": 42, "emoji": "👍"}
+func (h *MessageHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		MessageID int    `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.AddReaction(userID, req.MessageID, req.Emoji); err != nil {
+		respondWithServiceError(w, "Failed to add reaction", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Reaction added"})
+}
+
+// Take back a reaction: DELETE ?message_id=42&emoji=%F0%9F%91%8D
+func (h *MessageHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		respondWithError(w, "Method not allowed", "Use DELETE method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	messageID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "message_id must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RemoveReaction(userID, messageID, r.URL.Query().Get("emoji")); err != nil {
+		respondWithServiceError(w, "Failed to remove reaction", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]string{"message": "Reaction removed"})
+}
+
+// Mark a room read up to a message: POST {"room_id": 2, "message_id": 42};
+// leave out message_id to mark everything read
+func (h *MessageHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RoomID    int `json:"room_id"`
+		MessageID int `json:"message_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	marker, err := h.svc.MarkRead(userID, req.RoomID, req.MessageID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to mark as read", err)
+		return
+	}
+
+	respondWithSuccess(w, marker)
+}
+
+// How far each member has read a room: GET ?roomId=2
+func (h *MessageHandler) ReadMarkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	markers, err := h.svc.ListReadMarkers(userID, roomID)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch read markers", err)
+		return
+	}
+
+	respondWithSuccess(w, markers)
+}
+
+// My recent mentions across rooms: GET ?unread=true&limit=50
+func (h *MessageHandler) ListMentions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0 // the service's default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	mentions, err := h.svc.ListMentions(userID, limit, unreadOnly)
+	if err != nil {
+		respondWithServiceError(w, "Failed to fetch mentions", err)
+		return
+	}
+
+	respondWithSuccess(w, mentions)
+}
+
+// Mark mentions read: POST {"ids": [3, 4]}; leave out ids to mark them all read
+func (h *MessageHandler) MarkMentionsRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondWithError(w, "Method not allowed", "Use POST method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, "Invalid JSON", "Bad request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	marked, err := h.svc.MarkMentionsRead(userID, req.IDs)
+	if err != nil {
+		respondWithServiceError(w, "Failed to mark mentions read", err)
+		return
+	}
+
+	respondWithSuccess(w, map[string]int{"marked": marked})
+}
+
+// Search messages in rooms I can access:
+// GET ?q=deploy failed&roomId=2&senderId=5&from=2024-01-01&to=2024-01-31&limit=20&offset=0
+func (h *MessageHandler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, "Method not allowed", "Use GET method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("X-User-ID"))
+	if err != nil {
+		respondWithError(w, "Invalid user", "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	var filter services.SearchFilter
+	if v := query.Get("roomId"); v != "" {
+		if filter.RoomID, err = strconv.Atoi(v); err != nil {
+			respondWithError(w, "Invalid parameter", "roomId must be a valid number", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("senderId"); v != "" {
+		if filter.SenderID, err = strconv.Atoi(v); err != nil {
+			respondWithError(w, "Invalid parameter", "senderId must be a valid number", http.StatusBadRequest)
+			return
+		}
+	}
+	if filter.From, err = parseSearchTime(query.Get("from"), false); err != nil {
+		respondWithError(w, "Invalid parameter", "from must be a date (2006-01-02) or RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseSearchTime(query.Get("to"), true); err != nil {
+		respondWithError(w, "Invalid parameter", "to must be a date (2006-01-02) or RFC 3339 time", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	results, err := h.svc.Search(userID, query.Get("q"), filter, limit, offset)
+	if err != nil {
+		respondWithServiceError(w, "Search failed", err)
+		return
+	}
+
+	respondWithSuccess(w, results)
+}
+
+// parseSearchTime reads an RFC 3339 time or a UTC date. A date used as the
+// end of a range includes that whole day.
+func parseSearchTime(v string, endOfRange bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfRange {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
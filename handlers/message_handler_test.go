@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+	"chat-backend/services"
+)
+
+type nopHub struct{}
+
+func (nopHub) BroadcastMessage(models.Message, string)    {}
+func (nopHub) BroadcastDirect(models.Message, string)     {}
+func (nopHub) BroadcastEvent(int, string, map[string]any) {}
+func (nopHub) SendToUsers([]int, string, map[string]any)  {}
+
+type noPresence struct{}
+
+func (noPresence) Presence(int) models.Presence { return models.PresenceOffline }
+func (noPresence) RoomUsers(int) map[int]string { return nil }
+
+func TestPrivateRoomMessagesForbidOutsiders(t *testing.T) {
+	users, chats, memberships := repository.NewInMemoryUserRepo(), repository.NewInMemoryChatRepo(), repository.NewInMemoryMembershipRepo()
+	blobs, err := repository.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("blob store: %v", err)
+	}
+	svc := services.NewMessageService(repository.NewInMemoryMessageRepo(), repository.NewInMemoryReactionRepo(),
+		repository.NewInMemoryReadMarkerRepo(), repository.NewInMemoryMentionRepo(), repository.NewInMemorySearchIndex(),
+		repository.NewInMemoryAttachmentRepo(), blobs, chats, memberships, repository.NewInMemoryRestrictionRepo(),
+		users, nopHub{}, noPresence{}, &config.Config{MaxMessageLength: 1000, MaxReactions: 20})
+	h := NewMessageHandler(svc)
+
+	owner, err := users.Create("owner", "hash", "")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	outsider, err := users.Create("outsider", "hash", "")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	room, err := chats.Create("secret", true, owner.ID)
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	if err := memberships.AddMember(room.ID, owner.ID, models.RoleOwner); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	roomID := strconv.Itoa(room.ID)
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+	}{
+		{"list", h.ListMessages, http.MethodGet, "/api/messages?roomId=" + roomID, ""},
+		{"send", h.SendMessage, http.MethodPost, "/api/messages/send", `{"room_id":` + roomID + `,"content":"hi"}`},
+	} {
+		for _, user := range []*models.User{owner, outsider} {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("X-User-ID", strconv.Itoa(user.ID))
+			rec := httptest.NewRecorder()
+			tc.handler(rec, req)
+
+			want := http.StatusOK
+			if user == outsider {
+				want = http.StatusForbidden
+			}
+			if rec.Code != want {
+				t.Errorf("%s as %s: status = %d, want %d (%s)", tc.name, user.Username, rec.Code, want, rec.Body)
+			}
+		}
+	}
+}
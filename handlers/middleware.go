@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chat-backend/services"
+)
+
+// WithAuth returns the middleware every authenticated route goes through. It
+// lets a request in only with a valid access token and passes the caller on
+// to the handler in the X-User-ID, X-Username and X-Session-ID headers.
+func WithAuth(auth *services.AuthService) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("Authorization")
+			if token == "" {
+				// Fallback for WebSocket clients, which cannot set headers
+				token = r.Header.Get("Sec-WebSocket-Protocol")
+			}
+			if token == "" {
+				respondWithError(w, "Unauthorized", "Missing Authorization header (token only)", http.StatusUnauthorized)
+				return
+			}
+			id, err := auth.ParseToken(token)
+			if err != nil {
+				respondWithError(w, "Unauthorized", "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			r.Header.Set("X-User-ID", strconv.Itoa(id.UserID))
+			r.Header.Set("X-Username", id.Username)
+			r.Header.Set("X-Session-ID", id.SessionID)
+			next(w, r)
+		}
+	}
+}
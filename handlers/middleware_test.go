@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"chat-backend/config"
+	"chat-backend/repository"
+	"chat-backend/services"
+	"chat-backend/utils"
+)
+
+type noConns struct{}
+
+func (noConns) DisconnectSession(int, string) {}
+
+func TestWithAuth(t *testing.T) {
+	cfg := config.Config{JWTIssuer: "chat-test", JWTAudience: "chat-test", AccessTTL: 15, SessionTTL: 30,
+		LoginBackoff: 3, LoginLockout: 10, IPLoginBackoff: 20, IPLoginLockout: 100, LockoutTTL: 15}
+	auth := services.NewAuthService(repository.NewInMemoryUserRepo(), repository.NewInMemorySessionRepo(),
+		repository.NewInMemoryPasswordResetRepo(), repository.NewInMemoryTwoFactorRepo(), services.LogNotifier{},
+		utils.NewSecretKeyRing("test-secret"), noConns{}, &cfg)
+	user, err := auth.Register("alice", "correct horse", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	login, err := auth.Login("alice", "correct horse", services.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	token := login.Tokens.AccessToken
+
+	var seen http.Header
+	handler := WithAuth(auth)(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	})
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"invalid token", "Authorization", "not-a-token", http.StatusUnauthorized},
+		{"Authorization header", "Authorization", token, http.StatusOK},
+		{"WebSocket protocol", "Sec-WebSocket-Protocol", token, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodGet, "/api/rooms", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			// Headers a client sends itself must not survive
+			req.Header.Set("X-User-ID", "999")
+
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.status)
+			}
+			if tc.status != http.StatusOK {
+				if seen != nil {
+					t.Error("the handler ran without a valid token")
+				}
+				return
+			}
+			if seen.Get("X-User-ID") != strconv.Itoa(user.ID) || seen.Get("X-Username") != "alice" || seen.Get("X-Session-ID") == "" {
+				t.Errorf("handler saw X-User-ID %q, X-Username %q, X-Session-ID %q",
+					seen.Get("X-User-ID"), seen.Get("X-Username"), seen.Get("X-Session-ID"))
+			}
+		})
+	}
+}
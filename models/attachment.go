@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded with a message. Its bytes live in blob
+// storage under Key; clients download it by ID.
+//
+// Width and Height are set for PNG, JPEG and GIF images when they are
+// uploaded. Placeholder (a BlurHash) and ThumbnailType are filled in once the
+// image has been processed in the background; ThumbnailType is empty until a
+// thumbnail exists.
+type Attachment struct {
+	ID            int       `json:"id"`
+	MessageID     int       `json:"message_id"`
+	RoomID        int       `json:"-"` // 0 for direct messages
+	UploaderID    int       `json:"uploader_id"`
+	FileName      string    `json:"file_name"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	Width         int       `json:"width,omitempty"`
+	Height        int       `json:"height,omitempty"`
+	Placeholder   string    `json:"placeholder,omitempty"`
+	ThumbnailType string    `json:"thumbnail_type,omitempty"`
+	Key           string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -1,31 +1,62 @@
 package models
 
-import (
-	"crypto/rand"
-	"encoding/hex"
-	"time"
-)
+import "time"
 
 type ChatRoom struct {
 	ID         int       `json:"id"`
 	Name       string    `json:"name"`
 	IsPrivate  bool      `json:"is_private"`
-	InviteCode string    `json:"invite_code,omitempty"`
+	InviteCode string    `json:"invite_code,omitempty"` // first invite, only returned when a private room is created
 	CreatedBy  int       `json:"created_by"`
 	CreatedAt  time.Time `json:"created_at"`
 }
 
-// GenerateInviteCode generates a secure random invite code
-func GenerateInviteCode() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+// RoomSummary is a room as listed for one user, with what they haven't read
+type RoomSummary struct {
+	ChatRoom
+	LastReadID   int `json:"last_read_id"`
+	UnreadCount  int `json:"unread_count"`  // top-level messages from others after LastReadID
+	MentionCount int `json:"mention_count"` // unread mentions of the user, thread replies included
 }
 
-// RoomMembership represents user access to private rooms
+// RoomMembership represents a user's place in a room: access to private
+// rooms and the user's role in any room
 type RoomMembership struct {
 	ID       int       `json:"id"`
 	RoomID   int       `json:"room_id"`
 	UserID   int       `json:"user_id"`
-	JoinedAt time.Time `json:"joined_at"`
+	Username string    `json:"username,omitempty"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at,omitzero"`
+	Presence Presence  `json:"presence,omitempty"` // only filled in member listings
+}
+
+// Role is a member's rank within one room
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	RoleMember    Role = "member"
+)
+
+// Rank orders roles from member (1) up to owner (4). Unknown roles rank 0.
+func (r Role) Rank() int {
+	switch r {
+	case RoleOwner:
+		return 4
+	case RoleAdmin:
+		return 3
+	case RoleModerator:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// Outranks reports whether r is strictly above other
+func (r Role) Outranks(other Role) bool {
+	return r.Rank() > other.Rank()
 }
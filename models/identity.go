@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// ExternalIdentity links a user to their account at an OpenID Connect
+// provider, which is named by the provider's subject identifier. Logging in
+// through the provider logs in as the linked user.
+type ExternalIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email,omitempty"` // as the provider knew it when linked
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin is a login through an identity provider that is waiting for the
+// user to come back from it. It is found by a hash of its state parameter,
+// and holds what is needed to finish it: the PKCE code verifier and the
+// nonce the ID token has to carry.
+type OIDCLogin struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	LinkUserID   int // when set, the identity is linked to this user instead of logging in
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	UsedAt       *time.Time
+}
+
+// ActiveAt reports whether the login can still be finished at t
+func (l *OIDCLogin) ActiveAt(t time.Time) bool {
+	return l.UsedAt == nil && t.Before(l.ExpiresAt)
+}
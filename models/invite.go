@@ -0,0 +1,43 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Invite lets whoever holds its code join a private room, until it expires,
+// runs out of uses or is revoked
+type Invite struct {
+	ID        int        `json:"id"`
+	RoomID    int        `json:"room_id"`
+	Code      string     `json:"code"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses"` // 0 means unlimited
+	Uses      int        `json:"uses"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Expired reports whether the invite's expiry time has passed at t
+func (i *Invite) Expired(t time.Time) bool {
+	return i.ExpiresAt != nil && !t.Before(*i.ExpiresAt)
+}
+
+// Exhausted reports whether the invite has no uses left
+func (i *Invite) Exhausted() bool {
+	return i.MaxUses > 0 && i.Uses >= i.MaxUses
+}
+
+// ActiveAt reports whether the invite can still be redeemed at t
+func (i *Invite) ActiveAt(t time.Time) bool {
+	return i.RevokedAt == nil && !i.Expired(t) && !i.Exhausted()
+}
+
+// GenerateInviteCode generates a secure random invite code
+func GenerateInviteCode() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
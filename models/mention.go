@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// MentionKind says how a user was mentioned
+type MentionKind string
+
+const (
+	MentionUser MentionKind = "user" // @username
+	MentionRoom MentionKind = "room" // @room, everyone in the room
+	MentionHere MentionKind = "here" // @here, everyone in the room who is online
+)
+
+// Mention records that a message mentioned a user. ReadAt is set once the
+// user has seen it, from the mentions inbox or by reading past it in the room.
+type Mention struct {
+	ID        int         `json:"id"`
+	MessageID int         `json:"message_id"`
+	RoomID    int         `json:"room_id"`
+	ThreadID  int         `json:"thread_id,omitempty"`
+	UserID    int         `json:"user_id"`
+	SenderID  int         `json:"sender_id"`
+	Kind      MentionKind `json:"kind"`
+	CreatedAt time.Time   `json:"created_at"`
+	ReadAt    *time.Time  `json:"read_at,omitempty"`
+	// Filled in for the mentions inbox
+	RoomName string   `json:"room_name,omitempty"`
+	Message  *Message `json:"message,omitempty"`
+}
@@ -10,6 +10,45 @@ type Message struct {
 	// optional for 1-to-1 chat
 	RoomID int `json:"room_id,omitempty"`
 	// optional for group chat
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	// set when the message was deleted; Content is cleared at that point
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// ID of the thread root this message replies to, 0 for top-level messages
+	ThreadID int `json:"thread_id,omitempty"`
+	// thread summary, filled in on root messages when listing a room
+	ReplyCount  int        `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+	// aggregated emoji reactions, filled in when listing messages
+	Reactions []ReactionSummary `json:"reactions,omitempty"`
+	// files uploaded with the message
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// IsDeleted reports whether the message has been deleted.
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// ThreadSummary describes the replies to a thread root.
+type ThreadSummary struct {
+	ReplyCount  int
+	LastReplyAt time.Time
+}
+
+// MessageEdit records the content a message had before one of its edits.
+type MessageEdit struct {
+	ID         int       `json:"id"`
+	MessageID  int       `json:"message_id"`
+	EditorID   int       `json:"editor_id"`
+	OldContent string    `json:"old_content"`
+	EditedAt   time.Time `json:"edited_at"`
+}
+
+// DirectThread summarises a 1-to-1 conversation from one participant's side.
+type DirectThread struct {
+	UserID      int     `json:"user_id"`
+	Username    string  `json:"username"`
+	LastMessage Message `json:"last_message"`
 }
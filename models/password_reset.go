@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// PasswordReset is a single-use token that lets a user set a new password
+// without knowing the old one. Only a hash of the token is stored.
+type PasswordReset struct {
+	Hash      string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// ActiveAt reports whether the token can still be used at t
+func (p *PasswordReset) ActiveAt(t time.Time) bool {
+	return p.UsedAt == nil && t.Before(p.ExpiresAt)
+}
@@ -0,0 +1,10 @@
+package models
+
+// Presence is a user's availability, combined across all their connections
+type Presence string
+
+const (
+	PresenceOnline  Presence = "online"  // connected and recently active
+	PresenceAway    Presence = "away"    // connected but idle
+	PresenceOffline Presence = "offline" // no open connections
+)
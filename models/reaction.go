@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Reaction is one user's emoji reaction to a message.
+type Reaction struct {
+	MessageID int       `json:"message_id"`
+	UserID    int       `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReactionSummary aggregates the reactions with one emoji on a message, from
+// the point of view of the user listing it.
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"me"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ReadMarker is how far a user has read a room: every top-level message up to
+// and including LastReadID has been seen
+type ReadMarker struct {
+	RoomID     int       `json:"room_id"`
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username,omitempty"`
+	LastReadID int       `json:"last_read_id"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
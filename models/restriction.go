@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// RestrictionKind says what a room restriction stops a user from doing
+type RestrictionKind string
+
+const (
+	RestrictionBan  RestrictionKind = "ban"  // no access to the room, cannot rejoin
+	RestrictionMute RestrictionKind = "mute" // can read the room but not post
+)
+
+// Restriction bans or mutes a user in one room, until ExpiresAt when set
+type Restriction struct {
+	RoomID    int             `json:"room_id"`
+	UserID    int             `json:"user_id"`
+	Username  string          `json:"username,omitempty"`
+	Kind      RestrictionKind `json:"kind"`
+	Reason    string          `json:"reason,omitempty"`
+	CreatedBy int             `json:"created_by"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// ActiveAt reports whether the restriction is still in force at t
+func (r *Restriction) ActiveAt(t time.Time) bool {
+	return r.ExpiresAt == nil || t.Before(*r.ExpiresAt)
+}
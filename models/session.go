@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Session is one login. Access tokens carry its ID in their jti claim and
+// are only honoured while it is active; its refresh tokens keep it going
+// until it expires or is revoked.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Current    bool       `json:"current,omitempty"` // the session of the caller's token
+}
+
+// ActiveAt reports whether the session can still be used at t
+func (s *Session) ActiveAt(t time.Time) bool {
+	return s.RevokedAt == nil && t.Before(s.ExpiresAt)
+}
+
+// RefreshToken gets its session a new access token, once. Only a hash of
+// the token is kept, and used tokens are kept until they expire so that a
+// replayed one can be recognised.
+type RefreshToken struct {
+	Hash      string
+	SessionID string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// TOTPCredential is the authenticator app secret of a user with two-factor
+// authentication. It only protects logins once it has been confirmed with a
+// first code.
+type TOTPCredential struct {
+	UserID      int
+	Secret      string // base32, as shown to the user
+	LastStep    int64  // time step of the last accepted code, so each code works once
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+}
+
+// Confirmed reports whether enrollment has been finished.
+func (c *TOTPCredential) Confirmed() bool {
+	return c.ConfirmedAt != nil
+}
+
+// LoginChallenge is handed out by the first step of a login that needs a
+// second factor, and traded with a code for tokens in the second step. Only a
+// hash of it is stored.
+type LoginChallenge struct {
+	Hash      string
+	UserID    int
+	Attempts  int // wrong codes entered so far
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// ActiveAt reports whether the challenge can still be answered at t
+func (c *LoginChallenge) ActiveAt(t time.Time) bool {
+	return c.UsedAt == nil && t.Before(c.ExpiresAt)
+}
@@ -3,8 +3,10 @@ package models
 import "time"
 
 type User struct {
-    ID        int       `json:"id"`
-    Username  string    `json:"username"`
-    Password  string    `json:"-"` 
-    CreatedAt time.Time `json:"created_at"`
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	Password         string    `json:"-"`
+	Email            string    `json:"-"` // where password resets are sent; optional
+	TokensValidAfter time.Time `json:"-"` // access tokens issued earlier are rejected
+	CreatedAt        time.Time `json:"created_at"`
 }
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"errors"
+	"sync"
+
+	"chat-backend/models"
+)
+
+// AttachmentRepository stores the metadata of files uploaded with messages.
+type AttachmentRepository interface {
+	Save(att *models.Attachment) (*models.Attachment, error)
+	FindByID(id int) (*models.Attachment, error)
+	// ListByMessages returns the attachments of the given messages in upload
+	// order.
+	ListByMessages(messageIDs []int) ([]models.Attachment, error)
+	ListByRoom(roomID int) ([]models.Attachment, error)
+	// SetPreview records the placeholder and thumbnail type of a processed
+	// image.
+	SetPreview(id int, placeholder, thumbnailType string) error
+	DeleteByMessage(messageID int) error
+	DeleteByRoom(roomID int) error
+}
+
+type InMemoryAttachmentRepo struct {
+	mu   sync.RWMutex
+	seq  int
+	data map[int]*models.Attachment
+	byM  map[int][]int // message ID -> attachment IDs in upload order
+}
+
+func NewInMemoryAttachmentRepo() *InMemoryAttachmentRepo {
+	return &InMemoryAttachmentRepo{
+		data: make(map[int]*models.Attachment),
+		byM:  make(map[int][]int),
+	}
+}
+
+func (r *InMemoryAttachmentRepo) Save(att *models.Attachment) (*models.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	saved := *att
+	saved.ID = r.seq
+	r.data[saved.ID] = &saved
+	r.byM[saved.MessageID] = append(r.byM[saved.MessageID], saved.ID)
+	out := saved
+	return &out, nil
+}
+
+func (r *InMemoryAttachmentRepo) FindByID(id int) (*models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	att, ok := r.data[id]
+	if !ok {
+		return nil, errors.New("attachment not found")
+	}
+	out := *att
+	return &out, nil
+}
+
+func (r *InMemoryAttachmentRepo) ListByMessages(messageIDs []int) ([]models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var atts []models.Attachment
+	for _, messageID := range messageIDs {
+		for _, id := range r.byM[messageID] {
+			atts = append(atts, *r.data[id])
+		}
+	}
+	return atts, nil
+}
+
+func (r *InMemoryAttachmentRepo) ListByRoom(roomID int) ([]models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var atts []models.Attachment
+	for _, att := range r.data {
+		if att.RoomID == roomID {
+			atts = append(atts, *att)
+		}
+	}
+	return atts, nil
+}
+
+func (r *InMemoryAttachmentRepo) SetPreview(id int, placeholder, thumbnailType string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	att, ok := r.data[id]
+	if !ok {
+		return errors.New("attachment not found")
+	}
+	att.Placeholder = placeholder
+	att.ThumbnailType = thumbnailType
+	return nil
+}
+
+func (r *InMemoryAttachmentRepo) DeleteByMessage(messageID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range r.byM[messageID] {
+		delete(r.data, id)
+	}
+	delete(r.byM, messageID)
+	return nil
+}
+
+func (r *InMemoryAttachmentRepo) DeleteByRoom(roomID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, att := range r.data {
+		if att.RoomID == roomID {
+			delete(r.data, id)
+			delete(r.byM, att.MessageID)
+		}
+	}
+	return nil
+}
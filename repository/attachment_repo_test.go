@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestAttachmentRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		msg := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "files"})
+		direct := mustMessage(t, r, models.Message{SenderID: alice.ID, ReceiverID: bob.ID, Content: "file"})
+
+		attach := func(msg *models.Message, key string) *models.Attachment {
+			t.Helper()
+			att, err := r.attachments.Save(&models.Attachment{MessageID: msg.ID, RoomID: msg.RoomID, UploaderID: alice.ID,
+				FileName: key + ".png", ContentType: "image/png", Size: 1234, Width: 64, Height: 48, Key: key, CreatedAt: testNow})
+			if err != nil {
+				t.Fatalf("Save %s: %v", key, err)
+			}
+			return att
+		}
+		a1 := attach(msg, "k1")
+		a2 := attach(msg, "k2")
+		a3 := attach(direct, "k3")
+		if a1.ID == 0 || a1.ID == a2.ID {
+			t.Fatalf("Save assigned IDs %d and %d", a1.ID, a2.ID)
+		}
+
+		found, err := r.attachments.FindByID(a1.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		if !found.CreatedAt.Equal(testNow) {
+			t.Errorf("CreatedAt = %v, want %v", found.CreatedAt, testNow)
+		}
+		found.CreatedAt = a1.CreatedAt
+		if *found != *a1 {
+			t.Errorf("FindByID = %+v, want %+v", found, a1)
+		}
+		if found, _ := r.attachments.FindByID(a3.ID); found.RoomID != 0 {
+			t.Errorf("attachment of a direct message has room %d", found.RoomID)
+		}
+		if _, err := r.attachments.FindByID(a3.ID + 1); err == nil {
+			t.Error("FindByID found an attachment that does not exist")
+		}
+
+		atts, err := r.attachments.ListByMessages([]int{msg.ID})
+		if err != nil || len(atts) != 2 || atts[0].ID != a1.ID || atts[1].ID != a2.ID {
+			t.Errorf("ListByMessages = %+v, %v", atts, err)
+		}
+		if atts, _ := r.attachments.ListByMessages([]int{msg.ID, direct.ID}); len(atts) != 3 {
+			t.Errorf("ListByMessages of two messages = %d attachments", len(atts))
+		}
+		if atts, err := r.attachments.ListByMessages(nil); err != nil || len(atts) != 0 {
+			t.Errorf("ListByMessages(nil) = %+v, %v", atts, err)
+		}
+		atts, err = r.attachments.ListByRoom(room.ID)
+		if err != nil || len(atts) != 2 || !sameInts([]int{atts[0].ID, atts[1].ID}, []int{a1.ID, a2.ID}) {
+			t.Errorf("ListByRoom = %+v, %v", atts, err)
+		}
+
+		if err := r.attachments.SetPreview(a1.ID, "LEHV6nWB2yk8", "image/jpeg"); err != nil {
+			t.Fatalf("SetPreview: %v", err)
+		}
+		if found, _ := r.attachments.FindByID(a1.ID); found.Placeholder != "LEHV6nWB2yk8" || found.ThumbnailType != "image/jpeg" {
+			t.Errorf("after SetPreview FindByID = %+v", found)
+		}
+		if err := r.attachments.SetPreview(a3.ID+1, "x", "image/jpeg"); err == nil {
+			t.Error("SetPreview succeeded for a missing attachment")
+		}
+
+		if err := r.attachments.DeleteByMessage(msg.ID); err != nil {
+			t.Fatalf("DeleteByMessage: %v", err)
+		}
+		if atts, _ := r.attachments.ListByMessages([]int{msg.ID, direct.ID}); len(atts) != 1 || atts[0].ID != a3.ID {
+			t.Errorf("after DeleteByMessage ListByMessages = %+v", atts)
+		}
+
+		attach(msg, "k4")
+		if err := r.attachments.DeleteByRoom(room.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if atts, _ := r.attachments.ListByRoom(room.ID); len(atts) != 0 {
+			t.Errorf("after DeleteByRoom ListByRoom = %+v", atts)
+		}
+		if _, err := r.attachments.FindByID(a3.ID); err != nil {
+			t.Errorf("DeleteByRoom removed a direct message's attachment: %v", err)
+		}
+	})
+}
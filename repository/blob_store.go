@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore keeps the bytes of uploaded files. Keys are slash-separated
+// relative paths chosen by the caller.
+type BlobStore interface {
+	// Put stores exactly size bytes read from body under key.
+	Put(key string, body io.Reader, size int64, contentType string) error
+	// Get opens the blob under key, or returns ErrBlobNotFound.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(key string) error
+}
+
+var (
+	ErrBlobNotFound   = errors.New("blob not found")
+	errInvalidBlobKey = errors.New("invalid blob key")
+	errBlobSize       = errors.New("blob is not the declared size")
+)
+
+// LocalBlobStore keeps blobs as files under a directory.
+type LocalBlobStore struct {
+	root string
+}
+
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+// path maps key to a file under the root, refusing keys that would escape it.
+func (s *LocalBlobStore) path(key string) (string, error) {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", errInvalidBlobKey
+	}
+	return filepath.Join(s.root, rel), nil
+}
+
+func (s *LocalBlobStore) Put(key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it into place, so a failed upload
+	// never leaves a partial blob behind
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(body, size+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return errBlobSize
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -13,12 +13,16 @@ type ChatRepository interface {
 	List() ([]models.ChatRoom, error)
 	ListAccessibleRooms(userID int, membershipRepo MembershipRepository) ([]models.ChatRoom, error)
 	FindByID(id int) (*models.ChatRoom, error)
-	FindByInviteCode(inviteCode string) (*models.ChatRoom, error)
-	GetUserCount(roomID int) (int, error)
 	Delete(id int) error
 	CanUserAccess(roomID, userID int, membershipRepo MembershipRepository) (bool, error)
 }
 
+// RoomCascader is implemented by chat repositories whose Delete also removes
+// the room's memberships and messages, e.g. through ON DELETE CASCADE.
+type RoomCascader interface {
+	CascadesRoomDelete() bool
+}
+
 type InMemoryChatRepo struct {
 	mu   sync.RWMutex
 	seq  int
@@ -55,11 +59,6 @@ func (r *InMemoryChatRepo) Create(name string, isPrivate bool, createdBy int) (*
 		CreatedAt: time.Now(),
 	}
 
-	// Generate invite code for private rooms
-	if isPrivate {
-		room.InviteCode = models.GenerateInviteCode()
-	}
-
 	r.data[room.ID] = room
 	return room, nil
 }
@@ -85,14 +84,10 @@ func (r *InMemoryChatRepo) ListAccessibleRooms(userID int, membershipRepo Member
 			// Public rooms are accessible to everyone
 			accessibleRooms = append(accessibleRooms, *room)
 		} else {
-			// Check if user is member of private room or is the creator
-			if room.CreatedBy == userID {
+			// Check if user is member of private room; creators are owners
+			isMember, err := membershipRepo.IsUserMember(room.ID, userID)
+			if err == nil && isMember {
 				accessibleRooms = append(accessibleRooms, *room)
-			} else {
-				isMember, err := membershipRepo.IsUserMember(room.ID, userID)
-				if err == nil && isMember {
-					accessibleRooms = append(accessibleRooms, *room)
-				}
 			}
 		}
 	}
@@ -110,24 +105,6 @@ func (r *InMemoryChatRepo) FindByID(id int) (*models.ChatRoom, error) {
 	return room, nil
 }
 
-func (r *InMemoryChatRepo) GetUserCount(roomID int) (int, error) {
-	// This would need to be implemented with the websocket hub
-	// For now, return 0 as placeholder
-	return 0, nil
-}
-
-func (r *InMemoryChatRepo) FindByInviteCode(inviteCode string) (*models.ChatRoom, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	for _, room := range r.data {
-		if room.InviteCode == inviteCode {
-			return room, nil
-		}
-	}
-	return nil, errors.New("room not found")
-}
-
 func (r *InMemoryChatRepo) CanUserAccess(roomID, userID int, membershipRepo MembershipRepository) (bool, error) {
 	r.mu.RLock()
 	room, ok := r.data[roomID]
@@ -142,12 +119,7 @@ func (r *InMemoryChatRepo) CanUserAccess(roomID, userID int, membershipRepo Memb
 		return true, nil
 	}
 
-	// Creator has access
-	if room.CreatedBy == userID {
-		return true, nil
-	}
-
-	// Check membership for private rooms
+	// Check membership for private rooms; creators join them as owners
 	return membershipRepo.IsUserMember(roomID, userID)
 }
 
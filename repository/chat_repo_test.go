@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestChatRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+
+		general, err := r.chats.Create("general", false, alice.ID)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		secret := mustRoom(t, r, "secret", true, alice.ID)
+		if _, err := r.chats.Create("general", true, bob.ID); err == nil {
+			t.Error("Create accepted a taken room name")
+		}
+		if _, err := r.chats.Create("", false, bob.ID); err == nil {
+			t.Error("Create accepted an empty room name")
+		}
+
+		found, err := r.chats.FindByID(secret.ID)
+		if err != nil || found.Name != "secret" || !found.IsPrivate || found.CreatedBy != alice.ID {
+			t.Errorf("FindByID = %+v, %v", found, err)
+		}
+		if _, err := r.chats.FindByID(secret.ID + 1); err == nil {
+			t.Error("FindByID found a room that does not exist")
+		}
+
+		rooms, err := r.chats.List()
+		if err != nil || len(rooms) != 2 {
+			t.Errorf("List = %v, %v", rooms, err)
+		}
+
+		for _, tc := range []struct {
+			userID int
+			want   []int
+		}{
+			{alice.ID, []int{general.ID, secret.ID}},
+			{bob.ID, []int{general.ID}},
+		} {
+			rooms, err := r.chats.ListAccessibleRooms(tc.userID, r.memberships)
+			if err != nil {
+				t.Fatalf("ListAccessibleRooms(%d): %v", tc.userID, err)
+			}
+			ids := make([]int, len(rooms))
+			for i, room := range rooms {
+				ids[i] = room.ID
+			}
+			if !sameInts(ids, tc.want) {
+				t.Errorf("ListAccessibleRooms(%d) = %v, want %v", tc.userID, ids, tc.want)
+			}
+		}
+
+		for _, tc := range []struct {
+			roomID, userID int
+			want           bool
+		}{
+			{general.ID, bob.ID, true},
+			{secret.ID, alice.ID, true},
+			{secret.ID, bob.ID, false},
+		} {
+			ok, err := r.chats.CanUserAccess(tc.roomID, tc.userID, r.memberships)
+			if err != nil || ok != tc.want {
+				t.Errorf("CanUserAccess(%d, %d) = %v, %v, want %v", tc.roomID, tc.userID, ok, err, tc.want)
+			}
+		}
+		if _, err := r.chats.CanUserAccess(secret.ID+1, alice.ID, r.memberships); err == nil {
+			t.Error("CanUserAccess succeeded for a missing room")
+		}
+		if err := r.memberships.AddMember(secret.ID, bob.ID, models.RoleMember); err != nil {
+			t.Fatalf("AddMember: %v", err)
+		}
+		if ok, _ := r.chats.CanUserAccess(secret.ID, bob.ID, r.memberships); !ok {
+			t.Error("a member cannot access a private room")
+		}
+
+		if err := r.chats.Delete(general.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := r.chats.FindByID(general.ID); err == nil {
+			t.Error("FindByID found a deleted room")
+		}
+		if err := r.chats.Delete(general.ID); err == nil {
+			t.Error("Delete succeeded twice")
+		}
+	})
+}
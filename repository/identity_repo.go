@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// IdentityRepository stores the accounts users have at OpenID Connect
+// providers, and the logins through them that are under way.
+type IdentityRepository interface {
+	// Create links an identity to a user. A provider's subject can be linked
+	// to one user, and a user to one subject per provider.
+	Create(identity *models.ExternalIdentity) error
+	// Find returns the identity for a provider's subject, or nil if it is not
+	// linked to anyone.
+	Find(provider, subject string) (*models.ExternalIdentity, error)
+	// ListByUser returns a user's identities, oldest first.
+	ListByUser(userID int) ([]models.ExternalIdentity, error)
+
+	CreateLogin(login *models.OIDCLogin) error
+	FindLogin(stateHash string) (*models.OIDCLogin, error)
+	// UseLogin marks a login finished, reporting false if it already was, so
+	// that its state can only be redeemed once.
+	UseLogin(stateHash string, usedAt time.Time) (bool, error)
+}
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+type InMemoryIdentityRepo struct {
+	mu         sync.Mutex
+	identities map[identityKey]*models.ExternalIdentity
+	logins     map[string]*models.OIDCLogin // by state hash
+}
+
+func NewInMemoryIdentityRepo() *InMemoryIdentityRepo {
+	return &InMemoryIdentityRepo{
+		identities: make(map[identityKey]*models.ExternalIdentity),
+		logins:     make(map[string]*models.OIDCLogin),
+	}
+}
+
+func (r *InMemoryIdentityRepo) Create(identity *models.ExternalIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := identityKey{identity.Provider, identity.Subject}
+	if _, exists := r.identities[key]; exists {
+		return errors.New("identity already linked")
+	}
+	for _, linked := range r.identities {
+		if linked.UserID == identity.UserID && linked.Provider == identity.Provider {
+			return errors.New("identity already linked")
+		}
+	}
+	stored := *identity
+	r.identities[key] = &stored
+	return nil
+}
+
+func (r *InMemoryIdentityRepo) Find(provider, subject string) (*models.ExternalIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity, ok := r.identities[identityKey{provider, subject}]
+	if !ok {
+		return nil, nil
+	}
+	found := *identity
+	return &found, nil
+}
+
+func (r *InMemoryIdentityRepo) ListByUser(userID int) ([]models.ExternalIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identities := []models.ExternalIdentity{}
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identities = append(identities, *identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+	return identities, nil
+}
+
+func (r *InMemoryIdentityRepo) CreateLogin(login *models.OIDCLogin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.logins[login.StateHash]; exists {
+		return errors.New("login already exists")
+	}
+	stored := *login
+	r.logins[stored.StateHash] = &stored
+	return nil
+}
+
+func (r *InMemoryIdentityRepo) FindLogin(stateHash string) (*models.OIDCLogin, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	login, ok := r.logins[stateHash]
+	if !ok {
+		return nil, errors.New("login not found")
+	}
+	found := *login
+	return &found, nil
+}
+
+func (r *InMemoryIdentityRepo) UseLogin(stateHash string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	login, ok := r.logins[stateHash]
+	if !ok {
+		return false, errors.New("login not found")
+	}
+	if login.UsedAt != nil {
+		return false, nil
+	}
+	login.UsedAt = &usedAt
+	return true, nil
+}
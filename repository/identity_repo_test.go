@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestIdentityRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+
+		google := &models.ExternalIdentity{Provider: "google", Subject: "g-1", UserID: alice.ID, Email: "alice@example.com", CreatedAt: testNow}
+		if err := r.identities.Create(google); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		for _, taken := range []models.ExternalIdentity{
+			{Provider: "google", Subject: "g-1", UserID: bob.ID, CreatedAt: testNow},   // linked to alice
+			{Provider: "google", Subject: "g-2", UserID: alice.ID, CreatedAt: testNow}, // alice has a google account
+		} {
+			if err := r.identities.Create(&taken); err == nil {
+				t.Errorf("Create accepted %+v", taken)
+			}
+		}
+		github := &models.ExternalIdentity{Provider: "github", Subject: "g-1", UserID: alice.ID, CreatedAt: testNow.Add(time.Minute)}
+		if err := r.identities.Create(github); err != nil {
+			t.Fatalf("Create of a second provider: %v", err)
+		}
+
+		found, err := r.identities.Find("google", "g-1")
+		if err != nil || found == nil || found.UserID != alice.ID || found.Email != "alice@example.com" || !found.CreatedAt.Equal(testNow) {
+			t.Errorf("Find = %+v, %v", found, err)
+		}
+		if found, err := r.identities.Find("google", "g-2"); err != nil || found != nil {
+			t.Errorf("Find of an unlinked subject = %+v, %v", found, err)
+		}
+
+		identities, err := r.identities.ListByUser(alice.ID)
+		if err != nil || len(identities) != 2 || identities[0].Provider != "google" || identities[1].Provider != "github" {
+			t.Errorf("ListByUser = %+v, %v, want oldest first", identities, err)
+		}
+		if identities, err := r.identities.ListByUser(bob.ID); err != nil || len(identities) != 0 {
+			t.Errorf("ListByUser without identities = %+v, %v", identities, err)
+		}
+	})
+}
+
+func TestIdentityRepositoryLogins(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+
+		login := &models.OIDCLogin{StateHash: "s1", Provider: "google", Nonce: "n", CodeVerifier: "v",
+			CreatedAt: testNow, ExpiresAt: testNow.Add(10 * time.Minute)}
+		if err := r.identities.CreateLogin(login); err != nil {
+			t.Fatalf("CreateLogin: %v", err)
+		}
+		if err := r.identities.CreateLogin(login); err == nil {
+			t.Error("CreateLogin accepted a taken state")
+		}
+		link := &models.OIDCLogin{StateHash: "s2", Provider: "google", Nonce: "n2", CodeVerifier: "v2", LinkUserID: alice.ID,
+			CreatedAt: testNow, ExpiresAt: testNow.Add(10 * time.Minute)}
+		if err := r.identities.CreateLogin(link); err != nil {
+			t.Fatalf("CreateLogin: %v", err)
+		}
+
+		found, err := r.identities.FindLogin("s1")
+		if err != nil || found.Provider != "google" || found.Nonce != "n" || found.CodeVerifier != "v" || found.LinkUserID != 0 ||
+			!found.ActiveAt(testNow) || found.ActiveAt(testNow.Add(10*time.Minute)) {
+			t.Errorf("FindLogin = %+v, %v", found, err)
+		}
+		if found, _ := r.identities.FindLogin("s2"); found.LinkUserID != alice.ID {
+			t.Errorf("FindLogin of a link has LinkUserID %d, want %d", found.LinkUserID, alice.ID)
+		}
+		if _, err := r.identities.FindLogin("nope"); err == nil {
+			t.Error("FindLogin found a login that does not exist")
+		}
+
+		if used, err := r.identities.UseLogin("s1", testNow); err != nil || !used {
+			t.Errorf("UseLogin = %v, %v", used, err)
+		}
+		if used, err := r.identities.UseLogin("s1", testNow); err != nil || used {
+			t.Errorf("UseLogin twice = %v, %v", used, err)
+		}
+		if found, _ := r.identities.FindLogin("s1"); found.UsedAt == nil || found.ActiveAt(testNow) {
+			t.Errorf("a used login is still active: %+v", found)
+		}
+	})
+}
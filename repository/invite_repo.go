@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+type InviteRepository interface {
+	// Create stores a new invite, filling in its ID.
+	Create(invite *models.Invite) (*models.Invite, error)
+	FindByID(id int) (*models.Invite, error)
+	FindByCode(code string) (*models.Invite, error)
+	ListByRoom(roomID int) ([]models.Invite, error)
+	Revoke(id int, revokedAt time.Time) error
+	// Redeem counts one use of the invite unless it is revoked or has no
+	// uses left, reporting whether it did. Expiry is the caller's to check.
+	Redeem(id int) (bool, error)
+	DeleteByRoom(roomID int) error
+}
+
+type InMemoryInviteRepo struct {
+	mu     sync.RWMutex
+	seq    int
+	data   map[int]*models.Invite
+	byCode map[string]int
+}
+
+func NewInMemoryInviteRepo() *InMemoryInviteRepo {
+	return &InMemoryInviteRepo{
+		data:   make(map[int]*models.Invite),
+		byCode: make(map[string]int),
+	}
+}
+
+func (r *InMemoryInviteRepo) Create(invite *models.Invite) (*models.Invite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.byCode[invite.Code]; exists {
+		return nil, errors.New("invite code already exists")
+	}
+
+	r.seq++
+	stored := *invite
+	stored.ID = r.seq
+	r.data[stored.ID] = &stored
+	r.byCode[stored.Code] = stored.ID
+
+	invite.ID = stored.ID
+	return invite, nil
+}
+
+func (r *InMemoryInviteRepo) FindByID(id int) (*models.Invite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invite, ok := r.data[id]
+	if !ok {
+		return nil, errors.New("invite not found")
+	}
+	found := *invite
+	return &found, nil
+}
+
+func (r *InMemoryInviteRepo) FindByCode(code string) (*models.Invite, error) {
+	r.mu.RLock()
+	id, ok := r.byCode[code]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("invite not found")
+	}
+	return r.FindByID(id)
+}
+
+func (r *InMemoryInviteRepo) ListByRoom(roomID int) ([]models.Invite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invites := []models.Invite{}
+	for _, invite := range r.data {
+		if invite.RoomID == roomID {
+			invites = append(invites, *invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].ID < invites[j].ID
+	})
+	return invites, nil
+}
+
+func (r *InMemoryInviteRepo) Revoke(id int, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invite, ok := r.data[id]
+	if !ok {
+		return errors.New("invite not found")
+	}
+	if invite.RevokedAt == nil {
+		invite.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func (r *InMemoryInviteRepo) Redeem(id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invite, ok := r.data[id]
+	if !ok {
+		return false, errors.New("invite not found")
+	}
+	if invite.RevokedAt != nil || invite.Exhausted() {
+		return false, nil
+	}
+	invite.Uses++
+	return true, nil
+}
+
+func (r *InMemoryInviteRepo) DeleteByRoom(roomID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, invite := range r.data {
+		if invite.RoomID == roomID {
+			delete(r.data, id)
+			delete(r.byCode, invite.Code)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestInviteRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		room := mustRoom(t, r, "secret", true, alice.ID)
+
+		expires := testNow.Add(24 * time.Hour)
+		limited, err := r.invites.Create(&models.Invite{RoomID: room.ID, Code: "limited", CreatedBy: alice.ID,
+			CreatedAt: testNow, ExpiresAt: &expires, MaxUses: 2})
+		if err != nil || limited.ID == 0 {
+			t.Fatalf("Create = %+v, %v", limited, err)
+		}
+		unlimited, err := r.invites.Create(&models.Invite{RoomID: room.ID, Code: "unlimited", CreatedBy: alice.ID, CreatedAt: testNow})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := r.invites.Create(&models.Invite{RoomID: room.ID, Code: "limited", CreatedBy: alice.ID, CreatedAt: testNow}); err == nil {
+			t.Error("Create accepted a taken code")
+		}
+
+		found, err := r.invites.FindByCode("limited")
+		if err != nil || found.ID != limited.ID || found.RoomID != room.ID || found.MaxUses != 2 || found.Uses != 0 ||
+			found.ExpiresAt == nil || !found.ExpiresAt.Equal(expires) || found.RevokedAt != nil {
+			t.Errorf("FindByCode = %+v, %v", found, err)
+		}
+		if found, err := r.invites.FindByID(unlimited.ID); err != nil || found.Code != "unlimited" || found.ExpiresAt != nil {
+			t.Errorf("FindByID = %+v, %v", found, err)
+		}
+		if _, err := r.invites.FindByCode("nope"); err == nil {
+			t.Error("FindByCode found an invite that does not exist")
+		}
+		if _, err := r.invites.FindByID(unlimited.ID + 1); err == nil {
+			t.Error("FindByID found an invite that does not exist")
+		}
+
+		for i, want := range []bool{true, true, false} {
+			if ok, err := r.invites.Redeem(limited.ID); err != nil || ok != want {
+				t.Errorf("Redeem #%d = %v, %v, want %v", i+1, ok, err, want)
+			}
+		}
+		if found, _ := r.invites.FindByID(limited.ID); found.Uses != 2 {
+			t.Errorf("after redeeming Uses = %d, want 2", found.Uses)
+		}
+
+		if err := r.invites.Revoke(unlimited.ID, testNow); err != nil {
+			t.Fatalf("Revoke: %v", err)
+		}
+		if err := r.invites.Revoke(unlimited.ID, testNow.Add(time.Hour)); err != nil {
+			t.Fatalf("Revoke twice: %v", err)
+		}
+		if found, _ := r.invites.FindByID(unlimited.ID); found.RevokedAt == nil || !found.RevokedAt.Equal(testNow) {
+			t.Errorf("RevokedAt = %v, want the first revocation", found.RevokedAt)
+		}
+		if ok, err := r.invites.Redeem(unlimited.ID); err != nil || ok {
+			t.Errorf("Redeem of a revoked invite = %v, %v", ok, err)
+		}
+		if err := r.invites.Revoke(unlimited.ID+1, testNow); err == nil {
+			t.Error("Revoke succeeded for a missing invite")
+		}
+
+		invites, err := r.invites.ListByRoom(room.ID)
+		if err != nil || len(invites) != 2 || invites[0].ID != limited.ID || invites[1].ID != unlimited.ID {
+			t.Errorf("ListByRoom = %+v, %v", invites, err)
+		}
+
+		other := mustRoom(t, r, "other", true, alice.ID)
+		if _, err := r.invites.Create(&models.Invite{RoomID: other.ID, Code: "other", CreatedBy: alice.ID, CreatedAt: testNow}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := r.invites.DeleteByRoom(room.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if invites, err := r.invites.ListByRoom(room.ID); err != nil || len(invites) != 0 {
+			t.Errorf("DeleteByRoom kept %+v (%v)", invites, err)
+		}
+		if _, err := r.invites.FindByCode("limited"); err == nil {
+			t.Error("FindByCode found an invite to a deleted room")
+		}
+		if _, err := r.invites.FindByCode("other"); err != nil {
+			t.Errorf("DeleteByRoom removed another room's invite: %v", err)
+		}
+		if _, err := r.invites.Create(&models.Invite{RoomID: other.ID, Code: "limited", CreatedBy: alice.ID, CreatedAt: testNow}); err != nil {
+			t.Errorf("the code of a deleted invite cannot be reused: %v", err)
+		}
+	})
+}
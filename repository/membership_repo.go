@@ -10,9 +10,16 @@ import (
 )
 
 type MembershipRepository interface {
-	AddMember(roomID, userID int) error
+	// AddMember adds userID to the room with role. Existing members keep
+	// their current role.
+	AddMember(roomID, userID int, role models.Role) error
 	RemoveMember(roomID, userID int) error
 	IsUserMember(roomID, userID int) (bool, error)
+	GetMembership(roomID, userID int) (*models.RoomMembership, error)
+	SetRole(roomID, userID int, role models.Role) error
+	// TransferOwnership makes toID the owner and demotes fromID to admin in
+	// one step, so a room never has zero or two owners.
+	TransferOwnership(roomID, fromID, toID int) error
 	GetRoomMembers(roomID int) ([]int, error)
 	GetUserRooms(userID int) ([]int, error)
 	GetMembershipsByRoom(roomID int) ([]models.RoomMembership, error)
@@ -32,7 +39,7 @@ func NewInMemoryMembershipRepo() *InMemoryMembershipRepo {
 	}
 }
 
-func (r *InMemoryMembershipRepo) AddMember(roomID, userID int) error {
+func (r *InMemoryMembershipRepo) AddMember(roomID, userID int, role models.Role) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -46,6 +53,7 @@ func (r *InMemoryMembershipRepo) AddMember(roomID, userID int) error {
 		ID:       r.seq,
 		RoomID:   roomID,
 		UserID:   userID,
+		Role:     role,
 		JoinedAt: time.Now(),
 	}
 
@@ -80,6 +88,44 @@ func (r *InMemoryMembershipRepo) IsUserMember(roomID, userID int) (bool, error)
 	return exists, nil
 }
 
+func (r *InMemoryMembershipRepo) GetMembership(roomID, userID int) (*models.RoomMembership, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	membershipID, exists := r.byRU[formatRoomUserKey(roomID, userID)]
+	if !exists {
+		return nil, errors.New("membership not found")
+	}
+	membership := *r.data[membershipID]
+	return &membership, nil
+}
+
+func (r *InMemoryMembershipRepo) SetRole(roomID, userID int, role models.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	membershipID, exists := r.byRU[formatRoomUserKey(roomID, userID)]
+	if !exists {
+		return errors.New("membership not found")
+	}
+	r.data[membershipID].Role = role
+	return nil
+}
+
+func (r *InMemoryMembershipRepo) TransferOwnership(roomID, fromID, toID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fromMembershipID, fromExists := r.byRU[formatRoomUserKey(roomID, fromID)]
+	toMembershipID, toExists := r.byRU[formatRoomUserKey(roomID, toID)]
+	if !fromExists || !toExists {
+		return errors.New("membership not found")
+	}
+	r.data[fromMembershipID].Role = models.RoleAdmin
+	r.data[toMembershipID].Role = models.RoleOwner
+	return nil
+}
+
 func (r *InMemoryMembershipRepo) GetRoomMembers(roomID int) ([]int, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
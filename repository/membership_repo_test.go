@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestMembershipRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		carol := mustUser(t, r, "carol")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		other := mustRoom(t, r, "other", false, carol.ID)
+
+		if err := r.memberships.AddMember(room.ID, bob.ID, models.RoleMember); err != nil {
+			t.Fatalf("AddMember: %v", err)
+		}
+		if err := r.memberships.AddMember(room.ID, bob.ID, models.RoleAdmin); err != nil {
+			t.Fatalf("AddMember again: %v", err)
+		}
+		membership, err := r.memberships.GetMembership(room.ID, bob.ID)
+		if err != nil || membership.Role != models.RoleMember || membership.UserID != bob.ID || membership.RoomID != room.ID {
+			t.Errorf("GetMembership = %+v, %v; a second AddMember must keep the role", membership, err)
+		}
+		if _, err := r.memberships.GetMembership(room.ID, carol.ID); err == nil {
+			t.Error("GetMembership found a non-member")
+		}
+
+		if ok, err := r.memberships.IsUserMember(room.ID, bob.ID); err != nil || !ok {
+			t.Errorf("IsUserMember(bob) = %v, %v", ok, err)
+		}
+		if ok, err := r.memberships.IsUserMember(room.ID, carol.ID); err != nil || ok {
+			t.Errorf("IsUserMember(carol) = %v, %v", ok, err)
+		}
+
+		if err := r.memberships.SetRole(room.ID, bob.ID, models.RoleModerator); err != nil {
+			t.Fatalf("SetRole: %v", err)
+		}
+		if membership, _ := r.memberships.GetMembership(room.ID, bob.ID); membership.Role != models.RoleModerator {
+			t.Errorf("after SetRole role = %s", membership.Role)
+		}
+		if err := r.memberships.SetRole(room.ID, carol.ID, models.RoleAdmin); err == nil {
+			t.Error("SetRole succeeded for a non-member")
+		}
+
+		if err := r.memberships.TransferOwnership(room.ID, alice.ID, bob.ID); err != nil {
+			t.Fatalf("TransferOwnership: %v", err)
+		}
+		for userID, want := range map[int]models.Role{alice.ID: models.RoleAdmin, bob.ID: models.RoleOwner} {
+			if membership, _ := r.memberships.GetMembership(room.ID, userID); membership.Role != want {
+				t.Errorf("after TransferOwnership user %d is %s, want %s", userID, membership.Role, want)
+			}
+		}
+		if err := r.memberships.TransferOwnership(room.ID, bob.ID, carol.ID); err == nil {
+			t.Error("TransferOwnership to a non-member succeeded")
+		}
+		if membership, _ := r.memberships.GetMembership(room.ID, bob.ID); membership.Role != models.RoleOwner {
+			t.Errorf("a failed TransferOwnership left the owner as %s", membership.Role)
+		}
+
+		members, err := r.memberships.GetRoomMembers(room.ID)
+		if err != nil || !sameInts(members, []int{alice.ID, bob.ID}) {
+			t.Errorf("GetRoomMembers = %v, %v", members, err)
+		}
+		if err := r.memberships.AddMember(other.ID, bob.ID, models.RoleMember); err != nil {
+			t.Fatalf("AddMember: %v", err)
+		}
+		rooms, err := r.memberships.GetUserRooms(bob.ID)
+		if err != nil || !sameInts(rooms, []int{room.ID, other.ID}) {
+			t.Errorf("GetUserRooms = %v, %v", rooms, err)
+		}
+		memberships, err := r.memberships.GetMembershipsByRoom(room.ID)
+		if err != nil || len(memberships) != 2 {
+			t.Errorf("GetMembershipsByRoom = %v, %v", memberships, err)
+		}
+
+		if err := r.memberships.RemoveMember(room.ID, bob.ID); err != nil {
+			t.Fatalf("RemoveMember: %v", err)
+		}
+		if ok, _ := r.memberships.IsUserMember(room.ID, bob.ID); ok {
+			t.Error("a removed member is still a member")
+		}
+		if err := r.memberships.RemoveMember(room.ID, bob.ID); err == nil {
+			t.Error("RemoveMember succeeded twice")
+		}
+	})
+}
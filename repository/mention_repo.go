@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// MentionRepository stores which users each message mentioned.
+type MentionRepository interface {
+	// Add records mentions, skipping users already mentioned by the same
+	// message.
+	Add(mentions []models.Mention) error
+	// ListByUser returns userID's mentions newest first, at most limit of them
+	// when limit > 0 and only unread ones when unreadOnly is set.
+	ListByUser(userID, limit int, unreadOnly bool) ([]models.Mention, error)
+	// MarkRead marks userID's mentions with the given IDs read, or all of
+	// them when ids is empty, and returns how many were unread.
+	MarkRead(userID int, ids []int, at time.Time) (int, error)
+	// MarkReadUpTo marks userID's mentions in a room's top-level messages up
+	// to and including messageID read.
+	MarkReadUpTo(userID, roomID, messageID int, at time.Time) error
+	// CountUnreadByRoom returns userID's unread mentions per room.
+	CountUnreadByRoom(userID int) (map[int]int, error)
+	DeleteByMessage(messageID int) error
+	DeleteByRoom(roomID int) error
+}
+
+type mentionKey struct {
+	messageID int
+	userID    int
+}
+
+type InMemoryMentionRepo struct {
+	mu    sync.RWMutex
+	seq   int
+	data  []*models.Mention // in ID order
+	index map[mentionKey]bool
+}
+
+func NewInMemoryMentionRepo() *InMemoryMentionRepo {
+	return &InMemoryMentionRepo{
+		index: make(map[mentionKey]bool),
+	}
+}
+
+func (r *InMemoryMentionRepo) Add(mentions []models.Mention) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, mention := range mentions {
+		key := mentionKey{mention.MessageID, mention.UserID}
+		if r.index[key] {
+			continue
+		}
+		r.index[key] = true
+		r.seq++
+		mention.ID = r.seq
+		r.data = append(r.data, &mention)
+	}
+	return nil
+}
+
+func (r *InMemoryMentionRepo) ListByUser(userID, limit int, unreadOnly bool) ([]models.Mention, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mentions := []models.Mention{}
+	for i := len(r.data) - 1; i >= 0 && (limit <= 0 || len(mentions) < limit); i-- {
+		mention := r.data[i]
+		if mention.UserID != userID || (unreadOnly && mention.ReadAt != nil) {
+			continue
+		}
+		mentions = append(mentions, *mention)
+	}
+	return mentions, nil
+}
+
+func (r *InMemoryMentionRepo) MarkRead(userID int, ids []int, at time.Time) (int, error) {
+	wanted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	return r.markRead(at, func(mention *models.Mention) bool {
+		return mention.UserID == userID && (len(ids) == 0 || wanted[mention.ID])
+	}), nil
+}
+
+func (r *InMemoryMentionRepo) MarkReadUpTo(userID, roomID, messageID int, at time.Time) error {
+	r.markRead(at, func(mention *models.Mention) bool {
+		return mention.UserID == userID && mention.RoomID == roomID &&
+			mention.ThreadID == 0 && mention.MessageID <= messageID
+	})
+	return nil
+}
+
+func (r *InMemoryMentionRepo) markRead(at time.Time, match func(*models.Mention) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	marked := 0
+	for _, mention := range r.data {
+		if mention.ReadAt == nil && match(mention) {
+			readAt := at
+			mention.ReadAt = &readAt
+			marked++
+		}
+	}
+	return marked
+}
+
+func (r *InMemoryMentionRepo) CountUnreadByRoom(userID int) (map[int]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[int]int)
+	for _, mention := range r.data {
+		if mention.UserID == userID && mention.ReadAt == nil {
+			counts[mention.RoomID]++
+		}
+	}
+	return counts, nil
+}
+
+func (r *InMemoryMentionRepo) DeleteByMessage(messageID int) error {
+	r.delete(func(mention *models.Mention) bool { return mention.MessageID == messageID })
+	return nil
+}
+
+func (r *InMemoryMentionRepo) DeleteByRoom(roomID int) error {
+	r.delete(func(mention *models.Mention) bool { return mention.RoomID == roomID })
+	return nil
+}
+
+func (r *InMemoryMentionRepo) delete(match func(*models.Mention) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.data[:0]
+	for _, mention := range r.data {
+		if !match(mention) {
+			kept = append(kept, mention)
+		} else {
+			delete(r.index, mentionKey{mention.MessageID, mention.UserID})
+		}
+	}
+	r.data = kept
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestMentionRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		carol := mustUser(t, r, "carol")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		other := mustRoom(t, r, "other", false, alice.ID)
+		m1 := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "@bob @carol"})
+		m2 := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "@bob"})
+		reply := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, ThreadID: m1.ID, Content: "@bob"})
+		m3 := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: other.ID, Content: "@room"})
+
+		mention := func(msg *models.Message, userID int, kind models.MentionKind) models.Mention {
+			return models.Mention{MessageID: msg.ID, RoomID: msg.RoomID, ThreadID: msg.ThreadID, UserID: userID,
+				SenderID: msg.SenderID, Kind: kind, CreatedAt: testNow}
+		}
+		err := r.mentions.Add([]models.Mention{
+			mention(m1, bob.ID, models.MentionUser),
+			mention(m1, carol.ID, models.MentionUser),
+			mention(m2, bob.ID, models.MentionUser),
+			mention(reply, bob.ID, models.MentionUser),
+			mention(m3, bob.ID, models.MentionRoom),
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if err := r.mentions.Add([]models.Mention{mention(m1, bob.ID, models.MentionHere)}); err != nil {
+			t.Fatalf("Add of a repeated mention: %v", err)
+		}
+
+		mentions, err := r.mentions.ListByUser(bob.ID, 0, false)
+		if err != nil || len(mentions) != 4 {
+			t.Fatalf("ListByUser = %+v, %v", mentions, err)
+		}
+		got := make([]int, len(mentions))
+		for i, m := range mentions {
+			got[i] = m.MessageID
+		}
+		if want := []int{m3.ID, reply.ID, m2.ID, m1.ID}; !slices.Equal(got, want) {
+			t.Errorf("ListByUser messages = %v, want newest first %v", got, want)
+		}
+		if first := mentions[0]; first.Kind != models.MentionRoom || first.RoomID != other.ID || first.SenderID != alice.ID || first.ReadAt != nil {
+			t.Errorf("ListByUser[0] = %+v", first)
+		}
+		if mentions[1].ThreadID != m1.ID {
+			t.Errorf("thread reply mention has thread %d, want %d", mentions[1].ThreadID, m1.ID)
+		}
+		if mentions, _ := r.mentions.ListByUser(bob.ID, 2, false); len(mentions) != 2 {
+			t.Errorf("ListByUser(limit 2) = %d mentions", len(mentions))
+		}
+
+		counts, err := r.mentions.CountUnreadByRoom(bob.ID)
+		if want := map[int]int{room.ID: 3, other.ID: 1}; err != nil || !maps.Equal(counts, want) {
+			t.Errorf("CountUnreadByRoom = %v, %v, want %v", counts, err, want)
+		}
+
+		// Reading the room up to m1 leaves the later message and the thread
+		// reply unread
+		if err := r.mentions.MarkReadUpTo(bob.ID, room.ID, m1.ID, testNow); err != nil {
+			t.Fatalf("MarkReadUpTo: %v", err)
+		}
+		if counts, _ := r.mentions.CountUnreadByRoom(bob.ID); counts[room.ID] != 2 {
+			t.Errorf("after MarkReadUpTo %d unread in the room, want 2", counts[room.ID])
+		}
+
+		if n, err := r.mentions.MarkRead(bob.ID, []int{mentions[0].ID}, testNow); err != nil || n != 1 {
+			t.Errorf("MarkRead = %d, %v, want 1", n, err)
+		}
+		if n, err := r.mentions.MarkRead(bob.ID, []int{mentions[0].ID}, testNow); err != nil || n != 0 {
+			t.Errorf("MarkRead twice = %d, %v, want 0", n, err)
+		}
+		if n, _ := r.mentions.MarkRead(carol.ID, []int{mentions[1].ID}, testNow); n != 0 {
+			t.Errorf("MarkRead marked another user's mention")
+		}
+		unread, err := r.mentions.ListByUser(bob.ID, 0, true)
+		if err != nil || len(unread) != 2 {
+			t.Errorf("ListByUser(unread) = %+v, %v", unread, err)
+		}
+		if n, err := r.mentions.MarkRead(bob.ID, nil, testNow); err != nil || n != 2 {
+			t.Errorf("MarkRead(all) = %d, %v, want 2", n, err)
+		}
+		if counts, _ := r.mentions.CountUnreadByRoom(bob.ID); len(counts) != 0 {
+			t.Errorf("after MarkRead(all) CountUnreadByRoom = %v", counts)
+		}
+		if read, _ := r.mentions.ListByUser(bob.ID, 1, false); read[0].ReadAt == nil || !read[0].ReadAt.Equal(testNow) {
+			t.Errorf("ReadAt = %v, want %v", read[0].ReadAt, testNow)
+		}
+		if counts, _ := r.mentions.CountUnreadByRoom(carol.ID); counts[room.ID] != 1 {
+			t.Errorf("carol's mention was marked read by bob: %v", counts)
+		}
+
+		if err := r.mentions.DeleteByMessage(m1.ID); err != nil {
+			t.Fatalf("DeleteByMessage: %v", err)
+		}
+		if mentions, _ := r.mentions.ListByUser(carol.ID, 0, false); len(mentions) != 0 {
+			t.Errorf("DeleteByMessage kept %+v", mentions)
+		}
+		if mentions, _ := r.mentions.ListByUser(bob.ID, 0, false); len(mentions) != 3 {
+			t.Errorf("DeleteByMessage removed other messages' mentions: %d left", len(mentions))
+		}
+
+		if err := r.mentions.DeleteByRoom(room.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if mentions, _ := r.mentions.ListByUser(bob.ID, 0, false); len(mentions) != 1 || mentions[0].MessageID != m3.ID {
+			t.Errorf("after DeleteByRoom ListByUser = %+v, want only the mention in the other room", mentions)
+		}
+	})
+}
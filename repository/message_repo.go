@@ -9,23 +9,78 @@ import (
 	"chat-backend/models"
 )
 
+// MessageRange selects a window of a room's history by message ID. Both
+// bounds are exclusive and 0 means unbounded.
+//
+// With AfterID set the window starts right after AfterID and walks forward;
+// otherwise it ends right before BeforeID (or at the newest message) and walks
+// backward. Either way at most Limit messages are returned, oldest first.
+type MessageRange struct {
+	BeforeID int
+	AfterID  int
+	Limit    int
+}
+
 type MessageRepository interface {
 	Save(msg *models.Message) (*models.Message, error)
 	ListByRoom(roomID int, limit int) ([]models.Message, error)
+	// ListRoomRange pages through a room's top-level messages; thread replies
+	// are listed with ListThread.
+	ListRoomRange(roomID int, rng MessageRange) ([]models.Message, error)
+	ListThread(rootID int, rng MessageRange) ([]models.Message, error)
+	// ThreadSummaries returns reply counts for the given roots that have at
+	// least one (non-deleted) reply.
+	ThreadSummaries(rootIDs []int) (map[int]models.ThreadSummary, error)
+	// CountRoomAfter counts a room's top-level messages after afterID that
+	// are not deleted and were not sent by exceptSenderID.
+	CountRoomAfter(roomID, afterID, exceptSenderID int) (int, error)
+	// ListDirect returns the newest limit direct messages exchanged between
+	// two users, oldest first.
+	ListDirect(userA, userB int, limit int) ([]models.Message, error)
+	// ListDirectThreads returns the latest direct message of each
+	// conversation userID takes part in, newest first.
+	ListDirectThreads(userID int) ([]models.Message, error)
+	FindByID(id int) (*models.Message, error)
+	// UpdateContent replaces a message's content and records the previous
+	// content in its edit history.
+	UpdateContent(id int, content string, editorID int, editedAt time.Time) (*models.Message, error)
+	// SoftDelete marks a message deleted, clears its content and drops its
+	// edit history.
+	SoftDelete(id int, deletedAt time.Time) error
+	ListEdits(messageID int) ([]models.MessageEdit, error)
 	DeleteByRoom(roomID int) error
 }
 
+// userPair identifies a direct conversation regardless of who sent what.
+type userPair struct {
+	low, high int
+}
+
+func makeUserPair(a, b int) userPair {
+	if a > b {
+		a, b = b, a
+	}
+	return userPair{low: a, high: b}
+}
+
 type InMemoryMessageRepo struct {
-	mu   sync.RWMutex
-	seq  int
-	data map[int]*models.Message // by id
-	byR  map[int][]int           // room -> message IDs
+	mu      sync.RWMutex
+	seq     int
+	data    map[int]*models.Message // by id
+	byR     map[int][]int           // room -> message IDs
+	byPair  map[userPair][]int      // direct conversation -> message IDs
+	byT     map[int][]int           // thread root -> reply IDs
+	editSeq int
+	edits   map[int][]models.MessageEdit // message ID -> edit history
 }
 
 func NewInMemoryMessageRepo() *InMemoryMessageRepo {
 	return &InMemoryMessageRepo{
-		data: make(map[int]*models.Message),
-		byR:  make(map[int][]int),
+		data:   make(map[int]*models.Message),
+		byR:    make(map[int][]int),
+		byPair: make(map[userPair][]int),
+		byT:    make(map[int][]int),
+		edits:  make(map[int][]models.MessageEdit),
 	}
 }
 
@@ -41,7 +96,14 @@ func (r *InMemoryMessageRepo) Save(msg *models.Message) (*models.Message, error)
 		msg.CreatedAt = time.Now()
 	}
 	r.data[msg.ID] = msg
-	r.byR[msg.RoomID] = append(r.byR[msg.RoomID], msg.ID)
+	if msg.ThreadID != 0 {
+		r.byT[msg.ThreadID] = append(r.byT[msg.ThreadID], msg.ID)
+	} else if msg.RoomID != 0 {
+		r.byR[msg.RoomID] = append(r.byR[msg.RoomID], msg.ID)
+	} else {
+		pair := makeUserPair(msg.SenderID, msg.ReceiverID)
+		r.byPair[pair] = append(r.byPair[pair], msg.ID)
+	}
 	return msg, nil
 }
 
@@ -67,6 +129,168 @@ func (r *InMemoryMessageRepo) ListByRoom(roomID int, limit int) ([]models.Messag
 	return msgs, nil
 }
 
+func (r *InMemoryMessageRepo) ListRoomRange(roomID int, rng MessageRange) ([]models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.listRange(r.byR[roomID], rng), nil
+}
+
+func (r *InMemoryMessageRepo) ListThread(rootID int, rng MessageRange) ([]models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.listRange(r.byT[rootID], rng), nil
+}
+
+// listRange applies rng to an increasing list of message IDs. IDs are
+// appended in increasing order, so the window can be found by binary search
+// instead of sorting the whole room. Callers must hold r.mu.
+func (r *InMemoryMessageRepo) listRange(ids []int, rng MessageRange) []models.Message {
+	lo, hi := 0, len(ids)
+	if rng.AfterID > 0 {
+		lo = sort.SearchInts(ids, rng.AfterID+1)
+	}
+	if rng.BeforeID > 0 {
+		hi = sort.SearchInts(ids, rng.BeforeID)
+	}
+	if lo > hi {
+		lo = hi
+	}
+	if rng.Limit > 0 && hi-lo > rng.Limit {
+		if rng.AfterID > 0 {
+			hi = lo + rng.Limit
+		} else {
+			lo = hi - rng.Limit
+		}
+	}
+
+	msgs := make([]models.Message, 0, hi-lo)
+	for _, id := range ids[lo:hi] {
+		msgs = append(msgs, *r.data[id])
+	}
+	return msgs
+}
+
+func (r *InMemoryMessageRepo) ThreadSummaries(rootIDs []int) (map[int]models.ThreadSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	summaries := make(map[int]models.ThreadSummary)
+	for _, rootID := range rootIDs {
+		var summary models.ThreadSummary
+		for _, id := range r.byT[rootID] {
+			reply := r.data[id]
+			if reply.IsDeleted() {
+				continue
+			}
+			summary.ReplyCount++
+			summary.LastReplyAt = reply.CreatedAt
+		}
+		if summary.ReplyCount > 0 {
+			summaries[rootID] = summary
+		}
+	}
+	return summaries, nil
+}
+
+func (r *InMemoryMessageRepo) CountRoomAfter(roomID, afterID, exceptSenderID int) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := r.byR[roomID]
+	count := 0
+	for _, id := range ids[sort.SearchInts(ids, afterID+1):] {
+		msg := r.data[id]
+		if msg.SenderID != exceptSenderID && !msg.IsDeleted() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *InMemoryMessageRepo) ListDirect(userA, userB int, limit int) ([]models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := r.byPair[makeUserPair(userA, userB)]
+	if limit > 0 && len(ids) > limit {
+		ids = ids[len(ids)-limit:]
+	}
+	// IDs are appended in send order, so they are already oldest-first
+	msgs := make([]models.Message, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, *r.data[id])
+	}
+	return msgs, nil
+}
+
+func (r *InMemoryMessageRepo) ListDirectThreads(userID int) ([]models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var latest []models.Message
+	for pair, ids := range r.byPair {
+		if pair.low != userID && pair.high != userID {
+			continue
+		}
+		latest = append(latest, *r.data[ids[len(ids)-1]])
+	}
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].ID > latest[j].ID
+	})
+	return latest, nil
+}
+
+func (r *InMemoryMessageRepo) FindByID(id int) (*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.data[id]
+	if !ok {
+		return nil, errors.New("message not found")
+	}
+	msg := *m
+	return &msg, nil
+}
+
+func (r *InMemoryMessageRepo) UpdateContent(id int, content string, editorID int, editedAt time.Time) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.data[id]
+	if !ok {
+		return nil, errors.New("message not found")
+	}
+
+	r.editSeq++
+	r.edits[id] = append(r.edits[id], models.MessageEdit{
+		ID:         r.editSeq,
+		MessageID:  id,
+		EditorID:   editorID,
+		OldContent: m.Content,
+		EditedAt:   editedAt,
+	})
+
+	m.Content = content
+	m.EditedAt = &editedAt
+	msg := *m
+	return &msg, nil
+}
+
+func (r *InMemoryMessageRepo) SoftDelete(id int, deletedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.data[id]
+	if !ok {
+		return errors.New("message not found")
+	}
+	m.Content = ""
+	m.DeletedAt = &deletedAt
+	delete(r.edits, id)
+	return nil
+}
+
+func (r *InMemoryMessageRepo) ListEdits(messageID int) ([]models.MessageEdit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	edits := make([]models.MessageEdit, len(r.edits[messageID]))
+	copy(edits, r.edits[messageID])
+	return edits, nil
+}
+
 func (r *InMemoryMessageRepo) DeleteByRoom(roomID int) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -77,9 +301,15 @@ func (r *InMemoryMessageRepo) DeleteByRoom(roomID int) error {
 		return nil // No messages to delete
 	}
 
-	// Delete all messages from the data map
+	// Delete all messages, and their thread replies, from the data map
 	for _, messageID := range messageIDs {
+		for _, replyID := range r.byT[messageID] {
+			delete(r.data, replyID)
+			delete(r.edits, replyID)
+		}
+		delete(r.byT, messageID)
 		delete(r.data, messageID)
+		delete(r.edits, messageID)
 	}
 
 	// Remove the room from the byR map
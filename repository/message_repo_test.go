@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestMessageRepositoryRoomHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		room := mustRoom(t, r, "general", false, alice.ID)
+
+		var ids []int
+		for i, sender := range []int{alice.ID, alice.ID, alice.ID, bob.ID, bob.ID} {
+			msg := mustMessage(t, r, models.Message{SenderID: sender, RoomID: room.ID, Content: "hello",
+				CreatedAt: testNow.Add(time.Duration(i) * time.Minute)})
+			ids = append(ids, msg.ID)
+		}
+		if !slices.IsSorted(ids) {
+			t.Fatalf("Save assigned IDs out of order: %v", ids)
+		}
+		// A thread reply is not part of the room's own history
+		mustMessage(t, r, models.Message{SenderID: bob.ID, RoomID: room.ID, ThreadID: ids[0], Content: "reply"})
+
+		msgs, err := r.messages.ListByRoom(room.ID, 0)
+		if err != nil || !slices.Equal(messageIDs(msgs), ids) {
+			t.Errorf("ListByRoom = %v, %v, want %v", messageIDs(msgs), err, ids)
+		}
+		msgs, err = r.messages.ListByRoom(room.ID, 2)
+		if err != nil || !slices.Equal(messageIDs(msgs), ids[3:]) {
+			t.Errorf("ListByRoom(limit 2) = %v, %v, want %v", messageIDs(msgs), err, ids[3:])
+		}
+
+		for _, tc := range []struct {
+			rng  MessageRange
+			want []int
+		}{
+			{MessageRange{}, ids},
+			{MessageRange{Limit: 2}, ids[3:]},
+			{MessageRange{BeforeID: ids[3], Limit: 2}, ids[1:3]},
+			{MessageRange{AfterID: ids[1], Limit: 2}, ids[2:4]},
+			{MessageRange{AfterID: ids[1], BeforeID: ids[4]}, ids[2:4]},
+			{MessageRange{AfterID: ids[4]}, nil},
+		} {
+			msgs, err := r.messages.ListRoomRange(room.ID, tc.rng)
+			if err != nil || !slices.Equal(messageIDs(msgs), tc.want) {
+				t.Errorf("ListRoomRange(%+v) = %v, %v, want %v", tc.rng, messageIDs(msgs), err, tc.want)
+			}
+		}
+
+		count, err := r.messages.CountRoomAfter(room.ID, ids[1], alice.ID)
+		if err != nil || count != 2 {
+			t.Errorf("CountRoomAfter = %d, %v, want 2", count, err)
+		}
+		if err := r.messages.SoftDelete(ids[4], testNow); err != nil {
+			t.Fatalf("SoftDelete: %v", err)
+		}
+		if count, _ := r.messages.CountRoomAfter(room.ID, ids[1], alice.ID); count != 1 {
+			t.Errorf("CountRoomAfter counted a deleted message: %d", count)
+		}
+
+		if err := r.messages.DeleteByRoom(room.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if msgs, _ := r.messages.ListByRoom(room.ID, 0); len(msgs) != 0 {
+			t.Errorf("ListByRoom after DeleteByRoom = %v", messageIDs(msgs))
+		}
+		if _, err := r.messages.FindByID(ids[0]); err == nil {
+			t.Error("FindByID found a message of a deleted room")
+		}
+	})
+}
+
+func TestMessageRepositoryThreads(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		root := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "root"})
+		other := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "other"})
+
+		var replies []int
+		for i := 1; i <= 3; i++ {
+			reply := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, ThreadID: root.ID,
+				Content: "reply", CreatedAt: testNow.Add(time.Duration(i) * time.Minute)})
+			replies = append(replies, reply.ID)
+		}
+
+		msgs, err := r.messages.ListThread(root.ID, MessageRange{})
+		if err != nil || !slices.Equal(messageIDs(msgs), replies) {
+			t.Errorf("ListThread = %v, %v, want %v", messageIDs(msgs), err, replies)
+		}
+		msgs, err = r.messages.ListThread(root.ID, MessageRange{AfterID: replies[0], Limit: 1})
+		if err != nil || !slices.Equal(messageIDs(msgs), replies[1:2]) {
+			t.Errorf("ListThread(after first) = %v, %v", messageIDs(msgs), err)
+		}
+		if msg, _ := r.messages.FindByID(replies[0]); msg.ThreadID != root.ID {
+			t.Errorf("reply has thread %d, want %d", msg.ThreadID, root.ID)
+		}
+
+		if err := r.messages.SoftDelete(replies[2], testNow); err != nil {
+			t.Fatalf("SoftDelete: %v", err)
+		}
+		summaries, err := r.messages.ThreadSummaries([]int{root.ID, other.ID})
+		if err != nil {
+			t.Fatalf("ThreadSummaries: %v", err)
+		}
+		if len(summaries) != 1 {
+			t.Errorf("ThreadSummaries = %v, want only the root", summaries)
+		}
+		summary := summaries[root.ID]
+		if summary.ReplyCount != 2 || !summary.LastReplyAt.Equal(testNow.Add(2*time.Minute)) {
+			t.Errorf("ThreadSummaries[root] = %+v, want 2 replies, last at +2m", summary)
+		}
+		if summaries, err := r.messages.ThreadSummaries(nil); err != nil || len(summaries) != 0 {
+			t.Errorf("ThreadSummaries(nil) = %v, %v", summaries, err)
+		}
+	})
+}
+
+func TestMessageRepositoryDirect(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		carol := mustUser(t, r, "carol")
+
+		d1 := mustMessage(t, r, models.Message{SenderID: alice.ID, ReceiverID: bob.ID, Content: "hi bob"})
+		d2 := mustMessage(t, r, models.Message{SenderID: bob.ID, ReceiverID: alice.ID, Content: "hi alice"})
+		d3 := mustMessage(t, r, models.Message{SenderID: alice.ID, ReceiverID: carol.ID, Content: "hi carol"})
+
+		msgs, err := r.messages.ListDirect(bob.ID, alice.ID, 0)
+		if err != nil || !slices.Equal(messageIDs(msgs), []int{d1.ID, d2.ID}) {
+			t.Errorf("ListDirect = %v, %v", messageIDs(msgs), err)
+		}
+		msgs, err = r.messages.ListDirect(alice.ID, bob.ID, 1)
+		if err != nil || !slices.Equal(messageIDs(msgs), []int{d2.ID}) {
+			t.Errorf("ListDirect(limit 1) = %v, %v", messageIDs(msgs), err)
+		}
+		if msgs[0].ReceiverID != alice.ID || msgs[0].RoomID != 0 {
+			t.Errorf("ListDirect returned %+v", msgs[0])
+		}
+
+		msgs, err = r.messages.ListDirectThreads(alice.ID)
+		if err != nil || !slices.Equal(messageIDs(msgs), []int{d3.ID, d2.ID}) {
+			t.Errorf("ListDirectThreads(alice) = %v, %v", messageIDs(msgs), err)
+		}
+		msgs, err = r.messages.ListDirectThreads(carol.ID)
+		if err != nil || !slices.Equal(messageIDs(msgs), []int{d3.ID}) {
+			t.Errorf("ListDirectThreads(carol) = %v, %v", messageIDs(msgs), err)
+		}
+	})
+}
+
+func TestMessageRepositoryEdits(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		msg := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "first"})
+
+		found, err := r.messages.FindByID(msg.ID)
+		if err != nil || found.Content != "first" || found.SenderID != alice.ID || found.RoomID != room.ID || found.EditedAt != nil {
+			t.Errorf("FindByID = %+v, %v", found, err)
+		}
+		if _, err := r.messages.FindByID(msg.ID + 1); err == nil {
+			t.Error("FindByID found a message that does not exist")
+		}
+
+		edited, err := r.messages.UpdateContent(msg.ID, "second", alice.ID, testNow.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("UpdateContent: %v", err)
+		}
+		if edited.Content != "second" || edited.EditedAt == nil || !edited.EditedAt.Equal(testNow.Add(time.Minute)) {
+			t.Errorf("UpdateContent returned %+v", edited)
+		}
+		if _, err := r.messages.UpdateContent(msg.ID, "third", alice.ID, testNow.Add(2*time.Minute)); err != nil {
+			t.Fatalf("UpdateContent: %v", err)
+		}
+		edits, err := r.messages.ListEdits(msg.ID)
+		if err != nil || len(edits) != 2 {
+			t.Fatalf("ListEdits = %v, %v", edits, err)
+		}
+		if edits[0].OldContent != "first" || edits[1].OldContent != "second" || edits[0].EditorID != alice.ID {
+			t.Errorf("ListEdits = %+v", edits)
+		}
+		if _, err := r.messages.UpdateContent(msg.ID+1, "x", alice.ID, testNow); err == nil {
+			t.Error("UpdateContent succeeded for a missing message")
+		}
+
+		if err := r.messages.SoftDelete(msg.ID, testNow.Add(time.Hour)); err != nil {
+			t.Fatalf("SoftDelete: %v", err)
+		}
+		found, _ = r.messages.FindByID(msg.ID)
+		if found.Content != "" || !found.IsDeleted() || !found.DeletedAt.Equal(testNow.Add(time.Hour)) {
+			t.Errorf("after SoftDelete FindByID = %+v", found)
+		}
+		if edits, err := r.messages.ListEdits(msg.ID); err != nil || len(edits) != 0 {
+			t.Errorf("SoftDelete kept the edit history: %v, %v", edits, err)
+		}
+		if err := r.messages.SoftDelete(msg.ID+1, testNow); err == nil {
+			t.Error("SoftDelete succeeded for a missing message")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// testMigrations applies every migration, rolls them all back and applies
+// them again, which also checks that each down script undoes its up script.
+func testMigrations(t *testing.T, db *DB) {
+	migrations, err := LoadMigrations(db.Dialect())
+	if err != nil {
+		t.Fatalf("LoadMigrations: %v", err)
+	}
+
+	for round := 1; round <= 2; round++ {
+		applied, err := db.MigrateUp()
+		if err != nil {
+			t.Fatalf("migrate up (round %d): %v", round, err)
+		}
+		if len(applied) != len(migrations) {
+			t.Fatalf("migrate up applied %d of %d migrations", len(applied), len(migrations))
+		}
+		if pending, err := db.PendingMigrations(); err != nil || len(pending) != 0 {
+			t.Fatalf("after migrate up %d pending (%v)", len(pending), err)
+		}
+		if round == 2 {
+			break
+		}
+
+		rolledBack, err := db.MigrateDown(len(migrations))
+		if err != nil {
+			t.Fatalf("migrate down: %v", err)
+		}
+		if len(rolledBack) != len(migrations) || rolledBack[0].Version != migrations[len(migrations)-1].Version {
+			t.Fatalf("migrate down rolled back %d migrations, newest first expected", len(rolledBack))
+		}
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	for _, s := range status {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Errorf("migration %04d_%s not recorded as applied", s.Version, s.Name)
+		}
+	}
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	db, err := ConnectSQLite(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+	testMigrations(t, db)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	sqlite, err := LoadMigrations(DialectSQLite)
+	if err != nil {
+		t.Fatalf("LoadMigrations(sqlite): %v", err)
+	}
+	postgres, err := LoadMigrations(DialectPostgres)
+	if err != nil {
+		t.Fatalf("LoadMigrations(postgres): %v", err)
+	}
+
+	// Both dialects have the same migrations, except that PostgreSQL has no
+	// full-text search table and builds its search index in memory instead
+	byVersion := make(map[int]string)
+	for _, m := range postgres {
+		byVersion[m.Version] = m.Name
+		if m.Down == "" {
+			t.Errorf("postgres migration %04d_%s has no down script", m.Version, m.Name)
+		}
+	}
+	for _, m := range sqlite {
+		if m.Down == "" {
+			t.Errorf("sqlite migration %04d_%s has no down script", m.Version, m.Name)
+		}
+		name, ok := byVersion[m.Version]
+		switch {
+		case m.Name == "message_search":
+			if ok {
+				t.Errorf("postgres has migration %04d_%s", m.Version, name)
+			}
+		case !ok || name != m.Name:
+			t.Errorf("sqlite migration %04d_%s has no postgres counterpart", m.Version, m.Name)
+		}
+		delete(byVersion, m.Version)
+	}
+	for version, name := range byVersion {
+		t.Errorf("postgres migration %04d_%s has no sqlite counterpart", version, name)
+	}
+	if _, err := LoadMigrations("mysql"); err == nil {
+		t.Error("LoadMigrations accepted an unknown dialect")
+	}
+}
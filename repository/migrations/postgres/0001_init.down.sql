@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS room_memberships;
+DROP TABLE IF EXISTS chat_rooms;
+DROP TABLE IF EXISTS users;
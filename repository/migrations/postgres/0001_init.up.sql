@@ -0,0 +1,38 @@
+CREATE TABLE users (
+	id         BIGSERIAL PRIMARY KEY,
+	username   TEXT NOT NULL UNIQUE,
+	password   TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE chat_rooms (
+	id          BIGSERIAL PRIMARY KEY,
+	name        TEXT NOT NULL UNIQUE,
+	is_private  BOOLEAN NOT NULL DEFAULT FALSE,
+	invite_code TEXT NOT NULL DEFAULT '',
+	created_by  BIGINT NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_chat_rooms_invite_code ON chat_rooms (invite_code);
+
+CREATE TABLE room_memberships (
+	id        BIGSERIAL PRIMARY KEY,
+	room_id   BIGINT NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	user_id   BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	joined_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (room_id, user_id)
+);
+
+CREATE INDEX idx_room_memberships_user ON room_memberships (user_id);
+
+CREATE TABLE messages (
+	id          BIGSERIAL PRIMARY KEY,
+	sender_id   BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	receiver_id BIGINT REFERENCES users (id) ON DELETE CASCADE,
+	room_id     BIGINT REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	content     TEXT NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_messages_room ON messages (room_id, id);
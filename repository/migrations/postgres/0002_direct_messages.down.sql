@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_direct_receiver;
+DROP INDEX IF EXISTS idx_messages_direct;
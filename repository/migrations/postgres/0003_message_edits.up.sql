@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE TABLE message_edits (
+	id          BIGSERIAL PRIMARY KEY,
+	message_id  BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	editor_id   BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	old_content TEXT NOT NULL,
+	edited_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits (message_id, id);
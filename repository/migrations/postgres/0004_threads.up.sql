@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN thread_id BIGINT REFERENCES messages (id) ON DELETE CASCADE;
+
+CREATE INDEX idx_messages_thread ON messages (thread_id, id) WHERE thread_id IS NOT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE message_reactions (
+	message_id BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	emoji      TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (message_id, user_id, emoji)
+);
@@ -0,0 +1,11 @@
+ALTER TABLE room_memberships ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+
+-- Room creators become owners, including of public rooms where they had no
+-- membership row before
+UPDATE room_memberships SET role = 'owner'
+WHERE user_id = (SELECT r.created_by FROM chat_rooms r WHERE r.id = room_memberships.room_id);
+
+INSERT INTO room_memberships (room_id, user_id, role, joined_at)
+SELECT r.id, r.created_by, 'owner', r.created_at FROM chat_rooms r
+WHERE r.created_by IN (SELECT id FROM users)
+  AND NOT EXISTS (SELECT 1 FROM room_memberships m WHERE m.room_id = r.id AND m.user_id = r.created_by);
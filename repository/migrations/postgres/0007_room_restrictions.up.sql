@@ -0,0 +1,12 @@
+CREATE TABLE room_restrictions (
+	room_id    BIGINT NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind       TEXT NOT NULL,
+	reason     TEXT NOT NULL DEFAULT '',
+	created_by BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (room_id, user_id, kind)
+);
+
+CREATE INDEX idx_room_restrictions_user ON room_restrictions (user_id, kind);
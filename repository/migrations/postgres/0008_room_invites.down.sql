@@ -0,0 +1,12 @@
+ALTER TABLE chat_rooms ADD COLUMN invite_code TEXT NOT NULL DEFAULT '';
+
+-- Keep each room's oldest unrevoked invite as its permanent code
+UPDATE chat_rooms SET invite_code = COALESCE((
+	SELECT i.code FROM room_invites i
+	WHERE i.room_id = chat_rooms.id AND i.revoked_at IS NULL
+	ORDER BY i.id LIMIT 1
+), '');
+
+CREATE INDEX idx_chat_rooms_invite_code ON chat_rooms (invite_code);
+
+DROP TABLE IF EXISTS room_invites;
@@ -0,0 +1,9 @@
+CREATE TABLE read_markers (
+	room_id      BIGINT NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	user_id      BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	last_read_id BIGINT NOT NULL,
+	updated_at   TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX idx_read_markers_user ON read_markers (user_id);
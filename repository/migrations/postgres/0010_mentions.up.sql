@@ -0,0 +1,14 @@
+CREATE TABLE mentions (
+	id         BIGSERIAL PRIMARY KEY,
+	message_id BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	room_id    BIGINT NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	thread_id  BIGINT,
+	user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	sender_id  BIGINT NOT NULL,
+	kind       TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	read_at    TIMESTAMPTZ,
+	UNIQUE (message_id, user_id)
+);
+
+CREATE INDEX idx_mentions_user ON mentions (user_id, id);
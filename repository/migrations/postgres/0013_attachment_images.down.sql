@@ -0,0 +1,4 @@
+ALTER TABLE message_attachments DROP COLUMN thumbnail_type;
+ALTER TABLE message_attachments DROP COLUMN placeholder;
+ALTER TABLE message_attachments DROP COLUMN height;
+ALTER TABLE message_attachments DROP COLUMN width;
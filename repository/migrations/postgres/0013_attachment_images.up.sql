@@ -0,0 +1,4 @@
+ALTER TABLE message_attachments ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE message_attachments ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE message_attachments ADD COLUMN placeholder TEXT NOT NULL DEFAULT '';
+ALTER TABLE message_attachments ADD COLUMN thumbnail_type TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,19 @@
+CREATE TABLE sessions (
+	id         TEXT PRIMARY KEY,
+	user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+
+CREATE TABLE refresh_tokens (
+	token_hash TEXT PRIMARY KEY,
+	session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX idx_refresh_tokens_session ON refresh_tokens (session_id);
@@ -0,0 +1,26 @@
+CREATE TABLE totp_credentials (
+	user_id      BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	secret       TEXT NOT NULL,
+	last_step    BIGINT NOT NULL DEFAULT 0,
+	created_at   TIMESTAMPTZ NOT NULL,
+	confirmed_at TIMESTAMPTZ
+);
+
+CREATE TABLE recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id   BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	used_at   TIMESTAMPTZ
+);
+
+CREATE INDEX idx_recovery_codes_user ON recovery_codes (user_id);
+
+CREATE TABLE login_challenges (
+	challenge_hash TEXT PRIMARY KEY,
+	user_id        BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	attempts       INTEGER NOT NULL DEFAULT 0,
+	created_at     TIMESTAMPTZ NOT NULL,
+	expires_at     TIMESTAMPTZ NOT NULL,
+	used_at        TIMESTAMPTZ
+);
+
+CREATE INDEX idx_login_challenges_user ON login_challenges (user_id);
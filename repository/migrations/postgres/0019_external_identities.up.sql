@@ -0,0 +1,20 @@
+CREATE TABLE external_identities (
+	provider   TEXT NOT NULL,
+	subject    TEXT NOT NULL,
+	user_id    BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	email      TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (provider, subject),
+	UNIQUE (user_id, provider)
+);
+
+CREATE TABLE oidc_logins (
+	state_hash    TEXT PRIMARY KEY,
+	provider      TEXT NOT NULL,
+	nonce         TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	link_user_id  BIGINT REFERENCES users (id) ON DELETE CASCADE,
+	created_at    TIMESTAMPTZ NOT NULL,
+	expires_at    TIMESTAMPTZ NOT NULL,
+	used_at       TIMESTAMPTZ
+);
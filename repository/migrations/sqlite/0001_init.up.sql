@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS users (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	username   TEXT NOT NULL UNIQUE,
+	password   TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_rooms (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	name        TEXT NOT NULL UNIQUE,
+	is_private  BOOLEAN NOT NULL DEFAULT 0,
+	invite_code TEXT NOT NULL DEFAULT '',
+	created_by  INTEGER NOT NULL,
+	created_at  DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_rooms_invite_code ON chat_rooms (invite_code);
+
+CREATE TABLE IF NOT EXISTS room_memberships (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id   INTEGER NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	user_id   INTEGER NOT NULL REFERENCES users (id),
+	joined_at DATETIME NOT NULL,
+	UNIQUE (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_memberships_user ON room_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id   INTEGER NOT NULL REFERENCES users (id),
+	receiver_id INTEGER REFERENCES users (id),
+	room_id     INTEGER REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	content     TEXT NOT NULL,
+	created_at  DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_room ON messages (room_id, id);
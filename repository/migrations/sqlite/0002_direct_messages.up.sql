@@ -0,0 +1,2 @@
+CREATE INDEX idx_messages_direct ON messages (sender_id, receiver_id, id) WHERE room_id IS NULL;
+CREATE INDEX idx_messages_direct_receiver ON messages (receiver_id, id) WHERE room_id IS NULL;
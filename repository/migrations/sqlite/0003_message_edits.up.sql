@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN edited_at DATETIME;
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME;
+
+CREATE TABLE message_edits (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id  INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	editor_id   INTEGER NOT NULL,
+	old_content TEXT NOT NULL,
+	edited_at   DATETIME NOT NULL
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits (message_id, id);
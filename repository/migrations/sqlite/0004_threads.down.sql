@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_thread;
+ALTER TABLE messages DROP COLUMN thread_id;
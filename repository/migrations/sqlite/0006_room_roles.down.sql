@@ -0,0 +1 @@
+ALTER TABLE room_memberships DROP COLUMN role;
@@ -0,0 +1,12 @@
+CREATE TABLE room_restrictions (
+	room_id    INTEGER NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	kind       TEXT NOT NULL,
+	reason     TEXT NOT NULL DEFAULT '',
+	created_by INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME,
+	PRIMARY KEY (room_id, user_id, kind)
+);
+
+CREATE INDEX idx_room_restrictions_user ON room_restrictions (user_id, kind);
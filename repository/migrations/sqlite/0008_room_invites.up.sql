@@ -0,0 +1,20 @@
+CREATE TABLE room_invites (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id    INTEGER NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	code       TEXT NOT NULL UNIQUE,
+	created_by INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME,
+	max_uses   INTEGER NOT NULL DEFAULT 0,
+	uses       INTEGER NOT NULL DEFAULT 0,
+	revoked_at DATETIME
+);
+
+CREATE INDEX idx_room_invites_room ON room_invites (room_id);
+
+-- Each room's permanent code becomes an unlimited invite from its creator
+INSERT INTO room_invites (room_id, code, created_by, created_at)
+SELECT id, invite_code, created_by, created_at FROM chat_rooms WHERE invite_code <> '';
+
+DROP INDEX IF EXISTS idx_chat_rooms_invite_code;
+ALTER TABLE chat_rooms DROP COLUMN invite_code;
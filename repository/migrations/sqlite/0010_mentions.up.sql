@@ -0,0 +1,14 @@
+CREATE TABLE mentions (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	room_id    INTEGER NOT NULL REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	thread_id  INTEGER,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	sender_id  INTEGER NOT NULL,
+	kind       TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	read_at    DATETIME,
+	UNIQUE (message_id, user_id)
+);
+
+CREATE INDEX idx_mentions_user ON mentions (user_id, id);
@@ -0,0 +1,27 @@
+-- Full-text index over room messages. It reads content from messages, and
+-- the triggers below keep it in step with inserts, edits and deletes.
+CREATE VIRTUAL TABLE message_search USING fts5 (
+	content,
+	content = 'messages',
+	content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 0'
+);
+
+CREATE TRIGGER message_search_insert AFTER INSERT ON messages
+WHEN new.room_id IS NOT NULL BEGIN
+	INSERT INTO message_search (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER message_search_update AFTER UPDATE OF content ON messages
+WHEN old.room_id IS NOT NULL BEGIN
+	INSERT INTO message_search (message_search, rowid, content) VALUES ('delete', old.id, old.content);
+	INSERT INTO message_search (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER message_search_delete AFTER DELETE ON messages
+WHEN old.room_id IS NOT NULL BEGIN
+	INSERT INTO message_search (message_search, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+INSERT INTO message_search (rowid, content)
+SELECT id, content FROM messages WHERE room_id IS NOT NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE message_attachments (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id   INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	room_id      INTEGER REFERENCES chat_rooms (id) ON DELETE CASCADE,
+	uploader_id  INTEGER NOT NULL,
+	file_name    TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size         BIGINT NOT NULL,
+	blob_key     TEXT NOT NULL UNIQUE,
+	created_at   DATETIME NOT NULL
+);
+
+CREATE INDEX idx_message_attachments_message ON message_attachments (message_id);
+CREATE INDEX idx_message_attachments_room ON message_attachments (room_id);
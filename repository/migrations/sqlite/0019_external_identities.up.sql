@@ -0,0 +1,20 @@
+CREATE TABLE external_identities (
+	provider   TEXT NOT NULL,
+	subject    TEXT NOT NULL,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	email      TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (provider, subject),
+	UNIQUE (user_id, provider)
+);
+
+CREATE TABLE oidc_logins (
+	state_hash    TEXT PRIMARY KEY,
+	provider      TEXT NOT NULL,
+	nonce         TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	link_user_id  INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	created_at    DATETIME NOT NULL,
+	expires_at    DATETIME NOT NULL,
+	used_at       DATETIME
+);
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// PasswordResetRepository stores password reset tokens by hash.
+type PasswordResetRepository interface {
+	Create(reset *models.PasswordReset) error
+	FindByHash(hash string) (*models.PasswordReset, error)
+	// Use marks a token used, reporting false if it already was, so a token
+	// cannot be redeemed twice.
+	Use(hash string, usedAt time.Time) (bool, error)
+	// UseAllForUser marks every unused token of a user used, e.g. once the
+	// password has changed.
+	UseAllForUser(userID int, usedAt time.Time) error
+}
+
+type InMemoryPasswordResetRepo struct {
+	mu   sync.Mutex
+	data map[string]*models.PasswordReset // by hash
+}
+
+func NewInMemoryPasswordResetRepo() *InMemoryPasswordResetRepo {
+	return &InMemoryPasswordResetRepo{data: make(map[string]*models.PasswordReset)}
+}
+
+func (r *InMemoryPasswordResetRepo) Create(reset *models.PasswordReset) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.data[reset.Hash]; exists {
+		return errors.New("reset token already exists")
+	}
+	stored := *reset
+	r.data[stored.Hash] = &stored
+	return nil
+}
+
+func (r *InMemoryPasswordResetRepo) FindByHash(hash string) (*models.PasswordReset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset, ok := r.data[hash]
+	if !ok {
+		return nil, errors.New("reset token not found")
+	}
+	found := *reset
+	return &found, nil
+}
+
+func (r *InMemoryPasswordResetRepo) Use(hash string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset, ok := r.data[hash]
+	if !ok {
+		return false, errors.New("reset token not found")
+	}
+	if reset.UsedAt != nil {
+		return false, nil
+	}
+	reset.UsedAt = &usedAt
+	return true, nil
+}
+
+func (r *InMemoryPasswordResetRepo) UseAllForUser(userID int, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reset := range r.data {
+		if reset.UserID == userID && reset.UsedAt == nil {
+			reset.UsedAt = &usedAt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestPasswordResetRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+
+		for _, reset := range []*models.PasswordReset{
+			{Hash: "a1", UserID: alice.ID, CreatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)},
+			{Hash: "a2", UserID: alice.ID, CreatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)},
+			{Hash: "b1", UserID: bob.ID, CreatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)},
+		} {
+			if err := r.resets.Create(reset); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+		if err := r.resets.Create(&models.PasswordReset{Hash: "a1", UserID: bob.ID, CreatedAt: testNow, ExpiresAt: testNow}); err == nil {
+			t.Error("Create accepted a taken hash")
+		}
+
+		found, err := r.resets.FindByHash("a1")
+		if err != nil || found.UserID != alice.ID || !found.CreatedAt.Equal(testNow) || !found.ActiveAt(testNow) {
+			t.Errorf("FindByHash = %+v, %v", found, err)
+		}
+		if _, err := r.resets.FindByHash("nope"); err == nil {
+			t.Error("FindByHash found a token that does not exist")
+		}
+
+		if used, err := r.resets.Use("a1", testNow); err != nil || !used {
+			t.Errorf("Use = %v, %v", used, err)
+		}
+		if used, err := r.resets.Use("a1", testNow); err != nil || used {
+			t.Errorf("Use twice = %v, %v", used, err)
+		}
+		if used, _ := r.resets.Use("nope", testNow); used {
+			t.Error("Use used a token that does not exist")
+		}
+
+		if err := r.resets.UseAllForUser(alice.ID, testNow.Add(time.Minute)); err != nil {
+			t.Fatalf("UseAllForUser: %v", err)
+		}
+		for hash, want := range map[string]*time.Time{"a1": &testNow, "a2": ptr(testNow.Add(time.Minute)), "b1": nil} {
+			found, _ := r.resets.FindByHash(hash)
+			if (found.UsedAt == nil) != (want == nil) || (want != nil && !found.UsedAt.Equal(*want)) {
+				t.Errorf("%s used at %v, want %v", hash, found.UsedAt, want)
+			}
+		}
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// ConnectPostgres opens a PostgreSQL connection pool without touching its schema.
+func ConnectPostgres(dsn string) (*DB, error) {
+	if dsn == "" {
+		return nil, errors.New("postgres connection string is empty")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{DB: db, dialect: DialectPostgres}, nil
+}
+
+// OpenPostgres connects to PostgreSQL and refuses to continue while
+// migrations are pending. Production schemas are only changed through the
+// migrate command, never implicitly at server start.
+func OpenPostgres(dsn string) (*DB, error) {
+	db, err := ConnectPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(pending) > 0 {
+		db.Close()
+		return nil, fmt.Errorf("database schema is %d migration(s) behind; run `server migrate up`", len(pending))
+	}
+	return db, nil
+}
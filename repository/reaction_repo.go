@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+type ReactionRepository interface {
+	// Add records a reaction unless the user already reacted with that emoji,
+	// reporting whether it was added. It fails if the emoji would be the
+	// message's (maxDistinct+1)th distinct emoji.
+	Add(messageID, userID int, emoji string, maxDistinct int) (bool, error)
+	// Remove deletes a reaction, reporting whether it existed.
+	Remove(messageID, userID int, emoji string) (bool, error)
+	// ListByMessages returns the reactions on the given messages, oldest first.
+	ListByMessages(messageIDs []int) ([]models.Reaction, error)
+	DeleteByMessage(messageID int) error
+}
+
+var errTooManyReactions = errors.New("this message has too many different reactions")
+
+type InMemoryReactionRepo struct {
+	mu   sync.RWMutex
+	data map[int][]models.Reaction // message ID -> reactions in insertion order
+}
+
+func NewInMemoryReactionRepo() *InMemoryReactionRepo {
+	return &InMemoryReactionRepo{
+		data: make(map[int][]models.Reaction),
+	}
+}
+
+func (r *InMemoryReactionRepo) Add(messageID, userID int, emoji string, maxDistinct int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	distinct := make(map[string]bool)
+	for _, reaction := range r.data[messageID] {
+		if reaction.UserID == userID && reaction.Emoji == emoji {
+			return false, nil // Already reacted
+		}
+		distinct[reaction.Emoji] = true
+	}
+	if !distinct[emoji] && maxDistinct > 0 && len(distinct) >= maxDistinct {
+		return false, errTooManyReactions
+	}
+
+	r.data[messageID] = append(r.data[messageID], models.Reaction{
+		MessageID: messageID,
+		UserID:    userID,
+		Emoji:     emoji,
+		CreatedAt: time.Now(),
+	})
+	return true, nil
+}
+
+func (r *InMemoryReactionRepo) Remove(messageID, userID int, emoji string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reactions := r.data[messageID]
+	for i, reaction := range reactions {
+		if reaction.UserID == userID && reaction.Emoji == emoji {
+			r.data[messageID] = append(reactions[:i:i], reactions[i+1:]...)
+			if len(r.data[messageID]) == 0 {
+				delete(r.data, messageID)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *InMemoryReactionRepo) ListByMessages(messageIDs []int) ([]models.Reaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var reactions []models.Reaction
+	for _, id := range messageIDs {
+		reactions = append(reactions, r.data[id]...)
+	}
+	sort.SliceStable(reactions, func(i, j int) bool {
+		return reactions[i].CreatedAt.Before(reactions[j].CreatedAt)
+	})
+	return reactions, nil
+}
+
+func (r *InMemoryReactionRepo) DeleteByMessage(messageID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.data, messageID)
+	return nil
+}
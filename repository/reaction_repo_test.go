@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestReactionRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		msg := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "hello"})
+		other := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "again"})
+
+		for _, tc := range []struct {
+			userID int
+			emoji  string
+			added  bool
+		}{
+			{alice.ID, "👍", true},
+			{alice.ID, "👍", false},
+			{bob.ID, "👍", true},
+			{bob.ID, "🎉", true},
+			{alice.ID, "🎉", true}, // not a new distinct emoji
+		} {
+			added, err := r.reactions.Add(msg.ID, tc.userID, tc.emoji, 2)
+			if err != nil || added != tc.added {
+				t.Errorf("Add(%d, %s) = %v, %v, want %v", tc.userID, tc.emoji, added, err, tc.added)
+			}
+		}
+		if _, err := r.reactions.Add(msg.ID, alice.ID, "😀", 2); !errors.Is(err, errTooManyReactions) {
+			t.Errorf("Add of a third distinct emoji = %v, want errTooManyReactions", err)
+		}
+		if added, err := r.reactions.Add(other.ID, bob.ID, "😀", 0); err != nil || !added {
+			t.Errorf("Add without a limit = %v, %v", added, err)
+		}
+
+		reactions, err := r.reactions.ListByMessages([]int{msg.ID})
+		if err != nil || len(reactions) != 4 {
+			t.Fatalf("ListByMessages = %v, %v", reactions, err)
+		}
+		if first := reactions[0]; first.UserID != alice.ID || first.Emoji != "👍" || first.MessageID != msg.ID {
+			t.Errorf("ListByMessages is not oldest first: %+v", reactions)
+		}
+		if reactions, _ := r.reactions.ListByMessages([]int{msg.ID, other.ID}); len(reactions) != 5 {
+			t.Errorf("ListByMessages of two messages = %d reactions", len(reactions))
+		}
+		if reactions, err := r.reactions.ListByMessages(nil); err != nil || len(reactions) != 0 {
+			t.Errorf("ListByMessages(nil) = %v, %v", reactions, err)
+		}
+
+		if removed, err := r.reactions.Remove(msg.ID, bob.ID, "🎉"); err != nil || !removed {
+			t.Errorf("Remove = %v, %v", removed, err)
+		}
+		if removed, err := r.reactions.Remove(msg.ID, bob.ID, "🎉"); err != nil || removed {
+			t.Errorf("Remove twice = %v, %v", removed, err)
+		}
+
+		if err := r.reactions.DeleteByMessage(msg.ID); err != nil {
+			t.Fatalf("DeleteByMessage: %v", err)
+		}
+		if reactions, _ := r.reactions.ListByMessages([]int{msg.ID, other.ID}); len(reactions) != 1 {
+			t.Errorf("after DeleteByMessage %d reactions are left, want 1", len(reactions))
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// ReadMarkerRepository stores how far each user has read each room.
+type ReadMarkerRepository interface {
+	// Advance moves userID's marker in the room forward to messageID. Markers
+	// never move back; it returns the stored marker and whether it moved.
+	Advance(roomID, userID, messageID int, at time.Time) (*models.ReadMarker, bool, error)
+	// Get returns userID's marker in the room, or nil if they have none.
+	Get(roomID, userID int) (*models.ReadMarker, error)
+	ListByUser(userID int) ([]models.ReadMarker, error)
+	ListByRoom(roomID int) ([]models.ReadMarker, error)
+	DeleteByRoom(roomID int) error
+}
+
+type readMarkerKey struct {
+	roomID int
+	userID int
+}
+
+type InMemoryReadMarkerRepo struct {
+	mu   sync.RWMutex
+	data map[readMarkerKey]models.ReadMarker
+}
+
+func NewInMemoryReadMarkerRepo() *InMemoryReadMarkerRepo {
+	return &InMemoryReadMarkerRepo{
+		data: make(map[readMarkerKey]models.ReadMarker),
+	}
+}
+
+func (r *InMemoryReadMarkerRepo) Advance(roomID, userID, messageID int, at time.Time) (*models.ReadMarker, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := readMarkerKey{roomID, userID}
+	marker, ok := r.data[key]
+	if ok && marker.LastReadID >= messageID {
+		return &marker, false, nil
+	}
+	marker = models.ReadMarker{RoomID: roomID, UserID: userID, LastReadID: messageID, UpdatedAt: at}
+	r.data[key] = marker
+	return &marker, true, nil
+}
+
+func (r *InMemoryReadMarkerRepo) Get(roomID, userID int) (*models.ReadMarker, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	marker, ok := r.data[readMarkerKey{roomID, userID}]
+	if !ok {
+		return nil, nil
+	}
+	return &marker, nil
+}
+
+func (r *InMemoryReadMarkerRepo) ListByUser(userID int) ([]models.ReadMarker, error) {
+	return r.list(func(key readMarkerKey) bool { return key.userID == userID })
+}
+
+func (r *InMemoryReadMarkerRepo) ListByRoom(roomID int) ([]models.ReadMarker, error) {
+	return r.list(func(key readMarkerKey) bool { return key.roomID == roomID })
+}
+
+func (r *InMemoryReadMarkerRepo) list(match func(readMarkerKey) bool) ([]models.ReadMarker, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	markers := []models.ReadMarker{}
+	for key, marker := range r.data {
+		if match(key) {
+			markers = append(markers, marker)
+		}
+	}
+	sort.Slice(markers, func(i, j int) bool {
+		if markers[i].RoomID != markers[j].RoomID {
+			return markers[i].RoomID < markers[j].RoomID
+		}
+		return markers[i].UserID < markers[j].UserID
+	})
+	return markers, nil
+}
+
+func (r *InMemoryReadMarkerRepo) DeleteByRoom(roomID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.data {
+		if key.roomID == roomID {
+			delete(r.data, key)
+		}
+	}
+	return nil
+}
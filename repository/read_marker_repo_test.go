@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadMarkerRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		other := mustRoom(t, r, "other", false, alice.ID)
+
+		if marker, err := r.readMarkers.Get(room.ID, alice.ID); err != nil || marker != nil {
+			t.Errorf("Get without a marker = %+v, %v", marker, err)
+		}
+
+		for _, tc := range []struct {
+			messageID int
+			at        time.Time
+			moved     bool
+			want      int
+		}{
+			{5, testNow, true, 5},
+			{3, testNow.Add(time.Minute), false, 5},
+			{5, testNow.Add(time.Minute), false, 5},
+			{7, testNow.Add(2 * time.Minute), true, 7},
+		} {
+			marker, moved, err := r.readMarkers.Advance(room.ID, alice.ID, tc.messageID, tc.at)
+			if err != nil || moved != tc.moved || marker.LastReadID != tc.want {
+				t.Errorf("Advance(%d) = %+v, %v, %v, want %d moved %v", tc.messageID, marker, moved, err, tc.want, tc.moved)
+			}
+		}
+		marker, err := r.readMarkers.Get(room.ID, alice.ID)
+		if err != nil || marker.LastReadID != 7 || !marker.UpdatedAt.Equal(testNow.Add(2*time.Minute)) {
+			t.Errorf("Get = %+v, %v", marker, err)
+		}
+
+		if _, _, err := r.readMarkers.Advance(other.ID, alice.ID, 2, testNow); err != nil {
+			t.Fatalf("Advance: %v", err)
+		}
+		if _, _, err := r.readMarkers.Advance(room.ID, bob.ID, 1, testNow); err != nil {
+			t.Fatalf("Advance: %v", err)
+		}
+
+		markers, err := r.readMarkers.ListByUser(alice.ID)
+		if err != nil || len(markers) != 2 || markers[0].RoomID != room.ID || markers[1].RoomID != other.ID {
+			t.Errorf("ListByUser = %+v, %v", markers, err)
+		}
+		markers, err = r.readMarkers.ListByRoom(room.ID)
+		if err != nil || len(markers) != 2 || markers[0].UserID != alice.ID || markers[1].UserID != bob.ID {
+			t.Errorf("ListByRoom = %+v, %v", markers, err)
+		}
+		if markers, err := r.readMarkers.ListByUser(bob.ID + 1); err != nil || len(markers) != 0 {
+			t.Errorf("ListByUser of a user without markers = %+v, %v", markers, err)
+		}
+
+		if err := r.readMarkers.DeleteByRoom(room.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if markers, err := r.readMarkers.ListByRoom(room.ID); err != nil || len(markers) != 0 {
+			t.Errorf("DeleteByRoom kept %+v (%v)", markers, err)
+		}
+		if marker, _ := r.readMarkers.Get(other.ID, alice.ID); marker == nil {
+			t.Error("DeleteByRoom removed another room's marker")
+		}
+	})
+}
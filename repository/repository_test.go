@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+// testNow is the clock the tests store timestamps at. Whole seconds survive
+// a round trip through every backend.
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// repos is one backend's implementation of every repository, on an empty
+// store.
+type repos struct {
+	users        UserRepository
+	chats        ChatRepository
+	messages     MessageRepository
+	memberships  MembershipRepository
+	restrictions RestrictionRepository
+	reactions    ReactionRepository
+	invites      InviteRepository
+	readMarkers  ReadMarkerRepository
+	mentions     MentionRepository
+	search       SearchIndex
+	attachments  AttachmentRepository
+	sessions     SessionRepository
+	resets       PasswordResetRepository
+	twoFactor    TwoFactorRepository
+	identities   IdentityRepository
+	db           *DB // nil for the in-memory backend
+}
+
+type backend struct {
+	name string
+	open func(t *testing.T) *repos
+}
+
+// backends are the storages every repository test runs against.
+var backends = []backend{
+	{"memory", openMemory},
+	{"sqlite", openSQLite},
+}
+
+// forEachBackend runs test once per backend, each time on an empty store.
+func forEachBackend(t *testing.T, test func(t *testing.T, r *repos)) {
+	t.Helper()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			test(t, b.open(t))
+		})
+	}
+}
+
+func openMemory(t *testing.T) *repos {
+	return &repos{
+		users:        NewInMemoryUserRepo(),
+		chats:        NewInMemoryChatRepo(),
+		messages:     NewInMemoryMessageRepo(),
+		memberships:  NewInMemoryMembershipRepo(),
+		restrictions: NewInMemoryRestrictionRepo(),
+		reactions:    NewInMemoryReactionRepo(),
+		invites:      NewInMemoryInviteRepo(),
+		readMarkers:  NewInMemoryReadMarkerRepo(),
+		mentions:     NewInMemoryMentionRepo(),
+		search:       NewInMemorySearchIndex(),
+		attachments:  NewInMemoryAttachmentRepo(),
+		sessions:     NewInMemorySessionRepo(),
+		resets:       NewInMemoryPasswordResetRepo(),
+		twoFactor:    NewInMemoryTwoFactorRepo(),
+		identities:   NewInMemoryIdentityRepo(),
+	}
+}
+
+// openSQLite creates a database file and brings it up to date the way
+// `server migrate up` does.
+func openSQLite(t *testing.T) *repos {
+	db, err := ConnectSQLite(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	r := sqlRepos(db)
+	r.search = NewSQLiteSearchIndex(db)
+	return r
+}
+
+func sqlRepos(db *DB) *repos {
+	return &repos{
+		users:        NewSQLUserRepo(db),
+		chats:        NewSQLChatRepo(db),
+		messages:     NewSQLMessageRepo(db),
+		memberships:  NewSQLMembershipRepo(db),
+		restrictions: NewSQLRestrictionRepo(db),
+		reactions:    NewSQLReactionRepo(db),
+		invites:      NewSQLInviteRepo(db),
+		readMarkers:  NewSQLReadMarkerRepo(db),
+		mentions:     NewSQLMentionRepo(db),
+		attachments:  NewSQLAttachmentRepo(db),
+		sessions:     NewSQLSessionRepo(db),
+		resets:       NewSQLPasswordResetRepo(db),
+		twoFactor:    NewSQLTwoFactorRepo(db),
+		identities:   NewSQLIdentityRepo(db),
+		db:           db,
+	}
+}
+
+func mustUser(t *testing.T, r *repos, username string) *models.User {
+	t.Helper()
+	user, err := r.users.Create(username, "hash-"+username, "")
+	if err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return user
+}
+
+func mustRoom(t *testing.T, r *repos, name string, isPrivate bool, ownerID int) *models.ChatRoom {
+	t.Helper()
+	room, err := r.chats.Create(name, isPrivate, ownerID)
+	if err != nil {
+		t.Fatalf("create room %s: %v", name, err)
+	}
+	if err := r.memberships.AddMember(room.ID, ownerID, models.RoleOwner); err != nil {
+		t.Fatalf("add owner of %s: %v", name, err)
+	}
+	return room
+}
+
+// mustMessage saves msg, indexing room messages the way the message service
+// does.
+func mustMessage(t *testing.T, r *repos, msg models.Message) *models.Message {
+	t.Helper()
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = testNow
+	}
+	saved, err := r.messages.Save(&msg)
+	if err != nil {
+		t.Fatalf("save message %q: %v", msg.Content, err)
+	}
+	if err := r.search.Index(*saved); err != nil {
+		t.Fatalf("index message %q: %v", msg.Content, err)
+	}
+	return saved
+}
+
+func messageIDs(msgs []models.Message) []int {
+	ids := make([]int, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+// sameInts reports whether a and b hold the same numbers in any order.
+func sameInts(a, b []int) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// RestrictionRepository stores room bans and mutes. Expired restrictions are
+// ignored by every lookup, so they never need to be cleaned up.
+type RestrictionRepository interface {
+	// Put records a restriction, replacing any earlier one of the same kind
+	// for that user and room.
+	Put(restriction *models.Restriction) error
+	// Lift removes a restriction, reporting whether an active one existed.
+	Lift(roomID, userID int, kind models.RestrictionKind, now time.Time) (bool, error)
+	// Active returns the restriction in force at now, or nil if there is none.
+	Active(roomID, userID int, kind models.RestrictionKind, now time.Time) (*models.Restriction, error)
+	ListByRoom(roomID int, now time.Time) ([]models.Restriction, error)
+	// ListRoomsByUser returns the rooms where userID is under an active
+	// restriction of the given kind.
+	ListRoomsByUser(userID int, kind models.RestrictionKind, now time.Time) ([]int, error)
+	DeleteByRoom(roomID int) error
+}
+
+type restrictionKey struct {
+	roomID int
+	userID int
+	kind   models.RestrictionKind
+}
+
+type InMemoryRestrictionRepo struct {
+	mu   sync.RWMutex
+	data map[restrictionKey]models.Restriction
+}
+
+func NewInMemoryRestrictionRepo() *InMemoryRestrictionRepo {
+	return &InMemoryRestrictionRepo{
+		data: make(map[restrictionKey]models.Restriction),
+	}
+}
+
+func (r *InMemoryRestrictionRepo) Put(restriction *models.Restriction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.data[restrictionKey{restriction.RoomID, restriction.UserID, restriction.Kind}] = *restriction
+	return nil
+}
+
+func (r *InMemoryRestrictionRepo) Lift(roomID, userID int, kind models.RestrictionKind, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := restrictionKey{roomID, userID, kind}
+	restriction, ok := r.data[key]
+	if !ok {
+		return false, nil
+	}
+	delete(r.data, key)
+	return restriction.ActiveAt(now), nil
+}
+
+func (r *InMemoryRestrictionRepo) Active(roomID, userID int, kind models.RestrictionKind, now time.Time) (*models.Restriction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	restriction, ok := r.data[restrictionKey{roomID, userID, kind}]
+	if !ok || !restriction.ActiveAt(now) {
+		return nil, nil
+	}
+	return &restriction, nil
+}
+
+func (r *InMemoryRestrictionRepo) ListByRoom(roomID int, now time.Time) ([]models.Restriction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var restrictions []models.Restriction
+	for _, restriction := range r.data {
+		if restriction.RoomID == roomID && restriction.ActiveAt(now) {
+			restrictions = append(restrictions, restriction)
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool {
+		return restrictions[i].CreatedAt.Before(restrictions[j].CreatedAt)
+	})
+	return restrictions, nil
+}
+
+func (r *InMemoryRestrictionRepo) ListRoomsByUser(userID int, kind models.RestrictionKind, now time.Time) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var rooms []int
+	for key, restriction := range r.data {
+		if key.userID == userID && key.kind == kind && restriction.ActiveAt(now) {
+			rooms = append(rooms, key.roomID)
+		}
+	}
+	return rooms, nil
+}
+
+func (r *InMemoryRestrictionRepo) DeleteByRoom(roomID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.data {
+		if key.roomID == roomID {
+			delete(r.data, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestRestrictionRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		carol := mustUser(t, r, "carol")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		other := mustRoom(t, r, "other", false, alice.ID)
+
+		inAnHour := testNow.Add(time.Hour)
+		anHourAgo := testNow.Add(-time.Hour)
+		for _, restriction := range []models.Restriction{
+			{RoomID: room.ID, UserID: bob.ID, Kind: models.RestrictionBan, Reason: "spam", CreatedBy: alice.ID, CreatedAt: testNow},
+			{RoomID: room.ID, UserID: bob.ID, Kind: models.RestrictionMute, CreatedBy: alice.ID, CreatedAt: testNow.Add(time.Second), ExpiresAt: &inAnHour},
+			{RoomID: room.ID, UserID: carol.ID, Kind: models.RestrictionMute, CreatedBy: alice.ID, CreatedAt: anHourAgo.Add(-time.Minute), ExpiresAt: &anHourAgo},
+			{RoomID: other.ID, UserID: bob.ID, Kind: models.RestrictionBan, CreatedBy: alice.ID, CreatedAt: testNow},
+		} {
+			if err := r.restrictions.Put(&restriction); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+
+		ban, err := r.restrictions.Active(room.ID, bob.ID, models.RestrictionBan, testNow)
+		if err != nil || ban == nil || ban.Reason != "spam" || ban.CreatedBy != alice.ID || ban.ExpiresAt != nil {
+			t.Errorf("Active(ban) = %+v, %v", ban, err)
+		}
+		mute, err := r.restrictions.Active(room.ID, bob.ID, models.RestrictionMute, testNow)
+		if err != nil || mute == nil || !mute.ExpiresAt.Equal(inAnHour) {
+			t.Errorf("Active(mute) = %+v, %v", mute, err)
+		}
+		if mute, err := r.restrictions.Active(room.ID, bob.ID, models.RestrictionMute, inAnHour); err != nil || mute != nil {
+			t.Errorf("Active(mute) once expired = %+v, %v", mute, err)
+		}
+		if mute, err := r.restrictions.Active(room.ID, carol.ID, models.RestrictionMute, testNow); err != nil || mute != nil {
+			t.Errorf("Active returned an expired mute: %+v, %v", mute, err)
+		}
+
+		restrictions, err := r.restrictions.ListByRoom(room.ID, testNow)
+		if err != nil || len(restrictions) != 2 {
+			t.Fatalf("ListByRoom = %+v, %v", restrictions, err)
+		}
+		if restrictions[0].Kind != models.RestrictionBan || restrictions[1].Kind != models.RestrictionMute {
+			t.Errorf("ListByRoom is not oldest first: %+v", restrictions)
+		}
+		rooms, err := r.restrictions.ListRoomsByUser(bob.ID, models.RestrictionBan, testNow)
+		if err != nil || !sameInts(rooms, []int{room.ID, other.ID}) {
+			t.Errorf("ListRoomsByUser = %v, %v", rooms, err)
+		}
+
+		replacement := models.Restriction{RoomID: room.ID, UserID: bob.ID, Kind: models.RestrictionBan,
+			Reason: "again", CreatedBy: alice.ID, CreatedAt: testNow.Add(time.Minute)}
+		if err := r.restrictions.Put(&replacement); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if ban, _ := r.restrictions.Active(room.ID, bob.ID, models.RestrictionBan, testNow); ban == nil || ban.Reason != "again" {
+			t.Errorf("Put did not replace the ban: %+v", ban)
+		}
+		if restrictions, _ := r.restrictions.ListByRoom(room.ID, testNow); len(restrictions) != 2 {
+			t.Errorf("Put added a second ban: %+v", restrictions)
+		}
+
+		if lifted, err := r.restrictions.Lift(room.ID, bob.ID, models.RestrictionBan, testNow); err != nil || !lifted {
+			t.Errorf("Lift = %v, %v", lifted, err)
+		}
+		if lifted, err := r.restrictions.Lift(room.ID, bob.ID, models.RestrictionBan, testNow); err != nil || lifted {
+			t.Errorf("Lift twice = %v, %v", lifted, err)
+		}
+		if lifted, err := r.restrictions.Lift(room.ID, carol.ID, models.RestrictionMute, testNow); err != nil || lifted {
+			t.Errorf("Lift of an expired mute = %v, %v", lifted, err)
+		}
+		if ban, _ := r.restrictions.Active(room.ID, bob.ID, models.RestrictionBan, testNow); ban != nil {
+			t.Errorf("a lifted ban is still active: %+v", ban)
+		}
+
+		if err := r.restrictions.DeleteByRoom(room.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if restrictions, err := r.restrictions.ListByRoom(room.ID, testNow); err != nil || len(restrictions) != 0 {
+			t.Errorf("DeleteByRoom kept %+v (%v)", restrictions, err)
+		}
+		if rooms, _ := r.restrictions.ListRoomsByUser(bob.ID, models.RestrictionBan, testNow); !slices.Equal(rooms, []int{other.ID}) {
+			t.Errorf("after DeleteByRoom bob is banned from %v, want only %d", rooms, other.ID)
+		}
+	})
+}
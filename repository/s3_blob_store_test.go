@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The S3 tests need an S3-compatible server such as a local MinIO:
+//
+//	minio server /tmp/minio
+//	TEST_S3_ENDPOINT=http://localhost:9000 TEST_S3_ACCESS_KEY=minioadmin TEST_S3_SECRET_KEY=minioadmin go test ./repository/
+//
+// Each test creates a bucket of its own and removes it afterwards.
+func openS3(t *testing.T) *S3BlobStore {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT is not set")
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	bucket := "chat-test-" + hex.EncodeToString(suffix)
+
+	store, err := NewS3BlobStore(endpoint, bucket, os.Getenv("TEST_S3_REGION"),
+		os.Getenv("TEST_S3_ACCESS_KEY"), os.Getenv("TEST_S3_SECRET_KEY"))
+	if err != nil {
+		t.Fatalf("NewS3BlobStore: %v", err)
+	}
+	if err := s3Bucket(store, http.MethodPut); err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s3Bucket(store, http.MethodDelete); err != nil {
+			t.Errorf("delete bucket %s: %v", bucket, err)
+		}
+	})
+	return store
+}
+
+// s3Bucket creates or deletes the store's bucket.
+func s3Bucket(s *S3BlobStore, method string) error {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestS3BlobStore(t *testing.T) {
+	store := openS3(t)
+
+	const key = "attachments/2026/03/hello world.txt"
+	body := "hello, blob"
+	if err := store.Put(key, strings.NewReader(body), int64(len(body)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := readBlob(t, store, key); got != body {
+		t.Errorf("Get = %q, want %q", got, body)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrBlobNotFound", err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+	if _, err := store.Get("never/stored"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get of a missing key: err = %v, want ErrBlobNotFound", err)
+	}
+	if err := store.Put("/absolute", strings.NewReader(body), int64(len(body)), "text/plain"); !errors.Is(err, errInvalidBlobKey) {
+		t.Errorf("Put of an absolute key: err = %v, want errInvalidBlobKey", err)
+	}
+}
+
+func TestS3BlobStoreSizeMismatch(t *testing.T) {
+	store := openS3(t)
+
+	for name, size := range map[string]int64{"short": 20, "long": 5} {
+		t.Run(name, func(t *testing.T) {
+			key := "mismatch/" + name
+			if err := store.Put(key, strings.NewReader("ten bytes!"), size, "text/plain"); err == nil {
+				t.Errorf("Put of a %s body succeeded", name)
+			}
+			if _, err := store.Get(key); !errors.Is(err, ErrBlobNotFound) {
+				t.Errorf("Put of a %s body left a blob behind (Get err = %v)", name, err)
+			}
+		})
+	}
+}
+
+func readBlob(t *testing.T, store BlobStore, key string) string {
+	t.Helper()
+	rc, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read %s: %v", key, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"chat-backend/models"
+)
+
+// SearchQuery selects room messages containing every one of Terms. Zero
+// values leave a filter off, except RoomIDs: only the listed rooms are
+// searched.
+type SearchQuery struct {
+	Terms    []string // as returned by SearchTerms
+	RoomIDs  []int
+	SenderID int
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Limit    int
+	Offset   int
+}
+
+// SearchHit is one matching message. Higher scores rank first.
+type SearchHit struct {
+	MessageID int
+	Score     float64
+}
+
+// SearchIndex finds room messages by the words in them. Direct messages are
+// never indexed.
+type SearchIndex interface {
+	// Index adds a room message, or replaces it after an edit.
+	Index(msg models.Message) error
+	Remove(messageID int) error
+	RemoveRoom(roomID int) error
+	// Search returns matching messages, best first.
+	Search(q SearchQuery) ([]SearchHit, error)
+}
+
+// SearchTerms splits text into lowercased words the way both search indexes
+// do: letters and digits make up words and everything else separates them.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// BM25 parameters, the usual defaults and the ones SQLite's FTS5 uses
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+type searchDoc struct {
+	roomID    int
+	senderID  int
+	createdAt time.Time
+	length    int            // number of terms
+	terms     map[string]int // term -> frequency
+}
+
+// InMemorySearchIndex is an inverted index from terms to the messages that
+// contain them, ranked with BM25.
+type InMemorySearchIndex struct {
+	mu       sync.RWMutex
+	docs     map[int]*searchDoc
+	postings map[string]map[int]bool // term -> message IDs
+	totalLen int
+}
+
+func NewInMemorySearchIndex() *InMemorySearchIndex {
+	return &InMemorySearchIndex{
+		docs:     make(map[int]*searchDoc),
+		postings: make(map[string]map[int]bool),
+	}
+}
+
+func (x *InMemorySearchIndex) Index(msg models.Message) error {
+	if msg.RoomID == 0 {
+		return nil
+	}
+	terms := SearchTerms(msg.Content)
+	doc := &searchDoc{
+		roomID:    msg.RoomID,
+		senderID:  msg.SenderID,
+		createdAt: msg.CreatedAt,
+		length:    len(terms),
+		terms:     make(map[string]int),
+	}
+	for _, term := range terms {
+		doc.terms[term]++
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(msg.ID)
+	x.docs[msg.ID] = doc
+	x.totalLen += doc.length
+	for term := range doc.terms {
+		if x.postings[term] == nil {
+			x.postings[term] = make(map[int]bool)
+		}
+		x.postings[term][msg.ID] = true
+	}
+	return nil
+}
+
+func (x *InMemorySearchIndex) Remove(messageID int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(messageID)
+	return nil
+}
+
+func (x *InMemorySearchIndex) RemoveRoom(roomID int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for id, doc := range x.docs {
+		if doc.roomID == roomID {
+			x.remove(id)
+		}
+	}
+	return nil
+}
+
+// remove drops a message from the index. Callers must hold x.mu.
+func (x *InMemorySearchIndex) remove(messageID int) {
+	doc, ok := x.docs[messageID]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(x.postings[term], messageID)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	x.totalLen -= doc.length
+	delete(x.docs, messageID)
+}
+
+func (x *InMemorySearchIndex) Search(q SearchQuery) ([]SearchHit, error) {
+	if len(q.Terms) == 0 || len(q.RoomIDs) == 0 {
+		return []SearchHit{}, nil
+	}
+	rooms := make(map[int]bool, len(q.RoomIDs))
+	for _, roomID := range q.RoomIDs {
+		rooms[roomID] = true
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	// Walk the rarest term's postings and check the rest against each doc
+	rarest := q.Terms[0]
+	for _, term := range q.Terms[1:] {
+		if len(x.postings[term]) < len(x.postings[rarest]) {
+			rarest = term
+		}
+	}
+
+	n := float64(len(x.docs))
+	avgLen := float64(x.totalLen) / math.Max(n, 1)
+	hits := []SearchHit{}
+	for id := range x.postings[rarest] {
+		doc := x.docs[id]
+		if !rooms[doc.roomID] || (q.SenderID != 0 && doc.senderID != q.SenderID) {
+			continue
+		}
+		if (!q.From.IsZero() && doc.createdAt.Before(q.From)) || (!q.To.IsZero() && !doc.createdAt.Before(q.To)) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range q.Terms {
+			tf := float64(doc.terms[term])
+			if tf == 0 {
+				score = -1
+				break
+			}
+			// FTS5's idf, floored so very common terms still count a little
+			df := float64(len(x.postings[term]))
+			idf := math.Max(math.Log((n-df+0.5)/(df+0.5)), 1e-6)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avgLen))
+		}
+		if score >= 0 {
+			hits = append(hits, SearchHit{MessageID: id, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].MessageID > hits[j].MessageID // newer first
+	})
+	return pageHits(hits, q.Offset, q.Limit), nil
+}
+
+func pageHits(hits []SearchHit, offset, limit int) []SearchHit {
+	if offset >= len(hits) {
+		return []SearchHit{}
+	}
+	hits = hits[offset:]
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
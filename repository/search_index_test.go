@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func hitIDs(hits []SearchHit) []int {
+	ids := make([]int, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.MessageID
+	}
+	return ids
+}
+
+func TestSearchTerms(t *testing.T) {
+	got := SearchTerms("Hello, World! it's 2026 — Ünïcode_words")
+	want := []string{"hello", "world", "it", "s", "2026", "ünïcode", "words"}
+	if !slices.Equal(got, want) {
+		t.Errorf("SearchTerms = %q, want %q", got, want)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		other := mustRoom(t, r, "other", false, bob.ID)
+
+		at := func(minutes int) time.Time { return testNow.Add(time.Duration(minutes) * time.Minute) }
+		fox := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "The quick brown fox", CreatedAt: at(0)})
+		mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "a lazy dog sleeps", CreatedAt: at(1)})
+		foxes := mustMessage(t, r, models.Message{SenderID: bob.ID, RoomID: room.ID, Content: "quick, quick fox!", CreatedAt: at(2)})
+		elsewhere := mustMessage(t, r, models.Message{SenderID: bob.ID, RoomID: other.ID, Content: "Quick fox", CreatedAt: at(3)})
+		mustMessage(t, r, models.Message{SenderID: alice.ID, ReceiverID: bob.ID, Content: "quick fox in private", CreatedAt: at(4)})
+
+		search := func(q SearchQuery) []int {
+			t.Helper()
+			hits, err := r.search.Search(q)
+			if err != nil {
+				t.Fatalf("Search(%+v): %v", q, err)
+			}
+			return hitIDs(hits)
+		}
+		terms := SearchTerms("quick FOX")
+
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{room.ID}}); !slices.Equal(got, []int{foxes.ID, fox.ID}) {
+			t.Errorf("Search = %v, want %v ranked by term frequency", got, []int{foxes.ID, fox.ID})
+		}
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{room.ID, other.ID}}); !sameInts(got, []int{fox.ID, foxes.ID, elsewhere.ID}) {
+			t.Errorf("Search of two rooms = %v", got)
+		}
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{room.ID, other.ID}, SenderID: bob.ID}); !sameInts(got, []int{foxes.ID, elsewhere.ID}) {
+			t.Errorf("Search by sender = %v", got)
+		}
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{room.ID, other.ID}, From: at(2), To: at(3)}); !slices.Equal(got, []int{foxes.ID}) {
+			t.Errorf("Search by date = %v, want %v", got, []int{foxes.ID})
+		}
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{room.ID}, Limit: 1, Offset: 1}); !slices.Equal(got, []int{fox.ID}) {
+			t.Errorf("Search page 2 = %v, want %v", got, []int{fox.ID})
+		}
+		if got := search(SearchQuery{Terms: SearchTerms("fox dog"), RoomIDs: []int{room.ID}}); len(got) != 0 {
+			t.Errorf("Search matched messages without every term: %v", got)
+		}
+		if got := search(SearchQuery{RoomIDs: []int{room.ID}}); len(got) != 0 {
+			t.Errorf("Search without terms = %v", got)
+		}
+		if got := search(SearchQuery{Terms: terms}); len(got) != 0 {
+			t.Errorf("Search without rooms = %v", got)
+		}
+
+		// The message service reindexes edits and unindexes deletions
+		edited, err := r.messages.UpdateContent(fox.ID, "a slow turtle", alice.ID, at(5))
+		if err != nil {
+			t.Fatalf("UpdateContent: %v", err)
+		}
+		if err := r.search.Index(*edited); err != nil {
+			t.Fatalf("Index: %v", err)
+		}
+		if got := search(SearchQuery{Terms: SearchTerms("turtle"), RoomIDs: []int{room.ID}}); !slices.Equal(got, []int{fox.ID}) {
+			t.Errorf("Search for edited content = %v", got)
+		}
+		if err := r.messages.SoftDelete(foxes.ID, at(6)); err != nil {
+			t.Fatalf("SoftDelete: %v", err)
+		}
+		if err := r.search.Remove(foxes.ID); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{room.ID}}); len(got) != 0 {
+			t.Errorf("Search after edit and delete = %v", got)
+		}
+
+		if err := r.messages.DeleteByRoom(other.ID); err != nil {
+			t.Fatalf("DeleteByRoom: %v", err)
+		}
+		if err := r.search.RemoveRoom(other.ID); err != nil {
+			t.Fatalf("RemoveRoom: %v", err)
+		}
+		if got := search(SearchQuery{Terms: terms, RoomIDs: []int{other.ID}}); len(got) != 0 {
+			t.Errorf("Search of a removed room = %v", got)
+		}
+	})
+}
+
+// TestSearchIndexLoad builds the in-memory index from a database, as the
+// server does for databases without a search index of their own.
+func TestSearchIndexLoad(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		if r.db == nil {
+			t.Skip("nothing to load from")
+		}
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+		room := mustRoom(t, r, "general", false, alice.ID)
+		kept := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "quick fox"})
+		deleted := mustMessage(t, r, models.Message{SenderID: alice.ID, RoomID: room.ID, Content: "quick fox again"})
+		if err := r.messages.SoftDelete(deleted.ID, testNow); err != nil {
+			t.Fatalf("SoftDelete: %v", err)
+		}
+		mustMessage(t, r, models.Message{SenderID: alice.ID, ReceiverID: bob.ID, Content: "quick fox in private"})
+
+		index := NewInMemorySearchIndex()
+		if err := index.Load(r.db); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		hits, err := index.Search(SearchQuery{Terms: SearchTerms("fox"), RoomIDs: []int{room.ID}})
+		if err != nil || !slices.Equal(hitIDs(hits), []int{kept.ID}) {
+			t.Errorf("Search = %v, %v, want %v", hitIDs(hits), err, []int{kept.ID})
+		}
+		hits, err = index.Search(SearchQuery{Terms: SearchTerms("fox"), RoomIDs: []int{room.ID}, SenderID: alice.ID,
+			From: testNow, To: testNow.Add(time.Second)})
+		if err != nil || !slices.Equal(hitIDs(hits), []int{kept.ID}) {
+			t.Errorf("Search by sender and date = %v, %v", hitIDs(hits), err)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// SessionRepository stores login sessions and their refresh tokens.
+type SessionRepository interface {
+	Create(session *models.Session) error
+	FindByID(id string) (*models.Session, error)
+	// ListByUser returns a user's sessions, including ended ones, newest
+	// first.
+	ListByUser(userID int) ([]models.Session, error)
+	// Touch records that a session was used at lastSeenAt.
+	Touch(id string, lastSeenAt time.Time) error
+	// Extend moves the expiry of a session, e.g. when its refresh token is
+	// rotated.
+	Extend(id string, expiresAt time.Time) error
+	Revoke(id string, revokedAt time.Time) error
+
+	SaveRefreshToken(token *models.RefreshToken) error
+	FindRefreshToken(hash string) (*models.RefreshToken, error)
+	// UseRefreshToken marks a refresh token used, reporting false if it
+	// already was, so two refreshes with the same token cannot both succeed.
+	UseRefreshToken(hash string, usedAt time.Time) (bool, error)
+}
+
+type InMemorySessionRepo struct {
+	mu       sync.RWMutex
+	sessions map[string]*models.Session
+	tokens   map[string]*models.RefreshToken // by hash
+}
+
+func NewInMemorySessionRepo() *InMemorySessionRepo {
+	return &InMemorySessionRepo{
+		sessions: make(map[string]*models.Session),
+		tokens:   make(map[string]*models.RefreshToken),
+	}
+}
+
+func (r *InMemorySessionRepo) Create(session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.sessions[session.ID]; exists {
+		return errors.New("session already exists")
+	}
+	stored := *session
+	r.sessions[stored.ID] = &stored
+	return nil
+}
+
+func (r *InMemorySessionRepo) FindByID(id string) (*models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	found := *session
+	return &found, nil
+}
+
+func (r *InMemorySessionRepo) ListByUser(userID int) ([]models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := []models.Session{}
+	for _, session := range r.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (r *InMemorySessionRepo) Touch(id string, lastSeenAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return errors.New("session not found")
+	}
+	session.LastSeenAt = lastSeenAt
+	return nil
+}
+
+func (r *InMemorySessionRepo) Extend(id string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return errors.New("session not found")
+	}
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+func (r *InMemorySessionRepo) Revoke(id string, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return errors.New("session not found")
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func (r *InMemorySessionRepo) SaveRefreshToken(token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tokens[token.Hash]; exists {
+		return errors.New("refresh token already exists")
+	}
+	stored := *token
+	r.tokens[stored.Hash] = &stored
+	return nil
+}
+
+func (r *InMemorySessionRepo) FindRefreshToken(hash string) (*models.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	token, ok := r.tokens[hash]
+	if !ok {
+		return nil, errors.New("refresh token not found")
+	}
+	found := *token
+	return &found, nil
+}
+
+func (r *InMemorySessionRepo) UseRefreshToken(hash string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[hash]
+	if !ok {
+		return false, errors.New("refresh token not found")
+	}
+	if token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &usedAt
+	return true, nil
+}
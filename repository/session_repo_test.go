@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestSessionRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+
+		first := &models.Session{ID: "s1", UserID: alice.ID, UserAgent: "curl/8", IP: "192.0.2.1",
+			CreatedAt: testNow, LastSeenAt: testNow, ExpiresAt: testNow.Add(time.Hour)}
+		second := &models.Session{ID: "s2", UserID: alice.ID, CreatedAt: testNow.Add(time.Minute),
+			LastSeenAt: testNow.Add(time.Minute), ExpiresAt: testNow.Add(time.Hour)}
+		for _, session := range []*models.Session{first, second} {
+			if err := r.sessions.Create(session); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+		if err := r.sessions.Create(first); err == nil {
+			t.Error("Create accepted a taken session ID")
+		}
+
+		found, err := r.sessions.FindByID("s1")
+		if err != nil || found.UserID != alice.ID || found.UserAgent != "curl/8" || found.IP != "192.0.2.1" ||
+			!found.CreatedAt.Equal(testNow) || !found.LastSeenAt.Equal(testNow) || !found.ActiveAt(testNow) {
+			t.Errorf("FindByID = %+v, %v", found, err)
+		}
+		if _, err := r.sessions.FindByID("nope"); err == nil {
+			t.Error("FindByID found a session that does not exist")
+		}
+
+		if err := r.sessions.Touch("s1", testNow.Add(5*time.Minute)); err != nil {
+			t.Fatalf("Touch: %v", err)
+		}
+		if err := r.sessions.Extend("s1", testNow.Add(2*time.Hour)); err != nil {
+			t.Fatalf("Extend: %v", err)
+		}
+		if err := r.sessions.Revoke("s1", testNow.Add(10*time.Minute)); err != nil {
+			t.Fatalf("Revoke: %v", err)
+		}
+		if err := r.sessions.Revoke("s1", testNow.Add(20*time.Minute)); err != nil {
+			t.Fatalf("Revoke twice: %v", err)
+		}
+		found, _ = r.sessions.FindByID("s1")
+		if !found.LastSeenAt.Equal(testNow.Add(5*time.Minute)) || !found.ExpiresAt.Equal(testNow.Add(2*time.Hour)) ||
+			found.RevokedAt == nil || !found.RevokedAt.Equal(testNow.Add(10*time.Minute)) {
+			t.Errorf("after Touch, Extend and Revoke FindByID = %+v", found)
+		}
+		for name, update := range map[string]func(string, time.Time) error{
+			"Touch": r.sessions.Touch, "Extend": r.sessions.Extend, "Revoke": r.sessions.Revoke,
+		} {
+			if err := update("nope", testNow); err == nil {
+				t.Errorf("%s succeeded for a missing session", name)
+			}
+		}
+
+		sessions, err := r.sessions.ListByUser(alice.ID)
+		if err != nil || len(sessions) != 2 || sessions[0].ID != "s2" || sessions[1].ID != "s1" {
+			t.Errorf("ListByUser = %+v, %v, want newest first", sessions, err)
+		}
+	})
+}
+
+func TestSessionRepositoryRefreshTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		session := &models.Session{ID: "s1", UserID: alice.ID, CreatedAt: testNow, LastSeenAt: testNow, ExpiresAt: testNow.Add(time.Hour)}
+		if err := r.sessions.Create(session); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		token := &models.RefreshToken{Hash: "h1", SessionID: "s1", CreatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)}
+		if err := r.sessions.SaveRefreshToken(token); err != nil {
+			t.Fatalf("SaveRefreshToken: %v", err)
+		}
+		if err := r.sessions.SaveRefreshToken(token); err == nil {
+			t.Error("SaveRefreshToken accepted a taken hash")
+		}
+
+		found, err := r.sessions.FindRefreshToken("h1")
+		if err != nil || found.SessionID != "s1" || !found.ExpiresAt.Equal(testNow.Add(time.Hour)) || found.UsedAt != nil {
+			t.Errorf("FindRefreshToken = %+v, %v", found, err)
+		}
+		if _, err := r.sessions.FindRefreshToken("nope"); err == nil {
+			t.Error("FindRefreshToken found a token that does not exist")
+		}
+
+		if used, err := r.sessions.UseRefreshToken("h1", testNow); err != nil || !used {
+			t.Errorf("UseRefreshToken = %v, %v", used, err)
+		}
+		if used, err := r.sessions.UseRefreshToken("h1", testNow); err != nil || used {
+			t.Errorf("UseRefreshToken twice = %v, %v", used, err)
+		}
+		if used, _ := r.sessions.UseRefreshToken("nope", testNow); used {
+			t.Error("UseRefreshToken used a token that does not exist")
+		}
+		if found, _ := r.sessions.FindRefreshToken("h1"); found.UsedAt == nil || !found.UsedAt.Equal(testNow) {
+			t.Errorf("UsedAt = %v, want %v", found.UsedAt, testNow)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"chat-backend/models"
+)
+
+type SQLAttachmentRepo struct {
+	db *DB
+}
+
+func NewSQLAttachmentRepo(db *DB) *SQLAttachmentRepo {
+	return &SQLAttachmentRepo{db: db}
+}
+
+const attachmentColumns = `id, message_id, COALESCE(room_id, 0), uploader_id, file_name, content_type, size, width, height, placeholder, thumbnail_type, blob_key, created_at`
+
+func (r *SQLAttachmentRepo) Save(att *models.Attachment) (*models.Attachment, error) {
+	saved := *att
+	err := r.db.QueryRow(
+		`INSERT INTO message_attachments (message_id, room_id, uploader_id, file_name, content_type, size, width, height, placeholder, thumbnail_type, blob_key, created_at)
+		 VALUES (?, NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		att.MessageID, att.RoomID, att.UploaderID, att.FileName, att.ContentType, att.Size, att.Width, att.Height,
+		att.Placeholder, att.ThumbnailType, att.Key, att.CreatedAt,
+	).Scan(&saved.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (r *SQLAttachmentRepo) FindByID(id int) (*models.Attachment, error) {
+	att, err := scanAttachment(r.db.QueryRow(`SELECT `+attachmentColumns+` FROM message_attachments WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("attachment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return att, nil
+}
+
+func (r *SQLAttachmentRepo) ListByMessages(messageIDs []int) ([]models.Attachment, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	return r.query(`SELECT `+attachmentColumns+` FROM message_attachments
+		WHERE message_id IN (`+placeholders(len(messageIDs))+`) ORDER BY id`, args...)
+}
+
+func (r *SQLAttachmentRepo) ListByRoom(roomID int) ([]models.Attachment, error) {
+	return r.query(`SELECT `+attachmentColumns+` FROM message_attachments WHERE room_id = ? ORDER BY id`, roomID)
+}
+
+func (r *SQLAttachmentRepo) SetPreview(id int, placeholder, thumbnailType string) error {
+	res, err := r.db.Exec(`UPDATE message_attachments SET placeholder = ?, thumbnail_type = ? WHERE id = ?`,
+		placeholder, thumbnailType, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("attachment not found")
+	}
+	return nil
+}
+
+func (r *SQLAttachmentRepo) DeleteByMessage(messageID int) error {
+	_, err := r.db.Exec(`DELETE FROM message_attachments WHERE message_id = ?`, messageID)
+	return err
+}
+
+func (r *SQLAttachmentRepo) DeleteByRoom(roomID int) error {
+	_, err := r.db.Exec(`DELETE FROM message_attachments WHERE room_id = ?`, roomID)
+	return err
+}
+
+func (r *SQLAttachmentRepo) query(query string, args ...any) ([]models.Attachment, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var atts []models.Attachment
+	for rows.Next() {
+		att, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		atts = append(atts, *att)
+	}
+	return atts, rows.Err()
+}
+
+func scanAttachment(s rowScanner) (*models.Attachment, error) {
+	var att models.Attachment
+	err := s.Scan(&att.ID, &att.MessageID, &att.RoomID, &att.UploaderID, &att.FileName, &att.ContentType,
+		&att.Size, &att.Width, &att.Height, &att.Placeholder, &att.ThumbnailType, &att.Key, &att.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLChatRepo struct {
+	db *DB
+}
+
+func NewSQLChatRepo(db *DB) *SQLChatRepo {
+	return &SQLChatRepo{db: db}
+}
+
+const chatRoomColumns = `id, name, is_private, created_by, created_at`
+
+func (r *SQLChatRepo) Create(name string, isPrivate bool, createdBy int) (*models.ChatRoom, error) {
+	if name == "" {
+		return nil, errors.New("room name cannot be empty")
+	}
+
+	room := &models.ChatRoom{
+		Name:      name,
+		IsPrivate: isPrivate,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	err := r.db.QueryRow(
+		`INSERT INTO chat_rooms (name, is_private, created_by, created_at)
+		 VALUES (?, ?, ?, ?) RETURNING id`,
+		room.Name, room.IsPrivate, room.CreatedBy, room.CreatedAt,
+	).Scan(&room.ID)
+	if isUniqueViolation(err) {
+		return nil, errors.New("room name already exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+func (r *SQLChatRepo) List() ([]models.ChatRoom, error) {
+	return r.query(`SELECT ` + chatRoomColumns + ` FROM chat_rooms ORDER BY id`)
+}
+
+func (r *SQLChatRepo) ListAccessibleRooms(userID int, membershipRepo MembershipRepository) ([]models.ChatRoom, error) {
+	// Memberships live in the same database, so the access rules are
+	// evaluated in a single query instead of one lookup per private room.
+	return r.query(`
+		SELECT `+chatRoomColumns+` FROM chat_rooms r
+		WHERE r.is_private = ?
+		   OR EXISTS (SELECT 1 FROM room_memberships m WHERE m.room_id = r.id AND m.user_id = ?)
+		ORDER BY r.id`,
+		false, userID,
+	)
+}
+
+func (r *SQLChatRepo) FindByID(id int) (*models.ChatRoom, error) {
+	return r.findOne(`SELECT `+chatRoomColumns+` FROM chat_rooms WHERE id = ?`, id)
+}
+
+func (r *SQLChatRepo) CanUserAccess(roomID, userID int, membershipRepo MembershipRepository) (bool, error) {
+	room, err := r.FindByID(roomID)
+	if err != nil {
+		return false, err
+	}
+
+	// Public rooms are accessible to everyone
+	if !room.IsPrivate {
+		return true, nil
+	}
+
+	// Check membership for private rooms; creators join them as owners
+	return membershipRepo.IsUserMember(roomID, userID)
+}
+
+func (r *SQLChatRepo) Delete(id int) error {
+	res, err := r.db.Exec(`DELETE FROM chat_rooms WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("room not found")
+	}
+	return nil
+}
+
+// CascadesRoomDelete reports that memberships and messages reference
+// chat_rooms with ON DELETE CASCADE.
+func (r *SQLChatRepo) CascadesRoomDelete() bool {
+	return true
+}
+
+func (r *SQLChatRepo) findOne(query string, args ...any) (*models.ChatRoom, error) {
+	room, err := scanChatRoom(r.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("room not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+func (r *SQLChatRepo) query(query string, args ...any) ([]models.ChatRoom, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []models.ChatRoom{}
+	for rows.Next() {
+		room, err := scanChatRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+	return rooms, rows.Err()
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChatRoom(s rowScanner) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := s.Scan(&room.ID, &room.Name, &room.IsPrivate, &room.CreatedBy, &room.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLIdentityRepo struct {
+	db *DB
+}
+
+func NewSQLIdentityRepo(db *DB) *SQLIdentityRepo {
+	return &SQLIdentityRepo{db: db}
+}
+
+func (r *SQLIdentityRepo) Create(identity *models.ExternalIdentity) error {
+	_, err := r.db.Exec(
+		`INSERT INTO external_identities (provider, subject, user_id, email, created_at) VALUES (?, ?, ?, ?, ?)`,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email, identity.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return errors.New("identity already linked")
+	}
+	return err
+}
+
+func (r *SQLIdentityRepo) Find(provider, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	err := r.db.QueryRow(
+		`SELECT provider, subject, user_id, email, created_at FROM external_identities WHERE provider = ? AND subject = ?`,
+		provider, subject,
+	).Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *SQLIdentityRepo) ListByUser(userID int) ([]models.ExternalIdentity, error) {
+	rows, err := r.db.Query(
+		`SELECT provider, subject, user_id, email, created_at FROM external_identities WHERE user_id = ? ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.ExternalIdentity{}
+	for rows.Next() {
+		var identity models.ExternalIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (r *SQLIdentityRepo) CreateLogin(login *models.OIDCLogin) error {
+	_, err := r.db.Exec(
+		`INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, link_user_id, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, NULLIF(?, 0), ?, ?)`,
+		login.StateHash, login.Provider, login.Nonce, login.CodeVerifier, login.LinkUserID, login.CreatedAt, login.ExpiresAt,
+	)
+	if isUniqueViolation(err) {
+		return errors.New("login already exists")
+	}
+	return err
+}
+
+func (r *SQLIdentityRepo) FindLogin(stateHash string) (*models.OIDCLogin, error) {
+	var login models.OIDCLogin
+	var usedAt sql.NullTime
+	err := r.db.QueryRow(
+		`SELECT state_hash, provider, nonce, code_verifier, COALESCE(link_user_id, 0), created_at, expires_at, used_at
+		 FROM oidc_logins WHERE state_hash = ?`, stateHash,
+	).Scan(&login.StateHash, &login.Provider, &login.Nonce, &login.CodeVerifier, &login.LinkUserID, &login.CreatedAt, &login.ExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("login not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	login.UsedAt = nullTimePtr(usedAt)
+	return &login, nil
+}
+
+func (r *SQLIdentityRepo) UseLogin(stateHash string, usedAt time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE oidc_logins SET used_at = ? WHERE state_hash = ? AND used_at IS NULL`, usedAt, stateHash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
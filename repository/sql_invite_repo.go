@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLInviteRepo struct {
+	db *DB
+}
+
+func NewSQLInviteRepo(db *DB) *SQLInviteRepo {
+	return &SQLInviteRepo{db: db}
+}
+
+const inviteColumns = `id, room_id, code, created_by, created_at, expires_at, max_uses, uses, revoked_at`
+
+func (r *SQLInviteRepo) Create(invite *models.Invite) (*models.Invite, error) {
+	err := r.db.QueryRow(
+		`INSERT INTO room_invites (room_id, code, created_by, created_at, expires_at, max_uses)
+		 VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		invite.RoomID, invite.Code, invite.CreatedBy, invite.CreatedAt, invite.ExpiresAt, invite.MaxUses,
+	).Scan(&invite.ID)
+	if isUniqueViolation(err) {
+		return nil, errors.New("invite code already exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (r *SQLInviteRepo) FindByID(id int) (*models.Invite, error) {
+	return r.findOne(`SELECT `+inviteColumns+` FROM room_invites WHERE id = ?`, id)
+}
+
+func (r *SQLInviteRepo) FindByCode(code string) (*models.Invite, error) {
+	return r.findOne(`SELECT `+inviteColumns+` FROM room_invites WHERE code = ?`, code)
+}
+
+func (r *SQLInviteRepo) ListByRoom(roomID int) ([]models.Invite, error) {
+	rows, err := r.db.Query(`SELECT `+inviteColumns+` FROM room_invites WHERE room_id = ? ORDER BY id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+func (r *SQLInviteRepo) Revoke(id int, revokedAt time.Time) error {
+	res, err := r.db.Exec(`UPDATE room_invites SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, revokedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("invite not found")
+	}
+	return nil
+}
+
+func (r *SQLInviteRepo) Redeem(id int) (bool, error) {
+	// The use limit is checked in the UPDATE itself so concurrent joins
+	// cannot overshoot it.
+	res, err := r.db.Exec(
+		`UPDATE room_invites SET uses = uses + 1
+		 WHERE id = ? AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses)`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *SQLInviteRepo) DeleteByRoom(roomID int) error {
+	_, err := r.db.Exec(`DELETE FROM room_invites WHERE room_id = ?`, roomID)
+	return err
+}
+
+func (r *SQLInviteRepo) findOne(query string, args ...any) (*models.Invite, error) {
+	invite, err := scanInvite(r.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("invite not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func scanInvite(s rowScanner) (*models.Invite, error) {
+	var invite models.Invite
+	var expiresAt, revokedAt sql.NullTime
+	err := s.Scan(&invite.ID, &invite.RoomID, &invite.Code, &invite.CreatedBy, &invite.CreatedAt,
+		&expiresAt, &invite.MaxUses, &invite.Uses, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	invite.ExpiresAt = nullTimePtr(expiresAt)
+	invite.RevokedAt = nullTimePtr(revokedAt)
+	return &invite, nil
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLMembershipRepo struct {
+	db *DB
+}
+
+func NewSQLMembershipRepo(db *DB) *SQLMembershipRepo {
+	return &SQLMembershipRepo{db: db}
+}
+
+func (r *SQLMembershipRepo) AddMember(roomID, userID int, role models.Role) error {
+	_, err := r.db.Exec(
+		`INSERT INTO room_memberships (room_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (room_id, user_id) DO NOTHING`,
+		roomID, userID, role, time.Now(),
+	)
+	return err
+}
+
+func (r *SQLMembershipRepo) RemoveMember(roomID, userID int) error {
+	res, err := r.db.Exec(`DELETE FROM room_memberships WHERE room_id = ? AND user_id = ?`, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("membership not found")
+	}
+	return nil
+}
+
+func (r *SQLMembershipRepo) IsUserMember(roomID, userID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM room_memberships WHERE room_id = ? AND user_id = ?)`,
+		roomID, userID,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (r *SQLMembershipRepo) GetMembership(roomID, userID int) (*models.RoomMembership, error) {
+	memberships, err := r.query(`SELECT `+membershipColumns+` FROM room_memberships WHERE room_id = ? AND user_id = ?`,
+		roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return nil, errors.New("membership not found")
+	}
+	return &memberships[0], nil
+}
+
+func (r *SQLMembershipRepo) SetRole(roomID, userID int, role models.Role) error {
+	res, err := r.db.Exec(`UPDATE room_memberships SET role = ? WHERE room_id = ? AND user_id = ?`, role, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("membership not found")
+	}
+	return nil
+}
+
+func (r *SQLMembershipRepo) TransferOwnership(roomID, fromID, toID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, change := range []struct {
+		userID int
+		role   models.Role
+	}{{fromID, models.RoleAdmin}, {toID, models.RoleOwner}} {
+		res, err := tx.Exec(`UPDATE room_memberships SET role = ? WHERE room_id = ? AND user_id = ?`,
+			change.role, roomID, change.userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errors.New("membership not found")
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLMembershipRepo) GetRoomMembers(roomID int) ([]int, error) {
+	return r.queryIDs(`SELECT user_id FROM room_memberships WHERE room_id = ? ORDER BY id`, roomID)
+}
+
+func (r *SQLMembershipRepo) GetUserRooms(userID int) ([]int, error) {
+	return r.queryIDs(`SELECT room_id FROM room_memberships WHERE user_id = ? ORDER BY id`, userID)
+}
+
+func (r *SQLMembershipRepo) GetMembershipsByRoom(roomID int) ([]models.RoomMembership, error) {
+	return r.query(`SELECT `+membershipColumns+` FROM room_memberships WHERE room_id = ? ORDER BY id`, roomID)
+}
+
+const membershipColumns = `id, room_id, user_id, role, joined_at`
+
+func (r *SQLMembershipRepo) query(query string, args ...any) ([]models.RoomMembership, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []models.RoomMembership
+	for rows.Next() {
+		var m models.RoomMembership
+		if err := rows.Scan(&m.ID, &m.RoomID, &m.UserID, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+func (r *SQLMembershipRepo) queryIDs(query string, args ...any) ([]int, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLMentionRepo struct {
+	db *DB
+}
+
+func NewSQLMentionRepo(db *DB) *SQLMentionRepo {
+	return &SQLMentionRepo{db: db}
+}
+
+const mentionColumns = `id, message_id, room_id, COALESCE(thread_id, 0), user_id, sender_id, kind, created_at, read_at`
+
+func (r *SQLMentionRepo) Add(mentions []models.Mention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range mentions {
+		if _, err := tx.Exec(
+			`INSERT INTO mentions (message_id, room_id, thread_id, user_id, sender_id, kind, created_at)
+			 VALUES (?, ?, NULLIF(?, 0), ?, ?, ?, ?)
+			 ON CONFLICT (message_id, user_id) DO NOTHING`,
+			m.MessageID, m.RoomID, m.ThreadID, m.UserID, m.SenderID, m.Kind, m.CreatedAt,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLMentionRepo) ListByUser(userID, limit int, unreadOnly bool) ([]models.Mention, error) {
+	query := `SELECT ` + mentionColumns + ` FROM mentions WHERE user_id = ?`
+	if unreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	query += ` ORDER BY id DESC`
+	args := []any{userID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return r.query(query, args...)
+}
+
+func (r *SQLMentionRepo) MarkRead(userID int, ids []int, at time.Time) (int, error) {
+	query := `UPDATE mentions SET read_at = ? WHERE user_id = ? AND read_at IS NULL`
+	args := []any{at, userID}
+	if len(ids) > 0 {
+		query += ` AND id IN (` + placeholders(len(ids)) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *SQLMentionRepo) MarkReadUpTo(userID, roomID, messageID int, at time.Time) error {
+	_, err := r.db.Exec(
+		`UPDATE mentions SET read_at = ?
+		 WHERE user_id = ? AND room_id = ? AND thread_id IS NULL AND message_id <= ? AND read_at IS NULL`,
+		at, userID, roomID, messageID,
+	)
+	return err
+}
+
+func (r *SQLMentionRepo) CountUnreadByRoom(userID int) (map[int]int, error) {
+	rows, err := r.db.Query(
+		`SELECT room_id, COUNT(*) FROM mentions WHERE user_id = ? AND read_at IS NULL GROUP BY room_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var roomID, count int
+		if err := rows.Scan(&roomID, &count); err != nil {
+			return nil, err
+		}
+		counts[roomID] = count
+	}
+	return counts, rows.Err()
+}
+
+func (r *SQLMentionRepo) DeleteByMessage(messageID int) error {
+	_, err := r.db.Exec(`DELETE FROM mentions WHERE message_id = ?`, messageID)
+	return err
+}
+
+func (r *SQLMentionRepo) DeleteByRoom(roomID int) error {
+	_, err := r.db.Exec(`DELETE FROM mentions WHERE room_id = ?`, roomID)
+	return err
+}
+
+func (r *SQLMentionRepo) query(query string, args ...any) ([]models.Mention, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []models.Mention{}
+	for rows.Next() {
+		var m models.Mention
+		var readAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.MessageID, &m.RoomID, &m.ThreadID, &m.UserID, &m.SenderID,
+			&m.Kind, &m.CreatedAt, &readAt); err != nil {
+			return nil, err
+		}
+		m.ReadAt = nullTimePtr(readAt)
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
@@ -0,0 +1,306 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLMessageRepo struct {
+	db *DB
+}
+
+func NewSQLMessageRepo(db *DB) *SQLMessageRepo {
+	return &SQLMessageRepo{db: db}
+}
+
+const messageColumns = `m.id, m.sender_id, COALESCE(m.receiver_id, 0), COALESCE(m.room_id, 0), m.content, m.created_at,
+	m.edited_at, m.deleted_at, COALESCE(m.thread_id, 0)`
+
+func (r *SQLMessageRepo) Save(msg *models.Message) (*models.Message, error) {
+	if msg == nil {
+		return nil, errors.New("nil message")
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	err := r.db.QueryRow(
+		`INSERT INTO messages (sender_id, receiver_id, room_id, thread_id, content, created_at)
+		 VALUES (?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, 0), ?, ?) RETURNING id`,
+		msg.SenderID, msg.ReceiverID, msg.RoomID, msg.ThreadID, msg.Content, msg.CreatedAt,
+	).Scan(&msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (r *SQLMessageRepo) ListByRoom(roomID int, limit int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages m
+		WHERE m.room_id = ? AND m.thread_id IS NULL ORDER BY m.id DESC`
+	args := []any{roomID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	msgs, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	// newest-first from the index, returned oldest-first like the in-memory repo
+	reverseMessages(msgs)
+	return msgs, nil
+}
+
+func (r *SQLMessageRepo) ListRoomRange(roomID int, rng MessageRange) ([]models.Message, error) {
+	// Served from idx_messages_room (room_id, id) in either direction
+	return r.listRange(`m.room_id = ? AND m.thread_id IS NULL`, roomID, rng)
+}
+
+func (r *SQLMessageRepo) ListThread(rootID int, rng MessageRange) ([]models.Message, error) {
+	return r.listRange(`m.thread_id = ?`, rootID, rng)
+}
+
+func (r *SQLMessageRepo) listRange(where string, key int, rng MessageRange) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE ` + where
+	args := []any{key}
+	if rng.AfterID > 0 {
+		query += ` AND m.id > ?`
+		args = append(args, rng.AfterID)
+	}
+	if rng.BeforeID > 0 {
+		query += ` AND m.id < ?`
+		args = append(args, rng.BeforeID)
+	}
+	forward := rng.AfterID > 0
+	if forward {
+		query += ` ORDER BY m.id ASC`
+	} else {
+		query += ` ORDER BY m.id DESC`
+	}
+	if rng.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, rng.Limit)
+	}
+
+	msgs, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if !forward {
+		reverseMessages(msgs)
+	}
+	return msgs, nil
+}
+
+func (r *SQLMessageRepo) ThreadSummaries(rootIDs []int) (map[int]models.ThreadSummary, error) {
+	summaries := make(map[int]models.ThreadSummary)
+	if len(rootIDs) == 0 {
+		return summaries, nil
+	}
+
+	args := make([]any, len(rootIDs))
+	for i, id := range rootIDs {
+		args[i] = id
+	}
+	rows, err := r.db.Query(`
+		SELECT t.thread_id, t.replies, m.created_at FROM (
+			SELECT thread_id, COUNT(*) AS replies, MAX(id) AS last_id
+			FROM messages
+			WHERE thread_id IN (`+placeholders(len(rootIDs))+`) AND deleted_at IS NULL
+			GROUP BY thread_id
+		) t JOIN messages m ON m.id = t.last_id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rootID int
+		var summary models.ThreadSummary
+		if err := rows.Scan(&rootID, &summary.ReplyCount, &summary.LastReplyAt); err != nil {
+			return nil, err
+		}
+		summaries[rootID] = summary
+	}
+	return summaries, rows.Err()
+}
+
+func (r *SQLMessageRepo) CountRoomAfter(roomID, afterID, exceptSenderID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(
+		`SELECT COUNT(*) FROM messages
+		 WHERE room_id = ? AND thread_id IS NULL AND id > ? AND sender_id <> ? AND deleted_at IS NULL`,
+		roomID, afterID, exceptSenderID,
+	).Scan(&count)
+	return count, err
+}
+
+func (r *SQLMessageRepo) ListDirect(userA, userB int, limit int) ([]models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages m
+		WHERE m.room_id IS NULL
+		  AND ((m.sender_id = ? AND m.receiver_id = ?) OR (m.sender_id = ? AND m.receiver_id = ?))
+		ORDER BY m.id DESC`
+	args := []any{userA, userB, userB, userA}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	msgs, err := r.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(msgs)
+	return msgs, nil
+}
+
+func (r *SQLMessageRepo) ListDirectThreads(userID int) ([]models.Message, error) {
+	return r.query(`
+		SELECT `+messageColumns+` FROM messages m
+		JOIN (
+			SELECT CASE WHEN sender_id = ? THEN receiver_id ELSE sender_id END AS other_id,
+			       MAX(id) AS last_id
+			FROM messages
+			WHERE room_id IS NULL AND (sender_id = ? OR receiver_id = ?)
+			GROUP BY other_id
+		) t ON t.last_id = m.id
+		ORDER BY m.id DESC`,
+		userID, userID, userID,
+	)
+}
+
+func (r *SQLMessageRepo) FindByID(id int) (*models.Message, error) {
+	msgs, err := r.query(`SELECT `+messageColumns+` FROM messages m WHERE m.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New("message not found")
+	}
+	return &msgs[0], nil
+}
+
+func (r *SQLMessageRepo) UpdateContent(id int, content string, editorID int, editedAt time.Time) (*models.Message, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var oldContent string
+	err = tx.QueryRow(`SELECT content FROM messages WHERE id = ?`, id).Scan(&oldContent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("message not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO message_edits (message_id, editor_id, old_content, edited_at) VALUES (?, ?, ?, ?)`,
+		id, editorID, oldContent, editedAt,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`, content, editedAt, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+func (r *SQLMessageRepo) SoftDelete(id int, deletedAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE messages SET content = '', deleted_at = ? WHERE id = ?`, deletedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("message not found")
+	}
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLMessageRepo) ListEdits(messageID int) ([]models.MessageEdit, error) {
+	rows, err := r.db.Query(
+		`SELECT id, message_id, editor_id, old_content, edited_at FROM message_edits
+		 WHERE message_id = ? ORDER BY id`,
+		messageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEdit{}
+	for rows.Next() {
+		var e models.MessageEdit
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.EditorID, &e.OldContent, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+func (r *SQLMessageRepo) DeleteByRoom(roomID int) error {
+	_, err := r.db.Exec(`DELETE FROM messages WHERE room_id = ?`, roomID)
+	return err
+}
+
+func (r *SQLMessageRepo) query(query string, args ...any) ([]models.Message, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		var editedAt, deletedAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.RoomID, &m.Content, &m.CreatedAt,
+			&editedAt, &deletedAt, &m.ThreadID); err != nil {
+			return nil, err
+		}
+		m.EditedAt = nullTimePtr(editedAt)
+		m.DeletedAt = nullTimePtr(deletedAt)
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// placeholders returns "?, ?, ..." with n placeholders for an IN list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func reverseMessages(msgs []models.Message) {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+}
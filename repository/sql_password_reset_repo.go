@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLPasswordResetRepo struct {
+	db *DB
+}
+
+func NewSQLPasswordResetRepo(db *DB) *SQLPasswordResetRepo {
+	return &SQLPasswordResetRepo{db: db}
+}
+
+func (r *SQLPasswordResetRepo) Create(reset *models.PasswordReset) error {
+	_, err := r.db.Exec(
+		`INSERT INTO password_resets (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		reset.Hash, reset.UserID, reset.CreatedAt, reset.ExpiresAt,
+	)
+	if isUniqueViolation(err) {
+		return errors.New("reset token already exists")
+	}
+	return err
+}
+
+func (r *SQLPasswordResetRepo) FindByHash(hash string) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	var usedAt sql.NullTime
+	err := r.db.QueryRow(
+		`SELECT token_hash, user_id, created_at, expires_at, used_at FROM password_resets WHERE token_hash = ?`, hash,
+	).Scan(&reset.Hash, &reset.UserID, &reset.CreatedAt, &reset.ExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("reset token not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	reset.UsedAt = nullTimePtr(usedAt)
+	return &reset, nil
+}
+
+func (r *SQLPasswordResetRepo) Use(hash string, usedAt time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL`, usedAt, hash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *SQLPasswordResetRepo) UseAllForUser(userID int, usedAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`, usedAt, userID)
+	return err
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLReactionRepo struct {
+	db *DB
+}
+
+func NewSQLReactionRepo(db *DB) *SQLReactionRepo {
+	return &SQLReactionRepo{db: db}
+}
+
+func (r *SQLReactionRepo) Add(messageID, userID int, emoji string, maxDistinct int) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if maxDistinct > 0 {
+		var distinct int
+		var hasEmoji bool
+		err := tx.QueryRow(
+			`SELECT COUNT(DISTINCT emoji), COALESCE(MAX(CASE WHEN emoji = ? THEN 1 ELSE 0 END), 0) = 1
+			 FROM message_reactions WHERE message_id = ?`,
+			emoji, messageID,
+		).Scan(&distinct, &hasEmoji)
+		if err != nil {
+			return false, err
+		}
+		if !hasEmoji && distinct >= maxDistinct {
+			return false, errTooManyReactions
+		}
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (message_id, user_id, emoji) DO NOTHING`,
+		messageID, userID, emoji, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *SQLReactionRepo) Remove(messageID, userID int, emoji string) (bool, error) {
+	res, err := r.db.Exec(
+		`DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?`,
+		messageID, userID, emoji,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *SQLReactionRepo) ListByMessages(messageIDs []int) ([]models.Reaction, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	rows, err := r.db.Query(
+		`SELECT message_id, user_id, emoji, created_at FROM message_reactions
+		 WHERE message_id IN (`+placeholders(len(messageIDs))+`) ORDER BY created_at`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reactions []models.Reaction
+	for rows.Next() {
+		var reaction models.Reaction
+		if err := rows.Scan(&reaction.MessageID, &reaction.UserID, &reaction.Emoji, &reaction.CreatedAt); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, rows.Err()
+}
+
+func (r *SQLReactionRepo) DeleteByMessage(messageID int) error {
+	_, err := r.db.Exec(`DELETE FROM message_reactions WHERE message_id = ?`, messageID)
+	return err
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLReadMarkerRepo struct {
+	db *DB
+}
+
+func NewSQLReadMarkerRepo(db *DB) *SQLReadMarkerRepo {
+	return &SQLReadMarkerRepo{db: db}
+}
+
+const readMarkerColumns = `room_id, user_id, last_read_id, updated_at`
+
+func (r *SQLReadMarkerRepo) Advance(roomID, userID, messageID int, at time.Time) (*models.ReadMarker, bool, error) {
+	// The WHERE clause turns a backwards move into a no-op
+	res, err := r.db.Exec(
+		`INSERT INTO read_markers (`+readMarkerColumns+`) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (room_id, user_id) DO UPDATE SET
+		   last_read_id = excluded.last_read_id, updated_at = excluded.updated_at
+		 WHERE read_markers.last_read_id < excluded.last_read_id`,
+		roomID, userID, messageID, at,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	moved, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	marker, err := r.Get(roomID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	return marker, moved > 0, nil
+}
+
+func (r *SQLReadMarkerRepo) Get(roomID, userID int) (*models.ReadMarker, error) {
+	markers, err := r.query(
+		`SELECT `+readMarkerColumns+` FROM read_markers WHERE room_id = ? AND user_id = ?`,
+		roomID, userID,
+	)
+	if err != nil || len(markers) == 0 {
+		return nil, err
+	}
+	return &markers[0], nil
+}
+
+func (r *SQLReadMarkerRepo) ListByUser(userID int) ([]models.ReadMarker, error) {
+	return r.query(`SELECT `+readMarkerColumns+` FROM read_markers WHERE user_id = ? ORDER BY room_id`, userID)
+}
+
+func (r *SQLReadMarkerRepo) ListByRoom(roomID int) ([]models.ReadMarker, error) {
+	return r.query(`SELECT `+readMarkerColumns+` FROM read_markers WHERE room_id = ? ORDER BY user_id`, roomID)
+}
+
+func (r *SQLReadMarkerRepo) DeleteByRoom(roomID int) error {
+	_, err := r.db.Exec(`DELETE FROM read_markers WHERE room_id = ?`, roomID)
+	return err
+}
+
+func (r *SQLReadMarkerRepo) query(query string, args ...any) ([]models.ReadMarker, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markers := []models.ReadMarker{}
+	for rows.Next() {
+		var m models.ReadMarker
+		if err := rows.Scan(&m.RoomID, &m.UserID, &m.LastReadID, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		markers = append(markers, m)
+	}
+	return markers, rows.Err()
+}
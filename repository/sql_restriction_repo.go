@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLRestrictionRepo struct {
+	db *DB
+}
+
+func NewSQLRestrictionRepo(db *DB) *SQLRestrictionRepo {
+	return &SQLRestrictionRepo{db: db}
+}
+
+const restrictionColumns = `room_id, user_id, kind, reason, created_by, created_at, expires_at`
+
+func (r *SQLRestrictionRepo) Put(restriction *models.Restriction) error {
+	_, err := r.db.Exec(
+		`INSERT INTO room_restrictions (`+restrictionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (room_id, user_id, kind) DO UPDATE SET
+		   reason = excluded.reason, created_by = excluded.created_by,
+		   created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		restriction.RoomID, restriction.UserID, restriction.Kind, restriction.Reason,
+		restriction.CreatedBy, restriction.CreatedAt, restriction.ExpiresAt,
+	)
+	return err
+}
+
+func (r *SQLRestrictionRepo) Lift(roomID, userID int, kind models.RestrictionKind, now time.Time) (bool, error) {
+	active, err := r.Active(roomID, userID, kind, now)
+	if err != nil {
+		return false, err
+	}
+	if _, err := r.db.Exec(
+		`DELETE FROM room_restrictions WHERE room_id = ? AND user_id = ? AND kind = ?`,
+		roomID, userID, kind,
+	); err != nil {
+		return false, err
+	}
+	return active != nil, nil
+}
+
+func (r *SQLRestrictionRepo) Active(roomID, userID int, kind models.RestrictionKind, now time.Time) (*models.Restriction, error) {
+	restrictions, err := r.query(now,
+		`SELECT `+restrictionColumns+` FROM room_restrictions WHERE room_id = ? AND user_id = ? AND kind = ?`,
+		roomID, userID, kind,
+	)
+	if err != nil || len(restrictions) == 0 {
+		return nil, err
+	}
+	return &restrictions[0], nil
+}
+
+func (r *SQLRestrictionRepo) ListByRoom(roomID int, now time.Time) ([]models.Restriction, error) {
+	return r.query(now,
+		`SELECT `+restrictionColumns+` FROM room_restrictions WHERE room_id = ? ORDER BY created_at`,
+		roomID,
+	)
+}
+
+func (r *SQLRestrictionRepo) ListRoomsByUser(userID int, kind models.RestrictionKind, now time.Time) ([]int, error) {
+	restrictions, err := r.query(now,
+		`SELECT `+restrictionColumns+` FROM room_restrictions WHERE user_id = ? AND kind = ?`,
+		userID, kind,
+	)
+	if err != nil {
+		return nil, err
+	}
+	rooms := make([]int, len(restrictions))
+	for i, restriction := range restrictions {
+		rooms[i] = restriction.RoomID
+	}
+	return rooms, nil
+}
+
+// DeleteByRoom removes all of the room's restrictions, active or not.
+func (r *SQLRestrictionRepo) DeleteByRoom(roomID int) error {
+	_, err := r.db.Exec(`DELETE FROM room_restrictions WHERE room_id = ?`, roomID)
+	return err
+}
+
+// query returns the restrictions matched by query that are active at now.
+// Expiry is checked here rather than in SQL because SQLite stores timestamps
+// as text, and there are only ever a handful of rows per room or user.
+func (r *SQLRestrictionRepo) query(now time.Time, query string, args ...any) ([]models.Restriction, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var restrictions []models.Restriction
+	for rows.Next() {
+		var restriction models.Restriction
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&restriction.RoomID, &restriction.UserID, &restriction.Kind, &restriction.Reason,
+			&restriction.CreatedBy, &restriction.CreatedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		restriction.ExpiresAt = nullTimePtr(expiresAt)
+		if restriction.ActiveAt(now) {
+			restrictions = append(restrictions, restriction)
+		}
+	}
+	return restrictions, rows.Err()
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"chat-backend/models"
+)
+
+// SQLiteSearchIndex searches the FTS5 table message_search. Triggers on
+// messages keep the table up to date, so Index and the removals are no-ops.
+type SQLiteSearchIndex struct {
+	db *DB
+}
+
+func NewSQLiteSearchIndex(db *DB) *SQLiteSearchIndex {
+	return &SQLiteSearchIndex{db: db}
+}
+
+func (x *SQLiteSearchIndex) Index(msg models.Message) error { return nil }
+func (x *SQLiteSearchIndex) Remove(messageID int) error     { return nil }
+func (x *SQLiteSearchIndex) RemoveRoom(roomID int) error    { return nil }
+
+func (x *SQLiteSearchIndex) Search(q SearchQuery) ([]SearchHit, error) {
+	if len(q.Terms) == 0 || len(q.RoomIDs) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	// Quote each term so FTS5 treats it as a word, not query syntax; terms
+	// from SearchTerms never contain quotes. Listing them means all must match.
+	quoted := make([]string, len(q.Terms))
+	for i, term := range q.Terms {
+		quoted[i] = `"` + term + `"`
+	}
+	query := `SELECT m.id, -bm25(message_search), m.created_at
+		FROM message_search JOIN messages m ON m.id = message_search.rowid
+		WHERE message_search MATCH ? AND m.deleted_at IS NULL
+		  AND m.room_id IN (` + placeholders(len(q.RoomIDs)) + `)`
+	args := []any{strings.Join(quoted, " ")}
+	for _, roomID := range q.RoomIDs {
+		args = append(args, roomID)
+	}
+	if q.SenderID != 0 {
+		query += ` AND m.sender_id = ?`
+		args = append(args, q.SenderID)
+	}
+	query += ` ORDER BY 2 DESC, m.id DESC`
+
+	rows, err := x.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// SQLite stores timestamps as text, so the date range is checked here
+	// while paging through the ranked rows.
+	hits := []SearchHit{}
+	skipped := 0
+	for rows.Next() && (q.Limit <= 0 || len(hits) < q.Limit) {
+		var hit SearchHit
+		var createdAt time.Time
+		if err := rows.Scan(&hit.MessageID, &hit.Score, &createdAt); err != nil {
+			return nil, err
+		}
+		if (!q.From.IsZero() && createdAt.Before(q.From)) || (!q.To.IsZero() && !createdAt.Before(q.To)) {
+			continue
+		}
+		if skipped < q.Offset {
+			skipped++
+			continue
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// Load indexes every room message in db, for databases without a search
+// index of their own.
+func (x *InMemorySearchIndex) Load(db *DB) error {
+	rows, err := db.Query(`SELECT id, room_id, sender_id, content, created_at FROM messages
+		WHERE room_id IS NOT NULL AND deleted_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.SenderID, &msg.Content, &msg.CreatedAt); err != nil {
+			return err
+		}
+		x.Index(msg)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLSessionRepo struct {
+	db *DB
+}
+
+func NewSQLSessionRepo(db *DB) *SQLSessionRepo {
+	return &SQLSessionRepo{db: db}
+}
+
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at`
+
+func (r *SQLSessionRepo) Create(session *models.Session) error {
+	_, err := r.db.Exec(
+		`INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.UserAgent, session.IP, session.CreatedAt, session.LastSeenAt, session.ExpiresAt,
+	)
+	if isUniqueViolation(err) {
+		return errors.New("session already exists")
+	}
+	return err
+}
+
+func (r *SQLSessionRepo) FindByID(id string) (*models.Session, error) {
+	session, err := scanSession(r.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("session not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *SQLSessionRepo) ListByUser(userID int) ([]models.Session, error) {
+	rows, err := r.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+func (r *SQLSessionRepo) Touch(id string, lastSeenAt time.Time) error {
+	res, err := r.db.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ?`, lastSeenAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("session not found")
+	}
+	return nil
+}
+
+func (r *SQLSessionRepo) Extend(id string, expiresAt time.Time) error {
+	res, err := r.db.Exec(`UPDATE sessions SET expires_at = ? WHERE id = ?`, expiresAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("session not found")
+	}
+	return nil
+}
+
+func (r *SQLSessionRepo) Revoke(id string, revokedAt time.Time) error {
+	res, err := r.db.Exec(`UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, revokedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("session not found")
+	}
+	return nil
+}
+
+func (r *SQLSessionRepo) SaveRefreshToken(token *models.RefreshToken) error {
+	_, err := r.db.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		token.Hash, token.SessionID, token.CreatedAt, token.ExpiresAt,
+	)
+	if isUniqueViolation(err) {
+		return errors.New("refresh token already exists")
+	}
+	return err
+}
+
+func (r *SQLSessionRepo) FindRefreshToken(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var usedAt sql.NullTime
+	err := r.db.QueryRow(
+		`SELECT token_hash, session_id, created_at, expires_at, used_at FROM refresh_tokens WHERE token_hash = ?`, hash,
+	).Scan(&token.Hash, &token.SessionID, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("refresh token not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.UsedAt = nullTimePtr(usedAt)
+	return &token, nil
+}
+
+func (r *SQLSessionRepo) UseRefreshToken(hash string, usedAt time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL`, usedAt, hash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func scanSession(s rowScanner) (*models.Session, error) {
+	var session models.Session
+	var lastSeenAt, revokedAt sql.NullTime
+	err := s.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.CreatedAt,
+		&lastSeenAt, &session.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	// Sessions from before last-seen tracking count as seen when created
+	session.LastSeenAt = session.CreatedAt
+	if lastSeenAt.Valid {
+		session.LastSeenAt = lastSeenAt.Time
+	}
+	session.RevokedAt = nullTimePtr(revokedAt)
+	return &session, nil
+}
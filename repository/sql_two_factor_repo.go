@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLTwoFactorRepo struct {
+	db *DB
+}
+
+func NewSQLTwoFactorRepo(db *DB) *SQLTwoFactorRepo {
+	return &SQLTwoFactorRepo{db: db}
+}
+
+func (r *SQLTwoFactorRepo) SaveTOTP(cred *models.TOTPCredential) error {
+	_, err := r.db.Exec(
+		`INSERT INTO totp_credentials (user_id, secret, last_step, created_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (user_id) DO UPDATE SET
+		   secret = excluded.secret, last_step = excluded.last_step,
+		   created_at = excluded.created_at, confirmed_at = NULL`,
+		cred.UserID, cred.Secret, cred.LastStep, cred.CreatedAt,
+	)
+	return err
+}
+
+func (r *SQLTwoFactorRepo) FindTOTP(userID int) (*models.TOTPCredential, error) {
+	var cred models.TOTPCredential
+	var confirmedAt sql.NullTime
+	err := r.db.QueryRow(
+		`SELECT user_id, secret, last_step, created_at, confirmed_at FROM totp_credentials WHERE user_id = ?`, userID,
+	).Scan(&cred.UserID, &cred.Secret, &cred.LastStep, &cred.CreatedAt, &confirmedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cred.ConfirmedAt = nullTimePtr(confirmedAt)
+	return &cred, nil
+}
+
+func (r *SQLTwoFactorRepo) ConfirmTOTP(userID int, step int64, at time.Time) error {
+	res, err := r.db.Exec(`UPDATE totp_credentials SET confirmed_at = ?, last_step = ? WHERE user_id = ?`, at, step, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("two-factor authentication not set up")
+	}
+	return nil
+}
+
+func (r *SQLTwoFactorRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	res, err := r.db.Exec(`UPDATE totp_credentials SET last_step = ? WHERE user_id = ? AND last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *SQLTwoFactorRepo) DeleteTOTP(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_credentials WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLTwoFactorRepo) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (code_hash, user_id) VALUES (?, ?)`, hash, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLTwoFactorRepo) UseRecoveryCode(userID int, hash string, usedAt time.Time) (bool, error) {
+	res, err := r.db.Exec(
+		`UPDATE recovery_codes SET used_at = ? WHERE code_hash = ? AND user_id = ? AND used_at IS NULL`,
+		usedAt, hash, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *SQLTwoFactorRepo) CountRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	return n, err
+}
+
+func (r *SQLTwoFactorRepo) CreateChallenge(challenge *models.LoginChallenge) error {
+	_, err := r.db.Exec(
+		`INSERT INTO login_challenges (challenge_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		challenge.Hash, challenge.UserID, challenge.CreatedAt, challenge.ExpiresAt,
+	)
+	if isUniqueViolation(err) {
+		return errors.New("challenge already exists")
+	}
+	return err
+}
+
+func (r *SQLTwoFactorRepo) FindChallenge(hash string) (*models.LoginChallenge, error) {
+	var challenge models.LoginChallenge
+	var usedAt sql.NullTime
+	err := r.db.QueryRow(
+		`SELECT challenge_hash, user_id, attempts, created_at, expires_at, used_at FROM login_challenges WHERE challenge_hash = ?`, hash,
+	).Scan(&challenge.Hash, &challenge.UserID, &challenge.Attempts, &challenge.CreatedAt, &challenge.ExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("challenge not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	challenge.UsedAt = nullTimePtr(usedAt)
+	return &challenge, nil
+}
+
+func (r *SQLTwoFactorRepo) FailChallenge(hash string) (int, error) {
+	var attempts int
+	err := r.db.QueryRow(
+		`UPDATE login_challenges SET attempts = attempts + 1 WHERE challenge_hash = ? RETURNING attempts`, hash,
+	).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("challenge not found")
+	}
+	return attempts, err
+}
+
+func (r *SQLTwoFactorRepo) UseChallenge(hash string, usedAt time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE login_challenges SET used_at = ? WHERE challenge_hash = ? AND used_at IS NULL`, usedAt, hash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
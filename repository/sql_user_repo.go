@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chat-backend/models"
+)
+
+type SQLUserRepo struct {
+	db *DB
+}
+
+func NewSQLUserRepo(db *DB) *SQLUserRepo {
+	return &SQLUserRepo{db: db}
+}
+
+func (r *SQLUserRepo) Create(username, hashedPwd, email string) (*models.User, error) {
+	u := &models.User{
+		Username:  username,
+		Password:  hashedPwd,
+		Email:     email,
+		CreatedAt: time.Now(),
+	}
+	err := r.db.QueryRow(
+		`INSERT INTO users (username, password, email, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		u.Username, u.Password, u.Email, u.CreatedAt,
+	).Scan(&u.ID)
+	if isUniqueViolation(err) {
+		return nil, errors.New("username already exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *SQLUserRepo) FindByUsername(username string) (*models.User, error) {
+	return r.findOne(`SELECT id, username, password, email, created_at, tokens_valid_after FROM users WHERE username = ?`, username)
+}
+
+func (r *SQLUserRepo) FindByID(id int) (*models.User, error) {
+	return r.findOne(`SELECT id, username, password, email, created_at, tokens_valid_after FROM users WHERE id = ?`, id)
+}
+
+func (r *SQLUserRepo) UpdatePassword(id int, hashedPwd string) error {
+	return r.update(`UPDATE users SET password = ? WHERE id = ?`, hashedPwd, id)
+}
+
+func (r *SQLUserRepo) UpdateEmail(id int, email string) error {
+	return r.update(`UPDATE users SET email = ? WHERE id = ?`, email, id)
+}
+
+func (r *SQLUserRepo) SetTokensValidAfter(id int, t time.Time) error {
+	return r.update(`UPDATE users SET tokens_valid_after = ? WHERE id = ?`, t, id)
+}
+
+func (r *SQLUserRepo) update(query string, args ...any) error {
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func (r *SQLUserRepo) findOne(query string, args ...any) (*models.User, error) {
+	var u models.User
+	var validAfter sql.NullTime
+	err := r.db.QueryRow(query, args...).Scan(&u.ID, &u.Username, &u.Password, &u.Email, &u.CreatedAt, &validAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.TokensValidAfter = validAfter.Time
+	return &u, nil
+}
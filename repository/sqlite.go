@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	_ "modernc.org/sqlite"
+)
+
+// ConnectSQLite opens the SQLite database at path without touching its schema.
+func ConnectSQLite(path string) (*DB, error) {
+	if path == "" {
+		return nil, errors.New("sqlite database path is empty")
+	}
+
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY
+	// between our own goroutines.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{DB: db, dialect: DialectSQLite}, nil
+}
+
+// OpenSQLite opens (or creates) the SQLite database at path and applies any
+// pending migrations, so a fresh file is ready to use.
+func OpenSQLite(path string) (*DB, error) {
+	db, err := ConnectSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"chat-backend/models"
+)
+
+// TwoFactorRepository stores TOTP secrets, recovery codes and the challenges
+// of logins waiting for a second factor.
+type TwoFactorRepository interface {
+	// SaveTOTP stores a new, unconfirmed secret for a user, replacing any
+	// earlier one.
+	SaveTOTP(cred *models.TOTPCredential) error
+	// FindTOTP returns a user's secret, or nil if they have none.
+	FindTOTP(userID int) (*models.TOTPCredential, error)
+	// ConfirmTOTP finishes enrollment with the code for step.
+	ConfirmTOTP(userID int, step int64, at time.Time) error
+	// UseTOTPStep records that the code for step was accepted, reporting
+	// false if a code for that step or a later one already was.
+	UseTOTPStep(userID int, step int64) (bool, error)
+	// DeleteTOTP turns two-factor authentication off, removing the secret
+	// and the recovery codes.
+	DeleteTOTP(userID int) error
+
+	// ReplaceRecoveryCodes swaps all of a user's recovery codes for new
+	// ones, given by hash.
+	ReplaceRecoveryCodes(userID int, hashes []string) error
+	// UseRecoveryCode marks a code used, reporting false if it is not one of
+	// the user's unused codes.
+	UseRecoveryCode(userID int, hash string, usedAt time.Time) (bool, error)
+	CountRecoveryCodes(userID int) (int, error) // unused ones
+
+	CreateChallenge(challenge *models.LoginChallenge) error
+	FindChallenge(hash string) (*models.LoginChallenge, error)
+	// FailChallenge counts a wrong code against a challenge and returns how
+	// many there have been.
+	FailChallenge(hash string) (int, error)
+	UseChallenge(hash string, usedAt time.Time) (bool, error)
+}
+
+type recoveryCode struct {
+	userID int
+	usedAt *time.Time
+}
+
+type InMemoryTwoFactorRepo struct {
+	mu         sync.Mutex
+	totp       map[int]*models.TOTPCredential    // by user ID
+	codes      map[string]*recoveryCode          // by hash
+	challenges map[string]*models.LoginChallenge // by hash
+}
+
+func NewInMemoryTwoFactorRepo() *InMemoryTwoFactorRepo {
+	return &InMemoryTwoFactorRepo{
+		totp:       make(map[int]*models.TOTPCredential),
+		codes:      make(map[string]*recoveryCode),
+		challenges: make(map[string]*models.LoginChallenge),
+	}
+}
+
+func (r *InMemoryTwoFactorRepo) SaveTOTP(cred *models.TOTPCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *cred
+	stored.ConfirmedAt = nil
+	r.totp[stored.UserID] = &stored
+	return nil
+}
+
+func (r *InMemoryTwoFactorRepo) FindTOTP(userID int) (*models.TOTPCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.totp[userID]
+	if !ok {
+		return nil, nil
+	}
+	found := *cred
+	return &found, nil
+}
+
+func (r *InMemoryTwoFactorRepo) ConfirmTOTP(userID int, step int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.totp[userID]
+	if !ok {
+		return errors.New("two-factor authentication not set up")
+	}
+	cred.ConfirmedAt = &at
+	cred.LastStep = step
+	return nil
+}
+
+func (r *InMemoryTwoFactorRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cred, ok := r.totp[userID]
+	if !ok {
+		return false, errors.New("two-factor authentication not set up")
+	}
+	if cred.LastStep >= step {
+		return false, nil
+	}
+	cred.LastStep = step
+	return true, nil
+}
+
+func (r *InMemoryTwoFactorRepo) DeleteTOTP(userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.totp, userID)
+	for hash, code := range r.codes {
+		if code.userID == userID {
+			delete(r.codes, hash)
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryTwoFactorRepo) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, code := range r.codes {
+		if code.userID == userID {
+			delete(r.codes, hash)
+		}
+	}
+	for _, hash := range hashes {
+		r.codes[hash] = &recoveryCode{userID: userID}
+	}
+	return nil
+}
+
+func (r *InMemoryTwoFactorRepo) UseRecoveryCode(userID int, hash string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[hash]
+	if !ok || code.userID != userID || code.usedAt != nil {
+		return false, nil
+	}
+	code.usedAt = &usedAt
+	return true, nil
+}
+
+func (r *InMemoryTwoFactorRepo) CountRecoveryCodes(userID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, code := range r.codes {
+		if code.userID == userID && code.usedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *InMemoryTwoFactorRepo) CreateChallenge(challenge *models.LoginChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.challenges[challenge.Hash]; exists {
+		return errors.New("challenge already exists")
+	}
+	stored := *challenge
+	r.challenges[stored.Hash] = &stored
+	return nil
+}
+
+func (r *InMemoryTwoFactorRepo) FindChallenge(hash string) (*models.LoginChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[hash]
+	if !ok {
+		return nil, errors.New("challenge not found")
+	}
+	found := *challenge
+	return &found, nil
+}
+
+func (r *InMemoryTwoFactorRepo) FailChallenge(hash string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[hash]
+	if !ok {
+		return 0, errors.New("challenge not found")
+	}
+	challenge.Attempts++
+	return challenge.Attempts, nil
+}
+
+func (r *InMemoryTwoFactorRepo) UseChallenge(hash string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[hash]
+	if !ok {
+		return false, errors.New("challenge not found")
+	}
+	if challenge.UsedAt != nil {
+		return false, nil
+	}
+	challenge.UsedAt = &usedAt
+	return true, nil
+}
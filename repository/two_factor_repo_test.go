@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+func TestTwoFactorRepositoryTOTP(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+
+		if cred, err := r.twoFactor.FindTOTP(alice.ID); err != nil || cred != nil {
+			t.Errorf("FindTOTP without a secret = %+v, %v", cred, err)
+		}
+		if err := r.twoFactor.SaveTOTP(&models.TOTPCredential{UserID: alice.ID, Secret: "FIRST", CreatedAt: testNow}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		cred, err := r.twoFactor.FindTOTP(alice.ID)
+		if err != nil || cred.Secret != "FIRST" || cred.Confirmed() || !cred.CreatedAt.Equal(testNow) {
+			t.Errorf("FindTOTP = %+v, %v", cred, err)
+		}
+
+		if err := r.twoFactor.ConfirmTOTP(alice.ID, 100, testNow.Add(time.Minute)); err != nil {
+			t.Fatalf("ConfirmTOTP: %v", err)
+		}
+		cred, _ = r.twoFactor.FindTOTP(alice.ID)
+		if !cred.Confirmed() || !cred.ConfirmedAt.Equal(testNow.Add(time.Minute)) || cred.LastStep != 100 {
+			t.Errorf("after ConfirmTOTP FindTOTP = %+v", cred)
+		}
+		if err := r.twoFactor.ConfirmTOTP(bob.ID, 100, testNow); err == nil {
+			t.Error("ConfirmTOTP succeeded without a secret")
+		}
+
+		for _, tc := range []struct {
+			step int64
+			want bool
+		}{{99, false}, {100, false}, {101, true}, {101, false}, {103, true}, {102, false}} {
+			if ok, err := r.twoFactor.UseTOTPStep(alice.ID, tc.step); err != nil || ok != tc.want {
+				t.Errorf("UseTOTPStep(%d) = %v, %v, want %v", tc.step, ok, err, tc.want)
+			}
+		}
+
+		// Enrolling again starts over with an unconfirmed secret
+		if err := r.twoFactor.SaveTOTP(&models.TOTPCredential{UserID: alice.ID, Secret: "SECOND", CreatedAt: testNow}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		if cred, _ := r.twoFactor.FindTOTP(alice.ID); cred.Secret != "SECOND" || cred.Confirmed() || cred.LastStep != 0 {
+			t.Errorf("after a second SaveTOTP FindTOTP = %+v", cred)
+		}
+
+		if err := r.twoFactor.ReplaceRecoveryCodes(alice.ID, []string{"r1", "r2"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if err := r.twoFactor.DeleteTOTP(alice.ID); err != nil {
+			t.Fatalf("DeleteTOTP: %v", err)
+		}
+		if cred, err := r.twoFactor.FindTOTP(alice.ID); err != nil || cred != nil {
+			t.Errorf("after DeleteTOTP FindTOTP = %+v, %v", cred, err)
+		}
+		if n, err := r.twoFactor.CountRecoveryCodes(alice.ID); err != nil || n != 0 {
+			t.Errorf("DeleteTOTP kept %d recovery codes (%v)", n, err)
+		}
+	})
+}
+
+func TestTwoFactorRepositoryRecoveryCodes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+		bob := mustUser(t, r, "bob")
+
+		if err := r.twoFactor.ReplaceRecoveryCodes(alice.ID, []string{"a", "b", "c"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if err := r.twoFactor.ReplaceRecoveryCodes(bob.ID, []string{"x"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if n, err := r.twoFactor.CountRecoveryCodes(alice.ID); err != nil || n != 3 {
+			t.Errorf("CountRecoveryCodes = %d, %v, want 3", n, err)
+		}
+
+		for _, tc := range []struct {
+			userID int
+			hash   string
+			want   bool
+		}{
+			{alice.ID, "a", true},
+			{alice.ID, "a", false},
+			{bob.ID, "b", false}, // alice's
+			{alice.ID, "x", false},
+			{alice.ID, "nope", false},
+		} {
+			if ok, err := r.twoFactor.UseRecoveryCode(tc.userID, tc.hash, testNow); err != nil || ok != tc.want {
+				t.Errorf("UseRecoveryCode(%d, %s) = %v, %v, want %v", tc.userID, tc.hash, ok, err, tc.want)
+			}
+		}
+		if n, _ := r.twoFactor.CountRecoveryCodes(alice.ID); n != 2 {
+			t.Errorf("CountRecoveryCodes after one use = %d, want 2", n)
+		}
+
+		if err := r.twoFactor.ReplaceRecoveryCodes(alice.ID, []string{"d"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes: %v", err)
+		}
+		if ok, _ := r.twoFactor.UseRecoveryCode(alice.ID, "b", testNow); ok {
+			t.Error("a replaced recovery code still works")
+		}
+		if n, _ := r.twoFactor.CountRecoveryCodes(alice.ID); n != 1 {
+			t.Errorf("CountRecoveryCodes after replacing = %d, want 1", n)
+		}
+		if n, _ := r.twoFactor.CountRecoveryCodes(bob.ID); n != 1 {
+			t.Errorf("replacing alice's codes changed bob's: %d left", n)
+		}
+	})
+}
+
+func TestTwoFactorRepositoryChallenges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice := mustUser(t, r, "alice")
+
+		challenge := &models.LoginChallenge{Hash: "c1", UserID: alice.ID, CreatedAt: testNow, ExpiresAt: testNow.Add(5 * time.Minute)}
+		if err := r.twoFactor.CreateChallenge(challenge); err != nil {
+			t.Fatalf("CreateChallenge: %v", err)
+		}
+		if err := r.twoFactor.CreateChallenge(challenge); err == nil {
+			t.Error("CreateChallenge accepted a taken hash")
+		}
+
+		found, err := r.twoFactor.FindChallenge("c1")
+		if err != nil || found.UserID != alice.ID || found.Attempts != 0 || !found.ActiveAt(testNow) || found.ActiveAt(testNow.Add(5*time.Minute)) {
+			t.Errorf("FindChallenge = %+v, %v", found, err)
+		}
+		if _, err := r.twoFactor.FindChallenge("nope"); err == nil {
+			t.Error("FindChallenge found a challenge that does not exist")
+		}
+
+		for want := 1; want <= 2; want++ {
+			if n, err := r.twoFactor.FailChallenge("c1"); err != nil || n != want {
+				t.Errorf("FailChallenge = %d, %v, want %d", n, err, want)
+			}
+		}
+		if found, _ := r.twoFactor.FindChallenge("c1"); found.Attempts != 2 {
+			t.Errorf("Attempts = %d, want 2", found.Attempts)
+		}
+		if _, err := r.twoFactor.FailChallenge("nope"); err == nil {
+			t.Error("FailChallenge succeeded for a missing challenge")
+		}
+
+		if used, err := r.twoFactor.UseChallenge("c1", testNow); err != nil || !used {
+			t.Errorf("UseChallenge = %v, %v", used, err)
+		}
+		if used, err := r.twoFactor.UseChallenge("c1", testNow); err != nil || used {
+			t.Errorf("UseChallenge twice = %v, %v", used, err)
+		}
+		if found, _ := r.twoFactor.FindChallenge("c1"); found.UsedAt == nil || found.ActiveAt(testNow) {
+			t.Errorf("a used challenge is still active: %+v", found)
+		}
+	})
+}
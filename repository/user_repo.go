@@ -9,16 +9,21 @@ import (
 )
 
 type UserRepository interface {
-	Create(username, hashedPwd string) (*models.User, error)
+	// Create adds a user with an email address, which may be empty.
+	Create(username, hashedPwd, email string) (*models.User, error)
 	FindByUsername(username string) (*models.User, error)
 	FindByID(id int) (*models.User, error)
+	UpdatePassword(id int, hashedPwd string) error
+	UpdateEmail(id int, email string) error
+	// SetTokensValidAfter makes access tokens issued before t stop working.
+	SetTokensValidAfter(id int, t time.Time) error
 }
 
 type InMemoryUserRepo struct {
-	mu    sync.RWMutex
-	seq   int
-	byID  map[int]*models.User
-	byU   map[string]*models.User
+	mu   sync.RWMutex
+	seq  int
+	byID map[int]*models.User
+	byU  map[string]*models.User
 }
 
 func NewInMemoryUserRepo() *InMemoryUserRepo {
@@ -28,7 +33,7 @@ func NewInMemoryUserRepo() *InMemoryUserRepo {
 	}
 }
 
-func (r *InMemoryUserRepo) Create(username, hashedPwd string) (*models.User, error) {
+func (r *InMemoryUserRepo) Create(username, hashedPwd, email string) (*models.User, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if _, ok := r.byU[username]; ok {
@@ -39,6 +44,7 @@ func (r *InMemoryUserRepo) Create(username, hashedPwd string) (*models.User, err
 		ID:        r.seq,
 		Username:  username,
 		Password:  hashedPwd,
+		Email:     email,
 		CreatedAt: time.Now(),
 	}
 	r.byID[u.ID] = u
@@ -65,3 +71,36 @@ func (r *InMemoryUserRepo) FindByID(id int) (*models.User, error) {
 	}
 	return u, nil
 }
+
+func (r *InMemoryUserRepo) UpdatePassword(id int, hashedPwd string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok {
+		return errors.New("not found")
+	}
+	u.Password = hashedPwd
+	return nil
+}
+
+func (r *InMemoryUserRepo) UpdateEmail(id int, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok {
+		return errors.New("not found")
+	}
+	u.Email = email
+	return nil
+}
+
+func (r *InMemoryUserRepo) SetTokensValidAfter(id int, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok {
+		return errors.New("not found")
+	}
+	u.TokensValidAfter = t
+	return nil
+}
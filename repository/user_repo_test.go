@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"testing"
+)
+
+func TestUserRepository(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, r *repos) {
+		alice, err := r.users.Create("alice", "hash", "")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if alice.ID == 0 || alice.Username != "alice" || alice.Password != "hash" {
+			t.Fatalf("Create returned %+v", alice)
+		}
+		if _, err := r.users.Create("alice", "other", ""); err == nil {
+			t.Error("Create accepted a taken username")
+		}
+		bob, err := r.users.Create("bob", "hash", "bob@example.com")
+		if err != nil || bob.Email != "bob@example.com" {
+			t.Fatalf("Create with an email = %+v, %v", bob, err)
+		}
+		if found, err := r.users.FindByID(bob.ID); err != nil || found.Email != "bob@example.com" {
+			t.Errorf("FindByID after Create with an email = %+v, %v", found, err)
+		}
+
+		found, err := r.users.FindByUsername("alice")
+		if err != nil || found.ID != alice.ID || found.Password != "hash" || found.Email != "" {
+			t.Errorf("FindByUsername = %+v, %v", found, err)
+		}
+		if _, err := r.users.FindByUsername("carol"); err == nil {
+			t.Error("FindByUsername found a user that does not exist")
+		}
+		if _, err := r.users.FindByID(bob.ID + 1); err == nil {
+			t.Error("FindByID found a user that does not exist")
+		}
+
+		if err := r.users.UpdatePassword(alice.ID, "new-hash"); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		if err := r.users.UpdateEmail(alice.ID, "alice@example.com"); err != nil {
+			t.Fatalf("UpdateEmail: %v", err)
+		}
+		if err := r.users.SetTokensValidAfter(alice.ID, testNow); err != nil {
+			t.Fatalf("SetTokensValidAfter: %v", err)
+		}
+		found, err = r.users.FindByID(alice.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		if found.Password != "new-hash" || found.Email != "alice@example.com" || !found.TokensValidAfter.Equal(testNow) {
+			t.Errorf("after updates FindByID = %+v", found)
+		}
+
+		missing := bob.ID + 1
+		if err := r.users.UpdatePassword(missing, "x"); err == nil {
+			t.Error("UpdatePassword succeeded for a missing user")
+		}
+		if err := r.users.UpdateEmail(missing, "x@example.com"); err == nil {
+			t.Error("UpdateEmail succeeded for a missing user")
+		}
+		if err := r.users.SetTokensValidAfter(missing, testNow); err == nil {
+			t.Error("SetTokensValidAfter succeeded for a missing user")
+		}
+	})
+}
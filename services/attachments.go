@@ -0,0 +1,284 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+const (
+	maxAttachments   = 10  // files per message
+	maxFileNameBytes = 255 // longer names are cut down
+)
+
+// FileUpload is a file sent with a message, before it is stored.
+type FileUpload struct {
+	Name string
+	Size int64
+	Body io.Reader
+
+	contentType string // sniffed by checkFiles
+	width       int    // displayed size of decodable images
+	height      int
+}
+
+// MaxUploadRequestSize is the largest multipart request that can carry a
+// message with files: the most files at the size limit, plus room for the
+// other form fields.
+func (s *MessageService) MaxUploadRequestSize() int64 {
+	return maxAttachments*int64(s.config.MaxUploadSize) + 1<<20
+}
+
+// checkFiles validates the files sent with a message and works out their
+// content types from their first bytes; the type the client claimed is
+// ignored. It returns copies ready for storeFiles.
+func (s *MessageService) checkFiles(files []FileUpload) ([]FileUpload, error) {
+	if len(files) > maxAttachments {
+		return nil, errors.New("too many files (max " + strconv.Itoa(maxAttachments) + ")")
+	}
+
+	checked := make([]FileUpload, len(files))
+	for i, f := range files {
+		name := cleanFileName(f.Name)
+		if f.Size <= 0 {
+			return nil, errors.New(name + " is empty")
+		}
+		if f.Size > int64(s.config.MaxUploadSize) {
+			return nil, errors.New(name + " is too large (max " + strconv.Itoa(s.config.MaxUploadSize) + " bytes)")
+		}
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f.Body, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		contentType := http.DetectContentType(head[:n])
+		if !s.uploadTypeAllowed(contentType) {
+			mediaType, _, _ := mime.ParseMediaType(contentType)
+			return nil, errors.New(name + ": files of type " + mediaType + " are not allowed")
+		}
+
+		checked[i] = FileUpload{
+			Name:        name,
+			Size:        f.Size,
+			Body:        io.MultiReader(bytes.NewReader(head[:n]), f.Body),
+			contentType: contentType,
+		}
+
+		// Images are read in full to measure them and strip their metadata
+		if decodableImages[contentType] {
+			data, width, height, err := inspectImage(contentType, checked[i].Body)
+			if err != nil {
+				return nil, errors.New(name + " is not a valid image")
+			}
+			checked[i].Body = bytes.NewReader(data)
+			checked[i].Size = int64(len(data))
+			checked[i].width = width
+			checked[i].height = height
+		}
+	}
+	return checked, nil
+}
+
+// uploadTypeAllowed reports whether contentType matches one of the configured
+// upload types, where "image/*" matches every image type.
+func (s *MessageService) uploadTypeAllowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	family, _, _ := strings.Cut(mediaType, "/")
+	for _, allowed := range s.config.UploadTypes {
+		if allowed == mediaType || allowed == family+"/*" || allowed == "*/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanFileName keeps the base name of an uploaded file without control
+// characters, so it is safe to show and to send back in headers.
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > maxFileNameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// storeFiles writes checked files to blob storage. If one fails, the ones
+// already written are removed again.
+func (s *MessageService) storeFiles(uploaderID int, files []FileUpload) ([]models.Attachment, error) {
+	atts := make([]models.Attachment, 0, len(files))
+	for _, f := range files {
+		att := models.Attachment{
+			UploaderID:  uploaderID,
+			FileName:    f.Name,
+			ContentType: f.contentType,
+			Size:        f.Size,
+			Width:       f.width,
+			Height:      f.height,
+			Key:         newBlobKey(),
+			CreatedAt:   time.Now(),
+		}
+		if err := s.blobs.Put(att.Key, f.Body, f.Size, f.contentType); err != nil {
+			log.Printf("Failed to store upload %s: %v", att.Key, err)
+			deleteBlobs(s.blobs, atts)
+			return nil, errors.New("failed to store " + f.Name)
+		}
+		atts = append(atts, att)
+	}
+	return atts, nil
+}
+
+// saveAttachments records stored files as belonging to msg and queues its
+// images for processing.
+func (s *MessageService) saveAttachments(msg *models.Message, atts []models.Attachment) error {
+	for i := range atts {
+		atts[i].MessageID = msg.ID
+		atts[i].RoomID = msg.RoomID
+		saved, err := s.attachments.Save(&atts[i])
+		if err != nil {
+			deleteBlobs(s.blobs, atts[i:])
+			return err
+		}
+		msg.Attachments = append(msg.Attachments, *saved)
+	}
+	s.queueImages(msg.Attachments)
+	return nil
+}
+
+// deleteAttachments removes a message's files, e.g. when it is deleted.
+func (s *MessageService) deleteAttachments(messageID int) error {
+	atts, err := s.attachments.ListByMessages([]int{messageID})
+	if err != nil || len(atts) == 0 {
+		return err
+	}
+	if err := s.attachments.DeleteByMessage(messageID); err != nil {
+		return err
+	}
+	deleteBlobs(s.blobs, atts)
+	return nil
+}
+
+// attachFiles fills in the attachments of msgs.
+func (s *MessageService) attachFiles(msgs []models.Message) error {
+	ids := make([]int, len(msgs))
+	index := make(map[int]int, len(msgs))
+	for i := range msgs {
+		ids[i] = msgs[i].ID
+		index[msgs[i].ID] = i
+		msgs[i].Attachments = nil
+	}
+	atts, err := s.attachments.ListByMessages(ids)
+	if err != nil {
+		return err
+	}
+	for _, att := range atts {
+		msg := &msgs[index[att.MessageID]]
+		msg.Attachments = append(msg.Attachments, att)
+	}
+	return nil
+}
+
+// OpenAttachment returns an attachment and its contents, if userID can read
+// the message it was sent with. The caller must close the reader.
+func (s *MessageService) OpenAttachment(userID, attachmentID int) (*models.Attachment, io.ReadCloser, error) {
+	att, err := s.readableAttachment(userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := s.blobs.Get(att.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return att, body, nil
+}
+
+// OpenThumbnail returns an image attachment and its thumbnail, of type
+// ThumbnailType, once one has been made. The caller must close the reader.
+func (s *MessageService) OpenThumbnail(userID, attachmentID int) (*models.Attachment, io.ReadCloser, error) {
+	att, err := s.readableAttachment(userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if att.ThumbnailType == "" {
+		return nil, nil, errors.New("attachment has no thumbnail")
+	}
+	body, err := s.blobs.Get(thumbnailKey(*att))
+	if err != nil {
+		return nil, nil, err
+	}
+	return att, body, nil
+}
+
+// readableAttachment loads an attachment if userID can read the message it
+// was sent with.
+func (s *MessageService) readableAttachment(userID, attachmentID int) (*models.Attachment, error) {
+	att, err := s.attachments.FindByID(attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := s.msgs.FindByID(att.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(msg, userID); err != nil {
+		return nil, err
+	}
+	if msg.IsDeleted() {
+		return nil, errors.New("attachment not found")
+	}
+	return att, nil
+}
+
+// deleteBlobs removes the stored files of atts, with the thumbnails of images.
+// Failures only leave unused blobs behind, so they are logged rather than
+// returned.
+func deleteBlobs(blobs repository.BlobStore, atts []models.Attachment) {
+	for _, att := range atts {
+		keys := []string{att.Key}
+		// A thumbnail may be on its way even if none is recorded yet
+		if att.Width > 0 {
+			keys = append(keys, thumbnailKey(att))
+		}
+		for _, key := range keys {
+			if err := blobs.Delete(key); err != nil {
+				log.Printf("Failed to delete blob %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// newBlobKey returns a random key for a new upload, spread over 256
+// directories so none grows too large.
+func newBlobKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	return "attachments/" + id[:2] + "/" + id
+}
@@ -1,8 +1,14 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"log"
 	"time"
+	"unicode/utf8"
 
 	"chat-backend/config"
 	"chat-backend/models"
@@ -12,51 +18,357 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// SessionCloser closes the live connections of a session that has been
+// revoked.
+type SessionCloser interface {
+	DisconnectSession(userID int, sessionID string)
+}
+
 type AuthService struct {
-	users  repository.UserRepository
-	config *config.Config
+	users     repository.UserRepository
+	sessions  repository.SessionRepository
+	resets    repository.PasswordResetRepository
+	twoFactor repository.TwoFactorRepository
+	notifier  Notifier
+	jwt       utils.JWT
+	conns     SessionCloser
+	config    *config.Config
+	throttle  *loginThrottle
+	// dummyHash is compared against when a username does not exist, so that
+	// the reply takes as long as for a wrong password
+	dummyHash []byte
+	now       func() time.Time // the clock, which tests can replace
+}
+
+func NewAuthService(userRepo repository.UserRepository, sessionRepo repository.SessionRepository, resetRepo repository.PasswordResetRepository, twoFactorRepo repository.TwoFactorRepository, notifier Notifier, keys *utils.KeyRing, conns SessionCloser, cfg *config.Config) *AuthService {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte(newSecret(16)), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	throttle := newLoginThrottle(
+		throttlePolicy{backoffAfter: cfg.LoginBackoff, lockoutAfter: cfg.LoginLockout},
+		throttlePolicy{backoffAfter: cfg.IPLoginBackoff, lockoutAfter: cfg.IPLoginLockout},
+		time.Duration(cfg.LockoutTTL)*time.Minute,
+	)
+	return &AuthService{
+		users:     userRepo,
+		sessions:  sessionRepo,
+		resets:    resetRepo,
+		twoFactor: twoFactorRepo,
+		notifier:  notifier,
+		jwt:       utils.JWT{Keys: keys, Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience},
+		conns:     conns,
+		config:    cfg,
+		throttle:  throttle,
+		dummyHash: dummyHash,
+		now:       time.Now,
+	}
+}
+
+// Identity is who a valid access token belongs to.
+type Identity struct {
+	UserID    int
+	Username  string
+	SessionID string
+}
+
+// TokenPair is what a login or refresh gives the client: a short-lived
+// access token and the single-use refresh token that replaces it.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// LoginResult is the outcome of checking a password. Users with two-factor
+// authentication get a Challenge to answer with a code instead of tokens.
+type LoginResult struct {
+	Tokens    *TokenPair
+	User      *models.User
+	Challenge *Challenge
 }
 
-func NewAuthService(userRepo repository.UserRepository, cfg *config.Config) *AuthService {
-	return &AuthService{users: userRepo, config: cfg}
+// ClientInfo describes where a login came from, to help users recognise
+// their sessions.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
 }
 
-func (s *AuthService) Register(username, password string) (*models.User, error) {
+const (
+	maxUserAgentBytes = 255
+	// lastSeenResolution is how stale a session's last-seen time may get
+	// before a request updates it, so that not every request writes.
+	lastSeenResolution = time.Minute
+)
+
+var (
+	errInvalidCredentials  = errors.New("invalid credentials")
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+)
+
+// Register creates an account. email is optional; without one the user can
+// only get a password reset through an admin.
+func (s *AuthService) Register(username, password, email string) (*models.User, error) {
 	if len(username) < 3 || len(username) > 20 {
 		return nil, errors.New("username must be between 3 and 20 characters")
 	}
-	if len(password) < 6 || len(password) > 100 {
-		return nil, errors.New("password must be between 6 and 100 characters")
-	}
-
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashed, err := hashPassword(password)
 	if err != nil {
 		return nil, err
 	}
-	return s.users.Create(username, string(hashed))
+	if email != "" {
+		if email, err = normalizeEmail(email); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.users.Create(username, hashed, email)
 }
 
-func (s *AuthService) Login(username, password string) (string, *models.User, error) {
+// Login checks a password. Without two-factor authentication it starts a
+// session; with it, the login has to be finished with CompleteLogin. After
+// repeated failures for the username or the client's IP address it returns a
+// TooManyAttemptsError without checking anything.
+func (s *AuthService) Login(username, password string, client ClientInfo) (*LoginResult, error) {
 	if username == "" || password == "" {
-		return "", nil, errors.New("username and password are required")
+		return nil, errors.New("username and password are required")
+	}
+	if wait := s.throttle.begin(username, client.IP, s.now()); wait > 0 {
+		return nil, &TooManyAttemptsError{RetryAfter: wait}
 	}
 
 	u, err := s.users.FindByUsername(username)
 	if err != nil {
-		return "", nil, errors.New("invalid credentials")
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, errInvalidCredentials
 	}
 	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
-		return "", nil, errors.New("invalid credentials")
+		return nil, errInvalidCredentials
 	}
-	token, err := s.CreateToken(u.ID, u.Username)
-	return token, u, err
+	s.throttle.succeed(username, client.IP)
+	return s.loginAs(u, client)
+}
+
+// loginAs finishes a login whose first factor has been checked: it starts a
+// session, or asks for a code if user has two-factor authentication on.
+func (s *AuthService) loginAs(user *models.User, client ClientInfo) (*LoginResult, error) {
+	cred, err := s.twoFactor.FindTOTP(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if cred != nil && cred.Confirmed() {
+		challenge, err := s.newChallenge(user)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{User: user, Challenge: challenge}, nil
+	}
+
+	tokens, err := s.StartSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Tokens: tokens, User: user}, nil
+}
+
+// StartSession opens a new session for user and issues its first tokens.
+func (s *AuthService) StartSession(user *models.User, client ClientInfo) (*TokenPair, error) {
+	userAgent := client.UserAgent
+	for len(userAgent) > maxUserAgentBytes {
+		_, size := utf8.DecodeLastRuneInString(userAgent)
+		userAgent = userAgent[:len(userAgent)-size]
+	}
+
+	now := s.now()
+	session := &models.Session{
+		ID:         newSecret(16),
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.sessionTTL()),
+	}
+	if err := s.sessions.Create(session); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, session.ID, now)
+}
+
+// Refresh trades a refresh token for new tokens in the same session. Each
+// refresh token works once: presenting one again means it has leaked, so the
+// whole session is revoked.
+func (s *AuthService) Refresh(refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, errors.New("refresh token is required")
+	}
+	hash := hashToken(refreshToken)
+	token, err := s.sessions.FindRefreshToken(hash)
+	if err != nil {
+		return nil, errInvalidRefreshToken
+	}
+	session, err := s.sessions.FindByID(token.SessionID)
+	if err != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	now := s.now()
+	if !session.ActiveAt(now) || !now.Before(token.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+	used, err := s.sessions.UseRefreshToken(hash, now)
+	if err != nil {
+		return nil, err
+	}
+	if token.UsedAt != nil || !used {
+		log.Printf("Refresh token reused in session %s of user %d; revoking the session", session.ID, session.UserID)
+		if err := s.revoke(session); err != nil {
+			return nil, err
+		}
+		return nil, errInvalidRefreshToken
+	}
+
+	user, err := s.users.FindByID(session.UserID)
+	if err != nil {
+		return nil, errInvalidRefreshToken
+	}
+	if err := s.sessions.Extend(session.ID, now.Add(s.sessionTTL())); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, session.ID, now)
+}
+
+// ListSessions returns userID's active sessions, newest first, marking
+// currentID as the current one.
+func (s *AuthService) ListSessions(userID int, currentID string) ([]models.Session, error) {
+	all, err := s.sessions.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	sessions := []models.Session{}
+	for _, session := range all {
+		if session.ActiveAt(now) {
+			session.Current = session.ID == currentID
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of userID's sessions, closing its WebSocket
+// connections. Logging out revokes the caller's own session.
+func (s *AuthService) RevokeSession(userID int, sessionID string) error {
+	session, err := s.sessions.FindByID(sessionID)
+	if err != nil || session.UserID != userID {
+		return errors.New("session not found")
+	}
+	return s.revoke(session)
+}
+
+// RevokeOtherSessions ends every session of userID except keepID and reports
+// how many were ended.
+func (s *AuthService) RevokeOtherSessions(userID int, keepID string) (int, error) {
+	sessions, err := s.sessions.ListByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	now := s.now()
+	revoked := 0
+	for i := range sessions {
+		if sessions[i].ID == keepID || !sessions[i].ActiveAt(now) {
+			continue
+		}
+		if err := s.revoke(&sessions[i]); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (s *AuthService) revoke(session *models.Session) error {
+	if err := s.sessions.Revoke(session.ID, s.now()); err != nil {
+		return err
+	}
+	s.conns.DisconnectSession(session.UserID, session.ID)
+	return nil
+}
+
+// issueTokens signs an access token for sessionID and stores a new refresh
+// token for it.
+func (s *AuthService) issueTokens(user *models.User, sessionID string, now time.Time) (*TokenPair, error) {
+	ttl := time.Duration(s.config.AccessTTL) * time.Minute
+	access, err := s.jwt.Generate(user.ID, user.Username, sessionID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := newSecret(32)
+	err = s.sessions.SaveRefreshToken(&models.RefreshToken{
+		Hash:      hashToken(refresh),
+		SessionID: sessionID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: int(ttl.Seconds())}, nil
+}
+
+// ParseToken checks an access token, that it was issued after the user's
+// tokens were last invalidated, and that its session, named by the jti claim,
+// is still active. It notes that the session has been seen.
+func (s *AuthService) ParseToken(token string) (*Identity, error) {
+	claims, err := s.jwt.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.FindByID(claims.UserID)
+	// iat only has whole seconds
+	if err != nil || claims.IssuedAt.Before(user.TokensValidAfter.Truncate(time.Second)) {
+		return nil, errors.New("token has been revoked")
+	}
+	session, err := s.sessions.FindByID(claims.SessionID)
+	now := s.now()
+	if err != nil || session.UserID != claims.UserID || !session.ActiveAt(now) {
+		return nil, errors.New("session has ended")
+	}
+	if now.Sub(session.LastSeenAt) >= lastSeenResolution {
+		if err := s.sessions.Touch(session.ID, now); err != nil {
+			log.Printf("Failed to update last seen time of session %s: %v", session.ID, err)
+		}
+	}
+	return &Identity{UserID: claims.UserID, Username: claims.Username, SessionID: claims.SessionID}, nil
+}
+
+// JWKS returns the public keys access tokens can be checked with.
+func (s *AuthService) JWKS() utils.JWKSet {
+	return s.jwt.Keys.JWKS()
+}
+
+// invalidateTokens makes every access token issued to userID so far stop
+// working.
+func (s *AuthService) invalidateTokens(userID int) error {
+	return s.users.SetTokensValidAfter(userID, s.now())
+}
+
+func (s *AuthService) sessionTTL() time.Duration {
+	return time.Duration(s.config.SessionTTL) * 24 * time.Hour
 }
 
-func (s *AuthService) CreateToken(userID int, username string) (string, error) {
-	expiry := time.Duration(s.config.JWTExpiry) * time.Hour
-	return utils.GenerateJWT(s.config.JWTSecret, userID, username, expiry)
+// newSecret returns n random bytes, URL-safe base64 encoded.
+func newSecret(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
 }
 
-func (s *AuthService) ParseToken(token string) (int, string, error) {
-	return utils.ParseJWT(s.config.JWTSecret, token)
+// hashToken is how secret tokens are stored, so a leaked database does not
+// leak working tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
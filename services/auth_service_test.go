@@ -0,0 +1,403 @@
+package services
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+	"chat-backend/utils"
+)
+
+// notification is a message a recordingNotifier was asked to deliver.
+type notification struct {
+	to            *models.User
+	subject, body string
+}
+
+// recordingNotifier hands every message to the test instead of sending it.
+type recordingNotifier struct {
+	sent chan notification
+}
+
+func (n *recordingNotifier) Notify(to *models.User, subject, body string) error {
+	n.sent <- notification{to, subject, body}
+	return nil
+}
+
+// closedSession is a session whose connections a connRecorder was asked to
+// close.
+type closedSession struct {
+	userID    int
+	sessionID string
+}
+
+// connRecorder notes the sessions the service disconnects.
+type connRecorder struct {
+	mu     sync.Mutex
+	closed []closedSession
+}
+
+func (c *connRecorder) DisconnectSession(userID int, sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = append(c.closed, closedSession{userID, sessionID})
+}
+
+// take returns the sessions disconnected since it was last called.
+func (c *connRecorder) take() []closedSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	closed := c.closed
+	c.closed = nil
+	return closed
+}
+
+// authFixture is an AuthService on the in-memory store with a clock the test
+// moves by hand.
+type authFixture struct {
+	svc       *AuthService
+	users     *repository.InMemoryUserRepo
+	resets    *repository.InMemoryPasswordResetRepo
+	twoFactor *repository.InMemoryTwoFactorRepo
+	notifier  *recordingNotifier
+	conns     *connRecorder
+
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newAuthFixture(t *testing.T) *authFixture {
+	cfg := &config.Config{
+		JWTIssuer:      "chat-test",
+		JWTAudience:    "chat-test",
+		AccessTTL:      15,
+		SessionTTL:     30,
+		LoginBackoff:   3,
+		LoginLockout:   10,
+		IPLoginBackoff: 20,
+		IPLoginLockout: 100,
+		LockoutTTL:     15,
+		ResetTokenTTL:  30,
+		ChallengeTTL:   5,
+		TOTPIssuer:     "Chat",
+		AdminUsers:     []string{"admin"},
+	}
+	f := &authFixture{
+		users:     repository.NewInMemoryUserRepo(),
+		resets:    repository.NewInMemoryPasswordResetRepo(),
+		twoFactor: repository.NewInMemoryTwoFactorRepo(),
+		notifier:  &recordingNotifier{sent: make(chan notification, 10)},
+		conns:     &connRecorder{},
+		// Access tokens are checked against the real time, so the fake clock
+		// starts there
+		now: time.Now().Truncate(time.Second),
+	}
+	f.svc = NewAuthService(f.users, repository.NewInMemorySessionRepo(), f.resets, f.twoFactor, f.notifier,
+		utils.NewSecretKeyRing("test-secret"), f.conns, cfg)
+	f.svc.now = f.clock
+	return f
+}
+
+func (f *authFixture) clock() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// set moves the clock to t.
+func (f *authFixture) set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+}
+
+// advance moves the clock forward by d.
+func (f *authFixture) advance(d time.Duration) {
+	f.set(f.clock().Add(d))
+}
+
+func (f *authFixture) register(t *testing.T, username, password, email string) *models.User {
+	t.Helper()
+	user, err := f.svc.Register(username, password, email)
+	if err != nil {
+		t.Fatalf("Register %s: %v", username, err)
+	}
+	return user
+}
+
+// login logs in with a password and fails the test unless that is enough.
+func (f *authFixture) login(t *testing.T, username, password string) *TokenPair {
+	t.Helper()
+	result, err := f.svc.Login(username, password, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login %s: %v", username, err)
+	}
+	if result.Tokens == nil {
+		t.Fatalf("Login %s asked for a second factor", username)
+	}
+	return result.Tokens
+}
+
+// nextNotification waits for the notifier to be asked to send something.
+func (f *authFixture) nextNotification(t *testing.T) notification {
+	t.Helper()
+	select {
+	case n := <-f.notifier.sent:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was sent")
+		return notification{}
+	}
+}
+
+func TestRegisterStoresEmailWithUser(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "Alice@Example.com")
+	if alice.Email != "alice@example.com" {
+		t.Errorf("Register returned email %q", alice.Email)
+	}
+	if stored, err := f.users.FindByUsername("alice"); err != nil || stored.Email != "alice@example.com" {
+		t.Errorf("stored user = %+v, %v", stored, err)
+	}
+
+	if _, err := f.svc.Register("bob", "password", "not an address"); err == nil {
+		t.Fatal("Register accepted an invalid email address")
+	}
+	if _, err := f.users.FindByUsername("bob"); err == nil {
+		t.Error("a failed registration still took the username")
+	}
+	if _, err := f.svc.Register("alice", "password", "other@example.com"); err == nil {
+		t.Fatal("Register accepted a taken username")
+	}
+	if stored, _ := f.users.FindByUsername("alice"); stored.Email != "alice@example.com" {
+		t.Errorf("a failed registration changed the email to %q", stored.Email)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	first := f.login(t, "alice", "password")
+	identity, err := f.svc.ParseToken(first.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+
+	// Each refresh stays in the session and pushes its expiry back, so a
+	// session in use outlives SessionTTL
+	tokens := first
+	for i := 0; i < 3; i++ {
+		f.advance(20 * 24 * time.Hour)
+		next, err := f.svc.Refresh(tokens.RefreshToken)
+		if err != nil {
+			t.Fatalf("Refresh %d: %v", i, err)
+		}
+		if next.RefreshToken == tokens.RefreshToken || next.AccessToken == "" || next.ExpiresIn != 15*60 {
+			t.Fatalf("Refresh %d = %+v", i, next)
+		}
+		got, err := f.svc.ParseToken(next.AccessToken)
+		if err != nil || got.UserID != alice.ID || got.SessionID != identity.SessionID {
+			t.Fatalf("refreshed token: %+v, %v, want session %s", got, err, identity.SessionID)
+		}
+		tokens = next
+	}
+
+	// An unused session runs out, and so do its refresh tokens
+	f.advance(30*24*time.Hour + time.Second)
+	if _, err := f.svc.Refresh(tokens.RefreshToken); err != errInvalidRefreshToken {
+		t.Errorf("Refresh of an expired session: err = %v", err)
+	}
+	if _, err := f.svc.Refresh("made up"); err != errInvalidRefreshToken {
+		t.Errorf("Refresh of an unknown token: err = %v", err)
+	}
+	if len(f.conns.take()) != 0 {
+		t.Error("a session was disconnected")
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	other := f.login(t, "alice", "password")
+	first := f.login(t, "alice", "password")
+	identity, err := f.svc.ParseToken(first.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	second, err := f.svc.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	// A used refresh token coming back means someone else has a copy, so
+	// neither they nor the rightful client can go on in that session
+	if _, err := f.svc.Refresh(first.RefreshToken); err != errInvalidRefreshToken {
+		t.Fatalf("reused refresh token: err = %v", err)
+	}
+	if got, want := f.conns.take(), []closedSession{{alice.ID, identity.SessionID}}; !slices.Equal(got, want) {
+		t.Errorf("disconnected %v, want %v", got, want)
+	}
+	if _, err := f.svc.Refresh(second.RefreshToken); err != errInvalidRefreshToken {
+		t.Errorf("Refresh after reuse: err = %v", err)
+	}
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		if _, err := f.svc.ParseToken(token); err == nil {
+			t.Error("an access token of the revoked session still works")
+		}
+	}
+
+	// Other sessions carry on
+	if _, err := f.svc.ParseToken(other.AccessToken); err != nil {
+		t.Errorf("another session's token: %v", err)
+	}
+	if _, err := f.svc.Refresh(other.RefreshToken); err != nil {
+		t.Errorf("another session's refresh: %v", err)
+	}
+}
+
+func TestParseTokenNeedsLiveSession(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	bob := f.register(t, "bob", "password", "")
+	parse := func(t *testing.T, tokens *TokenPair) error {
+		t.Helper()
+		_, err := f.svc.ParseToken(tokens.AccessToken)
+		return err
+	}
+
+	t.Run("logged out", func(t *testing.T) {
+		tokens := f.login(t, "alice", "password")
+		identity, err := f.svc.ParseToken(tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("ParseToken: %v", err)
+		}
+		if err := f.svc.RevokeSession(bob.ID, identity.SessionID); err == nil {
+			t.Error("bob revoked alice's session")
+		}
+		if err := parse(t, tokens); err != nil {
+			t.Fatalf("after a failed revoke: %v", err)
+		}
+		if err := f.svc.RevokeSession(alice.ID, identity.SessionID); err != nil {
+			t.Fatalf("RevokeSession: %v", err)
+		}
+		if got, want := f.conns.take(), []closedSession{{alice.ID, identity.SessionID}}; !slices.Equal(got, want) {
+			t.Errorf("disconnected %v, want %v", got, want)
+		}
+		if err := parse(t, tokens); err == nil {
+			t.Error("a token of a revoked session still works")
+		}
+		if _, err := f.svc.Refresh(tokens.RefreshToken); err != errInvalidRefreshToken {
+			t.Errorf("Refresh of a revoked session: err = %v", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		start := f.clock()
+		defer f.set(start)
+		tokens := f.login(t, "alice", "password")
+		f.advance(30*24*time.Hour - time.Second)
+		if err := parse(t, tokens); err != nil {
+			t.Fatalf("just before the session expires: %v", err)
+		}
+		f.advance(time.Second)
+		if err := parse(t, tokens); err == nil {
+			t.Error("a token of an expired session still works")
+		}
+	})
+
+	t.Run("tokens invalidated", func(t *testing.T) {
+		tokens := f.login(t, "alice", "password")
+		bobs := f.login(t, "bob", "password")
+		// As a password reset does, a while after the login
+		f.advance(time.Minute)
+		if err := f.svc.invalidateTokens(alice.ID); err != nil {
+			t.Fatalf("invalidateTokens: %v", err)
+		}
+		if err := parse(t, tokens); err == nil {
+			t.Error("a token issued before TokensValidAfter still works")
+		}
+		if err := parse(t, bobs); err != nil {
+			t.Errorf("another user's token: %v", err)
+		}
+		if err := f.users.SetTokensValidAfter(alice.ID, f.clock().Add(-time.Minute)); err != nil {
+			t.Fatalf("SetTokensValidAfter: %v", err)
+		}
+		if err := parse(t, tokens); err != nil {
+			t.Errorf("a token issued after TokensValidAfter: %v", err)
+		}
+	})
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	bob := f.register(t, "bob", "password", "")
+	login := func(userAgent, ip string) string {
+		t.Helper()
+		result, err := f.svc.Login("alice", "password", ClientInfo{UserAgent: userAgent, IP: ip})
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		identity, err := f.svc.ParseToken(result.Tokens.AccessToken)
+		if err != nil {
+			t.Fatalf("ParseToken: %v", err)
+		}
+		f.advance(time.Minute)
+		return identity.SessionID
+	}
+	laptop := login("Firefox", "192.0.2.1")
+	phone := login("Safari", "192.0.2.2")
+	tablet := login("Chrome", "192.0.2.3")
+	revoked := login("curl", "192.0.2.4")
+	f.login(t, "bob", "password")
+	if err := f.svc.RevokeSession(alice.ID, revoked); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	f.conns.take()
+
+	list := func(currentID string) []models.Session {
+		t.Helper()
+		sessions, err := f.svc.ListSessions(alice.ID, currentID)
+		if err != nil {
+			t.Fatalf("ListSessions: %v", err)
+		}
+		return sessions
+	}
+
+	// Newest first, without ended sessions, and marking the caller's
+	sessions := list(phone)
+	var got []string
+	for _, session := range sessions {
+		got = append(got, session.ID)
+		if session.Current != (session.ID == phone) {
+			t.Errorf("session %s (%s) has Current = %v", session.ID, session.UserAgent, session.Current)
+		}
+	}
+	if want := []string{tablet, phone, laptop}; !slices.Equal(got, want) {
+		t.Fatalf("ListSessions = %v, want %v", got, want)
+	}
+	if last := sessions[2]; last.UserAgent != "Firefox" || last.IP != "192.0.2.1" || last.UserID != alice.ID {
+		t.Errorf("laptop session = %+v", last)
+	}
+
+	// Ending the others keeps the caller's and closes the rest's connections
+	n, err := f.svc.RevokeOtherSessions(alice.ID, phone)
+	if err != nil || n != 2 {
+		t.Fatalf("RevokeOtherSessions = %d, %v, want 2", n, err)
+	}
+	closed := f.conns.take()
+	want := []closedSession{{alice.ID, laptop}, {alice.ID, tablet}}
+	if len(closed) != len(want) || !slices.Contains(closed, want[0]) || !slices.Contains(closed, want[1]) {
+		t.Errorf("disconnected %v, want %v", closed, want)
+	}
+	if sessions := list(phone); len(sessions) != 1 || sessions[0].ID != phone || !sessions[0].Current {
+		t.Errorf("after revoking the others: %+v", sessions)
+	}
+	if sessions, err := f.svc.ListSessions(bob.ID, ""); err != nil || len(sessions) != 1 {
+		t.Errorf("bob's sessions = %+v, %v", sessions, err)
+	}
+}
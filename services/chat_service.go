@@ -2,20 +2,40 @@ package services
 
 import (
 	"errors"
+	"log"
+	"sort"
+	"time"
 
 	"chat-backend/models"
 	"chat-backend/repository"
 )
 
+// PresenceTracker reports who is connected, implemented by the websocket hub
+type PresenceTracker interface {
+	Presence(userID int) models.Presence
+	// RoomUsers returns the users connected to a room, by ID, with their usernames.
+	RoomUsers(roomID int) map[int]string
+}
+
 type ChatService struct {
-	chats       repository.ChatRepository
-	users       repository.UserRepository
-	messages    repository.MessageRepository
-	memberships repository.MembershipRepository
+	chats        repository.ChatRepository
+	users        repository.UserRepository
+	messages     repository.MessageRepository
+	reactions    repository.ReactionRepository
+	readMarkers  repository.ReadMarkerRepository
+	mentions     repository.MentionRepository
+	memberships  repository.MembershipRepository
+	restrictions repository.RestrictionRepository
+	invites      repository.InviteRepository
+	index        repository.SearchIndex
+	attachments  repository.AttachmentRepository
+	blobs        repository.BlobStore
+	presence     PresenceTracker
+	auth         *roomAuthorizer
 }
 
-func NewChatService(cr repository.ChatRepository, ur repository.UserRepository, mr repository.MessageRepository, memRepo repository.MembershipRepository) *ChatService {
-	return &ChatService{chats: cr, users: ur, messages: mr, memberships: memRepo}
+func NewChatService(cr repository.ChatRepository, ur repository.UserRepository, mr repository.MessageRepository, rr repository.ReactionRepository, rmRepo repository.ReadMarkerRepository, menRepo repository.MentionRepository, memRepo repository.MembershipRepository, resRepo repository.RestrictionRepository, invRepo repository.InviteRepository, index repository.SearchIndex, attRepo repository.AttachmentRepository, blobs repository.BlobStore, presence PresenceTracker) *ChatService {
+	return &ChatService{chats: cr, users: ur, messages: mr, reactions: rr, readMarkers: rmRepo, mentions: menRepo, memberships: memRepo, restrictions: resRepo, invites: invRepo, index: index, attachments: attRepo, blobs: blobs, presence: presence, auth: newRoomAuthorizer(cr, memRepo, resRepo)}
 }
 
 func (s *ChatService) CreateRoom(name string, isPrivate bool, createdBy int) (*models.ChatRoom, error) {
@@ -34,12 +54,22 @@ func (s *ChatService) CreateRoom(name string, isPrivate bool, createdBy int) (*m
 		return nil, err
 	}
 
-	// Add creator as member of private room
+	// The creator owns the room, public or private
+	err = s.memberships.AddMember(room.ID, createdBy, models.RoleOwner)
+	if err != nil {
+		return nil, errors.New("failed to add creator to room")
+	}
+
+	// Private rooms start with one open-ended invite for the creator to share
 	if isPrivate {
-		err = s.memberships.AddMember(room.ID, createdBy)
+		invite, err := s.newInvite(room.ID, createdBy, 0, 0)
 		if err != nil {
-			return nil, errors.New("failed to add creator to room")
+			return nil, errors.New("failed to create invite")
 		}
+		// Copy so the code is only in this response, not in the stored room
+		created := *room
+		created.InviteCode = invite.Code
+		return &created, nil
 	}
 
 	return room, nil
@@ -50,7 +80,7 @@ func (s *ChatService) ListRooms() ([]models.ChatRoom, error) {
 }
 
 func (s *ChatService) ListAccessibleRooms(userID int) ([]models.ChatRoom, error) {
-	return s.chats.ListAccessibleRooms(userID, s.memberships)
+	return s.auth.accessibleRooms(userID)
 }
 
 func (s *ChatService) GetRoomByID(roomID int) (*models.ChatRoom, error) {
@@ -58,17 +88,45 @@ func (s *ChatService) GetRoomByID(roomID int) (*models.ChatRoom, error) {
 }
 
 func (s *ChatService) JoinRoomByInvite(inviteCode string, userID int) (*models.ChatRoom, error) {
-	room, err := s.chats.FindByInviteCode(inviteCode)
+	invite, err := s.invites.FindByCode(inviteCode)
+	if err != nil {
+		return nil, errors.New("invalid invite code")
+	}
+	if invite.RevokedAt != nil {
+		return nil, errors.New("invite has been revoked")
+	}
+	if invite.Expired(time.Now()) {
+		return nil, errors.New("invite has expired")
+	}
+
+	room, err := s.chats.FindByID(invite.RoomID)
 	if err != nil {
 		return nil, errors.New("invalid invite code")
 	}
 
-	if !room.IsPrivate {
-		return nil, errors.New("invite codes are only for private rooms")
+	ban, err := s.restrictions.Active(room.ID, userID, models.RestrictionBan, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if ban != nil {
+		return nil, restrictedError("you are banned from this room", ban)
+	}
+
+	// Existing members don't use up the invite
+	if isMember, _ := s.memberships.IsUserMember(room.ID, userID); isMember {
+		return room, nil
+	}
+
+	redeemed, err := s.invites.Redeem(invite.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !redeemed {
+		return nil, errors.New("invite has reached its maximum number of uses")
 	}
 
 	// Add user as member
-	err = s.memberships.AddMember(room.ID, userID)
+	err = s.memberships.AddMember(room.ID, userID, models.RoleMember)
 	if err != nil {
 		return nil, errors.New("failed to join room")
 	}
@@ -77,7 +135,11 @@ func (s *ChatService) JoinRoomByInvite(inviteCode string, userID int) (*models.C
 }
 
 func (s *ChatService) CanUserAccessRoom(roomID, userID int) (bool, error) {
-	return s.chats.CanUserAccess(roomID, userID, s.memberships)
+	_, err := s.auth.role(roomID, userID)
+	if IsForbidden(err) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func (s *ChatService) DeleteRoom(roomID int, userID int) error {
@@ -87,14 +149,47 @@ func (s *ChatService) DeleteRoom(roomID int, userID int) error {
 	}
 
 	// Check if room exists and user can delete it
-	room, err := s.chats.FindByID(roomID)
+	if _, err := s.auth.require(roomID, userID, PermDeleteRoom); err != nil {
+		return err
+	}
+
+	// The attachment records go with the room; their files are removed after
+	atts, err := s.attachments.ListByRoom(roomID)
 	if err != nil {
-		return err // Room not found
+		return err
+	}
+
+	// Delete the room
+	if err := s.chats.Delete(roomID); err != nil {
+		return err
+	}
+	if err := s.index.RemoveRoom(roomID); err != nil {
+		log.Printf("Failed to remove room %d from the search index: %v", roomID, err)
 	}
+	deleteBlobs(s.blobs, atts)
 
-	// Only room creator can delete the room
-	if room.CreatedBy != userID {
-		return errors.New("only room creator can delete the room")
+	// SQL backends cascade the delete to everything that belongs to the
+	// room; the in-memory store has to be cleaned up here.
+	if c, ok := s.chats.(repository.RoomCascader); ok && c.CascadesRoomDelete() {
+		return nil
+	}
+	if err := s.attachments.DeleteByRoom(roomID); err != nil {
+		return err
+	}
+	if err := s.deleteRoomReactions(roomID); err != nil {
+		return err
+	}
+	if err := s.mentions.DeleteByRoom(roomID); err != nil {
+		return err
+	}
+	if err := s.readMarkers.DeleteByRoom(roomID); err != nil {
+		return err
+	}
+	if err := s.restrictions.DeleteByRoom(roomID); err != nil {
+		return err
+	}
+	if err := s.invites.DeleteByRoom(roomID); err != nil {
+		return err
 	}
 
 	// Delete all memberships for this room
@@ -110,10 +205,387 @@ func (s *ChatService) DeleteRoom(roomID int, userID int) error {
 		return errors.New("failed to delete messages from room")
 	}
 
-	// Delete the room
-	if err := s.chats.Delete(roomID); err != nil {
+	return nil
+}
+
+// deleteRoomReactions removes the reactions on every message in the room,
+// thread replies included. Reactions are stored by message, so this has to
+// run before the messages themselves are deleted.
+func (s *ChatService) deleteRoomReactions(roomID int) error {
+	roots, err := s.messages.ListRoomRange(roomID, repository.MessageRange{})
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		replies, err := s.messages.ListThread(root.ID, repository.MessageRange{})
+		if err != nil {
+			return err
+		}
+		for _, msg := range append(replies, root) {
+			if err := s.reactions.DeleteByMessage(msg.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListMembers returns the room's members with their roles, highest role
+// first.
+func (s *ChatService) ListMembers(roomID, userID int) ([]models.RoomMembership, error) {
+	if _, err := s.auth.role(roomID, userID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.memberships.GetMembershipsByRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range members {
+		if user, err := s.users.FindByID(members[i].UserID); err == nil {
+			members[i].Username = user.Username
+		}
+	}
+	members = s.withPresence(roomID, members)
+
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if a.Role != b.Role {
+			return a.Role.Outranks(b.Role)
+		}
+		if (a.ID == 0) != (b.ID == 0) {
+			return a.ID != 0 // memberships before connected visitors
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.UserID < b.UserID
+	})
+	return members, nil
+}
+
+// withPresence fills in each member's presence and adds users connected to
+// the room without a membership, as public room visitors are.
+func (s *ChatService) withPresence(roomID int, members []models.RoomMembership) []models.RoomMembership {
+	if s.presence == nil {
+		return members
+	}
+
+	connected := s.presence.RoomUsers(roomID)
+	for i := range members {
+		members[i].Presence = s.presence.Presence(members[i].UserID)
+		delete(connected, members[i].UserID)
+	}
+	for userID, username := range connected {
+		members = append(members, models.RoomMembership{
+			RoomID:   roomID,
+			UserID:   userID,
+			Username: username,
+			Role:     models.RoleMember,
+			Presence: s.presence.Presence(userID),
+		})
+	}
+	return members
+}
+
+// UserRoomIDs returns the rooms userID is a member of.
+func (s *ChatService) UserRoomIDs(userID int) ([]int, error) {
+	return s.memberships.GetUserRooms(userID)
+}
+
+// SetMemberRole promotes or demotes targetID. Callers need PermManageRoles,
+// must outrank the target, and can only hand out roles below their own; the
+// owner role changes hands through TransferOwnership instead.
+func (s *ChatService) SetMemberRole(roomID, actorID, targetID int, role models.Role) (*models.RoomMembership, error) {
+	if role.Rank() == 0 {
+		return nil, errors.New("role must be admin, moderator or member")
+	}
+	if role == models.RoleOwner {
+		return nil, errors.New("use ownership transfer to change the owner")
+	}
+	if actorID == targetID {
+		return nil, errors.New("you cannot change your own role")
+	}
+	if _, err := s.users.FindByID(targetID); err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	actorRole, targetRole, err := s.auth.requireOver(roomID, actorID, targetID, PermManageRoles)
+	if err != nil {
+		return nil, err
+	}
+	if targetRole == "" {
+		return nil, errors.New("user is not a member of this room")
+	}
+	if !actorRole.Outranks(role) {
+		return nil, &ForbiddenError{Reason: "you can only assign roles below your own"}
+	}
+
+	// Members of public rooms may not have a membership row yet
+	if err := s.memberships.AddMember(roomID, targetID, role); err != nil {
+		return nil, err
+	}
+	if err := s.memberships.SetRole(roomID, targetID, role); err != nil {
+		return nil, err
+	}
+	return s.memberships.GetMembership(roomID, targetID)
+}
+
+// TransferOwnership hands the room to targetID. The previous owner stays on
+// as an admin.
+func (s *ChatService) TransferOwnership(roomID, ownerID, targetID int) error {
+	role, err := s.auth.role(roomID, ownerID)
+	if err != nil {
+		return err
+	}
+	if role != models.RoleOwner {
+		return &ForbiddenError{Reason: "only the room owner can transfer ownership"}
+	}
+	if ownerID == targetID {
+		return errors.New("you already own this room")
+	}
+	if _, err := s.users.FindByID(targetID); err != nil {
+		return errors.New("user not found")
+	}
+
+	targetRole, err := s.auth.role(roomID, targetID)
+	if IsForbidden(err) {
+		return errors.New("user is not a member of this room")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.memberships.AddMember(roomID, targetID, targetRole); err != nil {
+		return err
+	}
+	return s.memberships.TransferOwnership(roomID, ownerID, targetID)
+}
+
+// Kick removes targetID from the room. Kicked users of private rooms need a
+// new invite to come back; public rooms they can simply reopen.
+func (s *ChatService) Kick(roomID, actorID, targetID int) error {
+	if err := s.checkModerationTarget(actorID, targetID); err != nil {
+		return err
+	}
+	_, targetRole, err := s.auth.requireOver(roomID, actorID, targetID, PermKickMembers)
+	if err != nil {
 		return err
 	}
+	if targetRole == "" {
+		return errors.New("user is not a member of this room")
+	}
+
+	if isMember, _ := s.memberships.IsUserMember(roomID, targetID); isMember {
+		return s.memberships.RemoveMember(roomID, targetID)
+	}
+	return nil
+}
+
+// Ban removes targetID from the room and keeps them out, for duration or
+// until unbanned when duration is 0.
+func (s *ChatService) Ban(roomID, actorID, targetID int, reason string, duration time.Duration) (*models.Restriction, error) {
+	restriction, err := s.restrict(roomID, actorID, targetID, models.RestrictionBan, PermBanMembers, reason, duration)
+	if err != nil {
+		return nil, err
+	}
+	if isMember, _ := s.memberships.IsUserMember(roomID, targetID); isMember {
+		if err := s.memberships.RemoveMember(roomID, targetID); err != nil {
+			return nil, err
+		}
+	}
+	return restriction, nil
+}
+
+// Mute stops targetID from posting in the room while still letting them read
+// it, for duration or until unmuted when duration is 0.
+func (s *ChatService) Mute(roomID, actorID, targetID int, reason string, duration time.Duration) (*models.Restriction, error) {
+	return s.restrict(roomID, actorID, targetID, models.RestrictionMute, PermMuteMembers, reason, duration)
+}
+
+func (s *ChatService) Unban(roomID, actorID, targetID int) error {
+	return s.lift(roomID, actorID, targetID, models.RestrictionBan, PermBanMembers, "user is not banned from this room")
+}
+
+func (s *ChatService) Unmute(roomID, actorID, targetID int) error {
+	return s.lift(roomID, actorID, targetID, models.RestrictionMute, PermMuteMembers, "user is not muted in this room")
+}
+
+// ListRestrictions returns the room's active bans and mutes.
+func (s *ChatService) ListRestrictions(roomID, userID int) ([]models.Restriction, error) {
+	if _, err := s.auth.require(roomID, userID, PermBanMembers); err != nil {
+		return nil, err
+	}
+
+	restrictions, err := s.restrictions.ListByRoom(roomID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range restrictions {
+		if user, err := s.users.FindByID(restrictions[i].UserID); err == nil {
+			restrictions[i].Username = user.Username
+		}
+	}
+	return restrictions, nil
+}
+
+func (s *ChatService) restrict(roomID, actorID, targetID int, kind models.RestrictionKind, perm Permission, reason string, duration time.Duration) (*models.Restriction, error) {
+	if err := s.checkModerationTarget(actorID, targetID); err != nil {
+		return nil, err
+	}
+	if duration < 0 {
+		return nil, errors.New("duration cannot be negative")
+	}
+	if len(reason) > 200 {
+		return nil, errors.New("reason too long (maximum 200 characters)")
+	}
+	if _, _, err := s.auth.requireOver(roomID, actorID, targetID, perm); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	restriction := &models.Restriction{
+		RoomID:    roomID,
+		UserID:    targetID,
+		Kind:      kind,
+		Reason:    reason,
+		CreatedBy: actorID,
+		CreatedAt: now,
+	}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		restriction.ExpiresAt = &expiresAt
+	}
+	if err := s.restrictions.Put(restriction); err != nil {
+		return nil, err
+	}
+	return restriction, nil
+}
 
+func (s *ChatService) lift(roomID, actorID, targetID int, kind models.RestrictionKind, perm Permission, notRestricted string) error {
+	if _, _, err := s.auth.requireOver(roomID, actorID, targetID, perm); err != nil {
+		return err
+	}
+	lifted, err := s.restrictions.Lift(roomID, targetID, kind, time.Now())
+	if err != nil {
+		return err
+	}
+	if !lifted {
+		return errors.New(notRestricted)
+	}
 	return nil
 }
+
+func (s *ChatService) checkModerationTarget(actorID, targetID int) error {
+	if actorID == targetID {
+		return errors.New("you cannot moderate yourself")
+	}
+	if _, err := s.users.FindByID(targetID); err != nil {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// CreateInvite makes a new invite to a private room. expiresIn and maxUses
+// are optional; 0 means the invite never expires or has unlimited uses.
+func (s *ChatService) CreateInvite(roomID, userID int, expiresIn time.Duration, maxUses int) (*models.Invite, error) {
+	if expiresIn < 0 {
+		return nil, errors.New("expiry cannot be negative")
+	}
+	if maxUses < 0 {
+		return nil, errors.New("max uses cannot be negative")
+	}
+	if _, err := s.auth.require(roomID, userID, PermManageInvites); err != nil {
+		return nil, err
+	}
+
+	room, err := s.chats.FindByID(roomID)
+	if err != nil {
+		return nil, errors.New("room not found")
+	}
+	if !room.IsPrivate {
+		return nil, errors.New("invite codes are only for private rooms")
+	}
+
+	return s.newInvite(roomID, userID, expiresIn, maxUses)
+}
+
+// ListInvites returns the room's invites that can still be redeemed, with
+// how often each has been used.
+func (s *ChatService) ListInvites(roomID, userID int) ([]models.Invite, error) {
+	if _, err := s.auth.require(roomID, userID, PermManageInvites); err != nil {
+		return nil, err
+	}
+
+	invites, err := s.invites.ListByRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active := invites[:0]
+	for _, invite := range invites {
+		if invite.ActiveAt(now) {
+			active = append(active, invite)
+		}
+	}
+	return active, nil
+}
+
+// RevokeInvite stops an invite from being redeemed. Members who already
+// joined with it keep their access.
+func (s *ChatService) RevokeInvite(inviteID, userID int) error {
+	invite, err := s.managedInvite(inviteID, userID)
+	if err != nil {
+		return err
+	}
+	return s.invites.Revoke(invite.ID, time.Now())
+}
+
+// RotateInvite revokes an invite and replaces it with a fresh code that has
+// the same use limit and lifetime, e.g. after the old code leaked.
+func (s *ChatService) RotateInvite(inviteID, userID int) (*models.Invite, error) {
+	invite, err := s.managedInvite(inviteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if invite.RevokedAt != nil {
+		return nil, errors.New("invite has already been revoked")
+	}
+
+	var lifetime time.Duration
+	if invite.ExpiresAt != nil {
+		lifetime = invite.ExpiresAt.Sub(invite.CreatedAt)
+	}
+	if err := s.invites.Revoke(invite.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.newInvite(invite.RoomID, userID, lifetime, invite.MaxUses)
+}
+
+// managedInvite loads an invite that userID may manage.
+func (s *ChatService) managedInvite(inviteID, userID int) (*models.Invite, error) {
+	invite, err := s.invites.FindByID(inviteID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.auth.require(invite.RoomID, userID, PermManageInvites); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func (s *ChatService) newInvite(roomID, createdBy int, expiresIn time.Duration, maxUses int) (*models.Invite, error) {
+	now := time.Now()
+	invite := &models.Invite{
+		RoomID:    roomID,
+		Code:      models.GenerateInviteCode(),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		MaxUses:   maxUses,
+	}
+	if expiresIn > 0 {
+		expiresAt := now.Add(expiresIn)
+		invite.ExpiresAt = &expiresAt
+	}
+	return s.invites.Create(invite)
+}
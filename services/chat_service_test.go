@@ -0,0 +1,463 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+type noPresence struct{}
+
+func (noPresence) Presence(int) models.Presence { return models.PresenceOffline }
+func (noPresence) RoomUsers(int) map[int]string { return nil }
+
+// chatFixture is a ChatService and a MessageService on the in-memory store,
+// with the repositories kept at hand to seed and inspect.
+type chatFixture struct {
+	svc          *ChatService
+	msgs         *MessageService
+	users        *repository.InMemoryUserRepo
+	messages     *repository.InMemoryMessageRepo
+	reactions    *repository.InMemoryReactionRepo
+	readMarkers  *repository.InMemoryReadMarkerRepo
+	mentions     *repository.InMemoryMentionRepo
+	memberships  *repository.InMemoryMembershipRepo
+	restrictions *repository.InMemoryRestrictionRepo
+	invites      *repository.InMemoryInviteRepo
+	attachments  *repository.InMemoryAttachmentRepo
+}
+
+func newChatFixture(t *testing.T) *chatFixture {
+	blobs, err := repository.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("blob store: %v", err)
+	}
+	f := &chatFixture{
+		users:        repository.NewInMemoryUserRepo(),
+		messages:     repository.NewInMemoryMessageRepo(),
+		reactions:    repository.NewInMemoryReactionRepo(),
+		readMarkers:  repository.NewInMemoryReadMarkerRepo(),
+		mentions:     repository.NewInMemoryMentionRepo(),
+		memberships:  repository.NewInMemoryMembershipRepo(),
+		restrictions: repository.NewInMemoryRestrictionRepo(),
+		invites:      repository.NewInMemoryInviteRepo(),
+		attachments:  repository.NewInMemoryAttachmentRepo(),
+	}
+	chats, index := repository.NewInMemoryChatRepo(), repository.NewInMemorySearchIndex()
+	f.svc = NewChatService(chats, f.users, f.messages, f.reactions, f.readMarkers, f.mentions, f.memberships, f.restrictions, f.invites, index, f.attachments, blobs, noPresence{})
+	cfg := &config.Config{MaxMessageLength: 1000, MaxReactions: 20}
+	f.msgs = NewMessageService(f.messages, f.reactions, f.readMarkers, f.mentions, index, f.attachments, blobs,
+		chats, f.memberships, f.restrictions, f.users, nopHub{}, noPresence{}, cfg)
+	return f
+}
+
+func (f *chatFixture) user(t *testing.T, username string) int {
+	t.Helper()
+	user, err := f.users.Create(username, "hash", "")
+	if err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return user.ID
+}
+
+func (f *chatFixture) room(t *testing.T, name string, isPrivate bool, ownerID int) int {
+	t.Helper()
+	room, err := f.svc.CreateRoom(name, isPrivate, ownerID)
+	if err != nil {
+		t.Fatalf("create room %s: %v", name, err)
+	}
+	return room.ID
+}
+
+func (f *chatFixture) member(t *testing.T, roomID, userID int, role models.Role) {
+	t.Helper()
+	if err := f.memberships.AddMember(roomID, userID, role); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if err := f.memberships.SetRole(roomID, userID, role); err != nil {
+		t.Fatalf("set role: %v", err)
+	}
+}
+
+func TestLiftRequiresOutranking(t *testing.T) {
+	f := newChatFixture(t)
+	owner, admin, mod, member := f.user(t, "owner"), f.user(t, "admin"), f.user(t, "mod"), f.user(t, "member")
+	room := f.room(t, "general", false, owner)
+	f.member(t, room, admin, models.RoleAdmin)
+	f.member(t, room, mod, models.RoleModerator)
+	f.member(t, room, member, models.RoleMember)
+
+	for _, target := range []int{admin, mod, member} {
+		if _, err := f.svc.Mute(room, owner, target, "", 0); err != nil {
+			t.Fatalf("Mute: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		actor, target int
+		allowed       bool
+	}{
+		{"moderator unmutes an admin", mod, admin, false},
+		{"moderator unmutes themselves", mod, mod, false},
+		{"member unmutes a member", member, member, false},
+		{"moderator unmutes a member", mod, member, true},
+		{"admin unmutes a moderator", admin, mod, true},
+		{"owner unmutes an admin", owner, admin, true},
+	} {
+		err := f.svc.Unmute(room, tc.actor, tc.target)
+		if tc.allowed && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !tc.allowed && !IsForbidden(err) {
+			t.Errorf("%s: err = %v, want forbidden", tc.name, err)
+		}
+	}
+}
+
+func TestDeleteRoomCleansUpInMemoryStore(t *testing.T) {
+	f := newChatFixture(t)
+	owner, bob := f.user(t, "owner"), f.user(t, "bob")
+	f.room(t, "default", false, owner)
+	room := f.room(t, "doomed", true, owner)
+	kept := f.room(t, "kept", true, owner)
+
+	now := time.Now()
+	save := func(roomID, threadID int) int {
+		msg, err := f.messages.Save(&models.Message{SenderID: owner, RoomID: roomID, ThreadID: threadID, Content: "@bob", CreatedAt: now})
+		if err != nil {
+			t.Fatalf("save message: %v", err)
+		}
+		if _, err := f.reactions.Add(msg.ID, bob, "👍", 0); err != nil {
+			t.Fatalf("add reaction: %v", err)
+		}
+		if err := f.mentions.Add([]models.Mention{{MessageID: msg.ID, RoomID: roomID, ThreadID: threadID, UserID: bob,
+			SenderID: owner, Kind: models.MentionUser, CreatedAt: now}}); err != nil {
+			t.Fatalf("add mention: %v", err)
+		}
+		if _, _, err := f.readMarkers.Advance(roomID, bob, msg.ID, now); err != nil {
+			t.Fatalf("advance read marker: %v", err)
+		}
+		return msg.ID
+	}
+	root := save(room, 0)
+	reply := save(room, root)
+	other := save(kept, 0)
+	for _, roomID := range []int{room, kept} {
+		if _, err := f.svc.Mute(roomID, owner, bob, "", 0); err != nil {
+			t.Fatalf("Mute: %v", err)
+		}
+		if _, err := f.svc.CreateInvite(roomID, owner, 0, 0); err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+	}
+
+	keptInvites, _ := f.invites.ListByRoom(kept)
+
+	if err := f.svc.DeleteRoom(room, owner); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+
+	if reactions, _ := f.reactions.ListByMessages([]int{root, reply}); len(reactions) != 0 {
+		t.Errorf("reactions left: %+v", reactions)
+	}
+	if mentions, _ := f.mentions.ListByUser(bob, 0, false); len(mentions) != 1 || mentions[0].MessageID != other {
+		t.Errorf("mentions left: %+v, want only the one in the kept room", mentions)
+	}
+	if markers, _ := f.readMarkers.ListByUser(bob); len(markers) != 1 || markers[0].RoomID != kept {
+		t.Errorf("read markers left: %+v, want only the kept room's", markers)
+	}
+	if restrictions, _ := f.restrictions.ListByRoom(room, now); len(restrictions) != 0 {
+		t.Errorf("restrictions left: %+v", restrictions)
+	}
+	if invites, _ := f.invites.ListByRoom(room); len(invites) != 0 {
+		t.Errorf("invites left: %+v", invites)
+	}
+	if reactions, _ := f.reactions.ListByMessages([]int{other}); len(reactions) != 1 {
+		t.Errorf("the kept room lost its reactions: %+v", reactions)
+	}
+	if restrictions, _ := f.restrictions.ListByRoom(kept, now); len(restrictions) != 1 {
+		t.Errorf("the kept room lost its restrictions: %+v", restrictions)
+	}
+	if invites, _ := f.invites.ListByRoom(kept); len(invites) != len(keptInvites) {
+		t.Errorf("the kept room lost its invites: %+v", invites)
+	}
+}
+
+func TestPermissionMatrix(t *testing.T) {
+	all := []Permission{PermDeleteRoom, PermKickMembers, PermBanMembers, PermMuteMembers,
+		PermManageMessages, PermChangeSettings, PermManageInvites, PermManageRoles}
+	granted := map[models.Role][]Permission{
+		models.RoleOwner:     all,
+		models.RoleAdmin:     all[1:],
+		models.RoleModerator: {PermKickMembers, PermBanMembers, PermMuteMembers, PermManageMessages},
+		models.RoleMember:    nil,
+	}
+	for role, perms := range granted {
+		for _, perm := range all {
+			if want := slices.Contains(perms, perm); RoleCan(role, perm) != want {
+				t.Errorf("RoleCan(%s, %s) = %v", role, perm, !want)
+			}
+		}
+	}
+
+	// Each action is taken by each role against a plain member
+	actions := []struct {
+		name    string
+		do      func(f *chatFixture, room, actor, target int) error
+		allowed []models.Role
+	}{
+		{"delete the room", func(f *chatFixture, room, actor, _ int) error {
+			return f.svc.DeleteRoom(room, actor)
+		}, []models.Role{models.RoleOwner}},
+		{"kick", func(f *chatFixture, room, actor, target int) error {
+			return f.svc.Kick(room, actor, target)
+		}, []models.Role{models.RoleOwner, models.RoleAdmin, models.RoleModerator}},
+		{"ban", func(f *chatFixture, room, actor, target int) error {
+			_, err := f.svc.Ban(room, actor, target, "", 0)
+			return err
+		}, []models.Role{models.RoleOwner, models.RoleAdmin, models.RoleModerator}},
+		{"mute", func(f *chatFixture, room, actor, target int) error {
+			_, err := f.svc.Mute(room, actor, target, "", 0)
+			return err
+		}, []models.Role{models.RoleOwner, models.RoleAdmin, models.RoleModerator}},
+		{"edit their message", func(f *chatFixture, room, actor, target int) error {
+			msg, err := f.msgs.Send(room, target, "hello")
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			_, err = f.msgs.Edit(actor, msg.ID, "edited")
+			return err
+		}, []models.Role{models.RoleOwner, models.RoleAdmin, models.RoleModerator}},
+		{"delete their message", func(f *chatFixture, room, actor, target int) error {
+			msg, err := f.msgs.Send(room, target, "hello")
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			return f.msgs.Delete(actor, msg.ID)
+		}, []models.Role{models.RoleOwner, models.RoleAdmin, models.RoleModerator}},
+		{"create an invite", func(f *chatFixture, room, actor, _ int) error {
+			_, err := f.svc.CreateInvite(room, actor, 0, 0)
+			return err
+		}, []models.Role{models.RoleOwner, models.RoleAdmin}},
+		{"promote them to moderator", func(f *chatFixture, room, actor, target int) error {
+			_, err := f.svc.SetMemberRole(room, actor, target, models.RoleModerator)
+			return err
+		}, []models.Role{models.RoleOwner, models.RoleAdmin}},
+	}
+	for _, action := range actions {
+		for _, role := range []models.Role{models.RoleOwner, models.RoleAdmin, models.RoleModerator, models.RoleMember} {
+			f := newChatFixture(t)
+			owner, target := f.user(t, "owner"), f.user(t, "target")
+			f.room(t, "default", false, owner)
+			room := f.room(t, "general", true, owner)
+			f.member(t, room, target, models.RoleMember)
+			actor := owner
+			if role != models.RoleOwner {
+				actor = f.user(t, "actor")
+				f.member(t, room, actor, role)
+			}
+
+			err := action.do(f, room, actor, target)
+			if slices.Contains(action.allowed, role) {
+				if err != nil {
+					t.Errorf("%s may %s: %v", role, action.name, err)
+				}
+			} else if !IsForbidden(err) {
+				t.Errorf("%s may not %s: err = %v, want forbidden", role, action.name, err)
+			}
+		}
+	}
+}
+
+func TestRoleChanges(t *testing.T) {
+	f := newChatFixture(t)
+	owner, admin, member := f.user(t, "owner"), f.user(t, "admin"), f.user(t, "member")
+	f.room(t, "default", false, owner)
+	room := f.room(t, "general", true, owner)
+	f.member(t, room, admin, models.RoleAdmin)
+	f.member(t, room, member, models.RoleMember)
+	role := func(userID int) models.Role {
+		t.Helper()
+		membership, err := f.memberships.GetMembership(room, userID)
+		if err != nil {
+			t.Fatalf("GetMembership: %v", err)
+		}
+		return membership.Role
+	}
+
+	if _, err := f.svc.SetMemberRole(room, admin, member, models.RoleOwner); err == nil {
+		t.Error("an admin made a member owner")
+	}
+	if _, err := f.svc.SetMemberRole(room, owner, member, models.RoleOwner); err == nil {
+		t.Error("the owner handed out the owner role without a transfer")
+	}
+	if _, err := f.svc.SetMemberRole(room, admin, member, models.RoleAdmin); !IsForbidden(err) {
+		t.Errorf("an admin made a member admin: err = %v, want forbidden", err)
+	}
+	if err := f.svc.TransferOwnership(room, admin, member); !IsForbidden(err) {
+		t.Errorf("an admin transferred ownership: err = %v, want forbidden", err)
+	}
+	if role(member) != models.RoleMember || role(owner) != models.RoleOwner {
+		t.Fatalf("roles changed: owner %s, member %s", role(owner), role(member))
+	}
+
+	if err := f.svc.TransferOwnership(room, owner, admin); err != nil {
+		t.Fatalf("TransferOwnership: %v", err)
+	}
+	if role(admin) != models.RoleOwner || role(owner) != models.RoleAdmin {
+		t.Errorf("after the transfer: new owner is %s, previous owner is %s", role(admin), role(owner))
+	}
+	// The previous owner keeps an admin's powers and nothing more
+	if err := f.svc.DeleteRoom(room, owner); !IsForbidden(err) {
+		t.Errorf("the previous owner deleted the room: err = %v, want forbidden", err)
+	}
+	if _, err := f.svc.SetMemberRole(room, owner, admin, models.RoleMember); !IsForbidden(err) {
+		t.Errorf("the previous owner demoted the new one: err = %v, want forbidden", err)
+	}
+	if _, err := f.svc.SetMemberRole(room, owner, member, models.RoleModerator); err != nil {
+		t.Errorf("the previous owner promoted a member: %v", err)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	f := newChatFixture(t)
+	owner := f.user(t, "owner")
+	f.room(t, "default", false, owner)
+	room := f.room(t, "secret", true, owner)
+	join := func(t *testing.T, code string, userID int) error {
+		t.Helper()
+		joined, err := f.svc.JoinRoomByInvite(code, userID)
+		if err == nil && joined.ID != room {
+			t.Fatalf("joined room %d, want %d", joined.ID, room)
+		}
+		return err
+	}
+	canAccess := func(t *testing.T, userID int) bool {
+		t.Helper()
+		ok, err := f.svc.CanUserAccessRoom(room, userID)
+		if err != nil {
+			t.Fatalf("CanUserAccessRoom: %v", err)
+		}
+		return ok
+	}
+
+	t.Run("max uses", func(t *testing.T) {
+		invite, err := f.svc.CreateInvite(room, owner, 0, 2)
+		if err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+		ann, ben, cat := f.user(t, "ann"), f.user(t, "ben"), f.user(t, "cat")
+		for _, userID := range []int{ann, ben, ann} {
+			if err := join(t, invite.Code, userID); err != nil {
+				t.Fatalf("join: %v", err)
+			}
+		}
+		if err := join(t, invite.Code, cat); err == nil || canAccess(t, cat) {
+			t.Errorf("a third user joined with a two-use invite (err = %v)", err)
+		}
+		if stored, _ := f.invites.FindByID(invite.ID); stored.Uses != 2 {
+			t.Errorf("Uses = %d, want 2: rejoining must not count", stored.Uses)
+		}
+		if active, _ := f.svc.ListInvites(room, owner); slices.ContainsFunc(active, func(i models.Invite) bool { return i.ID == invite.ID }) {
+			t.Error("ListInvites lists a used-up invite")
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		invite, err := f.svc.CreateInvite(room, owner, time.Hour, 0)
+		if err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+		if invite.ExpiresAt == nil || !invite.ExpiresAt.Equal(invite.CreatedAt.Add(time.Hour)) {
+			t.Errorf("ExpiresAt = %v, want an hour after %v", invite.ExpiresAt, invite.CreatedAt)
+		}
+		past := time.Now().Add(-time.Minute)
+		expired, err := f.invites.Create(&models.Invite{RoomID: room, Code: "expired", CreatedBy: owner,
+			CreatedAt: past.Add(-time.Hour), ExpiresAt: &past})
+		if err != nil {
+			t.Fatalf("create invite: %v", err)
+		}
+		dan := f.user(t, "dan")
+		if err := join(t, expired.Code, dan); err == nil || canAccess(t, dan) {
+			t.Errorf("joined with an expired invite (err = %v)", err)
+		}
+		if err := join(t, invite.Code, dan); err != nil {
+			t.Errorf("join before expiry: %v", err)
+		}
+		if _, err := f.svc.CreateInvite(room, owner, -time.Hour, 0); err == nil {
+			t.Error("CreateInvite accepted a negative expiry")
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		invite, err := f.svc.CreateInvite(room, owner, 0, 0)
+		if err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+		eve, fay := f.user(t, "eve"), f.user(t, "fay")
+		if err := join(t, invite.Code, eve); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		if err := f.svc.RevokeInvite(invite.ID, eve); !IsForbidden(err) {
+			t.Errorf("a member revoked an invite: err = %v, want forbidden", err)
+		}
+		if err := f.svc.RevokeInvite(invite.ID, owner); err != nil {
+			t.Fatalf("RevokeInvite: %v", err)
+		}
+		if err := join(t, invite.Code, fay); err == nil || canAccess(t, fay) {
+			t.Errorf("joined with a revoked invite (err = %v)", err)
+		}
+		if !canAccess(t, eve) {
+			t.Error("revoking an invite removed a member who joined with it")
+		}
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		old, err := f.svc.CreateInvite(room, owner, 2*time.Hour, 5)
+		if err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+		if _, err := f.svc.RotateInvite(old.ID, f.user(t, "outsider")); !IsForbidden(err) {
+			t.Errorf("an outsider rotated an invite: err = %v, want forbidden", err)
+		}
+		fresh, err := f.svc.RotateInvite(old.ID, owner)
+		if err != nil {
+			t.Fatalf("RotateInvite: %v", err)
+		}
+		if fresh.Code == old.Code || fresh.MaxUses != 5 || fresh.ExpiresAt == nil || fresh.ExpiresAt.Sub(fresh.CreatedAt) != 2*time.Hour {
+			t.Errorf("rotated %+v into %+v", old, fresh)
+		}
+		gus := f.user(t, "gus")
+		if err := join(t, old.Code, gus); err == nil {
+			t.Error("joined with the code that was rotated out")
+		}
+		if err := join(t, fresh.Code, gus); err != nil {
+			t.Errorf("join with the new code: %v", err)
+		}
+		if _, err := f.svc.RotateInvite(old.ID, owner); err == nil {
+			t.Error("rotated a revoked invite")
+		}
+	})
+
+	t.Run("banned", func(t *testing.T) {
+		invite, err := f.svc.CreateInvite(room, owner, 0, 0)
+		if err != nil {
+			t.Fatalf("CreateInvite: %v", err)
+		}
+		hal := f.user(t, "hal")
+		if _, err := f.svc.Ban(room, owner, hal, "", 0); err != nil {
+			t.Fatalf("Ban: %v", err)
+		}
+		if err := join(t, invite.Code, hal); !IsForbidden(err) {
+			t.Errorf("a banned user joined: err = %v, want forbidden", err)
+		}
+		if stored, _ := f.invites.FindByID(invite.ID); stored.Uses != 0 {
+			t.Errorf("a refused join used up the invite: Uses = %d", stored.Uses)
+		}
+	})
+}
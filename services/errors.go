@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ForbiddenError is returned when the caller is authenticated but not allowed
+// to perform the requested action. Handlers map it to 403.
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
+
+// IsForbidden reports whether err is, or wraps, a ForbiddenError.
+func IsForbidden(err error) bool {
+	var fe *ForbiddenError
+	return errors.As(err, &fe)
+}
+
+// TooManyAttemptsError is returned while logins are held back after repeated
+// failures. Handlers map it to 429 with a Retry-After header.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	wait := time.Duration(retrySeconds(e.RetryAfter)) * time.Second
+	return fmt.Sprintf("too many failed login attempts, try again in %s", wait)
+}
+
+// RetryAfter reports, in whole seconds rounded up, how long to wait when err
+// is, or wraps, a TooManyAttemptsError.
+func RetryAfter(err error) (int, bool) {
+	var te *TooManyAttemptsError
+	if !errors.As(err, &te) {
+		return 0, false
+	}
+	return retrySeconds(te.RetryAfter), true
+}
+
+func retrySeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+
+	"chat-backend/models"
+	"chat-backend/utils"
+)
+
+const (
+	maxImagePixels = 50_000_000 // larger images are kept but not processed
+	placeholderX   = 4          // BlurHash components across
+	placeholderY   = 3          // and down
+)
+
+// decodableImages are the upload types the server reads dimensions from and
+// makes thumbnails for.
+var decodableImages = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+var errInvalidJPEG = errors.New("invalid JPEG")
+
+// inspectImage reads a whole PNG, JPEG or GIF upload. It returns the bytes to
+// store, which for JPEGs have their location metadata removed, and the size
+// the image is displayed at.
+func inspectImage(contentType string, body io.Reader) ([]byte, int, int, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		if data, orientation, err = stripJPEGMetadata(data); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if orientation >= 5 {
+		return data, cfg.Height, cfg.Width, nil
+	}
+	return data, cfg.Width, cfg.Height, nil
+}
+
+// stripJPEGMetadata removes the APP1 (EXIF and XMP) and APP13 (IPTC)
+// segments of a JPEG, which can hold the GPS position a photo was taken at
+// along with the camera and owner. Only the EXIF orientation is kept, in a
+// minimal EXIF segment of its own, so photos still display the right way up.
+// It returns the cleaned JPEG and that orientation (1 to 8).
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errInvalidJPEG
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	orientation := 1
+	insertAt := len(out) // after JFIF's APP0, which has to come first
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return nil, 0, errInvalidJPEG
+		}
+		for i+1 < len(data) && data[i+1] == 0xFF { // fill bytes
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, 0, errInvalidJPEG
+		}
+		marker := data[i+1]
+
+		// Start of scan: entropy-coded data follows and metadata segments are
+		// only ever found before it
+		if marker == 0xDA || marker == 0xD9 {
+			out = append(out, data[i:]...)
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, 0, errInvalidJPEG
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, 0, errInvalidJPEG
+		}
+		segment := data[i:end]
+		payload := segment[4:]
+		switch marker {
+		case 0xE1, 0xED: // dropped
+			if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(payload[6:])
+			}
+		default:
+			out = append(out, segment...)
+			if marker == 0xE0 && insertAt == 2 {
+				insertAt = len(out)
+			}
+		}
+		i = end
+	}
+
+	if orientation != 1 {
+		segment := orientationSegment(orientation)
+		out = append(out[:insertAt], append(segment, out[insertAt:]...)...)
+	}
+	return out, orientation, nil
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure, defaulting to 1 (upright).
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 1
+}
+
+// orientationSegment builds an APP1 segment whose EXIF holds nothing but the
+// orientation tag.
+func orientationSegment(orientation int) []byte {
+	return []byte{
+		0xFF, 0xE1, 0x00, 0x22, // APP1, 34 bytes
+		'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big-endian TIFF, IFD at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+}
+
+// thumbnailKey is where the thumbnail of an image attachment is stored.
+func thumbnailKey(att models.Attachment) string {
+	return att.Key + ".thumb"
+}
+
+// StartImageWorkers starts n goroutines that make thumbnails and placeholders
+// for uploaded images. Uploads only queue images for them, so processing never
+// holds up the handler or the hub; when the queue is full, images are left
+// without a thumbnail.
+func (s *MessageService) StartImageWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for att := range s.images {
+				s.processImage(att)
+			}
+		}()
+	}
+}
+
+// queueImages hands the decodable images among atts to the image workers.
+func (s *MessageService) queueImages(atts []models.Attachment) {
+	for _, att := range atts {
+		if att.Width == 0 || att.Width*att.Height > maxImagePixels {
+			continue
+		}
+		select {
+		case s.images <- att:
+		default:
+			log.Printf("Image queue full, not processing attachment %d", att.ID)
+		}
+	}
+}
+
+// processImage stores a thumbnail of att, records its placeholder and tells
+// the message's audience with an attachment_processed event.
+func (s *MessageService) processImage(att models.Attachment) {
+	placeholder, thumbnailType, err := s.makeThumbnail(att)
+	if err != nil {
+		log.Printf("Failed to process image attachment %d: %v", att.ID, err)
+		return
+	}
+	if err := s.attachments.SetPreview(att.ID, placeholder, thumbnailType); err != nil {
+		// The message was deleted while its image was being processed
+		deleteBlobs(s.blobs, []models.Attachment{att})
+		return
+	}
+
+	msg, err := s.msgs.FindByID(att.MessageID)
+	if err != nil || msg.IsDeleted() {
+		return
+	}
+	att.Placeholder = placeholder
+	att.ThumbnailType = thumbnailType
+	s.publish(msg, "attachment_processed", map[string]any{
+		"message_id": msg.ID,
+		"room_id":    msg.RoomID,
+		"attachment": att,
+	})
+}
+
+// makeThumbnail decodes an image attachment, stores a thumbnail that fits in
+// ThumbnailSize pixels each way and returns its BlurHash placeholder and the
+// thumbnail's type. JPEGs get JPEG thumbnails; PNGs and GIFs, which may be
+// transparent, get PNG ones.
+func (s *MessageService) makeThumbnail(att models.Attachment) (string, string, error) {
+	body, err := s.blobs.Get(att.Key)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	orientation := 1
+	if att.ContentType == "image/jpeg" {
+		_, orientation, _ = stripJPEGMetadata(data)
+	}
+	thumb := resizeImage(img, orientation, s.config.ThumbnailSize)
+
+	var buf bytes.Buffer
+	thumbnailType := "image/png"
+	if att.ContentType == "image/jpeg" {
+		thumbnailType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.blobs.Put(thumbnailKey(att), &buf, int64(buf.Len()), thumbnailType); err != nil {
+		return "", "", err
+	}
+	return utils.BlurHash(thumb, placeholderX, placeholderY), thumbnailType, nil
+}
+
+// resizeImage scales img down to fit in a box of size pixels, keeping its
+// aspect ratio, and turns it the way its EXIF orientation says. Each output
+// pixel averages a grid of up to 4x4 samples from the area it covers, which
+// keeps very large images quick to shrink.
+func resizeImage(img image.Image, orientation, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if longest := max(w, h); longest > size {
+		w = max(1, w*size/longest)
+		h = max(1, h*size/longest)
+	}
+
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += max(1, (y1-y0)/4) {
+				for sx := x0; sx < x1; sx += max(1, (x1-x0)/4) {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+cr, g+cg, bl+cb, a+ca
+					n++
+				}
+			}
+			small.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return orient(small, orientation)
+}
+
+// orient applies an EXIF orientation (1 to 8) to img.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				dx, dy = x, h-1-y
+			case 5: // mirrored, turned left
+				dx, dy = y, x
+			case 6: // turned left, so rotate clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored, turned right
+				dx, dy = h-1-y, w-1-x
+			case 8: // turned right, so rotate anticlockwise
+				dx, dy = y, w-1-x
+			}
+			out.SetRGBA(dx, dy, img.RGBAAt(x, y))
+		}
+	}
+	return out
+}
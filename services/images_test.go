@@ -0,0 +1,244 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+// processedHub passes on the attachments of attachment_processed events,
+// which the image workers send from their own goroutines.
+type processedHub struct {
+	nopHub
+	processed chan models.Attachment
+}
+
+func (h processedHub) BroadcastEvent(_ int, event string, payload map[string]any) {
+	if event == "attachment_processed" {
+		h.processed <- payload["attachment"].(models.Attachment)
+	}
+}
+
+// newImageFixture is a chat fixture whose message service takes images and
+// text files, with room for queue images waiting for a worker.
+func newImageFixture(t *testing.T, queue int) *chatFixture {
+	f := newChatFixture(t)
+	f.msgs.config = &config.Config{
+		MaxMessageLength: 1000,
+		MaxUploadSize:    1 << 20,
+		UploadTypes:      []string{"image/*", "text/plain"},
+		ImageQueueSize:   queue,
+		ThumbnailSize:    64,
+	}
+	f.msgs.images = make(chan models.Attachment, queue)
+	return f
+}
+
+func encodeImage(t *testing.T, contentType string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", contentType, err)
+	}
+	return buf.Bytes()
+}
+
+func upload(name string, data []byte) FileUpload {
+	return FileUpload{Name: name, Size: int64(len(data)), Body: bytes.NewReader(data)}
+}
+
+// blobExists reports whether the message service's blob store holds key.
+func blobExists(t *testing.T, f *chatFixture, key string) bool {
+	t.Helper()
+	body, err := f.msgs.blobs.Get(key)
+	if errors.Is(err, repository.ErrBlobNotFound) {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	body.Close()
+	return true
+}
+
+func TestImageWorkersMakeThumbnails(t *testing.T) {
+	f := newImageFixture(t, 10)
+	hub := processedHub{processed: make(chan models.Attachment)}
+	f.msgs.hub = hub
+	alice := f.user(t, "alice")
+	room := f.room(t, "general", false, alice)
+	f.msgs.StartImageWorkers(2)
+
+	msg, err := f.msgs.Send(room, alice, "holiday",
+		upload("wide.png", encodeImage(t, "image/png", 200, 100)),
+		upload("tall.jpg", encodeImage(t, "image/jpeg", 30, 90)),
+		upload("small.gif", encodeImage(t, "image/gif", 16, 40)),
+		upload("notes.txt", []byte("not an image")))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	processed := make(map[string]models.Attachment)
+	for len(processed) < 3 {
+		select {
+		case att := <-hub.processed:
+			processed[att.FileName] = att
+		case <-time.After(5 * time.Second):
+			t.Fatalf("processed only %d images", len(processed))
+		}
+	}
+	if _, ok := processed["notes.txt"]; ok {
+		t.Error("a text file was processed")
+	}
+
+	for _, tc := range []struct {
+		name          string
+		thumbnailType string
+		width, height int
+	}{
+		// Scaled down to fit ThumbnailSize, keeping the aspect ratio
+		{"wide.png", "image/png", 64, 32},
+		{"tall.jpg", "image/jpeg", 21, 64},
+		// Small enough already, and PNG as GIFs may be transparent
+		{"small.gif", "image/png", 16, 40},
+	} {
+		att := processed[tc.name]
+		if att.MessageID != msg.ID || att.ThumbnailType != tc.thumbnailType || att.Placeholder == "" {
+			t.Errorf("%s: processed %+v, want a placeholder and a %s thumbnail", tc.name, att, tc.thumbnailType)
+		}
+		stored, err := f.attachments.FindByID(att.ID)
+		if err != nil || stored.Placeholder != att.Placeholder || stored.ThumbnailType != tc.thumbnailType {
+			t.Errorf("%s: stored %+v, %v", tc.name, stored, err)
+		}
+
+		body, err := f.msgs.blobs.Get(thumbnailKey(att))
+		if err != nil {
+			t.Fatalf("%s: thumbnail: %v", tc.name, err)
+		}
+		cfg, format, err := image.DecodeConfig(body)
+		body.Close()
+		if err != nil || "image/"+format != tc.thumbnailType || cfg.Width != tc.width || cfg.Height != tc.height {
+			t.Errorf("%s: thumbnail is a %dx%d %s (%v), want %dx%d", tc.name, cfg.Width, cfg.Height, format, err, tc.width, tc.height)
+		}
+	}
+}
+
+func TestImageQueue(t *testing.T) {
+	f := newImageFixture(t, 1)
+	alice := f.user(t, "alice")
+	room := f.room(t, "general", false, alice)
+	small := encodeImage(t, "image/png", 8, 8)
+
+	// With no worker free, images past the queue's size are skipped rather
+	// than holding up the upload
+	msg, err := f.msgs.Send(room, alice, "", upload("a.png", small), upload("b.png", small))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(f.msgs.images) != 1 {
+		t.Fatalf("queued %d images, want 1", len(f.msgs.images))
+	}
+	if att := <-f.msgs.images; att.ID != msg.Attachments[0].ID {
+		t.Errorf("queued attachment %d, want the first one", att.ID)
+	}
+
+	// Only images the server can decode are queued
+	if _, err := f.msgs.Send(room, alice, "", upload("notes.txt", []byte("plain text"))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(f.msgs.images) != 0 {
+		t.Errorf("queued a text file")
+	}
+}
+
+func TestImageProcessingFailures(t *testing.T) {
+	send := func(t *testing.T, f *chatFixture, room, sender int) models.Attachment {
+		t.Helper()
+		msg, err := f.msgs.Send(room, sender, "", upload("photo.png", encodeImage(t, "image/png", 100, 100)))
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		<-f.msgs.images
+		return msg.Attachments[0]
+	}
+
+	t.Run("undecodable", func(t *testing.T) {
+		f := newImageFixture(t, 1)
+		events := &eventHub{}
+		f.msgs.hub = events
+		alice := f.user(t, "alice")
+		room := f.room(t, "general", false, alice)
+		att := send(t, f, room, alice)
+
+		garbage := strings.NewReader("no longer an image")
+		if err := f.msgs.blobs.Put(att.Key, garbage, garbage.Size(), att.ContentType); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		events.events = nil
+		f.msgs.processImage(att)
+
+		if stored, err := f.attachments.FindByID(att.ID); err != nil || stored.Placeholder != "" || stored.ThumbnailType != "" {
+			t.Errorf("stored %+v, %v, want no preview", stored, err)
+		}
+		if blobExists(t, f, thumbnailKey(att)) {
+			t.Error("stored a thumbnail")
+		}
+		if len(events.events) != 0 {
+			t.Errorf("broadcast %+v", events.events)
+		}
+		// The upload itself is kept
+		if !blobExists(t, f, att.Key) {
+			t.Error("the upload was deleted")
+		}
+	})
+
+	t.Run("message deleted meanwhile", func(t *testing.T) {
+		f := newImageFixture(t, 1)
+		events := &eventHub{}
+		f.msgs.hub = events
+		alice := f.user(t, "alice")
+		room := f.room(t, "general", false, alice)
+		att := send(t, f, room, alice)
+
+		// The message's files went while the worker was decoding, so the
+		// thumbnail it stores afterwards is cleaned up along with the upload
+		if err := f.attachments.DeleteByMessage(att.MessageID); err != nil {
+			t.Fatalf("DeleteByMessage: %v", err)
+		}
+		events.events = nil
+		f.msgs.processImage(att)
+
+		if blobExists(t, f, att.Key) || blobExists(t, f, thumbnailKey(att)) {
+			t.Error("blobs left behind")
+		}
+		if len(events.events) != 0 {
+			t.Errorf("broadcast %+v", events.events)
+		}
+	})
+}
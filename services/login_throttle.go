@@ -0,0 +1,126 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBackoff caps the delay between attempts short of a lockout.
+const maxBackoff = 5 * time.Minute
+
+// throttlePolicy says when failures for one kind of key start to be delayed
+// and when they lock the key out.
+type throttlePolicy struct {
+	backoffAfter int
+	lockoutAfter int
+}
+
+type failureRecord struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle slows down password guessing. It counts failed logins per
+// username and per IP address; past a few failures each further attempt has
+// to wait twice as long as the last, and past a limit the key is locked out.
+// Failures are forgotten once a key has had none for the lockout period.
+//
+// An attempt counts as a failure from the moment it starts until it turns out
+// to have succeeded, so a burst of parallel requests cannot all get past the
+// limit before the first of them fails. State is kept in memory, per server.
+type loginThrottle struct {
+	mu        sync.Mutex
+	records   map[string]*failureRecord
+	users     throttlePolicy
+	ips       throttlePolicy
+	lockout   time.Duration
+	lastPrune time.Time
+}
+
+func newLoginThrottle(users, ips throttlePolicy, lockout time.Duration) *loginThrottle {
+	return &loginThrottle{records: make(map[string]*failureRecord), users: users, ips: ips, lockout: lockout}
+}
+
+// begin starts a login attempt for username from ip. If either is being held
+// back it returns how long to wait instead, and the attempt is not counted.
+func (t *loginThrottle) begin(username, ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+
+	keys := t.keys(username, ip)
+	var wait time.Duration
+	for key := range keys {
+		if r := t.records[key]; r != nil && now.Before(r.blockedUntil) {
+			wait = max(wait, r.blockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for key, policy := range keys {
+		r := t.records[key]
+		if r == nil || t.expired(r, now) {
+			r = &failureRecord{}
+			t.records[key] = r
+		}
+		r.count++
+		r.last = now
+		switch {
+		case r.count >= policy.lockoutAfter:
+			r.blockedUntil = now.Add(t.lockout)
+		case r.count > policy.backoffAfter:
+			delay := time.Second << min(r.count-policy.backoffAfter-1, 20)
+			r.blockedUntil = now.Add(min(delay, maxBackoff, t.lockout))
+		}
+	}
+	return 0
+}
+
+// succeed takes back the failure begin counted for a login that turned out
+// to be right. The username starts afresh; the IP address, which may be
+// shared by many users, only loses the one failure.
+func (t *loginThrottle) succeed(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.records, "user:"+username)
+	if r := t.records["ip:"+ip]; r != nil {
+		r.count--
+		if r.count <= t.ips.backoffAfter {
+			r.blockedUntil = time.Time{}
+		}
+		if r.count <= 0 {
+			delete(t.records, "ip:"+ip)
+		}
+	}
+}
+
+func (t *loginThrottle) keys(username, ip string) map[string]throttlePolicy {
+	keys := map[string]throttlePolicy{"user:" + username: t.users}
+	if ip != "" {
+		keys["ip:"+ip] = t.ips
+	}
+	return keys
+}
+
+// prune forgets keys whose failures have all expired, at most once per
+// lockout period so that it stays cheap.
+func (t *loginThrottle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < t.lockout {
+		return
+	}
+	t.lastPrune = now
+	for key, r := range t.records {
+		if t.expired(r, now) {
+			delete(t.records, key)
+		}
+	}
+}
+
+// expired reports whether a key's failures are old enough to be forgotten.
+func (t *loginThrottle) expired(r *failureRecord, now time.Time) bool {
+	return now.Sub(r.last) >= t.lockout && !now.Before(r.blockedUntil)
+}
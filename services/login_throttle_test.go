@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// attempt is one login against a loginThrottle: begin, then succeed if ok
+// and begin let it through.
+type attempt struct {
+	after    time.Duration // how long after the previous attempt
+	username string
+	ip       string
+	ok       bool
+	wait     time.Duration // what begin should return
+}
+
+func TestLoginThrottle(t *testing.T) {
+	fail := func(username, ip string) attempt { return attempt{username: username, ip: ip} }
+	failAfter := func(after time.Duration, username, ip string) attempt {
+		return attempt{after: after, username: username, ip: ip}
+	}
+	held := func(username, ip string, wait time.Duration) attempt {
+		return attempt{username: username, ip: ip, wait: wait}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		attempts []attempt
+	}{
+		{"backoff doubles until the lockout", []attempt{
+			fail("alice", ""), fail("alice", ""), fail("alice", ""),
+			// Past 3 failures, each one holds the next back, twice as long
+			// each time
+			fail("alice", ""),
+			held("alice", "", time.Second),
+			failAfter(time.Second, "alice", ""),
+			held("alice", "", 2*time.Second),
+			// The 6th failure locks the username out
+			failAfter(2*time.Second, "alice", ""),
+			held("alice", "", 15*time.Minute),
+			{after: 10 * time.Minute, username: "alice", wait: 5 * time.Minute},
+			// and then it starts afresh
+			failAfter(5*time.Minute, "alice", ""),
+			fail("alice", ""), fail("alice", ""),
+			fail("alice", ""),
+			held("alice", "", time.Second),
+		}},
+		{"failures are forgotten", []attempt{
+			fail("alice", "192.0.2.1"), fail("alice", "192.0.2.1"), fail("alice", "192.0.2.1"),
+			failAfter(15*time.Minute, "alice", "192.0.2.1"),
+			fail("alice", "192.0.2.1"), fail("alice", "192.0.2.1"),
+			fail("alice", "192.0.2.1"),
+			held("alice", "192.0.2.1", time.Second),
+		}},
+		{"usernames and addresses count separately", []attempt{
+			fail("alice", "192.0.2.1"), fail("alice", "192.0.2.2"), fail("alice", "192.0.2.3"),
+			fail("alice", "192.0.2.4"),
+			// Held back from anywhere, while others from the same addresses
+			// carry on
+			held("alice", "192.0.2.5", time.Second),
+			fail("bob", "192.0.2.1"),
+			fail("bob", "192.0.2.1"), fail("carol", "192.0.2.1"), fail("dave", "192.0.2.1"),
+			// The address's 6th failure, by whoever, holds it back
+			fail("erin", "192.0.2.1"),
+			held("frank", "192.0.2.1", time.Second),
+			fail("frank", "192.0.2.6"),
+		}},
+		{"success forgets the username's failures", []attempt{
+			fail("alice", "192.0.2.1"), fail("alice", "192.0.2.1"), fail("alice", "192.0.2.1"),
+			{username: "alice", ip: "192.0.2.1", ok: true},
+			fail("alice", "192.0.2.2"), fail("alice", "192.0.2.2"), fail("alice", "192.0.2.2"),
+			fail("alice", "192.0.2.2"),
+			held("alice", "192.0.2.2", time.Second),
+		}},
+		{"success takes one failure off the address", []attempt{
+			fail("alice", "192.0.2.1"), fail("bob", "192.0.2.1"), fail("carol", "192.0.2.1"),
+			fail("dave", "192.0.2.1"), fail("erin", "192.0.2.1"),
+			// The 6th attempt holds the address back until it succeeds, which
+			// leaves the 5 failures before it
+			{username: "frank", ip: "192.0.2.1", ok: true},
+			fail("grace", "192.0.2.1"),
+			held("heidi", "192.0.2.1", time.Second),
+		}},
+		{"locked out even with the right password", []attempt{
+			fail("alice", ""), fail("alice", ""), fail("alice", ""), fail("alice", ""),
+			{username: "alice", ok: true, wait: time.Second},
+			{after: time.Second, username: "alice", ok: true},
+			{username: "alice", ok: true},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			throttle := newLoginThrottle(
+				throttlePolicy{backoffAfter: 3, lockoutAfter: 6},
+				throttlePolicy{backoffAfter: 5, lockoutAfter: 10},
+				15*time.Minute,
+			)
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			for i, a := range tc.attempts {
+				now = now.Add(a.after)
+				if wait := throttle.begin(a.username, a.ip, now); wait != a.wait {
+					t.Fatalf("attempt %d (%s from %q): wait %s, want %s", i, a.username, a.ip, wait, a.wait)
+				}
+				if a.ok && a.wait == 0 {
+					throttle.succeed(a.username, a.ip)
+				}
+			}
+		})
+	}
+}
+
+func TestTooManyAttemptsError(t *testing.T) {
+	err := fmt.Errorf("login: %w", &TooManyAttemptsError{RetryAfter: 1500 * time.Millisecond})
+	if seconds, ok := RetryAfter(err); !ok || seconds != 2 {
+		t.Errorf("RetryAfter = %d, %v, want 2 rounded up", seconds, ok)
+	}
+	if got, want := err.Error(), "login: too many failed login attempts, try again in 2s"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if _, ok := RetryAfter(errors.New("something else")); ok {
+		t.Error("RetryAfter found a wait in another error")
+	}
+	if _, ok := RetryAfter(nil); ok {
+		t.Error("RetryAfter found a wait in nil")
+	}
+}
+
+func TestLoginThrottlesAndHidesUnknownUsers(t *testing.T) {
+	f := newAuthFixture(t)
+	f.register(t, "alice", "password", "")
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	for i := 0; i < 4; i++ {
+		if _, err := f.svc.Login("alice", "wrong password", client); err != errInvalidCredentials {
+			t.Fatalf("wrong password %d: err = %v", i, err)
+		}
+	}
+	_, err := f.svc.Login("alice", "password", client)
+	if seconds, ok := RetryAfter(err); !ok || seconds != 1 {
+		t.Fatalf("Login while held back: err = %v", err)
+	}
+	f.advance(time.Second)
+	f.login(t, "alice", "password")
+
+	// A username nobody has fails the same way as a wrong password, after a
+	// bcrypt comparison of the same cost, so neither the reply nor its timing
+	// tells whether the account exists
+	stored, err := f.users.FindByUsername("alice")
+	if err != nil {
+		t.Fatalf("FindByUsername: %v", err)
+	}
+	dummyCost, err := bcrypt.Cost(f.svc.dummyHash)
+	if err != nil {
+		t.Fatalf("dummy hash: %v", err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(stored.Password)); cost != dummyCost {
+		t.Errorf("dummy hash has cost %d, stored passwords %d", dummyCost, cost)
+	}
+	timeLogin := func(username string) time.Duration {
+		t.Helper()
+		fastest := time.Hour
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			if _, err := f.svc.Login(username, "wrong password", ClientInfo{}); err != errInvalidCredentials {
+				t.Fatalf("Login %s: err = %v", username, err)
+			}
+			fastest = min(fastest, time.Since(start))
+		}
+		return fastest
+	}
+	known, unknown := timeLogin("alice"), timeLogin("nobody")
+	if unknown < known/4 {
+		t.Errorf("an unknown username took %s to refuse, a wrong password %s", unknown, known)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chat-backend/models"
+)
+
+const (
+	defaultMentionsLimit = 50
+	maxMentionsLimit     = 100
+)
+
+// parseMentions returns the names mentioned as @name in content, each once,
+// in order of appearance. Names run until whitespace or punctuation other
+// than '_', '.' and '-'; a trailing '.' or '-' ends the sentence rather than
+// the name.
+func parseMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for i := strings.IndexByte(content, '@'); i >= 0; i = strings.IndexByte(content, '@') {
+		rest := content[i+1:]
+		// Skip email addresses and the like: a mention starts a word
+		if prev, _ := utf8.DecodeLastRuneInString(content[:i]); i > 0 && isMentionRune(prev) {
+			content = rest
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool { return !isMentionRune(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		name := strings.TrimRight(rest[:end], ".-")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		content = rest[end:]
+	}
+	return names
+}
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// mentionPriority decides which kind is kept when a message mentions a user
+// more than one way.
+var mentionPriority = map[models.MentionKind]int{
+	models.MentionHere: 1,
+	models.MentionRoom: 2,
+	models.MentionUser: 3,
+}
+
+// recordMentions stores who a newly posted room message mentions and sends
+// each of them a mentioned event. @username mentions count for users with
+// access to the room; @room reaches everyone in the room and @here those of
+// them who are online. The message is already posted, so failures here only
+// cost the notifications.
+func (s *MessageService) recordMentions(msg *models.Message) {
+	names := parseMentions(msg.Content)
+	if len(names) == 0 {
+		return
+	}
+
+	kinds := make(map[int]models.MentionKind)
+	mention := func(userID int, kind models.MentionKind) {
+		if mentionPriority[kind] > mentionPriority[kinds[userID]] {
+			kinds[userID] = kind
+		}
+	}
+	for _, name := range names {
+		switch {
+		case strings.EqualFold(name, "room"):
+			for _, userID := range s.roomAudience(msg.RoomID) {
+				mention(userID, models.MentionRoom)
+			}
+		case strings.EqualFold(name, "here"):
+			for _, userID := range s.roomAudience(msg.RoomID) {
+				if s.presence.Presence(userID) == models.PresenceOnline {
+					mention(userID, models.MentionHere)
+				}
+			}
+		default:
+			if user, err := s.users.FindByUsername(name); err == nil {
+				mention(user.ID, models.MentionUser)
+			}
+		}
+	}
+	delete(kinds, msg.SenderID)
+
+	var mentions []models.Mention
+	byKind := make(map[models.MentionKind][]int)
+	for userID, kind := range kinds {
+		if _, err := s.auth.role(msg.RoomID, userID); err != nil {
+			continue // no access to the room, so they can't see the message
+		}
+		mentions = append(mentions, models.Mention{
+			MessageID: msg.ID,
+			RoomID:    msg.RoomID,
+			ThreadID:  msg.ThreadID,
+			UserID:    userID,
+			SenderID:  msg.SenderID,
+			Kind:      kind,
+			CreatedAt: msg.CreatedAt,
+		})
+		byKind[kind] = append(byKind[kind], userID)
+	}
+	if len(mentions) == 0 || s.mentions.Add(mentions) != nil {
+		return
+	}
+
+	for kind, userIDs := range byKind {
+		payload := map[string]any{
+			"kind":       kind,
+			"message_id": msg.ID,
+			"room_id":    msg.RoomID,
+			"sender_id":  msg.SenderID,
+			"username":   msg.Username,
+			"content":    msg.Content,
+			"ts":         msg.CreatedAt.UnixMilli(),
+		}
+		if msg.ThreadID != 0 {
+			payload["thread_id"] = msg.ThreadID
+		}
+		s.hub.SendToUsers(userIDs, "mentioned", payload)
+	}
+}
+
+// roomAudience returns the users @room reaches: the room's members, anyone
+// who has read or posted in it and anyone connected to it right now.
+func (s *MessageService) roomAudience(roomID int) []int {
+	seen := make(map[int]bool)
+	var userIDs []int
+	add := func(userID int) {
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	if members, err := s.memberships.GetRoomMembers(roomID); err == nil {
+		for _, userID := range members {
+			add(userID)
+		}
+	}
+	if markers, err := s.reads.ListByRoom(roomID); err == nil {
+		for _, marker := range markers {
+			add(marker.UserID)
+		}
+	}
+	for userID := range s.presence.RoomUsers(roomID) {
+		add(userID)
+	}
+	return userIDs
+}
+
+// ListMentions returns userID's most recent mentions, newest first, with the
+// message and room name, leaving out rooms they can no longer access.
+func (s *MessageService) ListMentions(userID, limit int, unreadOnly bool) ([]models.Mention, error) {
+	if limit <= 0 {
+		limit = defaultMentionsLimit
+	}
+	if limit > maxMentionsLimit {
+		limit = maxMentionsLimit
+	}
+
+	mentions, err := s.mentions.ListByUser(userID, limit, unreadOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	roomNames := make(map[int]string)
+	inbox := mentions[:0]
+	for _, mention := range mentions {
+		name, checked := roomNames[mention.RoomID]
+		if !checked {
+			if _, err := s.auth.role(mention.RoomID, userID); err == nil {
+				if room, err := s.chats.FindByID(mention.RoomID); err == nil {
+					name = room.Name
+				}
+			}
+			roomNames[mention.RoomID] = name
+		}
+		if name == "" {
+			continue
+		}
+
+		msg, err := s.msgs.FindByID(mention.MessageID)
+		if err != nil || msg.IsDeleted() {
+			continue
+		}
+		msg.Username = s.usernameOf(msg.SenderID)
+		mention.RoomName = name
+		mention.Message = msg
+		inbox = append(inbox, mention)
+	}
+	return inbox, nil
+}
+
+// MarkMentionsRead marks userID's mentions with the given IDs read, or all of
+// them when ids is empty, and returns how many were unread.
+func (s *MessageService) MarkMentionsRead(userID int, ids []int) (int, error) {
+	if len(ids) > maxMentionsLimit {
+		return 0, errors.New("too many mention IDs")
+	}
+	return s.mentions.MarkRead(userID, ids, time.Now())
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestParseMentions(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		want    []string
+	}{
+		{"no mentions here", nil},
+		{"@alice", []string{"alice"}},
+		{"hi @alice and @bob", []string{"alice", "bob"}},
+		{"@alice, @bob: @carol!", []string{"alice", "bob", "carol"}},
+		{"ask @alice.", []string{"alice"}},
+		{"ask @alice-", []string{"alice"}},
+		{"@first.last and @snake_case and @kebab-case", []string{"first.last", "snake_case", "kebab-case"}},
+		{"@alice @alice @Alice", []string{"alice", "Alice"}},
+		{"mail alice@example.com", nil},
+		{"(@alice)", []string{"alice"}},
+		{"@ alone and @", nil},
+		{"@here @room", []string{"here", "room"}},
+		{"@josé", []string{"josé"}},
+	} {
+		if got := parseMentions(tc.content); !slices.Equal(got, tc.want) {
+			t.Errorf("parseMentions(%q) = %q, want %q", tc.content, got, tc.want)
+		}
+	}
+}
+
+// fakePresence reports the given users online and connected to rooms.
+type fakePresence struct {
+	online    map[int]bool
+	connected map[int]map[int]string // room -> user -> username
+}
+
+func (p fakePresence) Presence(userID int) models.Presence {
+	if p.online[userID] {
+		return models.PresenceOnline
+	}
+	return models.PresenceOffline
+}
+
+func (p fakePresence) RoomUsers(roomID int) map[int]string { return p.connected[roomID] }
+
+// mentionHub records the kind of mention each user was notified of.
+type mentionHub struct {
+	nopHub
+	sent map[int]models.MentionKind
+}
+
+func (h *mentionHub) SendToUsers(userIDs []int, event string, payload map[string]any) {
+	if event != "mentioned" {
+		return
+	}
+	if h.sent == nil {
+		h.sent = make(map[int]models.MentionKind)
+	}
+	for _, userID := range userIDs {
+		h.sent[userID] = payload["kind"].(models.MentionKind)
+	}
+}
+
+func TestMentionKinds(t *testing.T) {
+	f := newChatFixture(t)
+	hub := &mentionHub{}
+	f.msgs.hub = hub
+	owner, online, offline, reader, visitor, outsider :=
+		f.user(t, "owner"), f.user(t, "online"), f.user(t, "offline"), f.user(t, "reader"), f.user(t, "visitor"), f.user(t, "outsider")
+	room := f.room(t, "general", false, owner)
+	private := f.room(t, "secret", true, owner)
+	f.member(t, room, online, models.RoleMember)
+	f.member(t, room, offline, models.RoleMember)
+	f.member(t, private, online, models.RoleMember)
+	// Users of a public room need not be members: one has posted in it,
+	// another is looking at it right now
+	if _, err := f.msgs.Send(room, reader, "hello"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	f.msgs.presence = fakePresence{
+		online:    map[int]bool{owner: true, online: true, visitor: true},
+		connected: map[int]map[int]string{room: {visitor: "visitor"}},
+	}
+
+	kinds := func(roomID int, content string) map[int]models.MentionKind {
+		t.Helper()
+		hub.sent = nil
+		msg, err := f.msgs.Send(roomID, owner, content)
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		got := map[int]models.MentionKind{}
+		for _, userID := range []int{owner, online, offline, reader, visitor, outsider} {
+			mentions, _ := f.mentions.ListByUser(userID, 0, false)
+			for _, mention := range mentions {
+				if mention.MessageID == msg.ID {
+					got[userID] = mention.Kind
+				}
+			}
+		}
+		for userID, kind := range got {
+			if hub.sent[userID] != kind {
+				t.Errorf("%q: user %d was mentioned as %s but notified as %q", content, userID, kind, hub.sent[userID])
+			}
+		}
+		if len(hub.sent) != len(got) {
+			t.Errorf("%q: notified %v, mentioned %v", content, hub.sent, got)
+		}
+		return got
+	}
+	expect := func(roomID int, content string, want map[int]models.MentionKind) {
+		t.Helper()
+		if got := kinds(roomID, content); !maps.Equal(got, want) {
+			t.Errorf("%q mentions %v, want %v", content, got, want)
+		}
+	}
+
+	user, here, all := models.MentionUser, models.MentionHere, models.MentionRoom
+	expect(room, "@offline @nobody", map[int]models.MentionKind{offline: user})
+	// The sender is never mentioned, and outsiders can still be mentioned in
+	// a public room
+	expect(room, "@owner @outsider", map[int]models.MentionKind{outsider: user})
+	expect(room, "@here", map[int]models.MentionKind{online: here, visitor: here})
+	expect(room, "@room", map[int]models.MentionKind{online: all, offline: all, reader: all, visitor: all})
+	// A user mentioned several ways gets the most direct kind
+	expect(room, "@here @room @online", map[int]models.MentionKind{online: user, offline: all, reader: all, visitor: all})
+	expect(room, "@ROOM", map[int]models.MentionKind{online: all, offline: all, reader: all, visitor: all})
+	// Only those with access to a private room can be mentioned in it
+	expect(private, "@online @offline @room", map[int]models.MentionKind{online: user})
+	expect(room, "mail owner@example.com", map[int]models.MentionKind{})
+}
+
+func TestMentionInbox(t *testing.T) {
+	f := newChatFixture(t)
+	owner, bob := f.user(t, "owner"), f.user(t, "bob")
+	f.room(t, "default", false, owner)
+	public := f.room(t, "general", false, owner)
+	private := f.room(t, "secret", true, owner)
+	left := f.room(t, "gone", true, owner)
+	for _, roomID := range []int{private, left} {
+		f.member(t, roomID, bob, models.RoleMember)
+	}
+	send := func(roomID int, content string) int {
+		t.Helper()
+		msg, err := f.msgs.Send(roomID, owner, content)
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		return msg.ID
+	}
+	inbox := func(limit int, unreadOnly bool) []int {
+		t.Helper()
+		mentions, err := f.msgs.ListMentions(bob, limit, unreadOnly)
+		if err != nil {
+			t.Fatalf("ListMentions: %v", err)
+		}
+		var ids []int
+		for _, mention := range mentions {
+			if mention.Message == nil || mention.Message.Username != "owner" || mention.RoomName == "" {
+				t.Errorf("mention %+v is missing its message or room", mention)
+			}
+			ids = append(ids, mention.MessageID)
+		}
+		return ids
+	}
+
+	first := send(public, "@bob hello")
+	secret := send(private, "@bob a secret")
+	deleted := send(public, "@bob never mind")
+	kicked := send(left, "@bob see you")
+	last := send(private, "@room last call")
+	if err := f.msgs.Delete(owner, deleted); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := f.svc.Kick(left, owner, bob); err != nil {
+		t.Fatalf("Kick: %v", err)
+	}
+
+	// Newest first, leaving out deleted messages and rooms bob has left
+	if got, want := inbox(0, false), []int{last, secret, first}; !slices.Equal(got, want) {
+		t.Errorf("inbox = %v, want %v (not %d or %d)", got, want, deleted, kicked)
+	}
+	if got := inbox(1, false); !slices.Equal(got, []int{last}) {
+		t.Errorf("inbox limited to 1 = %v", got)
+	}
+
+	all, err := f.msgs.ListMentions(bob, 0, false)
+	if err != nil {
+		t.Fatalf("ListMentions: %v", err)
+	}
+	if n, err := f.msgs.MarkMentionsRead(bob, []int{all[0].ID, all[2].ID}); err != nil || n != 2 {
+		t.Errorf("MarkMentionsRead = %d, %v, want 2", n, err)
+	}
+	if got := inbox(0, true); !slices.Equal(got, []int{secret}) {
+		t.Errorf("unread inbox = %v, want %v", got, []int{secret})
+	}
+	if got := inbox(0, false); len(got) != 3 {
+		t.Errorf("read mentions left the inbox: %v", got)
+	}
+	if _, err := f.msgs.MarkMentionsRead(bob, nil); err != nil {
+		t.Errorf("MarkMentionsRead(all): %v", err)
+	}
+	if got := inbox(0, true); len(got) != 0 {
+		t.Errorf("unread inbox after marking all read = %v", got)
+	}
+}
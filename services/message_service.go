@@ -2,8 +2,12 @@ package services
 
 import (
 	"errors"
+	"log"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"chat-backend/config"
 	"chat-backend/models"
@@ -13,55 +17,200 @@ import (
 // MessageBroadcaster interface to avoid import cycles
 type MessageBroadcaster interface {
 	BroadcastMessage(msg models.Message, username string)
+	// BroadcastDirect delivers a 1-to-1 message to its sender and receiver only.
+	BroadcastDirect(msg models.Message, username string)
+	BroadcastEvent(roomID int, event string, payload map[string]any)
+	SendToUsers(userIDs []int, event string, payload map[string]any)
 }
 
 type MessageService struct {
-	msgs   repository.MessageRepository
-	chats  repository.ChatRepository
-	users  repository.UserRepository
-	hub    MessageBroadcaster
-	config *config.Config
+	msgs        repository.MessageRepository
+	reactions   repository.ReactionRepository
+	reads       repository.ReadMarkerRepository
+	mentions    repository.MentionRepository
+	index       repository.SearchIndex
+	attachments repository.AttachmentRepository
+	blobs       repository.BlobStore
+	images      chan models.Attachment // waiting for StartImageWorkers
+	chats       repository.ChatRepository
+	memberships repository.MembershipRepository
+	users       repository.UserRepository
+	hub         MessageBroadcaster
+	presence    PresenceTracker
+	config      *config.Config
+	auth        *roomAuthorizer
 }
 
-func NewMessageService(mr repository.MessageRepository, cr repository.ChatRepository, ur repository.UserRepository, hub MessageBroadcaster, cfg *config.Config) *MessageService {
-	return &MessageService{msgs: mr, chats: cr, users: ur, hub: hub, config: cfg}
+func NewMessageService(mr repository.MessageRepository, rr repository.ReactionRepository, rmRepo repository.ReadMarkerRepository, menRepo repository.MentionRepository, index repository.SearchIndex, attRepo repository.AttachmentRepository, blobs repository.BlobStore, cr repository.ChatRepository, memRepo repository.MembershipRepository, resRepo repository.RestrictionRepository, ur repository.UserRepository, hub MessageBroadcaster, presence PresenceTracker, cfg *config.Config) *MessageService {
+	return &MessageService{msgs: mr, reactions: rr, reads: rmRepo, mentions: menRepo, index: index, attachments: attRepo, blobs: blobs, images: make(chan models.Attachment, cfg.ImageQueueSize), chats: cr, memberships: memRepo, users: ur, hub: hub, presence: presence, config: cfg, auth: newRoomAuthorizer(cr, memRepo, resRepo)}
 }
 
-func (s *MessageService) Send(roomID, senderID int, content string) (*models.Message, error) {
+// authorizeRoom checks that the room exists and userID may read and write in
+// it. Every room message path goes through here.
+func (s *MessageService) authorizeRoom(roomID, userID int) error {
+	_, err := s.auth.role(roomID, userID)
+	return err
+}
+
+// authorizeMessage checks that userID may read msg: room messages follow the
+// room's access rules and direct messages are visible to their two
+// participants only.
+func (s *MessageService) authorizeMessage(msg *models.Message, userID int) error {
+	if msg.RoomID != 0 {
+		return s.authorizeRoom(msg.RoomID, userID)
+	}
+	if msg.SenderID != userID && msg.ReceiverID != userID {
+		return &ForbiddenError{Reason: "you don't have access to this message"}
+	}
+	return nil
+}
+
+// publish sends an event about msg to whoever can see it: the room for room
+// messages, the two participants for direct messages.
+func (s *MessageService) publish(msg *models.Message, event string, payload map[string]any) {
+	if msg.RoomID != 0 {
+		s.hub.BroadcastEvent(msg.RoomID, event, payload)
+		return
+	}
+	s.hub.SendToUsers([]int{msg.SenderID, msg.ReceiverID}, event, payload)
+}
+
+func (s *MessageService) validateContent(content string) error {
 	if content == "" {
-		return nil, errors.New("empty content")
+		return errors.New("empty content")
 	}
 	if len(content) > s.config.MaxMessageLength {
-		return nil, errors.New("message too long (max " + strconv.Itoa(s.config.MaxMessageLength) + " characters)")
+		return errors.New("message too long (max " + strconv.Itoa(s.config.MaxMessageLength) + " characters)")
 	}
+	return nil
+}
 
-	if _, err := s.chats.FindByID(roomID); err != nil {
-		return nil, errors.New("room not found")
+// validateMessage checks a new message. Messages with files may leave the
+// content empty.
+func (s *MessageService) validateMessage(content string, files []FileUpload) ([]FileUpload, error) {
+	if content != "" || len(files) == 0 {
+		if err := s.validateContent(content); err != nil {
+			return nil, err
+		}
 	}
+	return s.checkFiles(files)
+}
 
-	user, err := s.users.FindByID(senderID)
+func (s *MessageService) Send(roomID, senderID int, content string, files ...FileUpload) (*models.Message, error) {
+	files, err := s.validateMessage(content, files)
 	if err != nil {
-		return nil, errors.New("sender not found")
+		return nil, err
 	}
 
-	msg := &models.Message{
+	if err := s.authorizeRoom(roomID, senderID); err != nil {
+		return nil, err
+	}
+	if err := s.auth.checkNotMuted(roomID, senderID); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.save(&models.Message{
 		RoomID:    roomID,
 		SenderID:  senderID,
 		Content:   content,
 		CreatedAt: time.Now(),
+	}, files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Posting means the sender has read the room up to here
+	s.advanceRead(roomID, senderID, msg.ID, msg.CreatedAt)
+	return msg, nil
+}
+
+// Reply posts content into the thread of parentID. Replying to a reply
+// continues the same thread, so threads are always one level deep.
+func (s *MessageService) Reply(senderID, parentID int, content string, files ...FileUpload) (*models.Message, error) {
+	files, err := s.validateMessage(content, files)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := s.msgs.FindByID(parentID)
+	if err != nil {
+		return nil, errors.New("parent message not found")
+	}
+	if parent.RoomID == 0 {
+		return nil, errors.New("threads are only supported in rooms")
+	}
+	if err := s.authorizeRoom(parent.RoomID, senderID); err != nil {
+		return nil, err
+	}
+	if err := s.auth.checkNotMuted(parent.RoomID, senderID); err != nil {
+		return nil, err
+	}
+
+	rootID := parent.ID
+	if parent.ThreadID != 0 {
+		rootID = parent.ThreadID
+	}
+	if rootID == parent.ID && parent.IsDeleted() {
+		return nil, errors.New("cannot reply to a deleted message")
+	}
+
+	return s.save(&models.Message{
+		RoomID:    parent.RoomID,
+		ThreadID:  rootID,
+		SenderID:  senderID,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}, files)
+}
+
+// save persists a room message or reply with its files and broadcasts it to
+// the room.
+func (s *MessageService) save(msg *models.Message, files []FileUpload) (*models.Message, error) {
+	user, err := s.users.FindByID(msg.SenderID)
+	if err != nil {
+		return nil, errors.New("sender not found")
 	}
 
+	atts, err := s.storeFiles(msg.SenderID, files)
+	if err != nil {
+		return nil, err
+	}
 	saved, err := s.msgs.Save(msg)
 	if err != nil {
+		deleteBlobs(s.blobs, atts)
+		return nil, err
+	}
+	saved.Username = user.Username
+	if err := s.saveAttachments(saved, atts); err != nil {
 		return nil, err
 	}
 
 	// broadcast over hub with username
 	s.hub.BroadcastMessage(*saved, user.Username)
+	s.reindex(saved)
+	s.recordMentions(saved)
 	return saved, nil
 }
 
-func (s *MessageService) List(roomID int, limit int) ([]models.Message, error) {
+// MessageCursor anchors a page of room history. At most one of Before, After
+// and Around may be set; with none set the newest messages are returned.
+type MessageCursor struct {
+	Before int // messages older than this ID
+	After  int // messages newer than this ID
+	Around int // messages centred on this ID, including it
+}
+
+// MessagePage is one page of room history, oldest first. PrevCursor is passed
+// back as Before to load older messages and NextCursor as After to load newer
+// ones; each is 0 when there is nothing further in that direction.
+type MessagePage struct {
+	Messages   []models.Message `json:"messages"`
+	PrevCursor int              `json:"prev_cursor,omitempty"`
+	NextCursor int              `json:"next_cursor,omitempty"`
+}
+
+// List returns a page of history from a room the caller can access.
+func (s *MessageService) List(userID, roomID int, cursor MessageCursor, limit int) (*MessagePage, error) {
 	if limit <= 0 {
 		limit = 50
 	}
@@ -69,24 +218,550 @@ func (s *MessageService) List(roomID int, limit int) ([]models.Message, error) {
 		limit = 100
 	}
 
-	if _, err := s.chats.FindByID(roomID); err != nil {
-		return nil, errors.New("room not found")
+	anchors := 0
+	for _, id := range []int{cursor.Before, cursor.After, cursor.Around} {
+		if id < 0 {
+			return nil, errors.New("cursor must be a positive message id")
+		}
+		if id > 0 {
+			anchors++
+		}
+	}
+	if anchors > 1 {
+		return nil, errors.New("only one of before, after and around may be given")
 	}
 
-	msgs, err := s.msgs.ListByRoom(roomID, limit)
-	if err != nil {
+	if err := s.authorizeRoom(roomID, userID); err != nil {
 		return nil, err
 	}
+	// IDs are shared by all rooms, so a cursor from another room would page
+	// through this one from an arbitrary point
+	if anchor := cursor.Before + cursor.After + cursor.Around; anchor > 0 {
+		if msg, err := s.msgs.FindByID(anchor); err != nil || msg.RoomID != roomID {
+			return nil, errors.New("cursor is not a message in this room")
+		}
+	}
 
-	// Populate username for each message
-	for i := range msgs {
-		user, err := s.users.FindByID(msgs[i].SenderID)
+	var msgs []models.Message
+	var hasOlder, hasNewer bool
+	switch {
+	case cursor.After > 0:
+		newer, err := s.msgs.ListRoomRange(roomID, repository.MessageRange{AfterID: cursor.After, Limit: limit + 1})
+		if err != nil {
+			return nil, err
+		}
+		hasOlder = true
+		if hasNewer = len(newer) > limit; hasNewer {
+			newer = newer[:limit]
+		}
+		msgs = newer
+
+	case cursor.Around > 0:
+		// The anchor itself counts towards the older half
+		olderLimit := limit - limit/2
+		newerLimit := limit / 2
+		older, err := s.msgs.ListRoomRange(roomID, repository.MessageRange{BeforeID: cursor.Around + 1, Limit: olderLimit + 1})
+		if err != nil {
+			return nil, err
+		}
+		newer, err := s.msgs.ListRoomRange(roomID, repository.MessageRange{AfterID: cursor.Around, Limit: newerLimit + 1})
 		if err != nil {
-			msgs[i].Username = "Unknown User"
-		} else {
-			msgs[i].Username = user.Username
+			return nil, err
 		}
+		if hasOlder = len(older) > olderLimit; hasOlder {
+			older = older[1:]
+		}
+		if hasNewer = len(newer) > newerLimit; hasNewer {
+			newer = newer[:newerLimit]
+		}
+		msgs = append(older, newer...)
+
+	default:
+		// Before a cursor, or the newest messages when there is none
+		older, err := s.msgs.ListRoomRange(roomID, repository.MessageRange{BeforeID: cursor.Before, Limit: limit + 1})
+		if err != nil {
+			return nil, err
+		}
+		hasNewer = cursor.Before > 0
+		if hasOlder = len(older) > limit; hasOlder {
+			older = older[1:]
+		}
+		msgs = older
+	}
+
+	if err := s.attachThreadSummaries(msgs); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(msgs, userID); err != nil {
+		return nil, err
+	}
+	if err := s.attachFiles(msgs); err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: msgs}
+	if len(msgs) > 0 {
+		if hasOlder {
+			page.PrevCursor = msgs[0].ID
+		}
+		if hasNewer {
+			page.NextCursor = msgs[len(msgs)-1].ID
+		}
+	}
+
+	s.populateUsernames(page.Messages)
+	return page, nil
+}
+
+// attachThreadSummaries fills in reply counts on thread roots.
+func (s *MessageService) attachThreadSummaries(msgs []models.Message) error {
+	ids := make([]int, len(msgs))
+	for i := range msgs {
+		ids[i] = msgs[i].ID
+	}
+	summaries, err := s.msgs.ThreadSummaries(ids)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		if summary, ok := summaries[msgs[i].ID]; ok {
+			msgs[i].ReplyCount = summary.ReplyCount
+			lastReplyAt := summary.LastReplyAt
+			msgs[i].LastReplyAt = &lastReplyAt
+		}
+	}
+	return nil
+}
+
+// attachReactions fills in per-emoji reaction counts, marking the ones
+// userID has reacted with.
+func (s *MessageService) attachReactions(msgs []models.Message, userID int) error {
+	ids := make([]int, len(msgs))
+	index := make(map[int]int, len(msgs))
+	for i := range msgs {
+		ids[i] = msgs[i].ID
+		index[msgs[i].ID] = i
+	}
+	reactions, err := s.reactions.ListByMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	// Emojis are listed in the order they were first used on each message
+	for _, reaction := range reactions {
+		msg := &msgs[index[reaction.MessageID]]
+		j := 0
+		for j < len(msg.Reactions) && msg.Reactions[j].Emoji != reaction.Emoji {
+			j++
+		}
+		if j == len(msg.Reactions) {
+			msg.Reactions = append(msg.Reactions, models.ReactionSummary{Emoji: reaction.Emoji})
+		}
+		msg.Reactions[j].Count++
+		if reaction.UserID == userID {
+			msg.Reactions[j].ReactedByMe = true
+		}
+	}
+	return nil
+}
+
+// ThreadPage is a thread root with a page of its replies, oldest first. Pass
+// NextCursor back as after to load newer replies; it is 0 at the end.
+type ThreadPage struct {
+	Root       models.Message   `json:"root"`
+	Replies    []models.Message `json:"replies"`
+	NextCursor int              `json:"next_cursor,omitempty"`
+}
+
+// ListThread returns the replies to a thread root the caller can read.
+func (s *MessageService) ListThread(userID, rootID, afterID int, limit int) (*ThreadPage, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	root, err := s.msgs.FindByID(rootID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(root, userID); err != nil {
+		return nil, err
+	}
+	if root.ThreadID != 0 {
+		return nil, errors.New("message is a reply, not a thread root")
+	}
+
+	replies, err := s.msgs.ListThread(rootID, repository.MessageRange{AfterID: afterID, Limit: limit + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ThreadPage{}
+	if len(replies) > limit {
+		replies = replies[:limit]
+		page.NextCursor = replies[len(replies)-1].ID
+	}
+
+	// Root first, then its replies, so one pass fills in all of them
+	msgs := append([]models.Message{*root}, replies...)
+	if err := s.attachThreadSummaries(msgs[:1]); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(msgs, userID); err != nil {
+		return nil, err
+	}
+	if err := s.attachFiles(msgs); err != nil {
+		return nil, err
+	}
+	s.populateUsernames(msgs)
+	page.Root = msgs[0]
+	page.Replies = msgs[1:]
+	return page, nil
+}
+
+// SendDirect stores a 1-to-1 message and delivers it to the two participants.
+func (s *MessageService) SendDirect(senderID, receiverID int, content string, files ...FileUpload) (*models.Message, error) {
+	files, err := s.validateMessage(content, files)
+	if err != nil {
+		return nil, err
+	}
+	if receiverID == senderID {
+		return nil, errors.New("cannot send a direct message to yourself")
+	}
+
+	sender, err := s.users.FindByID(senderID)
+	if err != nil {
+		return nil, errors.New("sender not found")
+	}
+	if _, err := s.users.FindByID(receiverID); err != nil {
+		return nil, errors.New("recipient not found")
+	}
+
+	atts, err := s.storeFiles(senderID, files)
+	if err != nil {
+		return nil, err
+	}
+	msg := &models.Message{
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+		Content:    content,
+		CreatedAt:  time.Now(),
+	}
+
+	saved, err := s.msgs.Save(msg)
+	if err != nil {
+		deleteBlobs(s.blobs, atts)
+		return nil, err
+	}
+	saved.Username = sender.Username
+	if err := s.saveAttachments(saved, atts); err != nil {
+		return nil, err
+	}
+
+	s.hub.BroadcastDirect(*saved, sender.Username)
+	return saved, nil
+}
+
+// OpenDirect resolves the user to start a conversation with and returns the
+// recent history with them.
+func (s *MessageService) OpenDirect(userID int, username string, limit int) (*models.User, []models.Message, error) {
+	other, err := s.users.FindByUsername(username)
+	if err != nil {
+		return nil, nil, errors.New("user not found")
+	}
+	if other.ID == userID {
+		return nil, nil, errors.New("cannot open a direct conversation with yourself")
+	}
+
+	msgs, err := s.ListDirect(userID, other.ID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return other, msgs, nil
+}
+
+// ListDirect returns the conversation between userID and otherID. Only the two
+// participants can ever reach it, because userID is always the caller.
+func (s *MessageService) ListDirect(userID, otherID int, limit int) ([]models.Message, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	if _, err := s.users.FindByID(otherID); err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	msgs, err := s.msgs.ListDirect(userID, otherID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(msgs, userID); err != nil {
+		return nil, err
+	}
+	if err := s.attachFiles(msgs); err != nil {
+		return nil, err
 	}
 
+	s.populateUsernames(msgs)
 	return msgs, nil
 }
+
+// ListDirectThreads returns the caller's direct conversations, most recently
+// active first, each with its latest message.
+func (s *MessageService) ListDirectThreads(userID int) ([]models.DirectThread, error) {
+	latest, err := s.msgs.ListDirectThreads(userID)
+	if err != nil {
+		return nil, err
+	}
+	s.populateUsernames(latest)
+
+	threads := make([]models.DirectThread, 0, len(latest))
+	for _, msg := range latest {
+		otherID := msg.ReceiverID
+		if otherID == userID {
+			otherID = msg.SenderID
+		}
+		threads = append(threads, models.DirectThread{
+			UserID:      otherID,
+			Username:    s.usernameOf(otherID),
+			LastMessage: msg,
+		})
+	}
+	return threads, nil
+}
+
+// Edit replaces the content of a message. Authors can edit their own messages
+// and members with PermManageMessages can edit those of lower-ranked members.
+func (s *MessageService) Edit(userID, messageID int, content string) (*models.Message, error) {
+	if err := s.validateContent(content); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.msgs.FindByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(msg, userID); err != nil {
+		return nil, err
+	}
+	if msg.IsDeleted() {
+		return nil, errors.New("message has been deleted")
+	}
+	if err := s.authorizeModeration(msg, userID, "you can only edit your own messages"); err != nil {
+		return nil, err
+	}
+	if msg.Content == content {
+		msg.Username = s.usernameOf(msg.SenderID)
+		return msg, nil
+	}
+
+	updated, err := s.msgs.UpdateContent(messageID, content, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	updated.Username = s.usernameOf(updated.SenderID)
+	s.reindex(updated)
+
+	s.publish(updated, "message_edited", map[string]any{
+		"id":        updated.ID,
+		"room_id":   updated.RoomID,
+		"content":   updated.Content,
+		"edited_at": updated.EditedAt.UnixMilli(),
+	})
+	return updated, nil
+}
+
+// reindex brings the search index up to date with a stored message. The
+// index can be rebuilt from the messages, so a failure is logged rather than
+// failing a change that has already been saved.
+func (s *MessageService) reindex(msg *models.Message) {
+	if err := s.index.Index(*msg); err != nil {
+		log.Printf("Failed to index message %d: %v", msg.ID, err)
+	}
+}
+
+// Delete removes a message. Authors can delete their own messages and members
+// with PermManageMessages can delete messages of lower-ranked members.
+func (s *MessageService) Delete(userID, messageID int) error {
+	msg, err := s.msgs.FindByID(messageID)
+	if err != nil {
+		return err
+	}
+	if err := s.authorizeMessage(msg, userID); err != nil {
+		return err
+	}
+	if msg.IsDeleted() {
+		return nil
+	}
+
+	if err := s.authorizeModeration(msg, userID, "you can only delete your own messages"); err != nil {
+		return err
+	}
+
+	deletedAt := time.Now()
+	if err := s.msgs.SoftDelete(messageID, deletedAt); err != nil {
+		return err
+	}
+	if err := s.reactions.DeleteByMessage(messageID); err != nil {
+		return err
+	}
+	if err := s.mentions.DeleteByMessage(messageID); err != nil {
+		return err
+	}
+	if err := s.index.Remove(messageID); err != nil {
+		log.Printf("Failed to remove message %d from the search index: %v", messageID, err)
+	}
+	if err := s.deleteAttachments(messageID); err != nil {
+		return err
+	}
+
+	s.publish(msg, "message_deleted", map[string]any{
+		"id":         msg.ID,
+		"room_id":    msg.RoomID,
+		"deleted_by": userID,
+		"deleted_at": deletedAt.UnixMilli(),
+	})
+	return nil
+}
+
+// authorizeModeration lets authors act on their own messages, and members
+// with PermManageMessages act on room messages of members ranked below them.
+func (s *MessageService) authorizeModeration(msg *models.Message, userID int, reason string) error {
+	if msg.SenderID == userID {
+		return nil
+	}
+	if msg.RoomID == 0 {
+		return &ForbiddenError{Reason: reason}
+	}
+	_, _, err := s.auth.requireOver(msg.RoomID, userID, msg.SenderID, PermManageMessages)
+	if IsForbidden(err) {
+		return &ForbiddenError{Reason: reason}
+	}
+	return err
+}
+
+// ListEdits returns the previous versions of a message the caller can read.
+func (s *MessageService) ListEdits(userID, messageID int) ([]models.MessageEdit, error) {
+	msg, err := s.msgs.FindByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(msg, userID); err != nil {
+		return nil, err
+	}
+	return s.msgs.ListEdits(messageID)
+}
+
+// AddReaction reacts to a message with emoji. Reacting twice with the same
+// emoji is a no-op.
+func (s *MessageService) AddReaction(userID, messageID int, emoji string) error {
+	msg, err := s.reactionTarget(userID, messageID, emoji)
+	if err != nil {
+		return err
+	}
+
+	added, err := s.reactions.Add(messageID, userID, emoji, s.config.MaxReactions)
+	if err != nil {
+		return err
+	}
+	if added {
+		s.publishReaction(msg, "reaction_added", userID, emoji)
+	}
+	return nil
+}
+
+// RemoveReaction takes back the caller's emoji reaction to a message.
+func (s *MessageService) RemoveReaction(userID, messageID int, emoji string) error {
+	msg, err := s.reactionTarget(userID, messageID, emoji)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.reactions.Remove(messageID, userID, emoji)
+	if err != nil {
+		return err
+	}
+	if removed {
+		s.publishReaction(msg, "reaction_removed", userID, emoji)
+	}
+	return nil
+}
+
+// reactionTarget validates emoji and loads the message userID wants to react
+// to.
+func (s *MessageService) reactionTarget(userID, messageID int, emoji string) (*models.Message, error) {
+	if err := validateEmoji(emoji); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.msgs.FindByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeMessage(msg, userID); err != nil {
+		return nil, err
+	}
+	if msg.IsDeleted() {
+		return nil, errors.New("message has been deleted")
+	}
+	return msg, nil
+}
+
+// publishReaction announces a reaction change along with the emoji's new
+// count, so clients can update without refetching.
+func (s *MessageService) publishReaction(msg *models.Message, event string, userID int, emoji string) {
+	reactions, err := s.reactions.ListByMessages([]int{msg.ID})
+	if err != nil {
+		return
+	}
+	count := 0
+	for _, reaction := range reactions {
+		if reaction.Emoji == emoji {
+			count++
+		}
+	}
+
+	s.publish(msg, event, map[string]any{
+		"message_id": msg.ID,
+		"room_id":    msg.RoomID,
+		"user_id":    userID,
+		"username":   s.usernameOf(userID),
+		"emoji":      emoji,
+		"count":      count,
+	})
+}
+
+// validateEmoji accepts a single short token without whitespace, which covers
+// multi-codepoint emoji such as flags and skin-tone variants.
+func validateEmoji(emoji string) error {
+	if emoji == "" {
+		return errors.New("emoji is required")
+	}
+	if len(emoji) > 32 || !utf8.ValidString(emoji) {
+		return errors.New("invalid emoji")
+	}
+	if strings.IndexFunc(emoji, unicode.IsSpace) >= 0 {
+		return errors.New("invalid emoji")
+	}
+	return nil
+}
+
+// populateUsernames fills in the sender's username for each message.
+func (s *MessageService) populateUsernames(msgs []models.Message) {
+	for i := range msgs {
+		msgs[i].Username = s.usernameOf(msgs[i].SenderID)
+	}
+}
+
+func (s *MessageService) usernameOf(userID int) string {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return "Unknown User"
+	}
+	return user.Username
+}
@@ -0,0 +1,439 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+type nopHub struct{}
+
+func (nopHub) BroadcastMessage(models.Message, string)    {}
+func (nopHub) BroadcastDirect(models.Message, string)     {}
+func (nopHub) BroadcastEvent(int, string, map[string]any) {}
+func (nopHub) SendToUsers([]int, string, map[string]any)  {}
+
+// brokenIndex fails every update, as a search index that lost its storage
+// would.
+type brokenIndex struct {
+	repository.SearchIndex
+}
+
+var errIndexDown = errors.New("index unavailable")
+
+func (brokenIndex) Index(models.Message) error { return errIndexDown }
+func (brokenIndex) Remove(int) error           { return errIndexDown }
+func (brokenIndex) RemoveRoom(int) error       { return errIndexDown }
+
+func TestSearchIndexFailuresDoNotFailStoredChanges(t *testing.T) {
+	f := newChatFixture(t)
+	index := brokenIndex{repository.NewInMemorySearchIndex()}
+	blobs, err := repository.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("blob store: %v", err)
+	}
+	chats := repository.NewInMemoryChatRepo()
+	cfg := &config.Config{MaxMessageLength: 1000, MaxReactions: 20}
+	msgs := NewMessageService(f.messages, f.reactions, f.readMarkers, f.mentions, index, f.attachments, blobs,
+		chats, f.memberships, f.restrictions, f.users, nopHub{}, noPresence{}, cfg)
+	rooms := NewChatService(chats, f.users, f.messages, f.reactions, f.readMarkers, f.mentions, f.memberships,
+		f.restrictions, f.invites, index, f.attachments, blobs, noPresence{})
+
+	alice := f.user(t, "alice")
+	if _, err := rooms.CreateRoom("default", false, alice); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	room, err := rooms.CreateRoom("general", false, alice)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	msg, err := msgs.Send(room.ID, alice, "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	edited, err := msgs.Edit(alice, msg.ID, "hello again")
+	if err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	if stored, _ := f.messages.FindByID(msg.ID); stored.Content != "hello again" || edited.Content != "hello again" {
+		t.Errorf("Edit stored %q and returned %q", stored.Content, edited.Content)
+	}
+	if err := msgs.Delete(alice, msg.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := rooms.DeleteRoom(room.ID, alice); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+}
+
+func TestDirectMessagesStayBetweenParticipants(t *testing.T) {
+	f := newChatFixture(t)
+	alice, bob, carol := f.user(t, "alice"), f.user(t, "bob"), f.user(t, "carol")
+
+	dm, err := f.msgs.SendDirect(alice, bob, "just between us")
+	if err != nil {
+		t.Fatalf("SendDirect: %v", err)
+	}
+	if _, err := f.msgs.SendDirect(carol, bob, "hi bob"); err != nil {
+		t.Fatalf("SendDirect: %v", err)
+	}
+
+	// Carol can only ever list her own conversations
+	for _, other := range []int{alice, bob} {
+		msgs, err := f.msgs.ListDirect(carol, other, 0)
+		if err != nil {
+			t.Fatalf("ListDirect: %v", err)
+		}
+		for _, msg := range msgs {
+			if msg.ID == dm.ID {
+				t.Errorf("carol's conversation with %d includes alice's message to bob", other)
+			}
+		}
+	}
+	if msgs, err := f.msgs.ListDirect(bob, alice, 0); err != nil || len(msgs) != 1 || msgs[0].ID != dm.ID {
+		t.Errorf("ListDirect(bob, alice) = %v, %v", msgs, err)
+	}
+
+	if _, err := f.msgs.ListEdits(carol, dm.ID); !IsForbidden(err) {
+		t.Errorf("carol lists the edits: err = %v, want forbidden", err)
+	}
+	if _, err := f.msgs.Edit(carol, dm.ID, "rewritten"); !IsForbidden(err) {
+		t.Errorf("carol edits: err = %v, want forbidden", err)
+	}
+	if err := f.msgs.AddReaction(carol, dm.ID, "👍"); !IsForbidden(err) {
+		t.Errorf("carol reacts: err = %v, want forbidden", err)
+	}
+	if err := f.msgs.Delete(carol, dm.ID); !IsForbidden(err) {
+		t.Errorf("carol deletes: err = %v, want forbidden", err)
+	}
+	// Not even the receiver may change what the sender wrote
+	if _, err := f.msgs.Edit(bob, dm.ID, "rewritten"); !IsForbidden(err) {
+		t.Errorf("bob edits alice's message: err = %v, want forbidden", err)
+	}
+
+	stored, err := f.messages.FindByID(dm.ID)
+	if err != nil || stored.Content != "just between us" || stored.IsDeleted() {
+		t.Errorf("after carol's attempts the message is %+v, %v", stored, err)
+	}
+	if reactions, _ := f.reactions.ListByMessages([]int{dm.ID}); len(reactions) != 0 {
+		t.Errorf("reactions = %+v", reactions)
+	}
+	if err := f.msgs.AddReaction(bob, dm.ID, "👍"); err != nil {
+		t.Errorf("bob reacts: %v", err)
+	}
+}
+
+func TestPrivateRoomMessagesNeedMembership(t *testing.T) {
+	f := newChatFixture(t)
+	owner, member, outsider := f.user(t, "owner"), f.user(t, "member"), f.user(t, "outsider")
+	f.room(t, "default", false, owner)
+	room := f.room(t, "secret", true, owner)
+	f.member(t, room, member, models.RoleMember)
+
+	msg, err := f.msgs.Send(room, member, "members only")
+	if err != nil {
+		t.Fatalf("Send as a member: %v", err)
+	}
+	if page, err := f.msgs.List(member, room, MessageCursor{}, 50); err != nil || len(page.Messages) != 1 {
+		t.Fatalf("List as a member = %+v, %v", page, err)
+	}
+
+	if _, err := f.msgs.List(outsider, room, MessageCursor{}, 50); !IsForbidden(err) {
+		t.Errorf("List as an outsider: err = %v, want forbidden", err)
+	}
+	if _, err := f.msgs.Send(room, outsider, "let me in"); !IsForbidden(err) {
+		t.Errorf("Send as an outsider: err = %v, want forbidden", err)
+	}
+	if _, err := f.msgs.Reply(outsider, msg.ID, "let me in"); !IsForbidden(err) {
+		t.Errorf("Reply as an outsider: err = %v, want forbidden", err)
+	}
+	if page, _ := f.msgs.List(member, room, MessageCursor{}, 50); len(page.Messages) != 1 {
+		t.Errorf("the outsider's messages were stored: %+v", page.Messages)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	f := newChatFixture(t)
+	alice := f.user(t, "alice")
+	room := f.room(t, "general", false, alice)
+	other := f.room(t, "random", false, alice)
+
+	// Messages in the other room in between keep the IDs from being contiguous
+	var ids []int
+	var foreign int
+	for i := 0; i < 7; i++ {
+		msg, err := f.msgs.Send(room, alice, "hello")
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		ids = append(ids, msg.ID)
+		if msg, err = f.msgs.Send(other, alice, "elsewhere"); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		foreign = msg.ID
+	}
+
+	for _, tc := range []struct {
+		name       string
+		cursor     MessageCursor
+		limit      int
+		want       []int
+		prev, next int
+	}{
+		{"newest", MessageCursor{}, 3, ids[4:], ids[4], 0},
+		{"everything", MessageCursor{}, 0, ids, 0, 0},
+		{"before", MessageCursor{Before: ids[4]}, 3, ids[1:4], ids[1], ids[3]},
+		{"before, reaching the start", MessageCursor{Before: ids[3]}, 3, ids[:3], 0, ids[2]},
+		{"before the first", MessageCursor{Before: ids[0]}, 3, nil, 0, 0},
+		{"after", MessageCursor{After: ids[2]}, 3, ids[3:6], ids[3], ids[5]},
+		{"after, reaching the end", MessageCursor{After: ids[3]}, 3, ids[4:], ids[4], 0},
+		{"after the last", MessageCursor{After: ids[6]}, 3, nil, 0, 0},
+		{"around, split evenly", MessageCursor{Around: ids[3]}, 4, ids[2:6], ids[2], ids[5]},
+		{"around, odd limit favours older", MessageCursor{Around: ids[3]}, 3, ids[2:5], ids[2], ids[4]},
+		{"around the first", MessageCursor{Around: ids[0]}, 4, ids[:3], 0, ids[2]},
+		{"around the last", MessageCursor{Around: ids[6]}, 5, ids[4:], ids[4], 0},
+	} {
+		page, err := f.msgs.List(alice, room, tc.cursor, tc.limit)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got []int
+		for _, msg := range page.Messages {
+			got = append(got, msg.ID)
+		}
+		if !slices.Equal(got, tc.want) || page.PrevCursor != tc.prev || page.NextCursor != tc.next {
+			t.Errorf("%s: got %v (prev %d, next %d), want %v (prev %d, next %d)",
+				tc.name, got, page.PrevCursor, page.NextCursor, tc.want, tc.prev, tc.next)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		cursor MessageCursor
+	}{
+		{"before from another room", MessageCursor{Before: foreign}},
+		{"after from another room", MessageCursor{After: foreign}},
+		{"around from another room", MessageCursor{Around: foreign}},
+		{"a message that does not exist", MessageCursor{Before: foreign + 100}},
+		{"two anchors", MessageCursor{Before: ids[4], After: ids[1]}},
+		{"negative", MessageCursor{Before: -1}},
+	} {
+		if page, err := f.msgs.List(alice, room, tc.cursor, 3); err == nil {
+			t.Errorf("%s: got %d messages, want an error", tc.name, len(page.Messages))
+		}
+	}
+}
+
+func TestListClampsLimit(t *testing.T) {
+	f := newChatFixture(t)
+	alice := f.user(t, "alice")
+	room := f.room(t, "general", false, alice)
+	for i := 0; i < 120; i++ {
+		if _, err := f.messages.Save(&models.Message{SenderID: alice, RoomID: room, Content: "hello", CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	for _, tc := range []struct{ limit, want int }{{0, 50}, {-5, 50}, {10, 10}, {100, 100}, {1000, 100}} {
+		page, err := f.msgs.List(alice, room, MessageCursor{}, tc.limit)
+		if err != nil {
+			t.Fatalf("limit %d: %v", tc.limit, err)
+		}
+		if len(page.Messages) != tc.want || page.PrevCursor == 0 {
+			t.Errorf("limit %d: got %d messages (prev %d), want %d and more before", tc.limit, len(page.Messages), page.PrevCursor, tc.want)
+		}
+	}
+}
+
+func TestEditAndDeleteOthersMessages(t *testing.T) {
+	f := newChatFixture(t)
+	owner, mod, alice, bob := f.user(t, "owner"), f.user(t, "mod"), f.user(t, "alice"), f.user(t, "bob")
+	room := f.room(t, "general", false, owner)
+	f.member(t, room, mod, models.RoleModerator)
+	f.member(t, room, alice, models.RoleMember)
+	f.member(t, room, bob, models.RoleMember)
+
+	msg, err := f.msgs.Send(room, alice, "first")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if _, err := f.msgs.Edit(bob, msg.ID, "bob was here"); !IsForbidden(err) {
+		t.Errorf("another member edits: err = %v, want forbidden", err)
+	}
+	if err := f.msgs.Delete(bob, msg.ID); !IsForbidden(err) {
+		t.Errorf("another member deletes: err = %v, want forbidden", err)
+	}
+	edited, err := f.msgs.Edit(alice, msg.ID, "second")
+	if err != nil || edited.Content != "second" || edited.EditedAt == nil {
+		t.Fatalf("the sender edits: %+v, %v", edited, err)
+	}
+	if edits, err := f.msgs.ListEdits(bob, msg.ID); err != nil || len(edits) != 1 || edits[0].OldContent != "first" {
+		t.Errorf("ListEdits = %+v, %v", edits, err)
+	}
+
+	// A moderator can remove what members write, but not what the owner writes
+	if err := f.msgs.Delete(mod, msg.ID); err != nil {
+		t.Fatalf("a moderator deletes a member's message: %v", err)
+	}
+	if stored, _ := f.messages.FindByID(msg.ID); !stored.IsDeleted() || stored.Content != "" {
+		t.Errorf("after Delete the message is %+v", stored)
+	}
+	ownerMsg, err := f.msgs.Send(room, owner, "announcement")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := f.msgs.Delete(mod, ownerMsg.ID); !IsForbidden(err) {
+		t.Errorf("a moderator deletes the owner's message: err = %v, want forbidden", err)
+	}
+
+	// Once deleted, a message can't be brought back by editing it
+	if _, err := f.msgs.Edit(alice, msg.ID, "third"); err == nil {
+		t.Error("the sender edited a deleted message")
+	}
+	if stored, _ := f.messages.FindByID(msg.ID); stored.Content != "" {
+		t.Errorf("editing a deleted message stored %q", stored.Content)
+	}
+	if err := f.msgs.Delete(alice, msg.ID); err != nil {
+		t.Errorf("deleting twice: %v", err)
+	}
+}
+
+// eventHub records the room events it is asked to broadcast.
+type eventHub struct {
+	nopHub
+	events []hubEvent
+}
+
+type hubEvent struct {
+	roomID  int
+	event   string
+	payload map[string]any
+}
+
+func (h *eventHub) BroadcastEvent(roomID int, event string, payload map[string]any) {
+	h.events = append(h.events, hubEvent{roomID, event, payload})
+}
+
+func TestReactionsToggle(t *testing.T) {
+	f := newChatFixture(t)
+	hub := &eventHub{}
+	f.msgs.hub = hub
+	alice, bob := f.user(t, "alice"), f.user(t, "bob")
+	room := f.room(t, "general", false, alice)
+	msg, err := f.msgs.Send(room, alice, "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	summary := func(userID int) []models.ReactionSummary {
+		t.Helper()
+		page, err := f.msgs.List(userID, room, MessageCursor{}, 0)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		return page.Messages[0].Reactions
+	}
+
+	for _, tc := range []struct {
+		name  string
+		user  int
+		add   bool
+		event string // empty when nothing changes
+		count int
+	}{
+		{"alice adds", alice, true, "reaction_added", 1},
+		{"alice adds again", alice, true, "", 0},
+		{"bob adds", bob, true, "reaction_added", 2},
+		{"alice removes", alice, false, "reaction_removed", 1},
+		{"alice removes again", alice, false, "", 0},
+	} {
+		hub.events = nil
+		if tc.add {
+			err = f.msgs.AddReaction(tc.user, msg.ID, "👍")
+		} else {
+			err = f.msgs.RemoveReaction(tc.user, msg.ID, "👍")
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tc.event == "" {
+			if len(hub.events) != 0 {
+				t.Errorf("%s: broadcast %+v for no change", tc.name, hub.events)
+			}
+			continue
+		}
+		if len(hub.events) != 1 || hub.events[0].event != tc.event || hub.events[0].payload["count"] != tc.count {
+			t.Errorf("%s: broadcast %+v, want %s with count %d", tc.name, hub.events, tc.event, tc.count)
+		}
+	}
+
+	if got := summary(alice); len(got) != 1 || got[0].Count != 1 || got[0].ReactedByMe {
+		t.Errorf("alice sees %+v", got)
+	}
+	if got := summary(bob); len(got) != 1 || !got[0].ReactedByMe {
+		t.Errorf("bob sees %+v", got)
+	}
+
+	for _, emoji := range []string{"", "two words", strings.Repeat("x", 33)} {
+		if err := f.msgs.AddReaction(alice, msg.ID, emoji); err == nil {
+			t.Errorf("AddReaction accepted %q", emoji)
+		}
+	}
+	if err := f.msgs.Delete(alice, msg.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := f.msgs.AddReaction(bob, msg.ID, "🎉"); err == nil {
+		t.Error("reacted to a deleted message")
+	}
+	if reactions, _ := f.reactions.ListByMessages([]int{msg.ID}); len(reactions) != 0 {
+		t.Errorf("a deleted message kept its reactions: %+v", reactions)
+	}
+}
+
+func TestReactionsLimitDistinctEmoji(t *testing.T) {
+	f := newChatFixture(t)
+	f.msgs.config.MaxReactions = 2
+	alice, bob := f.user(t, "alice"), f.user(t, "bob")
+	room := f.room(t, "general", false, alice)
+	msg, err := f.msgs.Send(room, alice, "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	other, err := f.msgs.Send(room, alice, "again")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	for _, emoji := range []string{"👍", "🎉"} {
+		if err := f.msgs.AddReaction(alice, msg.ID, emoji); err != nil {
+			t.Fatalf("AddReaction %s: %v", emoji, err)
+		}
+	}
+	if err := f.msgs.AddReaction(bob, msg.ID, "😀"); err == nil {
+		t.Error("a third distinct emoji was accepted")
+	}
+	// Joining an emoji that is already there is always allowed
+	if err := f.msgs.AddReaction(bob, msg.ID, "🎉"); err != nil {
+		t.Errorf("joining an existing emoji: %v", err)
+	}
+	// The limit is per message
+	if err := f.msgs.AddReaction(bob, other.ID, "😀"); err != nil {
+		t.Errorf("reacting to another message: %v", err)
+	}
+
+	// Once nobody reacts with an emoji any more, it frees its place
+	if err := f.msgs.RemoveReaction(alice, msg.ID, "👍"); err != nil {
+		t.Fatalf("RemoveReaction: %v", err)
+	}
+	if err := f.msgs.AddReaction(bob, msg.ID, "😀"); err != nil {
+		t.Errorf("AddReaction after an emoji was freed: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"chat-backend/models"
+)
+
+// Notifier delivers account messages, such as password reset tokens, to a
+// user.
+type Notifier interface {
+	Notify(to *models.User, subject, body string) error
+}
+
+// LogNotifier writes messages to the server log instead of delivering them.
+// It is meant for development, where nobody needs to read real email.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(to *models.User, subject, body string) error {
+	log.Printf("Notification for %s (ID: %d): %s\n%s", to.Username, to.ID, subject, body)
+	return nil
+}
+
+// SMTPNotifier sends messages as plain-text email to the user's address.
+type SMTPNotifier struct {
+	addr string // host:port
+	from mail.Address
+	auth smtp.Auth // nil when the server needs no login
+}
+
+// NewSMTPNotifier sends mail through the server at addr. When username is
+// set it logs in with PLAIN auth, which net/smtp only allows over TLS or to
+// localhost.
+func NewSMTPNotifier(addr, username, password, from string) (*SMTPNotifier, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP address %q: %w", addr, err)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP sender %q: %w", from, err)
+	}
+
+	n := &SMTPNotifier{addr: addr, from: *sender}
+	if username != "" {
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n, nil
+}
+
+func (n *SMTPNotifier) Notify(to *models.User, subject, body string) error {
+	if to.Email == "" {
+		return errors.New(to.Username + " has no email address")
+	}
+	rcpt := mail.Address{Name: to.Username, Address: to.Email}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + n.from.String() + "\r\n")
+	msg.WriteString("To: " + rcpt.String() + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(n.addr, n.auth, n.from.Address, []string{to.Email}, []byte(msg.String()))
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is what a client handed to an smtpStandIn in one transaction.
+type smtpMessage struct {
+	auth string // decoded AUTH PLAIN response, if the client logged in
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn is just enough of an SMTP server for net/smtp to deliver to.
+type smtpStandIn struct {
+	addr     string
+	received chan smtpMessage
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &smtpStandIn{addr: l.Addr().String(), received: make(chan smtpMessage, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "HELO", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(resp)
+			if err != nil {
+				tp.PrintfLine("501 bad response")
+				continue
+			}
+			msg.auth = string(decoded)
+			tp.PrintfLine("235 OK")
+		case "MAIL":
+			msg.from = smtpPath(arg)
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, smtpPath(arg))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.received <- msg
+			msg = smtpMessage{auth: msg.auth}
+			tp.PrintfLine("250 OK")
+		case "RSET":
+			msg = smtpMessage{auth: msg.auth}
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// smtpPath takes the address out of a MAIL or RCPT argument such as
+// "FROM:<ann@example.com>".
+func smtpPath(arg string) string {
+	_, path, _ := strings.Cut(arg, "<")
+	path, _, _ = strings.Cut(path, ">")
+	return path
+}
+
+func (s *smtpStandIn) next(t *testing.T) smtpMessage {
+	t.Helper()
+	select {
+	case msg := <-s.received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail was delivered")
+		return smtpMessage{}
+	}
+}
+
+func TestSMTPNotifierDeliversResetToken(t *testing.T) {
+	server := newSMTPStandIn(t)
+	notifier, err := NewSMTPNotifier(server.addr, "chat", "smtp password", "Chat <no-reply@chat.example>")
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+	f := newAuthFixture(t)
+	f.svc.notifier = notifier
+	f.svc.config.ResetURL = "https://chat.example/reset"
+	f.register(t, "alice", "old password", "alice@example.com")
+
+	if err := f.svc.RequestPasswordReset("alice"); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	sent := server.next(t)
+	if sent.auth != "\x00chat\x00smtp password" {
+		t.Errorf("logged in with %q", sent.auth)
+	}
+	if sent.from != "no-reply@chat.example" || len(sent.to) != 1 || sent.to[0] != "alice@example.com" {
+		t.Errorf("envelope from %q to %q", sent.from, sent.to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(sent.data))
+	if err != nil {
+		t.Fatalf("reading the delivered message: %v", err)
+	}
+	if to := msg.Header.Get("To"); !strings.Contains(to, "<alice@example.com>") {
+		t.Errorf("To: %q", to)
+	}
+	if subject := msg.Header.Get("Subject"); subject != "Reset your password" {
+		t.Errorf("Subject: %q", subject)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := regexp.MustCompile(`https://chat\.example/reset\?token=([A-Za-z0-9_-]+)`).FindSubmatch(body)
+	if link == nil {
+		t.Fatalf("no reset link in %q", body)
+	}
+	token := string(link[1])
+
+	reset, err := f.resets.FindByHash(hashToken(token))
+	if err != nil {
+		t.Fatalf("the delivered token is not the one stored: %v", err)
+	}
+	if strings.Contains(sent.data, reset.Hash) {
+		t.Error("the message gives away the stored hash of the token")
+	}
+	if err := f.svc.ResetPassword(token, "new password"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	f.login(t, "alice", "new password")
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+	"chat-backend/utils"
+)
+
+const (
+	// maxOIDCUsernameLength leaves room for the number added to a username
+	// picked for a new account when it is already taken.
+	maxOIDCUsernameLength = 17
+	oidcUsernameAttempts  = 100
+)
+
+var (
+	errUnknownProvider  = errors.New("unknown identity provider")
+	errInvalidOIDCLogin = errors.New("invalid or expired login")
+)
+
+// OIDCProviderInfo is an identity provider users can log in with.
+type OIDCProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// OIDCRedirect starts a login at a provider. The client sends the user to
+// URL; the provider sends them back to the configured redirect URL with a
+// code and State, which the client checks against its own copy before
+// passing both on.
+type OIDCRedirect struct {
+	URL       string `json:"authorization_url"`
+	State     string `json:"state"`
+	ExpiresIn int    `json:"expires_in"` // seconds to come back with a code
+}
+
+type oidcProvider struct {
+	OIDCProviderInfo
+	client *utils.OIDCClient
+}
+
+// OIDCService logs users in through OpenID Connect providers, with the
+// authorization code flow and PKCE. A provider's user is linked to one of
+// our users by their subject identifier: the first login creates an account,
+// or a signed-in user can link the provider to the account they have. Either
+// way the login ends in a normal session, with two-factor authentication if
+// the account has it on.
+type OIDCService struct {
+	auth       *AuthService
+	users      repository.UserRepository
+	identities repository.IdentityRepository
+	providers  []oidcProvider
+	config     *config.Config
+}
+
+// NewOIDCService sets up the providers in cfg, which are contacted through
+// client.
+func NewOIDCService(auth *AuthService, userRepo repository.UserRepository, identityRepo repository.IdentityRepository, client *http.Client, cfg *config.Config) (*OIDCService, error) {
+	if len(cfg.OIDCProviders) > 0 && cfg.OIDCRedirectURL == "" {
+		return nil, errors.New("OIDC_REDIRECT_URL is required to log in with identity providers")
+	}
+	s := &OIDCService{auth: auth, users: userRepo, identities: identityRepo, config: cfg}
+	for _, p := range cfg.OIDCProviders {
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("identity provider %s needs an issuer and a client ID", p.ID)
+		}
+		if s.provider(p.ID) != nil {
+			return nil, fmt.Errorf("identity provider %s is configured twice", p.ID)
+		}
+		scopes := p.Scopes
+		if !slices.Contains(scopes, "openid") {
+			scopes = append([]string{"openid"}, scopes...)
+		}
+		s.providers = append(s.providers, oidcProvider{
+			OIDCProviderInfo: OIDCProviderInfo{ID: p.ID, Name: p.Name},
+			client:           utils.NewOIDCClient(p.Issuer, p.ClientID, p.ClientSecret, scopes, client),
+		})
+	}
+	return s, nil
+}
+
+// Providers lists the identity providers users can log in with.
+func (s *OIDCService) Providers() []OIDCProviderInfo {
+	providers := make([]OIDCProviderInfo, len(s.providers))
+	for i, p := range s.providers {
+		providers[i] = p.OIDCProviderInfo
+	}
+	return providers
+}
+
+// StartLogin begins a login at providerID.
+func (s *OIDCService) StartLogin(providerID string) (*OIDCRedirect, error) {
+	return s.start(providerID, 0)
+}
+
+// FinishLogin finishes a login with the state and code the provider sent
+// the user back with. The first login of a provider's user creates an
+// account for them.
+func (s *OIDCService) FinishLogin(state, code string, client ClientInfo) (*LoginResult, error) {
+	login, token, err := s.redeem(state, code, 0)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.identities.Find(login.Provider, token.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if identity != nil {
+		user, err = s.users.FindByID(identity.UserID)
+	} else {
+		user, err = s.createUser(login.Provider, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.auth.loginAs(user, client)
+}
+
+// StartLink begins linking userID's account to their account at
+// providerID, so they can log in through it.
+func (s *OIDCService) StartLink(userID int, providerID string) (*OIDCRedirect, error) {
+	identities, err := s.identities.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		if identity.Provider == providerID {
+			return nil, errors.New("an account at this identity provider is already linked")
+		}
+	}
+	return s.start(providerID, userID)
+}
+
+// FinishLink links the provider's user userID came back as. A link can only
+// be finished by the user who started it.
+func (s *OIDCService) FinishLink(userID int, state, code string) (*models.ExternalIdentity, error) {
+	login, token, err := s.redeem(state, code, userID)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.identities.Find(login.Provider, token.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		if identity.UserID != userID {
+			return nil, &ForbiddenError{Reason: "this account at the identity provider belongs to another user"}
+		}
+		return identity, nil
+	}
+	return s.link(userID, login.Provider, token)
+}
+
+// Identities lists the provider accounts linked to userID.
+func (s *OIDCService) Identities(userID int) ([]models.ExternalIdentity, error) {
+	return s.identities.ListByUser(userID)
+}
+
+// start stores what is needed to finish a login at providerID, and returns
+// where to send the user. linkUserID is set when linking an account.
+func (s *OIDCService) start(providerID string, linkUserID int) (*OIDCRedirect, error) {
+	p := s.provider(providerID)
+	if p == nil {
+		return nil, errUnknownProvider
+	}
+	state, nonce, verifier := newSecret(32), newSecret(16), newSecret(32)
+	authURL, err := p.client.AuthCodeURL(s.config.OIDCRedirectURL, state, nonce, verifier)
+	if err != nil {
+		log.Printf("Identity provider %s: %v", p.ID, err)
+		return nil, errors.New("identity provider is unavailable")
+	}
+
+	now := s.auth.now()
+	ttl := time.Duration(s.config.OIDCLoginTTL) * time.Minute
+	err = s.identities.CreateLogin(&models.OIDCLogin{
+		StateHash:    hashToken(state),
+		Provider:     p.ID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCRedirect{URL: authURL, State: state, ExpiresIn: int(ttl.Seconds())}, nil
+}
+
+// redeem uses up the login named by state and trades code for the user's
+// ID token at its provider.
+func (s *OIDCService) redeem(state, code string, linkUserID int) (*models.OIDCLogin, *utils.IDToken, error) {
+	if state == "" || code == "" {
+		return nil, nil, errors.New("state and code are required")
+	}
+	hash := hashToken(state)
+	login, err := s.identities.FindLogin(hash)
+	now := s.auth.now()
+	if err != nil || !login.ActiveAt(now) || login.LinkUserID != linkUserID {
+		return nil, nil, errInvalidOIDCLogin
+	}
+	p := s.provider(login.Provider)
+	if p == nil {
+		return nil, nil, errUnknownProvider
+	}
+	used, err := s.identities.UseLogin(hash, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !used {
+		return nil, nil, errInvalidOIDCLogin
+	}
+
+	token, err := p.client.Exchange(code, s.config.OIDCRedirectURL, login.CodeVerifier, login.Nonce, now)
+	if err != nil {
+		log.Printf("Login through identity provider %s failed: %v", p.ID, err)
+		return nil, nil, errors.New("the identity provider did not confirm the login")
+	}
+	return login, token, nil
+}
+
+// createUser makes an account for a provider's user logging in for the
+// first time and links it. The username comes from the ID token, with a
+// number added when it is taken. The account has no password; one can be
+// set with a password reset if the provider vouched for an email address.
+func (s *OIDCService) createUser(provider string, token *utils.IDToken) (*models.User, error) {
+	base := oidcUsername(token)
+	email, err := normalizeEmail(token.Email)
+	if err != nil || !token.EmailVerified {
+		email = ""
+	}
+	var user *models.User
+	for i := 1; user == nil; i++ {
+		if i > oidcUsernameAttempts {
+			return nil, errors.New("could not find a free username")
+		}
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		if _, err := s.users.FindByUsername(name); err == nil {
+			continue
+		}
+		if user, err = s.users.Create(name, "", email); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.link(user.ID, provider, token); err != nil {
+		return nil, err
+	}
+	log.Printf("Created user %s (ID: %d) for a login through %s", user.Username, user.ID, provider)
+	return user, nil
+}
+
+func (s *OIDCService) link(userID int, provider string, token *utils.IDToken) (*models.ExternalIdentity, error) {
+	identity := &models.ExternalIdentity{
+		Provider:  provider,
+		Subject:   token.Subject,
+		UserID:    userID,
+		Email:     token.Email,
+		CreatedAt: s.auth.now(),
+	}
+	if err := s.identities.Create(identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (s *OIDCService) provider(id string) *oidcProvider {
+	for i := range s.providers {
+		if s.providers[i].ID == id {
+			return &s.providers[i]
+		}
+	}
+	return nil
+}
+
+// oidcUsername picks a username for a new account from the ID token: the
+// preferred username, the start of the email address or the name, keeping
+// the characters usernames are usually made of.
+func oidcUsername(token *utils.IDToken) string {
+	for _, candidate := range []string{token.PreferredUsername, token.Email, token.Name} {
+		candidate, _, _ = strings.Cut(candidate, "@")
+		var b strings.Builder
+		for _, r := range candidate {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+				b.WriteRune(r)
+			case r == ' ':
+				b.WriteRune('_')
+			}
+		}
+		if name := b.String(); len(name) >= 3 {
+			return name[:min(len(name), maxOIDCUsernameLength)]
+		}
+	}
+	return "user"
+}
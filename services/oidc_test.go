@@ -0,0 +1,384 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"chat-backend/config"
+	"chat-backend/models"
+	"chat-backend/repository"
+	"chat-backend/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeGrant is what the fake provider remembers about a code it handed out.
+type fakeGrant struct {
+	redirectURI, challenge, nonce, subject string
+}
+
+// fakeProvider is an OpenID Connect provider serving discovery, keys and a
+// token endpoint that checks client credentials and PKCE verifiers. The
+// authorization step is a method, as no browser is involved.
+type fakeProvider struct {
+	srv              *httptest.Server
+	clientID, secret string
+	key              *ecdsa.PrivateKey
+	now              func() time.Time
+
+	mu     sync.Mutex
+	seq    int
+	grants map[string]fakeGrant // by code
+	tamper func(jwt.MapClaims)  // changes the next ID token
+}
+
+func newFakeProvider(t *testing.T, clientID, secret string, now func() time.Time) *fakeProvider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{clientID: clientID, secret: secret, key: key, now: now, grants: map[string]fakeGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *fakeProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.srv.URL,
+		"authorization_endpoint":                p.srv.URL + "/authorize",
+		"token_endpoint":                        p.srv.URL + "/token",
+		"jwks_uri":                              p.srv.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (p *fakeProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": "fake", "use": "sig", "alg": "ES256",
+		"x": base64.RawURLEncoding.EncodeToString(p.key.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(p.key.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	// Client credentials are form-encoded before going into the header
+	// (RFC 6749 section 2.3.1)
+	id, secret, ok := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if !ok || id != p.clientID || secret != p.secret {
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	r.ParseForm()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := r.PostForm.Get("code")
+	grant, ok := p.grants[code]
+	delete(p.grants, code)
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := p.now()
+	claims := jwt.MapClaims{
+		"iss":                p.srv.URL,
+		"aud":                p.clientID,
+		"sub":                grant.subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              grant.nonce,
+		"email":              grant.subject + "@corp.example",
+		"email_verified":     true,
+		"preferred_username": grant.subject,
+	}
+	if p.tamper != nil {
+		p.tamper(claims)
+		p.tamper = nil
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = "fake"
+	raw, err := token.SignedString(p.key)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": raw})
+}
+
+// authorize plays the user logging in at the provider as subject, and
+// returns the code and state they are sent back with.
+func (p *fakeProvider) authorize(t *testing.T, authURL, subject string) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("response_type") != "code" || q.Get("client_id") != p.clientID ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	code = "code-" + strconv.Itoa(p.seq)
+	p.grants[code] = fakeGrant{q.Get("redirect_uri"), q.Get("code_challenge"), q.Get("nonce"), subject}
+	return code, q.Get("state")
+}
+
+// setTamper changes the claims of the next ID token the provider issues.
+func (p *fakeProvider) setTamper(tamper func(jwt.MapClaims)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tamper = tamper
+}
+
+type oidcFixture struct {
+	*authFixture
+	oidc       *OIDCService
+	identities *repository.InMemoryIdentityRepo
+	provider   *fakeProvider
+}
+
+func newOIDCFixture(t *testing.T) *oidcFixture {
+	f := &oidcFixture{authFixture: newAuthFixture(t), identities: repository.NewInMemoryIdentityRepo()}
+	f.provider = newFakeProvider(t, "chat", "client secret", f.clock)
+	cfg := f.svc.config
+	cfg.OIDCProviders = []config.OIDCProvider{{
+		ID: "corp", Name: "Corp", Issuer: f.provider.srv.URL, ClientID: "chat", ClientSecret: "client secret",
+	}}
+	cfg.OIDCRedirectURL = "https://chat.example/oidc/callback"
+	cfg.OIDCLoginTTL = 10
+	oidc, err := NewOIDCService(f.svc, f.users, f.identities, f.provider.srv.Client(), cfg)
+	if err != nil {
+		t.Fatalf("NewOIDCService: %v", err)
+	}
+	f.oidc = oidc
+	return f
+}
+
+// loginAs goes through a whole login at the provider as subject.
+func (f *oidcFixture) loginAs(t *testing.T, subject string) (*LoginResult, error) {
+	t.Helper()
+	redirect, err := f.oidc.StartLogin("corp")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	code, state := f.provider.authorize(t, redirect.URL, subject)
+	if state != redirect.State {
+		t.Fatalf("the provider got state %q, the client %q", state, redirect.State)
+	}
+	return f.oidc.FinishLogin(state, code, ClientInfo{})
+}
+
+// link goes through linking user's account to subject at the provider.
+func (f *oidcFixture) link(t *testing.T, user *models.User, subject string) (*models.ExternalIdentity, error) {
+	t.Helper()
+	redirect, err := f.oidc.StartLink(user.ID, "corp")
+	if err != nil {
+		t.Fatalf("StartLink: %v", err)
+	}
+	code, state := f.provider.authorize(t, redirect.URL, subject)
+	return f.oidc.FinishLink(user.ID, state, code)
+}
+
+func TestOIDCLoginCreatesAccountOnce(t *testing.T) {
+	f := newOIDCFixture(t)
+	f.register(t, "alice", "password", "")
+
+	first, err := f.loginAs(t, "alice")
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if first.Tokens == nil {
+		t.Fatal("no tokens for a user without two-factor authentication")
+	}
+	// "alice" is taken by a password user, who must not be logged in as
+	if first.User.Username != "alice2" || first.User.Email != "alice@corp.example" {
+		t.Errorf("created user %+v", first.User)
+	}
+	if _, err := f.svc.ParseToken(first.Tokens.AccessToken); err != nil {
+		t.Errorf("the access token does not work: %v", err)
+	}
+
+	again, err := f.loginAs(t, "alice")
+	if err != nil {
+		t.Fatalf("second FinishLogin: %v", err)
+	}
+	if again.User.ID != first.User.ID {
+		t.Errorf("the second login was as user %d, the first as %d", again.User.ID, first.User.ID)
+	}
+}
+
+func TestOIDCStateWorksOnce(t *testing.T) {
+	f := newOIDCFixture(t)
+	redirect, err := f.oidc.StartLogin("corp")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	code, state := f.provider.authorize(t, redirect.URL, "alice")
+	if _, err := f.oidc.FinishLogin(state, code, ClientInfo{}); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+
+	// Even with a code the provider would still accept
+	code, _ = f.provider.authorize(t, redirect.URL, "alice")
+	if _, err := f.oidc.FinishLogin(state, code, ClientInfo{}); err != errInvalidOIDCLogin {
+		t.Errorf("a state worked twice: err = %v", err)
+	}
+	if _, err := f.oidc.FinishLogin("made-up", code, ClientInfo{}); err != errInvalidOIDCLogin {
+		t.Errorf("an unknown state worked: err = %v", err)
+	}
+
+	expired, err := f.oidc.StartLogin("corp")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	code, state = f.provider.authorize(t, expired.URL, "alice")
+	f.advance(time.Duration(expired.ExpiresIn+1) * time.Second)
+	if _, err := f.oidc.FinishLogin(state, code, ClientInfo{}); err != errInvalidOIDCLogin {
+		t.Errorf("an expired state worked: err = %v", err)
+	}
+}
+
+func TestOIDCCodeNeedsItsVerifier(t *testing.T) {
+	f := newOIDCFixture(t)
+	victim, err := f.oidc.StartLogin("corp")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	attacker, err := f.oidc.StartLogin("corp")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+
+	// A code stolen from one login and sent back with another login's state
+	// is redeemed with the wrong verifier
+	stolen, _ := f.provider.authorize(t, victim.URL, "alice")
+	if _, err := f.oidc.FinishLogin(attacker.State, stolen, ClientInfo{}); err == nil {
+		t.Fatal("a code was accepted with another login's verifier")
+	}
+	if _, err := f.users.FindByUsername("alice"); err == nil {
+		t.Error("the failed login created an account")
+	}
+}
+
+func TestOIDCRejectsBadIDTokens(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(jwt.MapClaims)
+	}{
+		{"nonce mismatch", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"no nonce", func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{"another audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"another issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"expired beyond the leeway", func(c jwt.MapClaims) { c["exp"] = c["iat"].(int64) - 120 }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newOIDCFixture(t)
+			f.provider.setTamper(tc.tamper)
+			if result, err := f.loginAs(t, "alice"); err == nil {
+				t.Fatalf("logged in as %+v", result.User)
+			}
+			if _, err := f.users.FindByUsername("alice"); err == nil {
+				t.Error("the failed login created an account")
+			}
+		})
+	}
+}
+
+func TestOIDCLinking(t *testing.T) {
+	f := newOIDCFixture(t)
+	bob := f.register(t, "bob", "password", "")
+	carol := f.register(t, "carol", "password", "")
+	if _, err := f.loginAs(t, "alice"); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+
+	if _, err := f.link(t, bob, "alice"); !IsForbidden(err) {
+		t.Errorf("linked an identity that belongs to another user: err = %v", err)
+	}
+	identity, err := f.link(t, bob, "bob")
+	if err != nil {
+		t.Fatalf("FinishLink: %v", err)
+	}
+	if identity.UserID != bob.ID || identity.Provider != "corp" || identity.Subject != "bob" {
+		t.Errorf("linked %+v", identity)
+	}
+	result, err := f.loginAs(t, "bob")
+	if err != nil || result.User.ID != bob.ID {
+		t.Fatalf("logging in through the link = %+v, %v", result, err)
+	}
+	if _, err := f.oidc.StartLink(bob.ID, "corp"); err == nil {
+		t.Error("started a second link to the same provider")
+	}
+
+	// A link can only be finished by the user who started it, and not as a
+	// login
+	redirect, err := f.oidc.StartLink(carol.ID, "corp")
+	if err != nil {
+		t.Fatalf("StartLink: %v", err)
+	}
+	code, state := f.provider.authorize(t, redirect.URL, "carol")
+	if _, err := f.oidc.FinishLink(bob.ID, state, code); err != errInvalidOIDCLogin {
+		t.Errorf("another user finished a link: err = %v", err)
+	}
+	redirect, err = f.oidc.StartLink(carol.ID, "corp")
+	if err != nil {
+		t.Fatalf("StartLink: %v", err)
+	}
+	code, state = f.provider.authorize(t, redirect.URL, "carol")
+	if _, err := f.oidc.FinishLogin(state, code, ClientInfo{}); err != errInvalidOIDCLogin {
+		t.Errorf("a link was finished as a login: err = %v", err)
+	}
+}
+
+func TestOIDCLoginAsksForSecondFactor(t *testing.T) {
+	f := newOIDCFixture(t)
+	bob := f.register(t, "bob", "password", "")
+	if _, err := f.link(t, bob, "bob"); err != nil {
+		t.Fatalf("FinishLink: %v", err)
+	}
+	f.toStepStart()
+	secret, _ := f.enableTOTP(t, bob, "password")
+	f.advance(utils.TOTPPeriod * time.Second)
+
+	result, err := f.loginAs(t, "bob")
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if result.Tokens != nil || result.Challenge == nil {
+		t.Fatalf("a login through the provider skipped two-factor authentication: %+v", result)
+	}
+	code := totpCode(t, secret, utils.TOTPStep(f.clock()))
+	tokens, user, err := f.svc.CompleteLogin(result.Challenge.Token, code, ClientInfo{})
+	if err != nil || tokens == nil || user.ID != bob.ID {
+		t.Fatalf("CompleteLogin = %v, %+v, %v", tokens, user, err)
+	}
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"chat-backend/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// hashPassword checks that password is an acceptable length and hashes it
+// for storage.
+func hashPassword(password string) (string, error) {
+	if len(password) < 6 || len(password) > 100 {
+		return "", errors.New("password must be between 6 and 100 characters")
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// normalizeEmail accepts a bare address such as "ann@example.com".
+func normalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 254 {
+		return "", errors.New("invalid email address")
+	}
+	return strings.ToLower(email), nil
+}
+
+// ChangePassword replaces userID's password after checking the current one.
+// Every other session of the user is ended, as is any pending reset token, so
+// whoever might have known the old password is locked out.
+func (s *AuthService) ChangePassword(userID int, sessionID, current, next string) error {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)) != nil {
+		return &ForbiddenError{Reason: "current password is incorrect"}
+	}
+	if err := s.setPassword(userID, next); err != nil {
+		return err
+	}
+	_, err = s.RevokeOtherSessions(userID, sessionID)
+	return err
+}
+
+// SetEmail sets or clears the address password resets are sent to. It asks
+// for the password, so a stolen access token cannot redirect resets.
+func (s *AuthService) SetEmail(userID int, password, email string) error {
+	_, err := s.checkPassword(userID, password)
+	if err != nil {
+		return err
+	}
+	if email != "" {
+		if email, err = normalizeEmail(email); err != nil {
+			return err
+		}
+	}
+	return s.users.UpdateEmail(userID, email)
+}
+
+// RequestPasswordReset sends username a single-use reset token. It reports
+// nothing about whether the account exists or the message was delivered, so
+// it cannot be used to find out who has an account. The token is issued and
+// sent in the background, so the reply does not take longer for accounts
+// that have an email address either.
+func (s *AuthService) RequestPasswordReset(username string) error {
+	if username == "" {
+		return errors.New("username is required")
+	}
+	go s.sendPasswordReset(username)
+	return nil
+}
+
+func (s *AuthService) sendPasswordReset(username string) {
+	user, err := s.users.FindByUsername(username)
+	if err != nil {
+		return
+	}
+	if user.Email == "" {
+		log.Printf("Password reset requested for %s, who has no email address", user.Username)
+		return
+	}
+	token, expiresAt, err := s.issueResetToken(user)
+	if err != nil {
+		log.Printf("Failed to issue a password reset for %s: %v", user.Username, err)
+		return
+	}
+	if err := s.notifyReset(user, token, expiresAt); err != nil {
+		log.Printf("Failed to send password reset to %s: %v", user.Username, err)
+	}
+}
+
+// AdminResetPassword lets an admin start a reset for username. The user's
+// password stops working, they are logged out everywhere and sent a reset
+// token; when it cannot be delivered, it is returned for the admin to pass on
+// instead.
+func (s *AuthService) AdminResetPassword(adminID int, username string) (string, error) {
+	admin, err := s.users.FindByID(adminID)
+	if err != nil || !slices.Contains(s.config.AdminUsers, admin.Username) {
+		return "", &ForbiddenError{Reason: "only admins can reset other users' passwords"}
+	}
+	user, err := s.users.FindByUsername(username)
+	if err != nil {
+		return "", errors.New("user not found")
+	}
+
+	// Replace the password with one nobody knows, so that only the reset
+	// token gets the user back in. A real hash keeps logins to the account
+	// as slow as any other.
+	locked, err := bcrypt.GenerateFromPassword([]byte(newSecret(32)), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	if err := s.users.UpdatePassword(user.ID, string(locked)); err != nil {
+		return "", err
+	}
+	if _, err := s.RevokeOtherSessions(user.ID, ""); err != nil {
+		return "", err
+	}
+	if err := s.invalidateTokens(user.ID); err != nil {
+		return "", err
+	}
+	token, expiresAt, err := s.issueResetToken(user)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Admin %s reset the password of %s", admin.Username, user.Username)
+	if user.Email != "" {
+		err := s.notifyReset(user, token, expiresAt)
+		if err == nil {
+			return "", nil
+		}
+		log.Printf("Failed to send password reset to %s: %v", user.Username, err)
+	}
+	return token, nil
+}
+
+// ResetPassword sets a new password with a reset token, which then stops
+// working. All of the user's sessions are ended.
+func (s *AuthService) ResetPassword(token, password string) error {
+	if token == "" {
+		return errors.New("reset token is required")
+	}
+	hash := hashToken(token)
+	reset, err := s.resets.FindByHash(hash)
+	now := s.now()
+	if err != nil || !reset.ActiveAt(now) {
+		return errInvalidResetToken
+	}
+	// Check the new password before using up the token, so a typo does not
+	// cost the user their reset
+	if _, err := hashPassword(password); err != nil {
+		return err
+	}
+	used, err := s.resets.Use(hash, now)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errInvalidResetToken
+	}
+
+	if err := s.setPassword(reset.UserID, password); err != nil {
+		return err
+	}
+	if _, err := s.RevokeOtherSessions(reset.UserID, ""); err != nil {
+		return err
+	}
+	return s.invalidateTokens(reset.UserID)
+}
+
+// setPassword stores a new password for userID and cancels its pending reset
+// tokens.
+func (s *AuthService) setPassword(userID int, password string) error {
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.users.UpdatePassword(userID, hashed); err != nil {
+		return err
+	}
+	return s.resets.UseAllForUser(userID, s.now())
+}
+
+// issueResetToken creates a reset token for user, replacing any earlier one.
+// Only its hash is stored.
+func (s *AuthService) issueResetToken(user *models.User) (string, time.Time, error) {
+	now := s.now()
+	if err := s.resets.UseAllForUser(user.ID, now); err != nil {
+		return "", time.Time{}, err
+	}
+	token := newSecret(32)
+	reset := &models.PasswordReset{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(s.config.ResetTokenTTL) * time.Minute),
+	}
+	if err := s.resets.Create(reset); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, reset.ExpiresAt, nil
+}
+
+func (s *AuthService) notifyReset(user *models.User, token string, expiresAt time.Time) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nA password reset was requested for your account.\n\n", user.Username)
+	if s.config.ResetURL != "" {
+		fmt.Fprintf(&body, "Open this link to choose a new password:\n%s\n\n", resetLink(s.config.ResetURL, token))
+	} else {
+		fmt.Fprintf(&body, "Your reset token is:\n%s\n\n", token)
+	}
+	fmt.Fprintf(&body, "It works once and expires at %s.\n", expiresAt.UTC().Format("2006-01-02 15:04 MST"))
+	body.WriteString("If you didn't ask for this, you can ignore this message.\n")
+	return s.notifier.Notify(user, "Reset your password", body.String())
+}
+
+// resetLink adds token to the query of base.
+func resetLink(base, token string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminResetPassword(t *testing.T) {
+	f := newAuthFixture(t)
+	admin := f.register(t, "admin", "admin password", "")
+	alice := f.register(t, "alice", "old password", "")
+	before := f.login(t, "alice", "old password")
+
+	if _, err := f.svc.AdminResetPassword(alice.ID, "admin"); !IsForbidden(err) {
+		t.Errorf("a non-admin reset someone's password: err = %v", err)
+	}
+
+	token, err := f.svc.AdminResetPassword(admin.ID, "alice")
+	if err != nil {
+		t.Fatalf("AdminResetPassword: %v", err)
+	}
+	if token == "" {
+		t.Fatal("no reset token for a user without an email address")
+	}
+	if _, err := f.svc.Login("alice", "old password", ClientInfo{}); err != errInvalidCredentials {
+		t.Errorf("the old password still works after an admin reset: err = %v", err)
+	}
+	if _, err := f.svc.ParseToken(before.AccessToken); err == nil {
+		t.Error("an access token from before the reset still works")
+	}
+
+	if err := f.svc.ResetPassword(token, "new password"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	f.login(t, "alice", "new password")
+}
+
+func TestRequestPasswordResetSendsInBackground(t *testing.T) {
+	f := newAuthFixture(t)
+	f.register(t, "alice", "old password", "alice@example.com")
+	f.register(t, "bob", "old password", "")
+	// Nothing is delivered until the test takes it, so a reset that waited
+	// for delivery would never return
+	f.notifier.sent = make(chan notification)
+
+	for _, username := range []string{"alice", "bob", "nobody"} {
+		done := make(chan error, 1)
+		go func() { done <- f.svc.RequestPasswordReset(username) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("RequestPasswordReset(%s): %v", username, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("RequestPasswordReset(%s) waited for the message to be delivered", username)
+		}
+	}
+
+	sent := f.nextNotification(t)
+	if sent.to.Username != "alice" {
+		t.Fatalf("reset sent to %s, want alice", sent.to.Username)
+	}
+	if err := f.svc.ResetPassword(resetTokenIn(t, sent.body), "new password"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	f.login(t, "alice", "new password")
+	select {
+	case n := <-f.notifier.sent:
+		t.Errorf("a reset was also sent to %s", n.to.Username)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// resetTokenIn finds the token in a reset message sent without RESET_URL.
+func resetTokenIn(t *testing.T, body string) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(body, "Your reset token is:\n")
+	token, _, _ := strings.Cut(rest, "\n")
+	if !ok || token == "" {
+		t.Fatalf("no reset token in %q", body)
+	}
+	return token
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+// Permission is a room action that only some roles may take.
+type Permission string
+
+const (
+	PermDeleteRoom     Permission = "delete_room"
+	PermKickMembers    Permission = "kick_members"
+	PermBanMembers     Permission = "ban_members"
+	PermMuteMembers    Permission = "mute_members"
+	PermManageMessages Permission = "manage_messages" // edit or delete anyone's messages
+	PermChangeSettings Permission = "change_settings"
+	PermManageInvites  Permission = "manage_invites"
+	PermManageRoles    Permission = "manage_roles" // promote and demote lower-ranked members
+)
+
+// rolePermissions is the permission matrix. Plain members hold none of these;
+// everyone with access to a room can read and post in it.
+var rolePermissions = map[models.Role][]Permission{
+	models.RoleOwner: {
+		PermDeleteRoom, PermKickMembers, PermBanMembers, PermMuteMembers,
+		PermManageMessages, PermChangeSettings, PermManageInvites, PermManageRoles,
+	},
+	models.RoleAdmin: {
+		PermKickMembers, PermBanMembers, PermMuteMembers,
+		PermManageMessages, PermChangeSettings, PermManageInvites, PermManageRoles,
+	},
+	models.RoleModerator: {
+		PermKickMembers, PermBanMembers, PermMuteMembers, PermManageMessages,
+	},
+}
+
+var permissionActions = map[Permission]string{
+	PermDeleteRoom:     "delete this room",
+	PermKickMembers:    "kick members",
+	PermBanMembers:     "ban members",
+	PermMuteMembers:    "mute members",
+	PermManageMessages: "manage other members' messages",
+	PermChangeSettings: "change this room's settings",
+	PermManageInvites:  "manage invites",
+	PermManageRoles:    "change member roles",
+}
+
+// RoleCan reports whether role grants perm.
+func RoleCan(role models.Role, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// roomAuthorizer resolves users' roles in rooms and checks them against the
+// permission matrix. ChatService and MessageService share it so the rules
+// live in one place.
+type roomAuthorizer struct {
+	chats        repository.ChatRepository
+	memberships  repository.MembershipRepository
+	restrictions repository.RestrictionRepository
+}
+
+func newRoomAuthorizer(cr repository.ChatRepository, memRepo repository.MembershipRepository, resRepo repository.RestrictionRepository) *roomAuthorizer {
+	return &roomAuthorizer{chats: cr, memberships: memRepo, restrictions: resRepo}
+}
+
+// role returns userID's role in the room. Users without a membership are
+// plain members of public rooms and have no access to private ones; banned
+// users have no access to either.
+func (a *roomAuthorizer) role(roomID, userID int) (models.Role, error) {
+	room, err := a.chats.FindByID(roomID)
+	if err != nil {
+		return "", errors.New("room not found")
+	}
+
+	ban, err := a.restrictions.Active(roomID, userID, models.RestrictionBan, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if ban != nil {
+		return "", restrictedError("you are banned from this room", ban)
+	}
+
+	if membership, err := a.memberships.GetMembership(roomID, userID); err == nil {
+		return membership.Role, nil
+	}
+	if room.IsPrivate {
+		return "", &ForbiddenError{Reason: "you don't have access to this room"}
+	}
+	return models.RoleMember, nil
+}
+
+// accessibleRooms lists the rooms userID may read: public rooms they are not
+// banned from and private rooms they are a member of.
+func (a *roomAuthorizer) accessibleRooms(userID int) ([]models.ChatRoom, error) {
+	rooms, err := a.chats.ListAccessibleRooms(userID, a.memberships)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave out public rooms the user is banned from
+	banned, err := a.restrictions.ListRoomsByUser(userID, models.RestrictionBan, time.Now())
+	if err != nil || len(banned) == 0 {
+		return rooms, err
+	}
+	isBanned := make(map[int]bool, len(banned))
+	for _, roomID := range banned {
+		isBanned[roomID] = true
+	}
+	accessible := rooms[:0]
+	for _, room := range rooms {
+		if !isBanned[room.ID] {
+			accessible = append(accessible, room)
+		}
+	}
+	return accessible, nil
+}
+
+// require checks that userID holds perm in the room and returns their role.
+func (a *roomAuthorizer) require(roomID, userID int, perm Permission) (models.Role, error) {
+	role, err := a.role(roomID, userID)
+	if err != nil {
+		return "", err
+	}
+	if !RoleCan(role, perm) {
+		return "", &ForbiddenError{Reason: "you don't have permission to " + permissionActions[perm]}
+	}
+	return role, nil
+}
+
+// requireOver checks that actorID holds perm and outranks targetID, so
+// moderators cannot act against admins or the owner. It returns both roles.
+func (a *roomAuthorizer) requireOver(roomID, actorID, targetID int, perm Permission) (actor, target models.Role, err error) {
+	actor, err = a.require(roomID, actorID, perm)
+	if err != nil {
+		return "", "", err
+	}
+	target, err = a.role(roomID, targetID)
+	if IsForbidden(err) {
+		// Outsiders to a private room rank below everyone in it
+		return actor, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if !actor.Outranks(target) {
+		return "", "", &ForbiddenError{Reason: "you can only act on members ranked below you"}
+	}
+	return actor, target, nil
+}
+
+// checkNotMuted rejects posting from users muted in the room.
+func (a *roomAuthorizer) checkNotMuted(roomID, userID int) error {
+	mute, err := a.restrictions.Active(roomID, userID, models.RestrictionMute, time.Now())
+	if err != nil {
+		return err
+	}
+	if mute != nil {
+		return restrictedError("you are muted in this room", mute)
+	}
+	return nil
+}
+
+// restrictedError explains a ban or mute, including when it ends.
+func restrictedError(reason string, restriction *models.Restriction) error {
+	if restriction.ExpiresAt != nil {
+		reason += " until " + restriction.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if restriction.Reason != "" {
+		reason += ": " + restriction.Reason
+	}
+	return &ForbiddenError{Reason: reason}
+}
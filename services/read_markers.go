@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+// MarkRead moves userID's read marker in the room up to messageID, or to the
+// newest message when messageID is 0. Markers only move forward; when this
+// one does, the room gets a read_up_to event for read receipts.
+func (s *MessageService) MarkRead(userID, roomID, messageID int) (*models.ReadMarker, error) {
+	if err := s.authorizeRoom(roomID, userID); err != nil {
+		return nil, err
+	}
+
+	if messageID == 0 {
+		latest, err := s.msgs.ListRoomRange(roomID, repository.MessageRange{Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(latest) == 0 {
+			return nil, errors.New("room has no messages")
+		}
+		messageID = latest[0].ID
+	} else {
+		msg, err := s.msgs.FindByID(messageID)
+		if err != nil || msg.RoomID != roomID {
+			return nil, errors.New("message not found in this room")
+		}
+		if msg.ThreadID != 0 {
+			return nil, errors.New("thread replies cannot be marked as read")
+		}
+	}
+
+	marker, moved, err := s.advanceRead(roomID, userID, messageID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	marker.Username = s.usernameOf(userID)
+	if moved {
+		s.hub.BroadcastEvent(roomID, "read_up_to", map[string]any{
+			"room_id":    roomID,
+			"user_id":    userID,
+			"username":   marker.Username,
+			"message_id": marker.LastReadID,
+		})
+	}
+	return marker, nil
+}
+
+// advanceRead moves userID's read marker and marks their mentions up to it
+// read.
+func (s *MessageService) advanceRead(roomID, userID, messageID int, at time.Time) (*models.ReadMarker, bool, error) {
+	marker, moved, err := s.reads.Advance(roomID, userID, messageID, at)
+	if err != nil || !moved {
+		return marker, moved, err
+	}
+	if err := s.mentions.MarkReadUpTo(userID, roomID, marker.LastReadID, at); err != nil {
+		return nil, false, err
+	}
+	return marker, true, nil
+}
+
+// ListReadMarkers returns how far each user has read the room, for read
+// receipts.
+func (s *MessageService) ListReadMarkers(userID, roomID int) ([]models.ReadMarker, error) {
+	if err := s.authorizeRoom(roomID, userID); err != nil {
+		return nil, err
+	}
+
+	markers, err := s.reads.ListByRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range markers {
+		markers[i].Username = s.usernameOf(markers[i].UserID)
+	}
+	return markers, nil
+}
+
+// SummarizeRooms adds userID's read marker and unread and mention counts to
+// each room.
+func (s *MessageService) SummarizeRooms(userID int, rooms []models.ChatRoom) ([]models.RoomSummary, error) {
+	markers, err := s.reads.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	lastRead := make(map[int]int, len(markers))
+	for _, marker := range markers {
+		lastRead[marker.RoomID] = marker.LastReadID
+	}
+	mentions, err := s.mentions.CountUnreadByRoom(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]models.RoomSummary, len(rooms))
+	for i, room := range rooms {
+		summary := models.RoomSummary{ChatRoom: room, LastReadID: lastRead[room.ID], MentionCount: mentions[room.ID]}
+		summary.UnreadCount, err = s.msgs.CountRoomAfter(room.ID, summary.LastReadID, userID)
+		if err != nil {
+			return nil, err
+		}
+		summaries[i] = summary
+	}
+	return summaries, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+
+	"chat-backend/models"
+)
+
+func TestReadMarkerCounts(t *testing.T) {
+	f := newChatFixture(t)
+	hub := &eventHub{}
+	f.msgs.hub = hub
+	alice, bob := f.user(t, "alice"), f.user(t, "bob")
+	room := f.room(t, "general", false, alice)
+	other := f.room(t, "random", false, alice)
+	f.member(t, room, bob, models.RoleMember)
+	f.member(t, other, bob, models.RoleMember)
+
+	// Posting marks the room read up to the new message
+	own, err := f.msgs.Send(room, bob, "I'm here")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var ids []int
+	for _, content := range []string{"hello", "hi @bob", "how are things", "@bob?"} {
+		msg, err := f.msgs.Send(room, alice, content)
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	// Thread replies do not add to the unread count, but a mention in one
+	// does
+	reply, err := f.msgs.Reply(alice, ids[0], "@bob in a thread")
+	if err != nil {
+		t.Fatalf("Reply: %v", err)
+	}
+	if _, err := f.msgs.Send(other, alice, "@bob elsewhere"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	counts := func(when string, lastRead, unread, mentions int) {
+		t.Helper()
+		summaries, err := f.msgs.SummarizeRooms(bob, []models.ChatRoom{{ID: room}, {ID: other}})
+		if err != nil {
+			t.Fatalf("SummarizeRooms: %v", err)
+		}
+		got := summaries[0]
+		if got.LastReadID != lastRead || got.UnreadCount != unread || got.MentionCount != mentions {
+			t.Errorf("%s: last read %d, %d unread, %d mentions; want %d, %d, %d", when,
+				got.LastReadID, got.UnreadCount, got.MentionCount, lastRead, unread, mentions)
+		}
+		if elsewhere := summaries[1]; elsewhere.UnreadCount != 1 || elsewhere.MentionCount != 1 {
+			t.Errorf("%s: the other room has %d unread, %d mentions", when, elsewhere.UnreadCount, elsewhere.MentionCount)
+		}
+	}
+	markRead := func(messageID int, wantEvent bool) {
+		t.Helper()
+		hub.events = nil
+		if _, err := f.msgs.MarkRead(bob, room, messageID); err != nil {
+			t.Fatalf("MarkRead(%d): %v", messageID, err)
+		}
+		if sent := len(hub.events) == 1 && hub.events[0].event == "read_up_to"; sent != wantEvent {
+			t.Errorf("MarkRead(%d) broadcast %+v", messageID, hub.events)
+		}
+	}
+
+	counts("before reading", own.ID, 4, 3)
+	markRead(ids[1], true)
+	counts("read up to the first mention", ids[1], 2, 2)
+	// Markers never move back
+	markRead(ids[0], false)
+	counts("marked an older message", ids[1], 2, 2)
+	markRead(0, true)
+	counts("read everything", ids[3], 0, 1)
+	markRead(0, false)
+
+	if _, err := f.msgs.MarkRead(bob, room, reply.ID); err == nil {
+		t.Error("marked a thread reply as read")
+	}
+	if _, err := f.msgs.MarkMentionsRead(bob, nil); err != nil {
+		t.Fatalf("MarkMentionsRead: %v", err)
+	}
+	if summaries, _ := f.msgs.SummarizeRooms(bob, []models.ChatRoom{{ID: room}}); summaries[0].MentionCount != 0 {
+		t.Errorf("after MarkMentionsRead %d mentions are unread", summaries[0].MentionCount)
+	}
+
+	// A new message after the marker is unread again
+	if _, err := f.msgs.Send(room, alice, "one more"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if summaries, _ := f.msgs.SummarizeRooms(bob, []models.ChatRoom{{ID: room}}); summaries[0].UnreadCount != 1 {
+		t.Errorf("after a new message %d are unread, want 1", summaries[0].UnreadCount)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
+	"chat-backend/models"
+	"chat-backend/repository"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchTerms     = 10
+	// Snippets show up to snippetWords words, starting snippetLead words
+	// before the first match.
+	snippetWords = 20
+	snippetLead  = 5
+)
+
+// SearchFilter narrows a message search. Zero values leave a filter off.
+type SearchFilter struct {
+	RoomID   int
+	SenderID int
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+}
+
+// SearchResult is a message matching a search. Snippet is an HTML-escaped
+// excerpt of the message with the matched words wrapped in <mark> tags.
+type SearchResult struct {
+	Message  models.Message `json:"message"`
+	RoomName string         `json:"room_name"`
+	Score    float64        `json:"score"`
+	Snippet  string         `json:"snippet"`
+}
+
+// Search finds room messages containing every word of query, best match
+// first, in the rooms userID can access.
+func (s *MessageService) Search(userID int, query string, filter SearchFilter, limit, offset int) ([]SearchResult, error) {
+	terms := uniqueTerms(repository.SearchTerms(query))
+	if len(terms) == 0 {
+		return nil, errors.New("search query is required")
+	}
+	if len(terms) > maxSearchTerms {
+		return nil, errors.New("too many search terms")
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, errors.New("from must be before to")
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	var rooms []models.ChatRoom
+	if filter.RoomID != 0 {
+		if err := s.authorizeRoom(filter.RoomID, userID); err != nil {
+			return nil, err
+		}
+		room, err := s.chats.FindByID(filter.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		rooms = []models.ChatRoom{*room}
+	} else {
+		var err error
+		if rooms, err = s.auth.accessibleRooms(userID); err != nil {
+			return nil, err
+		}
+	}
+	roomNames := make(map[int]string, len(rooms))
+	roomIDs := make([]int, len(rooms))
+	for i, room := range rooms {
+		roomNames[room.ID] = room.Name
+		roomIDs[i] = room.ID
+	}
+
+	hits, err := s.index.Search(repository.SearchQuery{
+		Terms:    terms,
+		RoomIDs:  roomIDs,
+		SenderID: filter.SenderID,
+		From:     filter.From,
+		To:       filter.To,
+		Limit:    limit,
+		Offset:   offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		msg, err := s.msgs.FindByID(hit.MessageID)
+		if err != nil || msg.IsDeleted() {
+			continue
+		}
+		msg.Username = s.usernameOf(msg.SenderID)
+		results = append(results, SearchResult{
+			Message:  *msg,
+			RoomName: roomNames[msg.RoomID],
+			Score:    hit.Score,
+			Snippet:  highlight(msg.Content, terms),
+		})
+	}
+	return results, nil
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+// highlight cuts a snippet around the first match of terms in content and
+// marks every matched word in it. Words are found the way
+// repository.SearchTerms finds them.
+func highlight(content string, terms []string) string {
+	type span struct{ start, end int }
+	var words []span
+	start := -1
+	for i, r := range content {
+		isWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			words = append(words, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(content)})
+	}
+	if len(words) == 0 {
+		return html.EscapeString(content)
+	}
+
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	matched := make([]bool, len(words))
+	first := -1
+	for i, w := range words {
+		if wanted[strings.ToLower(content[w.start:w.end])] {
+			matched[i] = true
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+
+	from := max(first-snippetLead, 0)
+	to := min(from+snippetWords, len(words))
+	from = max(to-snippetWords, 0)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := 0
+	if from > 0 {
+		pos = words[from].start
+	}
+	for i := from; i < to; i++ {
+		w := words[i]
+		b.WriteString(html.EscapeString(content[pos:w.start]))
+		if matched[i] {
+			b.WriteString("<mark>" + html.EscapeString(content[w.start:w.end]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(content[w.start:w.end]))
+		}
+		pos = w.end
+	}
+	if to < len(words) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(html.EscapeString(content[pos:]))
+	}
+	return b.String()
+}
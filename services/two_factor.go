@@ -0,0 +1,279 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"chat-backend/models"
+	"chat-backend/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryCodeCount    = 10
+	maxChallengeAttempts = 5 // wrong codes before the password has to be entered again
+	// totpSkew is how many time steps either side of now a code is accepted
+	// from, for phones whose clock is a little off.
+	totpSkew = 1
+)
+
+// recoveryAlphabet has 32 characters, so a random byte picks one evenly.
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+var (
+	errInvalidChallenge = errors.New("invalid or expired login challenge")
+	errInvalidCode      = errors.New("invalid code")
+)
+
+// Challenge is what the first step of a login with two-factor authentication
+// gives the client, to send back with a code.
+type Challenge struct {
+	Token     string `json:"challenge"`
+	ExpiresIn int    `json:"expires_in"` // seconds left to finish the login
+}
+
+// TOTPEnrollment is what a user adds to their authenticator app: the secret,
+// and the otpauth:// URI to show as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// TwoFactorStatus reports whether userID has two-factor authentication on.
+func (s *AuthService) TwoFactorStatus(userID int) (*TwoFactorStatus, error) {
+	cred, err := s.twoFactor.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil || !cred.Confirmed() {
+		return &TwoFactorStatus{}, nil
+	}
+	left, err := s.twoFactor.CountRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactorStatus{Enabled: true, RecoveryCodesLeft: left}, nil
+}
+
+// EnrollTOTP starts turning on two-factor authentication with a new secret.
+// Logins are not affected until ConfirmTOTP has seen a code from it; enrolling
+// again before then replaces the secret.
+func (s *AuthService) EnrollTOTP(userID int, password string) (*TOTPEnrollment, error) {
+	user, err := s.checkPassword(userID, password)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := s.twoFactor.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cred != nil && cred.Confirmed() {
+		return nil, errors.New("two-factor authentication is already on")
+	}
+
+	secret := utils.NewTOTPSecret()
+	err = s.twoFactor.SaveTOTP(&models.TOTPCredential{UserID: userID, Secret: secret, CreatedAt: s.now()})
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: utils.TOTPURI(s.config.TOTPIssuer, user.Username, secret)}, nil
+}
+
+// ConfirmTOTP finishes enrollment with the first code from the authenticator
+// app and returns the recovery codes, which are only ever shown this once.
+func (s *AuthService) ConfirmTOTP(userID int, code string) ([]string, error) {
+	cred, err := s.twoFactor.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil {
+		return nil, errors.New("two-factor authentication has not been set up")
+	}
+	if cred.Confirmed() {
+		return nil, errors.New("two-factor authentication is already on")
+	}
+
+	now := s.now()
+	step, ok := utils.MatchTOTP(cred.Secret, normalizeCode(code), now, totpSkew)
+	if !ok {
+		return nil, errInvalidCode
+	}
+	if err := s.twoFactor.ConfirmTOTP(userID, step, now); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(userID)
+}
+
+// RegenerateRecoveryCodes replaces userID's recovery codes, for when they
+// have been used up or lost. It takes a current code.
+func (s *AuthService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	cred, err := s.enabledTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.checkSecondFactor(cred, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errInvalidCode
+	}
+	return s.newRecoveryCodes(userID)
+}
+
+// DisableTwoFactor turns two-factor authentication off. It takes the password
+// and a current or recovery code.
+func (s *AuthService) DisableTwoFactor(userID int, password, code string) error {
+	if _, err := s.checkPassword(userID, password); err != nil {
+		return err
+	}
+	cred, err := s.enabledTOTP(userID)
+	if err != nil {
+		return err
+	}
+	ok, err := s.checkSecondFactor(cred, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInvalidCode
+	}
+	return s.twoFactor.DeleteTOTP(userID)
+}
+
+// CompleteLogin finishes a login that Login answered with a challenge, taking
+// a code from the authenticator app or a recovery code. After too many wrong
+// codes the challenge stops working and the login has to start over.
+func (s *AuthService) CompleteLogin(challengeToken, code string, client ClientInfo) (*TokenPair, *models.User, error) {
+	if challengeToken == "" || code == "" {
+		return nil, nil, errors.New("challenge and code are required")
+	}
+	hash := hashToken(challengeToken)
+	challenge, err := s.twoFactor.FindChallenge(hash)
+	if err != nil || !challenge.ActiveAt(s.now()) || challenge.Attempts >= maxChallengeAttempts {
+		return nil, nil, errInvalidChallenge
+	}
+	cred, err := s.enabledTOTP(challenge.UserID)
+	if err != nil {
+		return nil, nil, errInvalidChallenge
+	}
+
+	ok, err := s.checkSecondFactor(cred, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		attempts, err := s.twoFactor.FailChallenge(hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if attempts >= maxChallengeAttempts {
+			return nil, nil, errors.New("too many wrong codes, log in again")
+		}
+		return nil, nil, errInvalidCode
+	}
+	used, err := s.twoFactor.UseChallenge(hash, s.now())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !used {
+		return nil, nil, errInvalidChallenge
+	}
+
+	user, err := s.users.FindByID(challenge.UserID)
+	if err != nil {
+		return nil, nil, errInvalidChallenge
+	}
+	tokens, err := s.StartSession(user, client)
+	return tokens, user, err
+}
+
+// newChallenge stores a challenge for the second step of user's login.
+func (s *AuthService) newChallenge(user *models.User) (*Challenge, error) {
+	token := newSecret(32)
+	now := s.now()
+	ttl := time.Duration(s.config.ChallengeTTL) * time.Minute
+	err := s.twoFactor.CreateChallenge(&models.LoginChallenge{
+		Hash:      hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Challenge{Token: token, ExpiresIn: int(ttl.Seconds())}, nil
+}
+
+// checkSecondFactor accepts a code from the authenticator app that has not
+// been used yet, or one of the user's unused recovery codes, which it uses up.
+func (s *AuthService) checkSecondFactor(cred *models.TOTPCredential, code string) (bool, error) {
+	code = normalizeCode(code)
+	if len(code) == utils.TOTPDigits {
+		step, ok := utils.MatchTOTP(cred.Secret, code, s.now(), totpSkew)
+		if !ok {
+			return false, nil
+		}
+		return s.twoFactor.UseTOTPStep(cred.UserID, step)
+	}
+	return s.twoFactor.UseRecoveryCode(cred.UserID, hashToken(code), s.now())
+}
+
+// enabledTOTP returns userID's secret if two-factor authentication is on.
+func (s *AuthService) enabledTOTP(userID int) (*models.TOTPCredential, error) {
+	cred, err := s.twoFactor.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if cred == nil || !cred.Confirmed() {
+		return nil, errors.New("two-factor authentication is not on")
+	}
+	return cred, nil
+}
+
+// checkPassword confirms a signed-in user's password before a sensitive
+// change.
+func (s *AuthService) checkPassword(userID int, password string) (*models.User, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return nil, &ForbiddenError{Reason: "password is incorrect"}
+	}
+	return user, nil
+}
+
+// newRecoveryCodes replaces userID's recovery codes with new ones, shaped
+// like "k3x9a-p2mfq", and returns them. Only their hashes are stored.
+func (s *AuthService) newRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		rand.Read(b)
+		for j := range b {
+			b[j] = recoveryAlphabet[b[j]%32]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = hashToken(normalizeCode(codes[i]))
+	}
+	if err := s.twoFactor.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeCode drops the spaces and dashes people type codes with.
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"chat-backend/models"
+	"chat-backend/utils"
+)
+
+// enableTOTP turns on two-factor authentication for user, confirming it with
+// the code for the current step, and returns the secret and recovery codes.
+func (f *authFixture) enableTOTP(t *testing.T, user *models.User, password string) (string, []string) {
+	t.Helper()
+	enrollment, err := f.svc.EnrollTOTP(user.ID, password)
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	codes, err := f.svc.ConfirmTOTP(user.ID, totpCode(t, enrollment.Secret, utils.TOTPStep(f.clock())))
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return enrollment.Secret, codes
+}
+
+// challenge logs in with a password and fails the test unless a second
+// factor is asked for.
+func (f *authFixture) challenge(t *testing.T, username, password string) string {
+	t.Helper()
+	result, err := f.svc.Login(username, password, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login %s: %v", username, err)
+	}
+	if result.Challenge == nil {
+		t.Fatalf("Login %s did not ask for a second factor", username)
+	}
+	return result.Challenge.Token
+}
+
+// toStepStart moves the clock to the first second of the next time step.
+func (f *authFixture) toStepStart() int64 {
+	step := utils.TOTPStep(f.clock()) + 1
+	f.set(time.Unix(step*utils.TOTPPeriod, 0))
+	return step
+}
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+// wrongCode is a code that is not accepted anywhere near step.
+func wrongCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	near := map[string]bool{}
+	for s := step - totpSkew - 1; s <= step+totpSkew+1; s++ {
+		near[totpCode(t, secret, s)] = true
+	}
+	for i := 0; ; i++ {
+		code := strings.Repeat(string(rune('0'+i)), utils.TOTPDigits)
+		if !near[code] {
+			return code
+		}
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	step := f.toStepStart()
+
+	enrollment, err := f.svc.EnrollTOTP(alice.ID, "password")
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	if !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("URI %q does not carry the secret", enrollment.URI)
+	}
+	// An unconfirmed secret does not change how logins work
+	f.login(t, "alice", "password")
+
+	if _, err := f.svc.ConfirmTOTP(alice.ID, wrongCode(t, enrollment.Secret, step)); err != errInvalidCode {
+		t.Errorf("ConfirmTOTP with a wrong code: err = %v", err)
+	}
+	codes, err := f.svc.ConfirmTOTP(alice.ID, totpCode(t, enrollment.Secret, step))
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	status, err := f.svc.TwoFactorStatus(alice.ID)
+	if err != nil || !status.Enabled || status.RecoveryCodesLeft != recoveryCodeCount {
+		t.Errorf("TwoFactorStatus = %+v, %v", status, err)
+	}
+	f.challenge(t, "alice", "password")
+}
+
+func TestTOTPStepWindow(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	f.toStepStart()
+	secret, _ := f.enableTOTP(t, alice, "password")
+
+	// Move well past the step used to confirm, to the first second of a step
+	f.advance(10 * utils.TOTPPeriod * time.Second)
+	now := utils.TOTPStep(f.clock())
+	check := func(what string, step int64, want bool) {
+		t.Helper()
+		_, err := f.svc.RegenerateRecoveryCodes(alice.ID, totpCode(t, secret, step))
+		if accepted := err == nil; accepted != want {
+			t.Errorf("%s: accepted = %v, want %v (err = %v)", what, accepted, want, err)
+		}
+	}
+
+	check("two steps back", now-2, false)
+	check("two steps ahead", now+2, false)
+	check("one step back at the start of a step", now-1, true)
+	check("the same code again", now-1, false)
+	check("the current step", now, true)
+	check("an earlier step than one already used", now-1, false)
+
+	// At the last second of the step, the next one is in the window
+	f.advance((utils.TOTPPeriod - 1) * time.Second)
+	check("one step ahead at the end of a step", now+1, true)
+	f.advance(time.Second)
+	check("a code used early, once its step comes", now+1, false)
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	f.toStepStart()
+	secret, codes := f.enableTOTP(t, alice, "password")
+
+	// Codes may be typed in capitals and without the dash
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	if _, _, err := f.svc.CompleteLogin(f.challenge(t, "alice", "password"), typed, ClientInfo{}); err != nil {
+		t.Fatalf("CompleteLogin with a recovery code: %v", err)
+	}
+	status, err := f.svc.TwoFactorStatus(alice.ID)
+	if err != nil || status.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("TwoFactorStatus = %+v, %v", status, err)
+	}
+
+	challenge := f.challenge(t, "alice", "password")
+	if _, _, err := f.svc.CompleteLogin(challenge, codes[0], ClientInfo{}); err != errInvalidCode {
+		t.Errorf("a recovery code worked twice: err = %v", err)
+	}
+	if _, _, err := f.svc.CompleteLogin(challenge, codes[1], ClientInfo{}); err != nil {
+		t.Fatalf("CompleteLogin with another recovery code: %v", err)
+	}
+
+	f.advance(utils.TOTPPeriod * time.Second)
+	fresh, err := f.svc.RegenerateRecoveryCodes(alice.ID, totpCode(t, secret, utils.TOTPStep(f.clock())))
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes: %v", err)
+	}
+	challenge = f.challenge(t, "alice", "password")
+	if _, _, err := f.svc.CompleteLogin(challenge, codes[2], ClientInfo{}); err != errInvalidCode {
+		t.Errorf("a replaced recovery code still works: err = %v", err)
+	}
+	if _, _, err := f.svc.CompleteLogin(challenge, fresh[0], ClientInfo{}); err != nil {
+		t.Fatalf("CompleteLogin with a new recovery code: %v", err)
+	}
+}
+
+func TestChallengeAttemptLimit(t *testing.T) {
+	f := newAuthFixture(t)
+	alice := f.register(t, "alice", "password", "")
+	f.toStepStart()
+	secret, _ := f.enableTOTP(t, alice, "password")
+	f.advance(utils.TOTPPeriod * time.Second)
+	step := utils.TOTPStep(f.clock())
+	wrong := wrongCode(t, secret, step)
+
+	challenge := f.challenge(t, "alice", "password")
+	for i := 1; i < maxChallengeAttempts; i++ {
+		if _, _, err := f.svc.CompleteLogin(challenge, wrong, ClientInfo{}); err != errInvalidCode {
+			t.Fatalf("wrong code %d: err = %v", i, err)
+		}
+	}
+	_, _, err := f.svc.CompleteLogin(challenge, wrong, ClientInfo{})
+	if err == nil || err == errInvalidCode {
+		t.Fatalf("the last allowed wrong code: err = %v", err)
+	}
+	if _, _, err := f.svc.CompleteLogin(challenge, totpCode(t, secret, step), ClientInfo{}); err != errInvalidChallenge {
+		t.Errorf("the right code after too many wrong ones: err = %v", err)
+	}
+
+	// The code was not used up, so a fresh login can still take it
+	challenge = f.challenge(t, "alice", "password")
+	tokens, user, err := f.svc.CompleteLogin(challenge, totpCode(t, secret, step), ClientInfo{})
+	if err != nil || tokens == nil || user.ID != alice.ID {
+		t.Fatalf("CompleteLogin = %v, %+v, %v", tokens, user, err)
+	}
+	if _, _, err := f.svc.CompleteLogin(challenge, totpCode(t, secret, step+1), ClientInfo{}); err != errInvalidChallenge {
+		t.Errorf("a challenge worked twice: err = %v", err)
+	}
+
+	challenge = f.challenge(t, "alice", "password")
+	f.advance(time.Duration(f.svc.config.ChallengeTTL)*time.Minute + time.Second)
+	code := totpCode(t, secret, utils.TOTPStep(f.clock()))
+	if _, _, err := f.svc.CompleteLogin(challenge, code, ClientInfo{}); err != errInvalidChallenge {
+		t.Errorf("an expired challenge worked: err = %v", err)
+	}
+}
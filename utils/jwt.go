@@ -2,54 +2,78 @@ package utils
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateJWT(secret string, userID int, username string, ttl time.Duration) (string, error) {
+// Claims are what the server reads back from one of its access tokens.
+type Claims struct {
+	UserID    int
+	Username  string
+	SessionID string // the jti claim
+	IssuedAt  time.Time
+}
+
+// JWT signs and checks the server's access tokens. Tokens name Issuer in the
+// iss claim and Audience in aud, and both are required when parsing.
+type JWT struct {
+	Keys     *KeyRing
+	Issuer   string
+	Audience string
+}
+
+func (j JWT) Generate(userID int, username, sessionID string, ttl time.Duration) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
-		"uid":    userID,
-		"uname":  username,
-		"exp":    time.Now().Add(ttl).Unix(),
-		"iat":    time.Now().Unix(),
-		"iss":    "chat-backend",
-		"sub":    "user-auth",
+		"uid":   userID,
+		"uname": username,
+		"jti":   sessionID,
+		"sub":   strconv.Itoa(userID),
+		"iss":   j.Issuer,
+		"aud":   j.Audience,
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return j.Keys.sign(claims)
 }
 
-func ParseJWT(secret, tokenStr string) (int, string, error) {
+func (j JWT) Parse(tokenStr string) (*Claims, error) {
 	if tokenStr == "" {
-		return 0, "", errors.New("token is empty")
+		return nil, errors.New("token is empty")
 	}
-	
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secret), nil
-	})
-	
+
+	token, err := jwt.Parse(tokenStr, j.Keys.verificationKey,
+		jwt.WithValidMethods(j.Keys.methods()),
+		jwt.WithIssuer(j.Issuer),
+		jwt.WithAudience(j.Audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+
 	if err != nil {
-		return 0, "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
-	
+
 	if !token.Valid {
-		return 0, "", errors.New("token is invalid")
+		return nil, errors.New("token is invalid")
 	}
-	
+
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, "", errors.New("invalid claims")
+		return nil, errors.New("invalid claims")
 	}
-	
+
 	uidF, ok1 := claims["uid"].(float64)
 	uname, ok2 := claims["uname"].(string)
-	if !ok1 || !ok2 {
-		return 0, "", errors.New("bad claims")
+	jti, ok3 := claims["jti"].(string)
+	iat, _ := claims.GetIssuedAt()
+	nbf, _ := claims.GetNotBefore() // checked by the parser, but must be there
+	if !ok1 || !ok2 || !ok3 || iat == nil || nbf == nil {
+		return nil, errors.New("bad claims")
 	}
-	
-	return int(uidF), uname, nil
+
+	return &Claims{UserID: int(uidF), Username: uname, SessionID: jti, IssuedAt: iat.Time}, nil
 }
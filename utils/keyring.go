@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyRing holds the keys access tokens are signed and checked with.
+//
+// Loaded from a directory, it reads every *.pem private key in it, RSA (for
+// RS256) or Ed25519 (for EdDSA). The file name without .pem is the key ID,
+// sent as the kid header. Names start with the key's creation time in UTC,
+// like 20060102T150405Z.pem as written by `server keygen`, so the newest key
+// sorts last and signs. Each earlier key is retired at the time in its
+// successor's name, and keeps verifying tokens for a grace period after that
+// so tokens signed just before a rotation still work; then it can be deleted.
+// The time comes from the name rather than the file's modification time,
+// which copying or restoring the directory would change. Without a
+// directory, tokens are signed with a shared HS256 secret and nothing is
+// published.
+type KeyRing struct {
+	dir    string
+	grace  time.Duration
+	secret []byte // HS256, when there is no key directory
+
+	mu   sync.RWMutex
+	keys []*ringKey // in name order; the last one signs
+}
+
+type ringKey struct {
+	id        string
+	createdAt time.Time // from the start of id
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	retiredAt time.Time // zero for the signing key
+}
+
+// keyTimeFormat is how key file names start.
+const keyTimeFormat = "20060102T150405Z"
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // EC or OKP curve
+	X         string `json:"x,omitempty"`   // EC x coordinate, or OKP public key
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JWKSet is what /.well-known/jwks.json serves.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes an RSA, EC or Ed25519 key.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+// NewSecretKeyRing signs and checks tokens with an HS256 secret.
+func NewSecretKeyRing(secret string) *KeyRing {
+	return &KeyRing{secret: []byte(secret)}
+}
+
+// LoadKeyRing reads the keys in dir. Retired keys are accepted for grace
+// after their successor was created.
+func LoadKeyRing(dir string, grace time.Duration) (*KeyRing, error) {
+	k := &KeyRing{dir: dir, grace: grace}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the key directory again, picking up rotated keys. On error
+// the keys already loaded stay in use.
+func (k *KeyRing) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*ringKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no *.pem keys in %s; create one with `server keygen`", k.dir)
+	}
+	// The times have a fixed width, so name order is also creation order
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+	for i := 0; i+1 < len(keys); i++ {
+		keys[i].retiredAt = keys[i+1].createdAt
+	}
+
+	k.mu.Lock()
+	changed := len(k.keys) == 0 || k.keys[len(k.keys)-1].id != keys[len(keys)-1].id
+	k.keys = keys
+	k.mu.Unlock()
+	if changed {
+		log.Printf("Signing access tokens with key %s (%s)", keys[len(keys)-1].id, keys[len(keys)-1].method.Alg())
+	}
+	return nil
+}
+
+// StartReloading re-reads the key directory every interval, so keys can be
+// rotated without a restart.
+func (k *KeyRing) StartReloading(interval time.Duration) {
+	if k.dir == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := k.Reload(); err != nil {
+				log.Printf("Failed to reload signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// sign signs claims with the current key, naming it in the kid header.
+func (k *KeyRing) sign(claims jwt.MapClaims) (string, error) {
+	if k.dir == "" {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+	k.mu.RLock()
+	key := k.keys[len(k.keys)-1]
+	k.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// methods are the signing algorithms tokens may use.
+func (k *KeyRing) methods() []string {
+	if k.dir == "" {
+		return []string{jwt.SigningMethodHS256.Alg()}
+	}
+	return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+}
+
+// verificationKey finds the key a token says it was signed with, refusing
+// unknown and expired keys and algorithms that do not match the key.
+func (k *KeyRing) verificationKey(t *jwt.Token) (any, error) {
+	if k.dir == "" {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return k.secret, nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.id != kid {
+			continue
+		}
+		if key.method.Alg() != t.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		if !key.usableAt(time.Now(), k.grace) {
+			return nil, errors.New("signing key has expired")
+		}
+		return key.private.Public(), nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// JWKS returns the public keys that tokens may currently be signed with.
+func (k *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := time.Now()
+	for _, key := range k.keys {
+		if !key.usableAt(now, k.grace) {
+			continue
+		}
+		jwk := JWK{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (key *ringKey) usableAt(t time.Time, grace time.Duration) bool {
+	return key.retiredAt.IsZero() || t.Before(key.retiredAt.Add(grace))
+}
+
+// readKeyFile parses a PEM private key: PKCS #8 RSA or Ed25519, or PKCS #1
+// RSA. The file name has to start with the key's creation time.
+func readKeyFile(path string) (*ringKey, error) {
+	id := strings.TrimSuffix(filepath.Base(path), ".pem")
+	if len(id) < len(keyTimeFormat) {
+		return nil, fmt.Errorf("file name must start with the key's creation time, like %s.pem", keyTimeFormat)
+	}
+	createdAt, err := time.Parse(keyTimeFormat, id[:len(keyTimeFormat)])
+	if err != nil {
+		return nil, fmt.Errorf("file name must start with the key's creation time, like %s.pem", keyTimeFormat)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ringKey{id: id, createdAt: createdAt}
+	switch p := parsed.(type) {
+	case *rsa.PrivateKey:
+		if p.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.method, key.private = jwt.SigningMethodRS256, p
+	case ed25519.PrivateKey:
+		key.method, key.private = jwt.SigningMethodEdDSA, p
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// GenerateKeyFile writes a new "rsa" or "ed25519" private key to dir, named
+// after the current time, and returns its path. The key ring picks it up as
+// the new signing key.
+func GenerateKeyFile(dir, kind string) (string, error) {
+	var key any
+	var err error
+	switch kind {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unknown key type %q (expected rsa or ed25519)", kind)
+	}
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, time.Now().UTC().Format(keyTimeFormat)+".pem")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey writes a new Ed25519 key to dir under name.
+func writeKey(t *testing.T, dir, name string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func kidOf(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestKeyRingRetiresKeysByNameTime(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	name := func(ago time.Duration) string { return now.Add(-ago).Format(keyTimeFormat) }
+	oldest, previous, current := name(5*time.Hour), name(2*time.Hour), name(30*time.Minute)
+
+	writeKey(t, dir, oldest+".pem")
+	keys, err := LoadKeyRing(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("LoadKeyRing: %v", err)
+	}
+	j := JWT{Keys: keys, Issuer: "chat-test", Audience: "chat-test"}
+	generate := func() string {
+		t.Helper()
+		token, err := j.Generate(1, "alice", "session", time.Hour)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		return token
+	}
+	signedByOldest := generate()
+
+	writeKey(t, dir, previous+"-rsa-migration.pem")
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	signedByPrevious := generate()
+	writeKey(t, dir, current+".pem")
+	// Files that were all just copied into place: their modification times
+	// say nothing about when each key was rotated in
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	for _, path := range paths {
+		if err := os.Chtimes(path, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if kid := kidOf(t, generate()); kid != current {
+		t.Errorf("signing with %s, want %s", kid, current)
+	}
+	if _, err := j.Parse(signedByOldest); err == nil {
+		t.Error("a key retired more than the grace period ago still verifies tokens")
+	}
+	if _, err := j.Parse(signedByPrevious); err != nil {
+		t.Errorf("a key retired within the grace period no longer verifies tokens: %v", err)
+	}
+
+	var published []string
+	for _, key := range keys.JWKS().Keys {
+		published = append(published, key.KeyID)
+	}
+	if len(published) != 2 || published[0] != previous+"-rsa-migration" || published[1] != current {
+		t.Errorf("JWKS publishes %v", published)
+	}
+}
+
+func TestKeyRingNeedsTimedNames(t *testing.T) {
+	for _, name := range []string{"signing.pem", "2025-01-02.pem", "20251302T000000Z.pem"} {
+		dir := t.TempDir()
+		writeKey(t, dir, name)
+		if _, err := LoadKeyRing(dir, time.Hour); err == nil {
+			t.Errorf("LoadKeyRing accepted a key named %s", name)
+		}
+	}
+}
+
+func TestGenerateKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, kind := range []string{"ed25519", "rsa"} {
+		path, err := GenerateKeyFile(filepath.Join(dir, kind), kind)
+		if err != nil {
+			t.Fatalf("GenerateKeyFile %s: %v", kind, err)
+		}
+		if !regexp.MustCompile(`^\d{8}T\d{6}Z\.pem$`).MatchString(filepath.Base(path)) {
+			t.Errorf("key written to %s", path)
+		}
+		keys, err := LoadKeyRing(filepath.Dir(path), time.Hour)
+		if err != nil {
+			t.Fatalf("LoadKeyRing: %v", err)
+		}
+		if set := keys.JWKS(); len(set.Keys) != 1 {
+			t.Errorf("JWKS for a new %s key = %+v", kind, set)
+		}
+	}
+	if _, err := GenerateKeyFile(dir, "dsa"); err == nil {
+		t.Error("GenerateKeyFile accepted an unknown key type")
+	}
+}
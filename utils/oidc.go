@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksRefreshInterval is how often an ID token signed with a key the
+	// client does not know makes it fetch the provider's keys again, for
+	// when the provider has rotated them.
+	jwksRefreshInterval = 10 * time.Second
+	// idTokenLeeway allows for the provider's clock being a little off.
+	idTokenLeeway       = time.Minute
+	maxOIDCDocumentSize = 1 << 20
+)
+
+// idTokenMethods are the algorithms an ID token may be signed with. Shared
+// secret (HS*) signatures are not accepted.
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDCClient logs users in through one OpenID Connect provider with the
+// authorization code flow and PKCE. It reads the provider's endpoints from
+// its discovery document, builds the URL users are sent to, trades the code
+// they come back with for an ID token and checks that token against the keys
+// the provider publishes. The document and keys are fetched when first
+// needed and then cached.
+type OIDCClient struct {
+	issuer       string
+	clientID     string
+	clientSecret string // empty for a public client, which relies on PKCE alone
+	scopes       []string
+	http         *http.Client
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]oidcKey // by kid
+	keysFetched time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcKey struct {
+	public crypto.PublicKey
+	alg    string // empty when the provider does not restrict the key to one
+}
+
+// IDToken is what an ID token says about the user who logged in.
+type IDToken struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// NewOIDCClient returns a client for the provider at issuer, registered
+// with it as clientID. scopes should include "openid".
+func NewOIDCClient(issuer, clientID, clientSecret string, scopes []string, client *http.Client) *OIDCClient {
+	return &OIDCClient{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		http:         client,
+	}
+}
+
+// AuthCodeURL returns the provider's page to send the user to. It will send
+// them back to redirectURI with state and a code, and put nonce in the ID
+// token. The code only works together with verifier, which is sent as its
+// S256 PKCE challenge.
+func (c *OIDCClient) AuthCodeURL(redirectURI, state, nonce, verifier string) (string, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("authorization endpoint: %w", err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", c.clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(c.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades a code for tokens at the provider, and returns the ID
+// token after checking its signature, issuer, audience, lifetime and nonce.
+func (c *OIDCClient) Exchange(code, redirectURI, verifier, nonce string, now time.Time) (*IDToken, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+		"client_id":     {c.clientID},
+	}
+	// client_secret_basic is the default; some providers only take the
+	// secret in the form
+	secretInForm := c.clientSecret != "" &&
+		!slices.Contains(meta.TokenAuthMethods, "client_secret_basic") &&
+		slices.Contains(meta.TokenAuthMethods, "client_secret_post")
+	if secretInForm {
+		form.Set("client_secret", c.clientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.clientSecret != "" && !secretInForm {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCDocumentSize)).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Error != "" {
+			return nil, fmt.Errorf("token endpoint: %s %s", body.Error, body.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("token endpoint: %w", decodeErr)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("token endpoint returned no ID token")
+	}
+	return c.verifyIDToken(meta, body.IDToken, nonce, now)
+}
+
+func (c *OIDCClient) verifyIDToken(meta *oidcMetadata, raw, nonce string, now time.Time) (*IDToken, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, c.verificationKey,
+		jwt.WithValidMethods(idTokenMethods),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(c.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(idTokenLeeway),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	switch {
+	case claims.IssuedAt == nil:
+		return nil, errors.New("invalid ID token: no iat")
+	case claims.Subject == "":
+		return nil, errors.New("invalid ID token: no sub")
+	case claims.Nonce != nonce:
+		return nil, errors.New("invalid ID token: nonce does not match")
+	case (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != c.clientID:
+		return nil, errors.New("invalid ID token: issued to another client")
+	}
+	return &IDToken{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}, nil
+}
+
+// verificationKey finds the provider key an ID token names in its kid
+// header, fetching the keys again if it is not one the client knows.
+func (c *OIDCClient) verificationKey(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.lookupKey(kid)
+	if !ok && time.Since(c.keysFetched) >= jwksRefreshInterval {
+		if err := c.fetchKeys(); err != nil {
+			return nil, err
+		}
+		key, ok = c.lookupKey(kid)
+	}
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if key.alg != "" && key.alg != t.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// lookupKey finds a key by ID. A token without a kid can only be checked
+// when the provider has a single key.
+func (c *OIDCClient) lookupKey(kid string) (oidcKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// fetchKeys reads the provider's JSON Web Key Set. Keys that are not for
+// signatures or of an unsupported type are left out. c.mu must be held.
+func (c *OIDCClient) fetchKeys() error {
+	var set JWKSet
+	if err := c.getJSON(c.meta.JWKSURI, &set); err != nil {
+		return fmt.Errorf("provider keys: %w", err)
+	}
+	keys := make(map[string]oidcKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = oidcKey{public: public, alg: jwk.Algorithm}
+	}
+	c.keys = keys
+	c.keysFetched = time.Now()
+	return nil
+}
+
+// metadata returns the provider's discovery document, fetching it and its
+// keys the first time.
+func (c *OIDCClient) metadata() (*oidcMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.meta != nil {
+		return c.meta, nil
+	}
+
+	var meta oidcMetadata
+	wellKnown := strings.TrimSuffix(c.issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(wellKnown, &meta); err != nil {
+		return nil, fmt.Errorf("provider discovery: %w", err)
+	}
+	switch {
+	case meta.Issuer != c.issuer:
+		return nil, fmt.Errorf("provider discovery: issuer is %q, expected %q", meta.Issuer, c.issuer)
+	case meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "":
+		return nil, errors.New("provider discovery: authorization, token or JWKS endpoint missing")
+	}
+	c.meta = &meta
+	if err := c.fetchKeys(); err != nil {
+		c.meta = nil
+		return nil, err
+	}
+	return c.meta, nil
+}
+
+func (c *OIDCClient) getJSON(addr string, v any) error {
+	resp, err := c.http.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", addr, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCDocumentSize)).Decode(v)
+}
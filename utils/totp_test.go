@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit ones are their last 6 digits
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	} {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		if code != tc.code {
+			t.Errorf("code at %d = %s, want %s", tc.unix, code, tc.code)
+		}
+	}
+
+	lower, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || lower != "287082" {
+		t.Errorf("lowercase secret gave %q, %v", lower, err)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestMatchTOTPWindow(t *testing.T) {
+	const step = 1000
+	start := time.Unix(step*TOTPPeriod, 0) // first second of the step
+	end := start.Add(TOTPPeriod*time.Second - time.Second)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name string
+		now  time.Time
+		code int64
+		ok   bool
+	}{
+		{"current step", start, step, true},
+		{"one step back", start, step - 1, true},
+		{"one step ahead", end, step + 1, true},
+		{"two steps back", start, step - 2, false},
+		{"two steps ahead", end, step + 2, false},
+		{"one step back, from the next step", end.Add(time.Second), step - 1, false},
+	} {
+		got, ok := MatchTOTP(rfcSecret, code(tc.code), tc.now, 1)
+		if ok != tc.ok || (ok && got != tc.code) {
+			t.Errorf("%s: MatchTOTP = %d, %v", tc.name, got, ok)
+		}
+	}
+
+	if _, ok := MatchTOTP(rfcSecret, code(step)[:5], start, 1); ok {
+		t.Error("MatchTOTP accepted a short code")
+	}
+}
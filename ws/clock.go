@@ -0,0 +1,22 @@
+package ws
+
+import "time"
+
+// clock is where the hub gets the time and its timers from, so tests can
+// control them.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// timer is the part of *time.Timer the hub uses.
+type timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer { return time.AfterFunc(d, f) }
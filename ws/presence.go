@@ -0,0 +1,220 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"chat-backend/models"
+)
+
+const (
+	// idleTimeout is how long a connected user can go without activity on
+	// any of their connections before they show as away.
+	idleTimeout = 5 * time.Minute
+	// idleSweepInterval is how often the hub looks for newly idle users.
+	idleSweepInterval = 30 * time.Second
+)
+
+// userPresence is the presence of one connected user. Users with no open
+// connections have no entry and are offline.
+type userPresence struct {
+	status     models.Presence
+	lastActive time.Time
+}
+
+// presenceChange is queued whenever a user's presence changes and is
+// broadcast by runPresence, outside the hub lock.
+type presenceChange struct {
+	userID   int
+	username string
+	status   models.Presence
+	rooms    []int // rooms the user was connected to at the time
+}
+
+// SetRoomLookup sets how the hub finds the rooms a user belongs to, so
+// presence changes reach rooms the user is not connected to right now. It
+// must be called before Run.
+func (h *Hub) SetRoomLookup(lookup func(userID int) ([]int, error)) {
+	h.roomLookup = lookup
+}
+
+// Presence returns userID's current presence.
+func (h *Hub) Presence(userID int) models.Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if p, ok := h.presence[userID]; ok {
+		return p.status
+	}
+	return models.PresenceOffline
+}
+
+// RoomUsers returns the users connected to a room, by ID, with their usernames.
+func (h *Hub) RoomUsers(roomID int) map[int]string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make(map[int]string, len(h.rooms[roomID]))
+	for client := range h.rooms[roomID] {
+		users[client.userID] = client.username
+	}
+	return users
+}
+
+// markActive records activity from client and brings its user back online
+// if they were away.
+func (h *Hub) markActive(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.touchLocked(client, h.clock.Now())
+}
+
+// markAway shows client's user as away until their next activity, e.g. when
+// the client notices the user has left the tab.
+func (h *Hub) markAway(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.presence[client.userID]
+	if !ok || p.status == models.PresenceAway {
+		return
+	}
+	p.status = models.PresenceAway
+	h.queuePresence(client, models.PresenceAway)
+}
+
+// connectPresence is called when client has been registered. A user's first
+// connection brings them online; later ones count as activity. Callers must
+// hold h.mu.
+func (h *Hub) connectPresence(client *Client) {
+	now := h.clock.Now()
+	if _, ok := h.presence[client.userID]; ok {
+		h.touchLocked(client, now)
+		return
+	}
+	h.presence[client.userID] = &userPresence{status: models.PresenceOnline, lastActive: now}
+	h.queuePresence(client, models.PresenceOnline)
+}
+
+// disconnectPresence is called when client has been detached. The user goes
+// offline once their last connection is gone. Callers must hold h.mu.
+func (h *Hub) disconnectPresence(client *Client) {
+	if len(h.users[client.userID]) > 0 {
+		return
+	}
+	delete(h.presence, client.userID)
+	h.queuePresence(client, models.PresenceOffline)
+}
+
+// touchLocked records activity for client's user. Callers must hold h.mu.
+func (h *Hub) touchLocked(client *Client, now time.Time) {
+	p, ok := h.presence[client.userID]
+	if !ok {
+		return
+	}
+	p.lastActive = now
+	if p.status == models.PresenceAway {
+		p.status = models.PresenceOnline
+		h.queuePresence(client, models.PresenceOnline)
+	}
+}
+
+// queuePresence hands a change to runPresence without blocking, since it
+// runs under h.mu. Callers must hold h.mu.
+func (h *Hub) queuePresence(client *Client, status models.Presence) {
+	change := presenceChange{
+		userID:   client.userID,
+		username: client.username,
+		status:   status,
+		rooms:    h.connectedRoomsLocked(client.userID),
+	}
+	if client.roomID != 0 {
+		change.rooms = append(change.rooms, client.roomID)
+	}
+
+	select {
+	case h.presenceChanges <- change:
+	default:
+		log.Printf("Presence queue full, dropping %s change for user %d", status, client.userID)
+	}
+}
+
+// connectedRoomsLocked returns the rooms userID has connections to. Callers
+// must hold h.mu.
+func (h *Hub) connectedRoomsLocked(userID int) []int {
+	var rooms []int
+	for client := range h.users[userID] {
+		if client.roomID != 0 {
+			rooms = append(rooms, client.roomID)
+		}
+	}
+	return rooms
+}
+
+// runPresence broadcasts queued presence changes and moves idle users to
+// away. Run starts it.
+func (h *Hub) runPresence() {
+	ticker := time.NewTicker(idleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case change := <-h.presenceChanges:
+			h.broadcastPresence(change)
+		case <-ticker.C:
+			for _, change := range h.expireIdle(h.clock.Now()) {
+				h.broadcastPresence(change)
+			}
+		}
+	}
+}
+
+// expireIdle marks users with no activity for idleTimeout as away.
+func (h *Hub) expireIdle(now time.Time) []presenceChange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var changes []presenceChange
+	for userID, p := range h.presence {
+		if p.status != models.PresenceOnline || now.Sub(p.lastActive) < idleTimeout {
+			continue
+		}
+		p.status = models.PresenceAway
+
+		change := presenceChange{userID: userID, status: models.PresenceAway, rooms: h.connectedRoomsLocked(userID)}
+		for client := range h.users[userID] {
+			change.username = client.username
+			break
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// broadcastPresence sends a presence_changed event to every room the user
+// belongs to or is connected to.
+func (h *Hub) broadcastPresence(change presenceChange) {
+	rooms := change.rooms
+	if h.roomLookup != nil {
+		memberOf, err := h.roomLookup(change.userID)
+		if err != nil {
+			log.Printf("Presence room lookup failed for user %d: %v", change.userID, err)
+		}
+		rooms = append(rooms, memberOf...)
+	}
+
+	data := eventFrame("presence_changed", map[string]any{
+		"user_id":  change.userID,
+		"username": change.username,
+		"status":   change.status,
+	})
+	sent := make(map[int]bool, len(rooms))
+	for _, roomID := range rooms {
+		if sent[roomID] {
+			continue
+		}
+		sent[roomID] = true
+		h.broadcast <- outbound{roomID: roomID, data: data}
+	}
+}
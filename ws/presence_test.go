@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"chat-backend/models"
+)
+
+// presenceBroadcasts hands the queued presence changes to broadcastPresence,
+// as runPresence would, and describes the frames that result as
+// "<status> <user_id>@<room_id>", sorted.
+func presenceBroadcasts(t *testing.T, h *Hub) []string {
+	t.Helper()
+	for queued := true; queued; {
+		select {
+		case change := <-h.presenceChanges:
+			h.broadcastPresence(change)
+		default:
+			queued = false
+		}
+	}
+
+	var got []string
+	for {
+		select {
+		case out := <-h.broadcast:
+			var frame struct {
+				Type   string          `json:"type"`
+				UserID int             `json:"user_id"`
+				Status models.Presence `json:"status"`
+			}
+			if err := json.Unmarshal(out.data, &frame); err != nil || frame.Type != "presence_changed" {
+				t.Fatalf("unexpected frame %s (%v)", out.data, err)
+			}
+			got = append(got, fmt.Sprintf("%s %d@%d", frame.Status, frame.UserID, out.roomID))
+		default:
+			slices.Sort(got)
+			return got
+		}
+	}
+}
+
+func expectPresence(t *testing.T, h *Hub, when string, want ...string) {
+	t.Helper()
+	if got := presenceBroadcasts(t, h); !slices.Equal(got, want) {
+		t.Errorf("%s: broadcast %q, want %q", when, got, want)
+	}
+}
+
+func TestPresenceAcrossConnections(t *testing.T) {
+	h, _ := newTestHub()
+	tab1 := newTestClient(h, 1, 10, "alice")
+	tab2 := newTestClient(h, 2, 10, "alice")
+	direct := newTestClient(h, 0, 10, "alice")
+
+	if got := h.Presence(10); got != models.PresenceOffline {
+		t.Errorf("before connecting: %s", got)
+	}
+	h.addClient(tab1)
+	expectPresence(t, h, "first connection", "online 10@1")
+	h.addClient(tab2)
+	h.addClient(direct)
+	expectPresence(t, h, "more connections")
+
+	if users := h.RoomUsers(2); len(users) != 1 || users[10] != "alice" {
+		t.Errorf("RoomUsers(2) = %v", users)
+	}
+
+	h.removeClient(tab1)
+	h.removeClient(direct)
+	expectPresence(t, h, "some connections closed")
+	if got := h.Presence(10); got != models.PresenceOnline {
+		t.Errorf("with a connection left: %s", got)
+	}
+	h.removeClient(tab2)
+	expectPresence(t, h, "last connection closed", "offline 10@2")
+	if got := h.Presence(10); got != models.PresenceOffline {
+		t.Errorf("after disconnecting: %s", got)
+	}
+}
+
+func TestPresenceGoesAwayWhenIdle(t *testing.T) {
+	h, clock := newTestHub()
+	alice := newTestClient(h, 1, 10, "alice")
+	bob := newTestClient(h, 1, 20, "bob")
+	h.addClient(alice)
+	clock.advance(time.Minute)
+	h.addClient(bob)
+	presenceBroadcasts(t, h)
+
+	sweep := func() {
+		for _, change := range h.expireIdle(clock.Now()) {
+			h.broadcastPresence(change)
+		}
+	}
+
+	clock.advance(idleTimeout - time.Minute - time.Second)
+	sweep()
+	expectPresence(t, h, "just before the idle timeout")
+	clock.advance(time.Second)
+	sweep()
+	expectPresence(t, h, "idle timeout", "away 10@1")
+	if got, other := h.Presence(10), h.Presence(20); got != models.PresenceAway || other != models.PresenceOnline {
+		t.Errorf("alice is %s and bob is %s", got, other)
+	}
+	sweep()
+	expectPresence(t, h, "already away")
+
+	// Activity on any connection brings the user back
+	h.markActive(alice)
+	expectPresence(t, h, "activity", "online 10@1")
+	h.markActive(alice)
+	expectPresence(t, h, "more activity")
+
+	// Activity pushes the timeout back
+	clock.advance(idleTimeout - time.Second)
+	sweep()
+	expectPresence(t, h, "idle since the activity", "away 20@1")
+
+	// Clients can say the user left without waiting for the timeout
+	h.markAway(alice)
+	h.markAway(alice)
+	expectPresence(t, h, "marked away", "away 10@1")
+	h.addClient(newTestClient(h, 1, 10, "alice"))
+	expectPresence(t, h, "new connection", "online 10@1")
+}
+
+func TestPresenceReachesEveryRoom(t *testing.T) {
+	h, _ := newTestHub()
+	h.SetRoomLookup(func(userID int) ([]int, error) {
+		if userID == 10 {
+			return []int{1, 3}, nil
+		}
+		return nil, fmt.Errorf("no rooms for %d", userID)
+	})
+
+	// Rooms the user is a member of and rooms they are connected to, once
+	// each
+	alice := newTestClient(h, 1, 10, "alice")
+	h.addClient(alice)
+	expectPresence(t, h, "member of 1 and 3", "online 10@1", "online 10@3")
+	elsewhere := newTestClient(h, 2, 10, "alice")
+	h.addClient(elsewhere)
+	h.markAway(alice)
+	expectPresence(t, h, "connected to 2 as well", "away 10@1", "away 10@2", "away 10@3")
+
+	// A failed lookup still reaches the rooms the user is connected to
+	h.addClient(newTestClient(h, 0, 20, "bob"))
+	expectPresence(t, h, "direct-only connection")
+	bob := newTestClient(h, 4, 20, "bob")
+	h.addClient(bob)
+	h.markAway(bob)
+	expectPresence(t, h, "bob away", "away 20@4")
+}
@@ -0,0 +1,123 @@
+package ws
+
+import "time"
+
+const (
+	// typingTimeout is how long a typing indicator lasts without a fresh
+	// typing_start before the server sends typing_stop on the client's behalf.
+	typingTimeout = 6 * time.Second
+	// typingThrottle is the minimum gap between typing_start frames the
+	// server accepts from one client; extra frames are dropped.
+	typingThrottle = time.Second
+)
+
+type typingKey struct {
+	roomID int
+	userID int
+}
+
+// typingEntry is one connection's typing indicator. Its timer clears it when
+// the indicator expires.
+type typingEntry struct {
+	timer timer
+}
+
+// handleTyping processes a typing_start or typing_stop frame from c.
+func (c *Client) handleTyping(start bool) {
+	if c.roomID == 0 {
+		return
+	}
+	if !start {
+		c.hub.typingStop(c)
+		return
+	}
+
+	// Only the readPump goroutine touches lastTypingAt
+	now := c.hub.clock.Now()
+	if now.Sub(c.lastTypingAt) < typingThrottle {
+		return
+	}
+	c.lastTypingAt = now
+	c.hub.typingStart(c)
+}
+
+// typingStart marks c's user as typing in c's room. The room hears about it
+// when the first of the user's connections there starts typing; later starts
+// only push that connection's expiry back.
+func (h *Hub) typingStart(c *Client) {
+	key := typingKey{roomID: c.roomID, userID: c.userID}
+
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+
+	// Stop fails if the timer already fired; its expiry then finds a newer
+	// entry in the map and leaves it alone.
+	clients := h.typing[key]
+	if entry, ok := clients[c]; ok && entry.timer.Stop() {
+		entry.timer.Reset(typingTimeout)
+		return
+	}
+
+	if clients == nil {
+		clients = make(map[*Client]*typingEntry)
+		h.typing[key] = clients
+	}
+	alreadyTyping := len(clients) > 0
+	entry := &typingEntry{}
+	entry.timer = h.clock.AfterFunc(typingTimeout, func() { h.expireTyping(c, entry) })
+	clients[c] = entry
+	if !alreadyTyping {
+		h.broadcastTyping(key, "typing_start", c.username)
+	}
+}
+
+// typingStop clears c's typing indicator, if any. The room is told the user
+// stopped once none of their connections there are typing.
+func (h *Hub) typingStop(c *Client) {
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+
+	entry, ok := h.typing[typingKey{roomID: c.roomID, userID: c.userID}][c]
+	if !ok {
+		return
+	}
+	entry.timer.Stop()
+	h.clearTypingLocked(c)
+}
+
+func (h *Hub) expireTyping(c *Client, entry *typingEntry) {
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+
+	if h.typing[typingKey{roomID: c.roomID, userID: c.userID}][c] != entry {
+		return // stopped or restarted in the meantime
+	}
+	h.clearTypingLocked(c)
+}
+
+// clearTypingLocked removes c's entry and broadcasts typing_stop if it was
+// the user's last one in the room. Callers must hold h.typingMu.
+func (h *Hub) clearTypingLocked(c *Client) {
+	key := typingKey{roomID: c.roomID, userID: c.userID}
+	clients := h.typing[key]
+	delete(clients, c)
+	if len(clients) > 0 {
+		return
+	}
+	delete(h.typing, key)
+	h.broadcastTyping(key, "typing_stop", c.username)
+}
+
+// broadcastTyping sends a typing event to everyone in the room except the
+// typing user. Typing state is never persisted.
+func (h *Hub) broadcastTyping(key typingKey, event, username string) {
+	h.broadcast <- outbound{
+		roomID:       key.roomID,
+		exceptUserID: key.userID,
+		data: eventFrame(event, map[string]any{
+			"room_id":  key.roomID,
+			"user_id":  key.userID,
+			"username": username,
+		}),
+	}
+}
@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to, firing due timers as it goes.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	at     time.Time
+	f      func()
+	active bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.active
+	t.active = true
+	t.at = t.clock.now.Add(d)
+	return active
+}
+
+// advance moves the clock forward by d and runs the timers that came due,
+// earliest first, outside the clock's lock.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if t.active && !t.at.After(c.now) {
+			t.active = false
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// newTestHub returns a hub on a fake clock that is not running, so what it
+// broadcasts stays queued for the test to read.
+func newTestHub() (*Hub, *fakeClock) {
+	h := NewHub()
+	clock := newFakeClock()
+	h.clock = clock
+	return h, clock
+}
+
+func newTestClient(h *Hub, roomID, userID int, username string) *Client {
+	return &Client{hub: h, send: make(chan []byte, 256), roomID: roomID, userID: userID, username: username}
+}
+
+// broadcasts drains the hub's queue, describing each frame as
+// "<type> <user_id>@<room_id>".
+func broadcasts(t *testing.T, h *Hub) []string {
+	t.Helper()
+	var got []string
+	for {
+		select {
+		case out := <-h.broadcast:
+			var frame struct {
+				Type   string `json:"type"`
+				UserID int    `json:"user_id"`
+				RoomID int    `json:"room_id"`
+			}
+			if err := json.Unmarshal(out.data, &frame); err != nil {
+				t.Fatalf("bad frame %s: %v", out.data, err)
+			}
+			got = append(got, fmt.Sprintf("%s %d@%d", frame.Type, frame.UserID, out.roomID))
+		default:
+			return got
+		}
+	}
+}
+
+func expectBroadcasts(t *testing.T, h *Hub, when string, want ...string) {
+	t.Helper()
+	if got := broadcasts(t, h); !slices.Equal(got, want) {
+		t.Errorf("%s: broadcast %q, want %q", when, got, want)
+	}
+}
+
+func TestTypingThrottleAndExpiry(t *testing.T) {
+	h, clock := newTestHub()
+	alice := newTestClient(h, 1, 10, "alice")
+
+	alice.handleTyping(true)
+	expectBroadcasts(t, h, "first start", "typing_start 10@1")
+
+	// Too soon after the last one: dropped, so the expiry stays put
+	clock.advance(typingThrottle / 2)
+	alice.handleTyping(true)
+	expectBroadcasts(t, h, "throttled start")
+	clock.advance(typingTimeout - typingThrottle/2 - time.Millisecond)
+	expectBroadcasts(t, h, "just before expiry")
+	clock.advance(time.Millisecond)
+	expectBroadcasts(t, h, "expiry", "typing_stop 10@1")
+
+	// A start after the throttle pushes the expiry back without a new
+	// broadcast
+	alice.handleTyping(true)
+	clock.advance(typingThrottle)
+	alice.handleTyping(true)
+	expectBroadcasts(t, h, "restart", "typing_start 10@1")
+	clock.advance(typingTimeout - time.Millisecond)
+	expectBroadcasts(t, h, "within the pushed back expiry")
+	clock.advance(time.Millisecond)
+	expectBroadcasts(t, h, "pushed back expiry", "typing_stop 10@1")
+
+	// An explicit stop ends it at once, and the timer does not fire later
+	clock.advance(typingThrottle)
+	alice.handleTyping(true)
+	alice.handleTyping(false)
+	alice.handleTyping(false)
+	clock.advance(typingTimeout)
+	expectBroadcasts(t, h, "stop", "typing_start 10@1", "typing_stop 10@1")
+}
+
+func TestTypingAcrossConnections(t *testing.T) {
+	h, clock := newTestHub()
+	tab1 := newTestClient(h, 1, 10, "alice")
+	tab2 := newTestClient(h, 1, 10, "alice")
+	elsewhere := newTestClient(h, 2, 10, "alice")
+	bob := newTestClient(h, 1, 20, "bob")
+	directOnly := newTestClient(h, 0, 20, "bob")
+
+	tab1.handleTyping(true)
+	tab2.handleTyping(true)
+	elsewhere.handleTyping(true)
+	bob.handleTyping(true)
+	directOnly.handleTyping(true)
+	expectBroadcasts(t, h, "starts", "typing_start 10@1", "typing_start 10@2", "typing_start 20@1")
+
+	// Closing one tab leaves alice typing in the other
+	h.typingStop(tab1)
+	expectBroadcasts(t, h, "one tab closed")
+	h.typingStop(tab2)
+	expectBroadcasts(t, h, "last tab closed", "typing_stop 10@1")
+
+	// Expiry is per connection too
+	clock.advance(typingThrottle)
+	tab1.handleTyping(true)
+	clock.advance(2 * time.Second)
+	tab2.handleTyping(true)
+	expectBroadcasts(t, h, "restart", "typing_start 10@1")
+	clock.advance(typingTimeout - 2*time.Second)
+	// The other room's and bob's indicators from the start run out here too
+	expectBroadcasts(t, h, "first tab expired", "typing_stop 10@2", "typing_stop 20@1")
+	clock.advance(2 * time.Second)
+	expectBroadcasts(t, h, "second tab expired", "typing_stop 10@1")
+
+	if len(h.typing) != 0 {
+		t.Errorf("typing state left behind: %v", h.typing)
+	}
+}
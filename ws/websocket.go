@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,39 +18,76 @@ import (
 type Hub struct {
 	// roomID -> clients
 	rooms map[int]map[*Client]bool
+	// userID -> clients, across all rooms (used for direct messages)
+	users map[int]map[*Client]bool
 
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan outbound
 
 	mu sync.RWMutex
+
+	// who is typing where, by connection; see typing.go
+	typing   map[typingKey]map[*Client]*typingEntry
+	typingMu sync.Mutex
+
+	// userID -> presence of connected users, guarded by mu; see presence.go
+	presence        map[int]*userPresence
+	presenceChanges chan presenceChange
+	roomLookup      func(userID int) ([]int, error)
+
+	// where typing timers and presence get the time from; tests replace it
+	clock clock
 }
 
 type outbound struct {
-	roomID int
-	data   []byte
+	roomID       int
+	userIDs      []int // when set, deliver to these users' clients instead of a room
+	exceptUserID int   // skip this user's clients in a room delivery
+	data         []byte
 }
 
 type Client struct {
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan []byte
-	roomID   int
-	userID   int
-	username string
-	msgSvc   *services.MessageService
+	hub       *Hub
+	conn      *websocket.Conn
+	send      chan []byte
+	roomID    int // 0 for connections that only receive direct messages
+	userID    int
+	username  string
+	sessionID string // login session the connection was opened with
+	msgSvc    *services.MessageService
+	// closeMsg, when set before send is closed, is sent as the close frame
+	closeMsg []byte
+	// when the last typing_start from this client was accepted
+	lastTypingAt time.Time
 }
 
+// CloseRemovedFromRoom is the WebSocket close code sent to users who are
+// kicked or banned from the room they are connected to.
+const CloseRemovedFromRoom = 4001
+
+// CloseSessionEnded is the WebSocket close code sent when the session a
+// connection was opened with is logged out or revoked.
+const CloseSessionEnded = 4002
+
 func NewHub() *Hub {
 	return &Hub{
 		rooms:      make(map[int]map[*Client]bool),
+		users:      make(map[int]map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan outbound, 256),
+		typing:     make(map[typingKey]map[*Client]*typingEntry),
+		clock:      realClock{},
+
+		presence:        make(map[int]*userPresence),
+		presenceChanges: make(chan presenceChange, 256),
 	}
 }
 
 func (h *Hub) Run() {
+	go h.runPresence()
+
 	for {
 		select {
 		case c := <-h.register:
@@ -57,15 +95,38 @@ func (h *Hub) Run() {
 		case c := <-h.unregister:
 			h.removeClient(c)
 		case out := <-h.broadcast:
-			for client := range h.rooms[out.roomID] {
-				select {
-				case client.send <- out.data:
-				default:
-					close(client.send)
-					delete(h.rooms[out.roomID], client)
-				}
+			h.deliver(out)
+		}
+	}
+}
+
+func (h *Hub) deliver(out outbound) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var targets []*Client
+	if out.userIDs != nil {
+		for _, userID := range out.userIDs {
+			for client := range h.users[userID] {
+				targets = append(targets, client)
 			}
 		}
+	} else {
+		for client := range h.rooms[out.roomID] {
+			if out.exceptUserID != 0 && client.userID == out.exceptUserID {
+				continue
+			}
+			targets = append(targets, client)
+		}
+	}
+
+	for _, client := range targets {
+		select {
+		case client.send <- out.data:
+		default:
+			// Slow client: drop it rather than block the hub
+			h.detach(client)
+		}
 	}
 }
 
@@ -73,6 +134,17 @@ func (h *Hub) addClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.users[client.userID] == nil {
+		h.users[client.userID] = make(map[*Client]bool)
+	}
+	h.users[client.userID][client] = true
+	h.connectPresence(client)
+
+	if client.roomID == 0 {
+		log.Printf("Client %s (ID: %d) connected for direct messages", client.username, client.userID)
+		return
+	}
+
 	if h.rooms[client.roomID] == nil {
 		h.rooms[client.roomID] = make(map[*Client]bool)
 	}
@@ -86,20 +158,38 @@ func (h *Hub) removeClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.detach(client) {
+		log.Printf("Client %s (ID: %d) left room %d. Remaining clients in room: %d",
+			client.username, client.userID, client.roomID, len(h.rooms[client.roomID]))
+	}
+}
+
+// detach removes a client from the hub's indexes and closes its send
+// channel. It reports false if the client was already detached. Callers must
+// hold h.mu.
+func (h *Hub) detach(client *Client) bool {
+	userClients, ok := h.users[client.userID]
+	if !ok || !userClients[client] {
+		return false
+	}
+	delete(userClients, client)
+	if len(userClients) == 0 {
+		delete(h.users, client.userID)
+	}
+	h.disconnectPresence(client)
+
 	if clients, exists := h.rooms[client.roomID]; exists {
-		if _, ok := clients[client]; ok {
-			delete(clients, client)
-			close(client.send)
-			log.Printf("Client %s (ID: %d) left room %d. Remaining clients in room: %d",
-				client.username, client.userID, client.roomID, len(clients))
-
-			// Clean up empty rooms
-			if len(clients) == 0 {
-				delete(h.rooms, client.roomID)
-				log.Printf("Room %d is now empty, removing from hub", client.roomID)
-			}
+		delete(clients, client)
+
+		// Clean up empty rooms
+		if len(clients) == 0 {
+			delete(h.rooms, client.roomID)
+			log.Printf("Room %d is now empty, removing from hub", client.roomID)
 		}
 	}
+
+	close(client.send)
+	return true
 }
 
 var upgrader = websocket.Upgrader{
@@ -109,7 +199,7 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, roomID, userID int, username string, msgSvc *services.MessageService) {
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, roomID, userID int, username, sessionID string, msgSvc *services.MessageService) {
 	log.Printf("Attempting WebSocket upgrade for user %s (ID: %d) in room %d", username, userID, roomID)
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -121,13 +211,14 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, roomID, userID int
 	log.Printf("WebSocket upgrade successful for user %s (ID: %d) in room %d", username, userID, roomID)
 
 	client := &Client{
-		hub:      h,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		roomID:   roomID,
-		userID:   userID,
-		username: username,
-		msgSvc:   msgSvc,
+		hub:       h,
+		conn:      conn,
+		send:      make(chan []byte, 256),
+		roomID:    roomID,
+		userID:    userID,
+		username:  username,
+		sessionID: sessionID,
+		msgSvc:    msgSvc,
 	}
 	h.register <- client
 
@@ -138,6 +229,7 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, roomID, userID int
 // Client reads from socket, rebroadcasts messages as-is (content is validated in service).
 func (c *Client) readPump() {
 	defer func() {
+		c.hub.typingStop(c)
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
@@ -162,9 +254,29 @@ func (c *Client) readPump() {
 			continue
 		}
 
-		// Handle ping/pong messages
-		if msgType, ok := msgData["type"].(string); ok {
+		// Handle ping/pong and direct messages
+		msgType, _ := msgData["type"].(string)
+		if msgType != "ping" && msgType != "pong" && msgType != "away" {
+			// Anything but a heartbeat counts as user activity
+			c.hub.markActive(c)
+		}
+		if msgType != "" {
 			switch msgType {
+			case "direct_message":
+				c.handleDirectMessage(message)
+				continue
+			case "typing_start", "typing_stop":
+				c.handleTyping(msgType == "typing_start")
+				continue
+			case "read":
+				c.handleRead(message)
+				continue
+			case "active":
+				// Sent by clients while the user interacts without posting
+				continue
+			case "away":
+				c.hub.markAway(c)
+				continue
 			case "ping":
 				// Respond to ping with pong
 				pongMsg := map[string]string{"type": "pong"}
@@ -178,9 +290,10 @@ func (c *Client) readPump() {
 			}
 		}
 
-		// Handle regular chat messages
+		// Handle regular chat messages and thread replies
 		var body struct {
-			Content string `json:"content"`
+			Content  string `json:"content"`
+			ThreadID int    `json:"thread_id"`
 		}
 		if err := json.Unmarshal(message, &body); err != nil {
 			log.Printf("Client %s content unmarshal error: %v", c.username, err)
@@ -192,6 +305,21 @@ func (c *Client) readPump() {
 			continue
 		}
 
+		if c.roomID == 0 {
+			log.Printf("Client %s sent a room message on a direct-only connection", c.username)
+			continue
+		}
+
+		// Sending a message ends the sender's typing indicator
+		c.hub.typingStop(c)
+
+		if body.ThreadID != 0 {
+			if _, err := c.msgSvc.Reply(c.userID, body.ThreadID, body.Content); err != nil {
+				log.Printf("Client %s reply send error: %v", c.username, err)
+			}
+			continue
+		}
+
 		if _, err := c.msgSvc.Send(c.roomID, c.userID, body.Content); err != nil {
 			log.Printf("Client %s message send error: %v", c.username, err)
 			continue
@@ -199,6 +327,42 @@ func (c *Client) readPump() {
 	}
 }
 
+// handleDirectMessage sends a {"type":"direct_message","receiver_id":..,"content":..} frame.
+func (c *Client) handleDirectMessage(message []byte) {
+	var body struct {
+		ReceiverID int    `json:"receiver_id"`
+		Content    string `json:"content"`
+	}
+	if err := json.Unmarshal(message, &body); err != nil {
+		log.Printf("Client %s direct message unmarshal error: %v", c.username, err)
+		return
+	}
+
+	if _, err := c.msgSvc.SendDirect(c.userID, body.ReceiverID, body.Content); err != nil {
+		log.Printf("Client %s direct message send error: %v", c.username, err)
+	}
+}
+
+// handleRead moves the user's read marker in the connection's room with a
+// {"type":"read","message_id":..} frame; without message_id the whole room
+// is marked read.
+func (c *Client) handleRead(message []byte) {
+	if c.roomID == 0 {
+		return
+	}
+	var body struct {
+		MessageID int `json:"message_id"`
+	}
+	if err := json.Unmarshal(message, &body); err != nil {
+		log.Printf("Client %s read marker unmarshal error: %v", c.username, err)
+		return
+	}
+
+	if _, err := c.msgSvc.MarkRead(c.userID, c.roomID, body.MessageID); err != nil {
+		log.Printf("Client %s read marker error: %v", c.username, err)
+	}
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(240 * time.Second)
 	defer func() {
@@ -211,7 +375,11 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
 			if !ok {
 				log.Printf("Client %s send channel closed", c.username)
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMsg := c.closeMsg
+				if closeMsg == nil {
+					closeMsg = []byte{}
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMsg)
 				return
 			}
 			w, err := c.conn.NextWriter(websocket.TextMessage)
@@ -241,16 +409,67 @@ func (c *Client) writePump() {
 func (h *Hub) BroadcastMessage(msg models.Message, username string) {
 	out := map[string]any{
 		"type":      "message",
+		"id":        msg.ID,
 		"room_id":   msg.RoomID,
 		"sender_id": msg.SenderID,
 		"username":  username,
 		"content":   msg.Content,
 		"ts":        msg.CreatedAt.UnixMilli(),
 	}
+	if msg.ThreadID != 0 {
+		out["thread_id"] = msg.ThreadID
+	}
+	if len(msg.Attachments) > 0 {
+		out["attachments"] = msg.Attachments
+	}
 	b, _ := json.Marshal(out)
 	h.broadcast <- outbound{roomID: msg.RoomID, data: b}
 }
 
+// BroadcastDirect delivers a 1-to-1 message to every connection of its sender
+// and receiver, whichever room those connections are in.
+func (h *Hub) BroadcastDirect(msg models.Message, username string) {
+	out := map[string]any{
+		"type":        "direct_message",
+		"id":          msg.ID,
+		"sender_id":   msg.SenderID,
+		"receiver_id": msg.ReceiverID,
+		"username":    username,
+		"content":     msg.Content,
+		"ts":          msg.CreatedAt.UnixMilli(),
+	}
+	if len(msg.Attachments) > 0 {
+		out["attachments"] = msg.Attachments
+	}
+	b, _ := json.Marshal(out)
+	h.broadcast <- outbound{userIDs: []int{msg.SenderID, msg.ReceiverID}, data: b}
+}
+
+// BroadcastEvent fans out a {"type": event, ...payload} frame to every client
+// in a room.
+func (h *Hub) BroadcastEvent(roomID int, event string, payload map[string]any) {
+	h.broadcast <- outbound{roomID: roomID, data: eventFrame(event, payload)}
+}
+
+// SendToUsers delivers a {"type": event, ...payload} frame to every
+// connection of the given users.
+func (h *Hub) SendToUsers(userIDs []int, event string, payload map[string]any) {
+	if len(userIDs) == 0 {
+		return
+	}
+	h.broadcast <- outbound{userIDs: userIDs, data: eventFrame(event, payload)}
+}
+
+func eventFrame(event string, payload map[string]any) []byte {
+	out := make(map[string]any, len(payload)+1)
+	for k, v := range payload {
+		out[k] = v
+	}
+	out["type"] = event
+	b, _ := json.Marshal(out)
+	return b
+}
+
 // GetUserCount returns the number of users in a specific room
 func (h *Hub) GetUserCount(roomID int) int {
 	h.mu.RLock()
@@ -270,7 +489,7 @@ func (h *Hub) DisconnectRoom(roomID int) {
 	if clients, exists := h.rooms[roomID]; exists {
 		// Close all client connections in this room
 		for client := range clients {
-			close(client.send)
+			h.detach(client)
 			client.conn.Close()
 		}
 		// Remove the room from the hub
@@ -278,3 +497,43 @@ func (h *Hub) DisconnectRoom(roomID int) {
 		log.Printf("Disconnected all clients from room %d due to room deletion", roomID)
 	}
 }
+
+// DisconnectUser closes userID's connections to a room with a close frame
+// carrying reason, e.g. after a kick or ban. Their other connections stay up.
+func (h *Hub) DisconnectUser(roomID, userID int, reason string) {
+	// Close frame payloads are limited to 125 bytes, 2 of them for the code
+	if len(reason) > 123 {
+		reason = strings.ToValidUTF8(reason[:123], "")
+	}
+	closeMsg := websocket.FormatCloseMessage(CloseRemovedFromRoom, reason)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.users[userID] {
+		if client.roomID != roomID {
+			continue
+		}
+		client.closeMsg = closeMsg
+		h.detach(client)
+		log.Printf("Disconnected %s (ID: %d) from room %d: %s", client.username, userID, roomID, reason)
+	}
+}
+
+// DisconnectSession closes every connection opened with a session, in any
+// room, once it has been logged out or revoked.
+func (h *Hub) DisconnectSession(userID int, sessionID string) {
+	closeMsg := websocket.FormatCloseMessage(CloseSessionEnded, "session ended")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.users[userID] {
+		if client.sessionID != sessionID {
+			continue
+		}
+		client.closeMsg = closeMsg
+		h.detach(client)
+		log.Printf("Disconnected %s (ID: %d) from room %d: session ended", client.username, userID, client.roomID)
+	}
+}